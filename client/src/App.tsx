@@ -1,13 +1,14 @@
 import { useState, useEffect } from "react";
 import { BrowserRouter as Router, Routes, Route, Navigate } from "react-router-dom";
-import axios from "axios";
 // import * as localMealService from "./services/localMealService";
 import LoginButton from "./components/LoginButton";
+import PasswordLoginForm from "./components/PasswordLoginForm";
+import MagicLinkForm from "./components/MagicLinkForm";
 import MealPlannerContainer from "./components/MealPlannerContainer"; // Import the new component
 import { MealPlan } from "./features/meals/types";
 import "./App.css";
 import { fetchMealPlans } from "./features/meals/mealsApi";
-import api, { setAuthToken } from "./services/axios";
+import api, { logout, refreshAccessToken } from "./services/axios";
 
 
 function App() {
@@ -24,27 +25,32 @@ function App() {
   const [showJoinModal, setShowJoinModal] = useState(false);
 
   useEffect(() => {
-    // Check URL for token parameter and return URL
+    // The server keeps the session in a refresh token cookie; exchange it for
+    // an access token, which is only ever held in memory
     const queryParams = new URLSearchParams(window.location.search);
-    const token = queryParams.get('token');
     const returnTo = queryParams.get('returnTo');
+    if (returnTo) {
+      window.history.replaceState({}, document.title, returnTo)
+    }
 
-    if (token) {
-      localStorage.setItem('token', token)
-      setAuthToken(token) // ✅ update your axios instance
-      setIsAuthenticated(true)
-    
-      const newUrl = returnTo || window.location.pathname
-      window.history.replaceState({}, document.title, newUrl)
-    } else {
-      const storedToken = localStorage.getItem('token')
-      if (storedToken) {
-        setAuthToken(storedToken) // ✅ apply token from localStorage
-        setIsAuthenticated(true)
+    // A sign-in link from an email opens the app with its token, which is
+    // exchanged for a session before anything else
+    const magicToken = queryParams.get('magicToken');
+    let signInStatus = "";
+    const signIn = magicToken
+      ? api.post('/auth/magic-link/verify', { token: magicToken })
+          .catch(() => { signInStatus = "This sign-in link is invalid or has expired." })
+          .finally(() => window.history.replaceState({}, document.title, "/"))
+      : Promise.resolve();
+
+    signIn.then(() => refreshAccessToken()).then(token => {
+      if (!token) {
+        setStatus(signInStatus)
+        return
       }
-    }
+      setIsAuthenticated(true)
 
-     fetchMealPlans().then( response => {
+      fetchMealPlans().then( response => {
         if(response.status == 200) {
           setMealPlans(response.data)
           setStatus("")
@@ -53,8 +59,8 @@ function App() {
           setMealPlans([])
           setStatus(response.error)
         }
-     });
-    
+      });
+    });
   }, []);
 
   const handleCreateMealPlan = async () => { // Keep: Manages meal plan list and creation modal
@@ -85,9 +91,8 @@ function App() {
   //   setAuthError(error);
   // };
 
-  const handleLogout = () => { // Keep: Manages auth state
-    localStorage.removeItem('token');
-    delete axios.defaults.headers.common['Authorization'];
+  const handleLogout = async () => { // Keep: Manages auth state
+    await logout();
     setIsAuthenticated(false);
     // setMeals([]); // meals state moved
   };
@@ -112,6 +117,8 @@ function App() {
               <h2>Please login to access your meal planner</h2>
               {authError && <div className="error-message">{authError}</div>}
               <LoginButton />
+              <PasswordLoginForm />
+              <MagicLinkForm />
             </section>
           ) : (
             <Routes>
@@ -43,6 +43,7 @@ const AddMealForm: React.FC<AddMealFormProps> = ({
     const meal: Meal = {
       ...formData,
       id: mealToEdit?.id || crypto.randomUUID(),
+      version: mealToEdit?.version,
       createdAt: mealToEdit?.createdAt || new Date().toISOString(),
       updatedAt: new Date().toISOString()
     };
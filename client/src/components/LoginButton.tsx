@@ -1,20 +1,44 @@
-import React from 'react';
+import React, { useEffect, useState } from 'react';
+import api from '../services/axios';
+
+interface Provider {
+  name: string;
+  loginUrl: string;
+}
+
+const displayNames: Record<string, string> = {
+  google: 'Google',
+};
 
 const LoginButton: React.FC = () => {
-  const handleLogin = () => {
-    // Redirect to the server's OAuth login endpoint
+  const [providers, setProviders] = useState<Provider[]>([]);
+
+  useEffect(() => {
+    api
+      .get<Provider[]>('/auth/providers')
+      .then(response => setProviders(response.data))
+      .catch(() => setProviders([]));
+  }, []);
+
+  const handleLogin = (provider: Provider) => {
+    // Redirect to the server's login endpoint for this provider
     const apiUrl = import.meta.env.VITE_API_URL;
-    window.location.href = `${apiUrl}/auth/google/login`;
+    window.location.href = `${apiUrl}${provider.loginUrl}`;
   };
 
   return (
-    <button 
-      className="login-button"
-      onClick={handleLogin}
-    >
-      Sign in with Google
-    </button>
+    <>
+      {providers.map(provider => (
+        <button
+          key={provider.name}
+          className="login-button"
+          onClick={() => handleLogin(provider)}
+        >
+          Sign in with {displayNames[provider.name] ?? provider.name}
+        </button>
+      ))}
+    </>
   );
 };
 
-export default LoginButton; 
\ No newline at end of file
+export default LoginButton;
@@ -0,0 +1,43 @@
+import React, { useState } from 'react';
+import api from '../services/axios';
+
+// Asks the server to email a one-time sign-in link
+const MagicLinkForm: React.FC = () => {
+  const [email, setEmail] = useState('');
+  const [sent, setSent] = useState(false);
+  const [error, setError] = useState<string | null>(null);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError(null);
+    try {
+      await api.post('/auth/magic-link', { email });
+      setSent(true);
+    } catch {
+      setError('Could not send a sign-in link. Please try again.');
+    }
+  };
+
+  if (sent) {
+    return <p className="magic-link-sent">Check your email for a sign-in link.</p>;
+  }
+
+  return (
+    <form className="magic-link-form" onSubmit={handleSubmit}>
+      <input
+        type="email"
+        placeholder="Email"
+        autoComplete="email"
+        value={email}
+        onChange={e => setEmail(e.target.value)}
+        required
+      />
+      {error && <div className="error-message">{error}</div>}
+      <button type="submit" className="login-button">
+        Email me a sign-in link
+      </button>
+    </form>
+  );
+};
+
+export default MagicLinkForm;
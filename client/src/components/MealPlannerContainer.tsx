@@ -115,7 +115,8 @@ const MealPlannerContainer: React.FC<MealPlannerContainerProps> = ({
     // TODO: Call backend API to delete meal
     // For now, using localMealService as placeholder if needed, or just update state
     // localMealService.deleteMeal(mealId); // Example if using local service
-    deleteMeal(mealId).then(() => {
+    const meal = meals.find(m => m.id === mealId);
+    deleteMeal(mealId, meal?.version).then(() => {
       setMeals((prevMeals) => prevMeals.filter((meal) => meal.id !== mealId));
     })
   };
@@ -141,7 +142,12 @@ const MealPlannerContainer: React.FC<MealPlannerContainerProps> = ({
       setMeals(prevMeals =>
         prevMeals.map(m => m.id === mealId ? updatedMeal : m)
       );
-      updateMeal(updatedMeal)
+      updateMeal(updatedMeal).then((saved) => {
+        // Keep the new version so the next edit isn't rejected as stale
+        if (saved) {
+          setMeals(prevMeals => prevMeals.map(m => m.id === saved.id ? saved : m));
+        }
+      })
     }
   };
 
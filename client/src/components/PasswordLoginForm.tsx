@@ -0,0 +1,82 @@
+import React, { useState } from 'react';
+import axios from 'axios';
+import api from '../services/axios';
+
+// Signs in to, or creates, an account with an email and password. The
+// server sets the session cookie, so reloading picks up the new session. New
+// accounts are created once the emailed confirmation link is opened.
+const PasswordLoginForm: React.FC = () => {
+  const [mode, setMode] = useState<'login' | 'register'>('login');
+  const [email, setEmail] = useState('');
+  const [password, setPassword] = useState('');
+  const [name, setName] = useState('');
+  const [error, setError] = useState<string | null>(null);
+  const [confirmationSent, setConfirmationSent] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError(null);
+    try {
+      if (mode === 'register') {
+        await api.post('/auth/register', { email, password, name });
+        setConfirmationSent(true);
+        return;
+      }
+      await api.post('/auth/login', { email, password });
+      window.location.reload();
+    } catch (err) {
+      if (axios.isAxiosError(err) && typeof err.response?.data === 'string') {
+        setError(err.response.data.trim());
+      } else {
+        setError('Sign-in failed. Please try again.');
+      }
+    }
+  };
+
+  if (confirmationSent) {
+    return <p className="magic-link-sent">Check your email for a link to confirm your account.</p>;
+  }
+
+  return (
+    <form className="password-login-form" onSubmit={handleSubmit}>
+      {mode === 'register' && (
+        <input
+          type="text"
+          placeholder="Name"
+          value={name}
+          onChange={e => setName(e.target.value)}
+        />
+      )}
+      <input
+        type="email"
+        placeholder="Email"
+        autoComplete="email"
+        value={email}
+        onChange={e => setEmail(e.target.value)}
+        required
+      />
+      <input
+        type="password"
+        placeholder="Password"
+        autoComplete={mode === 'register' ? 'new-password' : 'current-password'}
+        minLength={8}
+        value={password}
+        onChange={e => setPassword(e.target.value)}
+        required
+      />
+      {error && <div className="error-message">{error}</div>}
+      <button type="submit" className="login-button">
+        {mode === 'register' ? 'Create account' : 'Sign in'}
+      </button>
+      <button
+        type="button"
+        className="link-button"
+        onClick={() => setMode(mode === 'register' ? 'login' : 'register')}
+      >
+        {mode === 'register' ? 'Already have an account? Sign in' : 'No account? Create one'}
+      </button>
+    </form>
+  );
+};
+
+export default PasswordLoginForm;
@@ -60,24 +60,36 @@ export const fetchMealPlans = async (): Promise<MealPlanResponse> => {
     }
   }
 
-  export const deleteMeal = async(mealId : string) : Promise<void> => {
+  // The server rejects writes without If-Match, so edits made from a stale
+  // copy of a meal fail instead of overwriting someone else's changes. The
+  // version comes from the response the copy was loaded from; a copy without
+  // one is checked against the meal's current ETag, fetched first.
+  const ifMatch = async(mealId : string, version? : number) => {
+    if (version) {
+        return { "If-Match": `"${version}"` };
+    }
+    const response = await api.get<Meal>(`/api/meals/${mealId}`);
+    return { "If-Match": response.headers["etag"] as string };
+  }
+
+  export const deleteMeal = async(mealId : string, version? : number) : Promise<void> => {
     try {
-        await api.delete<Meal>(`/api/meals/${mealId}`);
+        await api.delete<Meal>(`/api/meals/${mealId}`, { headers: await ifMatch(mealId, version) });
     } catch (error) {
         console.error("Error deleting meal:", error);
     }
   }
 
-  export const updateMeal = async(meal : Meal) : Promise<void> => {
+  export const updateMeal = async(meal : Meal) : Promise<Meal | undefined> => {
     try {
-        
-        await api.put<Meal>(`/api/meals/${meal.id}`, {
+        const response = await api.put<Meal>(`/api/meals/${meal.id}`, {
             name:  meal.name,
             description : meal.description,
             mealType: meal.mealType,
             day: meal.day
-          });
+          }, { headers: await ifMatch(meal.id, meal.version) });
+        return response.data;
     } catch (error) {
-        console.error("Error adding meal:", error);
+        console.error("Error updating meal:", error);
     }
   }
\ No newline at end of file
@@ -8,6 +8,7 @@ export interface Meal {
   chef?: string;
   day: Day;
   mealType: MealType;
+  version?: number;
   createdAt: string;
   updatedAt: string;
 }
@@ -24,6 +25,7 @@ export interface MealPlan {
   id: string;
   name: string;
   description: string;
+  version?: number;
   createdAt: string;
   updatedAt: string;
   createdBy: string;
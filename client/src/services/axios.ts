@@ -1,9 +1,9 @@
 // src/api/axios.ts
-import axios from 'axios'
+import axios, { AxiosError, InternalAxiosRequestConfig } from 'axios'
 
 const api = axios.create({
   baseURL: import.meta.env.VITE_API_URL,
-  withCredentials: true, // if you're using cookies for sessions
+  withCredentials: true, // sends the refresh token cookie to /auth
 })
 
 export const setAuthToken = (token: string | null) => {
@@ -14,4 +14,75 @@ export const setAuthToken = (token: string | null) => {
   }
 }
 
+const setCSRFToken = (token: string | null) => {
+  if (token) {
+    api.defaults.headers.common['X-CSRF-Token'] = token
+  } else {
+    delete api.defaults.headers.common['X-CSRF-Token']
+  }
+}
+
+// In cookie session mode the server keeps the access token in a cookie and
+// sends a CSRF token to repeat in a header instead
+interface TokenResponse {
+  accessToken?: string
+  csrfToken?: string
+  expiresIn: number
+}
+
+// Exchanges the refresh token cookie for a new access token. Concurrent
+// callers share one request, since each refresh token can only be used once.
+// Resolves to the access token, the CSRF token in cookie session mode, or
+// null when there is no session.
+let refreshing: Promise<string | null> | null = null
+
+export const refreshAccessToken = (): Promise<string | null> => {
+  if (!refreshing) {
+    refreshing = api
+      .post<TokenResponse>('/auth/refresh')
+      .then(response => {
+        const { accessToken, csrfToken } = response.data
+        setAuthToken(accessToken ?? null)
+        setCSRFToken(csrfToken ?? null)
+        return accessToken ?? csrfToken ?? null
+      })
+      .catch(() => {
+        setAuthToken(null)
+        setCSRFToken(null)
+        return null
+      })
+      .finally(() => {
+        refreshing = null
+      })
+  }
+  return refreshing
+}
+
+// Ends the session on the server and forgets the access token
+export const logout = async () => {
+  try {
+    await api.post('/auth/logout')
+  } finally {
+    setAuthToken(null)
+    setCSRFToken(null)
+  }
+}
+
+// Access tokens are short-lived, so retry a request once with a fresh token
+api.interceptors.response.use(undefined, async (error: AxiosError) => {
+  const config = error.config as (InternalAxiosRequestConfig & { _retried?: boolean }) | undefined
+  if (error.response?.status !== 401 || !config || config._retried || config.url?.startsWith('/auth/')) {
+    throw error
+  }
+  config._retried = true
+
+  if (!(await refreshAccessToken())) {
+    throw error
+  }
+  // Pick up the new Authorization or CSRF header from the defaults
+  delete config.headers['Authorization']
+  delete config.headers['X-CSRF-Token']
+  return api(config)
+})
+
 export default api
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+
+	"my-meal-planner/db"
+	"my-meal-planner/models"
+)
+
+// handleAccount handles DELETE requests for /api/account, deleting the
+// caller's account. Each plan the caller owns alone passes to the editor who
+// joined it first; plans without an editor are deleted with the account.
+func (h *Handler) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	err := h.store.WithTx(r.Context(), func(tx db.Store) error {
+		plans, _, err := tx.ListMealPlansByUser(r.Context(), caller.UserID, db.ListOptions{})
+		if err != nil {
+			return err
+		}
+		for _, plan := range plans {
+			if ok, _ := tx.CheckMealPlanOwnership(r.Context(), caller.UserID, plan.ID); !ok {
+				continue
+			}
+			members, err := tx.ListMealPlanMembers(r.Context(), plan.ID)
+			if err != nil {
+				return err
+			}
+			heir := successor(members, caller.UserID)
+			if heir == nil || heir.Role == "owner" {
+				continue
+			}
+			if err := tx.SetMemberRole(r.Context(), plan.ID, heir.UserID, "owner"); err != nil {
+				return err
+			}
+		}
+		return tx.DeleteUser(r.Context(), caller.UserID)
+	})
+	if err != nil {
+		writeError(w, err, "Failed to delete account")
+		return
+	}
+
+	clearRefreshCookie(w, r)
+	clearAccessCookies(w, r)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// successor picks who takes over a plan from a departing owner: another
+// owner if there is one, otherwise the editor who joined first. It returns
+// nil if nobody qualifies.
+func successor(members []*models.MealPlanMember, userID string) *models.MealPlanMember {
+	var heir *models.MealPlanMember
+	for _, member := range members {
+		if member.UserID == userID {
+			continue
+		}
+		switch member.Role {
+		case "owner":
+			return member
+		case "editor":
+			if heir == nil || member.JoinedAt.Before(heir.JoinedAt) {
+				heir = member
+			}
+		}
+	}
+	return heir
+}
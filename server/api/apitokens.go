@@ -0,0 +1,186 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"my-meal-planner/db"
+	"my-meal-planner/models"
+)
+
+// maxAPITokenNameLength bounds the label a user gives a token
+const maxAPITokenNameLength = 100
+
+// apiTokenResponse describes a personal access token. Token holds the secret
+// itself and is only returned once, when the token is created.
+type apiTokenResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	Token      string     `json:"token,omitempty"`
+}
+
+func toAPITokenResponse(token *models.APIToken) apiTokenResponse {
+	return apiTokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     token.Scopes,
+		CreatedAt:  token.CreatedAt,
+		LastUsedAt: token.LastUsedAt,
+		ExpiresAt:  token.ExpiresAt,
+	}
+}
+
+// handleAPITokens handles GET and POST requests for /api/tokens
+func (h *Handler) handleAPITokens(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.listAPITokens(w, r)
+	case http.MethodPost:
+		h.createAPIToken(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// listAPITokens returns the caller's personal access tokens
+func (h *Handler) listAPITokens(w http.ResponseWriter, r *http.Request) {
+	caller := principal(r)
+
+	tokens, err := h.store.ListAPITokensByUser(r.Context(), caller.UserID)
+	if err != nil {
+		http.Error(w, "Failed to list tokens", http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]apiTokenResponse, 0, len(tokens))
+	for _, token := range tokens {
+		resp = append(resp, toAPITokenResponse(token))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// createAPIToken creates a personal access token for the caller
+func (h *Handler) createAPIToken(w http.ResponseWriter, r *http.Request) {
+	caller := principal(r)
+
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		// ExpiresInDays is optional; tokens without it last until revoked
+		ExpiresInDays int `json:"expiresInDays"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxAPITokenNameLength {
+		http.Error(w, fmt.Sprintf("Name is required and must be at most %d characters", maxAPITokenNameLength), http.StatusBadRequest)
+		return
+	}
+	scopes, err := parseScopes(req.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInDays < 0 {
+		http.Error(w, "expiresInDays must not be negative", http.StatusBadRequest)
+		return
+	}
+
+	value, hash, prefix, err := db.NewAPIToken()
+	if err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	token := &models.APIToken{
+		UserID:    caller.UserID,
+		Name:      name,
+		TokenHash: hash,
+		Prefix:    prefix,
+		Scopes:    scopes,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := h.store.CreateAPIToken(r.Context(), token); err != nil {
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+
+	resp := toAPITokenResponse(token)
+	resp.Token = value
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleAPITokenByID handles DELETE requests for /api/tokens/{id}, revoking
+// one of the caller's tokens
+func (h *Handler) handleAPITokenByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/tokens/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+
+	// Other users' tokens are reported as missing
+	token, err := h.store.GetAPIToken(r.Context(), id)
+	if errors.Is(err, db.ErrAPITokenNotFound) || (err == nil && token.UserID != caller.UserID) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.store.DeleteAPIToken(r.Context(), id); err != nil && !errors.Is(err, db.ErrAPITokenNotFound) {
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseScopes checks requested scopes and returns them sorted without duplicates
+func parseScopes(requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, scope := range requested {
+		if !knownScopes[scope] {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+	return scopes, nil
+}
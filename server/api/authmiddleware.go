@@ -1,26 +1,103 @@
 package api
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strings"
+	"time"
+
+	"my-meal-planner/db"
 )
 
-// authMiddleware verifies JWT tokens
+// apiTokenTouchInterval limits how often a token's last-used time is written,
+// so a busy script doesn't cause a write on every request
+const apiTokenTouchInterval = time.Minute
+
+// authMiddleware verifies the request's credentials and stores the caller's
+// Principal in the request context for the handlers behind it. Credentials
+// are either JWT access tokens or personal access tokens; the latter are
+// limited to the routes their scopes cover. In cookie session mode, requests
+// without an Authorization header may use the access cookie instead, and
+// must then pass the CSRF check.
 func (h *Handler) authMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		tokenString := r.Header.Get("Authorization")
+		tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		fromCookie := false
+		if tokenString == "" && h.cookieSessions {
+			if cookie, err := r.Cookie(accessCookie); err == nil {
+				tokenString, fromCookie = cookie.Value, true
+			}
+		}
 		if tokenString == "" {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+		if fromCookie && !validCSRF(r) {
+			http.Error(w, "Missing or invalid CSRF token", http.StatusForbidden)
+			return
+		}
 
-		// Validate JWT token
-		_, err := h.store.ValidateToken(strings.TrimPrefix(tokenString, "Bearer "))
+		var p *Principal
+		var err error
+		if db.IsAPIToken(tokenString) {
+			p, err = h.apiTokenPrincipal(r, tokenString)
+		} else {
+			p, err = h.jwtPrincipal(r, tokenString)
+		}
 		if err != nil {
 			http.Error(w, "Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		if !allowedScope(p, r) {
+			http.Error(w, "Token does not have the scope required for this request", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), p)))
 	})
 }
+
+// jwtPrincipal validates a JWT access token
+func (h *Handler) jwtPrincipal(r *http.Request, tokenString string) (*Principal, error) {
+	claims, err := h.store.ValidateToken(r.Context(), tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{
+		UserID:    claims.UserID,
+		Email:     claims.Email,
+		TokenID:   claims.ID,
+		SessionID: claims.SessionID,
+		Scopes:    claims.Scopes,
+	}, nil
+}
+
+// apiTokenPrincipal looks up a personal access token and records its use
+func (h *Handler) apiTokenPrincipal(r *http.Request, tokenString string) (*Principal, error) {
+	token, err := h.store.GetAPITokenByHash(r.Context(), db.HashAPIToken(tokenString))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if token.Expired(now) {
+		return nil, errors.New("API token expired")
+	}
+	user, err := h.store.GetUserByID(r.Context(), token.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= apiTokenTouchInterval {
+		if err := h.store.TouchAPIToken(r.Context(), token.ID, now); err != nil {
+			log.Printf("failed to record use of API token %s: %v", token.ID, err)
+		}
+	}
+
+	return &Principal{
+		UserID:  user.ID,
+		Email:   user.Email,
+		TokenID: token.ID,
+		Scopes:  token.Scopes,
+	}, nil
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"my-meal-planner/db"
+)
+
+// permission names an action on a meal plan and its meals
+type permission string
+
+const (
+	permReadPlan      permission = "plan:read"
+	permUpdatePlan    permission = "plan:update"
+	permDeletePlan    permission = "plan:delete"
+	permSharePlan     permission = "plan:share"
+	permManageMembers permission = "plan:members"
+	permTransferPlan  permission = "plan:transfer"
+	permReadMeals     permission = "meals:read"
+	permWriteMeals    permission = "meals:write"
+)
+
+// policy maps each permission to the least role that grants it
+var policy = map[permission]string{
+	permReadPlan:      "viewer",
+	permUpdatePlan:    "editor",
+	permDeletePlan:    "owner",
+	permSharePlan:     "owner",
+	permManageMembers: "owner",
+	permTransferPlan:  "owner",
+	permReadMeals:     "viewer",
+	permWriteMeals:    "editor",
+}
+
+// authorize checks that userID's role on mealPlanID grants perm. The returned
+// error is an apiError naming the missing permission, ready for writeError.
+func (h *Handler) authorize(ctx context.Context, userID, mealPlanID string, perm permission) error {
+	required, known := policy[perm]
+	if !known {
+		return fmt.Errorf("no policy for permission %q", perm)
+	}
+
+	role, err := h.store.GetRole(ctx, userID, mealPlanID)
+	switch {
+	case errors.Is(err, db.ErrMealPlanNotFound):
+		return newAPIError(http.StatusNotFound, "Meal plan not found")
+	case errors.Is(err, db.ErrAccessDenied):
+		return newAPIError(http.StatusForbidden, fmt.Sprintf("Missing permission %q: you don't have access to this meal plan", perm))
+	case err != nil:
+		return err
+	}
+
+	if db.RoleRank(role) < db.RoleRank(required) {
+		return newAPIError(http.StatusForbidden, fmt.Sprintf("Missing permission %q: requires the %s role, but your role is %s", perm, required, role))
+	}
+	return nil
+}
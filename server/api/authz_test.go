@@ -0,0 +1,94 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"my-meal-planner/api"
+	"my-meal-planner/models"
+)
+
+func TestRolesLimitPlanActions(t *testing.T) {
+	meal := map[string]string{"name": "Soup", "day": "Monday", "mealType": "Lunch"}
+	actions := []struct {
+		method, path string
+		body         any
+		ifMatch      bool
+		// wants are the statuses for a viewer, an editor, the owner and a
+		// user without access
+		wants [4]int
+	}{
+		{http.MethodGet, "/api/meal-plans/plan-1", nil, false, [4]int{200, 200, 200, 403}},
+		{http.MethodPut, "/api/meal-plans/plan-1", map[string]string{"name": "Renamed"}, true, [4]int{403, 200, 200, 403}},
+		{http.MethodDelete, "/api/meal-plans/plan-1", nil, true, [4]int{403, 403, 204, 403}},
+		{http.MethodGet, "/api/meal-plans/plan-1/meals", nil, false, [4]int{200, 200, 200, 403}},
+		{http.MethodPost, "/api/meal-plans/plan-1/meals", meal, false, [4]int{403, 201, 201, 403}},
+		{http.MethodPut, "/api/meal-plans/plan-1/meals/meal-1", meal, true, [4]int{403, 200, 200, 403}},
+		{http.MethodDelete, "/api/meal-plans/plan-1/meals/meal-1", nil, true, [4]int{403, 204, 204, 403}},
+		{http.MethodPatch, "/api/meal-plans/plan-1/members/viewer", map[string]string{"role": "editor"}, false, [4]int{403, 403, 200, 403}},
+		{http.MethodGet, "/api/meal-plans/plan-1/share-codes", nil, false, [4]int{403, 403, 200, 403}},
+	}
+	users := [4]string{"viewer", "editor", "owner", "outsider"}
+
+	for _, action := range actions {
+		for i, user := range users {
+			// Each request gets a fresh plan so earlier writes can't change
+			// the outcome
+			ts := newTestServer(t)
+			var caller *models.User
+			for _, id := range users {
+				if u := ts.seedUser(id); id == user {
+					caller = u
+				}
+			}
+			ts.seedPlan("plan-1", "owner")
+			ts.grant("viewer", "plan-1", "viewer")
+			ts.grant("editor", "plan-1", "editor")
+			ts.seedMeal("meal-1", "plan-1")
+
+			r := request(action.method, action.path, ts.accessToken(caller), action.body)
+			if action.ifMatch {
+				r.Header.Set("If-Match", `"1"`)
+			}
+			w := ts.serve(r)
+			if w.Code != action.wants[i] {
+				t.Errorf("%s %s as %s = %d %q, want %d", action.method, action.path, user, w.Code, w.Body.String(), action.wants[i])
+			}
+		}
+	}
+}
+
+func TestTokenScopesLimitRequests(t *testing.T) {
+	ts := newTestServer(t)
+	ts.seedUser("alice")
+	ts.seedPlan("plan-1", "alice")
+	ts.seedMeal("meal-1", "plan-1")
+
+	mealsRead := ts.apiToken("alice", api.ScopeMealsRead)
+	plansRead := ts.apiToken("alice", api.ScopePlansRead)
+	meal := map[string]string{"name": "Soup", "day": "Monday", "mealType": "Lunch"}
+
+	tests := []struct {
+		method, path, token string
+		body                any
+		want                int
+	}{
+		{http.MethodGet, "/api/meals/meal-1", mealsRead, nil, http.StatusOK},
+		{http.MethodPut, "/api/meals/meal-1", mealsRead, meal, http.StatusForbidden},
+		{http.MethodPost, "/api/meal-plans/plan-1/meals", mealsRead, meal, http.StatusForbidden},
+		{http.MethodGet, "/api/meal-plans/plan-1", mealsRead, nil, http.StatusForbidden},
+		{http.MethodGet, "/api/meal-plans/plan-1", plansRead, nil, http.StatusOK},
+		{http.MethodPut, "/api/meal-plans/plan-1", plansRead, map[string]string{"name": "Renamed"}, http.StatusForbidden},
+		{http.MethodGet, "/api/meal-plans/plan-1/meals", plansRead, nil, http.StatusForbidden},
+		// Managing tokens needs an unrestricted credential
+		{http.MethodGet, "/api/tokens", plansRead, nil, http.StatusForbidden},
+		{http.MethodPost, "/api/tokens", plansRead, map[string]string{"name": "more"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := request(tt.method, tt.path, tt.token, tt.body)
+		r.Header.Set("If-Match", `"1"`)
+		if w := ts.serve(r); w.Code != tt.want {
+			t.Errorf("%s %s = %d %q, want %d", tt.method, tt.path, w.Code, w.Body.String(), tt.want)
+		}
+	}
+}
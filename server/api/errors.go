@@ -0,0 +1,39 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"my-meal-planner/db"
+)
+
+// apiError carries the status and message a handler wants to report,
+// so it can be returned from inside a store transaction.
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+// newAPIError creates an error that writeError reports with the given status
+func newAPIError(status int, message string) error {
+	return &apiError{status: status, message: message}
+}
+
+// writeError reports err to the client. apiErrors keep their own status and
+// message, anything else becomes a 500 with the fallback message.
+func writeError(w http.ResponseWriter, err error, fallback string) {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		http.Error(w, apiErr.message, apiErr.status)
+		return
+	}
+	if errors.Is(err, db.ErrInvalidListOptions) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, fallback, http.StatusInternalServerError)
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"my-meal-planner/db"
+)
+
+// setETag reports a record's version as a strong entity tag
+func setETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", `"`+strconv.FormatInt(version, 10)+`"`)
+}
+
+// ifMatchVersion returns the version a PUT or DELETE expects to replace.
+// The If-Match header is required so that concurrent edits can't silently
+// overwrite each other. It must name a version: "*" would match whatever
+// version is current, so it is refused like a missing header.
+func ifMatchVersion(r *http.Request, current int64) (int64, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return 0, newAPIError(http.StatusPreconditionRequired, "If-Match header is required")
+	}
+	if header == "*" {
+		return 0, newAPIError(http.StatusPreconditionRequired, "If-Match must name the version being replaced, not *")
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if strings.HasPrefix(tag, "W/") {
+			// Weak tags never match under If-Match's strong comparison
+			continue
+		}
+		version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+		if err == nil && version == current {
+			return version, nil
+		}
+	}
+	return 0, newAPIError(http.StatusPreconditionFailed, "Resource has been modified")
+}
+
+// versionConflict converts a store version conflict into a 412 response
+func versionConflict(err error) error {
+	if err == db.ErrVersionConflict {
+		return newAPIError(http.StatusPreconditionFailed, "Resource has been modified")
+	}
+	return err
+}
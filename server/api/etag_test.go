@@ -0,0 +1,78 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestWritesNeedCurrentVersion(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.seedUser("alice")
+	ts.seedPlan("plan-1", "alice")
+	ts.seedMeal("meal-1", "plan-1")
+	token := ts.accessToken(alice)
+
+	body := map[string]string{"name": "Soup", "day": "Monday", "mealType": "Lunch"}
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    int
+	}{
+		{"missing", "", http.StatusPreconditionRequired},
+		{"wildcard", "*", http.StatusPreconditionRequired},
+		{"stale", `"7"`, http.StatusPreconditionFailed},
+		{"weak", `W/"1"`, http.StatusPreconditionFailed},
+		{"current", `"1"`, http.StatusOK},
+		{"replaced", `"1"`, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		r := request(http.MethodPut, "/api/meals/meal-1", token, body)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		w := ts.serve(r)
+		if w.Code != tt.want {
+			t.Errorf("%s If-Match: PUT = %d %q, want %d", tt.name, w.Code, w.Body.String(), tt.want)
+		}
+	}
+
+	r := request(http.MethodDelete, "/api/meals/meal-1", token, nil)
+	r.Header.Set("If-Match", "*")
+	if w := ts.serve(r); w.Code != http.StatusPreconditionRequired {
+		t.Errorf("wildcard If-Match: DELETE = %d, want 428", w.Code)
+	}
+	r = request(http.MethodDelete, "/api/meals/meal-1", token, nil)
+	r.Header.Set("If-Match", `"2"`)
+	if w := ts.serve(r); w.Code != http.StatusNoContent {
+		t.Errorf("current If-Match: DELETE = %d %q, want 204", w.Code, w.Body.String())
+	}
+}
+
+func TestPlanWritesNeedCurrentVersion(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.seedUser("alice")
+	ts.seedPlan("plan-1", "alice")
+	token := ts.accessToken(alice)
+
+	tests := []struct {
+		method  string
+		ifMatch string
+		want    int
+	}{
+		{http.MethodPut, "", http.StatusPreconditionRequired},
+		{http.MethodPut, `"3"`, http.StatusPreconditionFailed},
+		{http.MethodPut, `"1"`, http.StatusOK},
+		{http.MethodDelete, "", http.StatusPreconditionRequired},
+		{http.MethodDelete, `"1"`, http.StatusPreconditionFailed},
+		{http.MethodDelete, `"2"`, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		r := request(tt.method, "/api/meal-plans/plan-1", token, map[string]string{"name": "Renamed"})
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+		if w := ts.serve(r); w.Code != tt.want {
+			t.Errorf("%s with If-Match %q = %d %q, want %d", tt.method, tt.ifMatch, w.Code, w.Body.String(), tt.want)
+		}
+	}
+}
@@ -3,25 +3,100 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"net/netip"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
-
+	"my-meal-planner/auth"
 	"my-meal-planner/db"
+	"my-meal-planner/mailer"
 	"my-meal-planner/models"
 )
 
+// defaultRequestTimeout bounds how long a single request may use the store
+const defaultRequestTimeout = 15 * time.Second
+
 // Handler contains all the dependencies for the API handlers
 type Handler struct {
-	store db.Store
+	store          db.Store
+	providers      map[string]auth.Provider
+	mailer         mailer.Mailer
+	cookieSessions bool
+	requestTimeout time.Duration
+	publicURL      string
+	trustedProxies []netip.Prefix
+
+	linkEmailsByAddress *rateLimiter
+	linkEmailsByIP      *rateLimiter
+}
+
+// Option configures optional Handler behaviour
+type Option func(*Handler)
+
+// WithRequestTimeout sets the deadline applied to every request's context.
+// A zero or negative duration disables the deadline.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(h *Handler) {
+		h.requestTimeout = timeout
+	}
+}
+
+// WithProviders sets the identity providers users can sign in with
+func WithProviders(providers ...auth.Provider) Option {
+	return func(h *Handler) {
+		for _, provider := range providers {
+			h.providers[provider.Name()] = provider
+		}
+	}
+}
+
+// WithMailer sets the mailer used to send sign-in links and invitations.
+// Without one, email sign-in is disabled and invitations aren't emailed.
+func WithMailer(m mailer.Mailer) Option {
+	return func(h *Handler) {
+		h.mailer = m
+	}
+}
+
+// WithPublicURL sets the address users reach the server at, used to build the
+// join links for share codes. Without one, share codes have no join link.
+func WithPublicURL(publicURL string) Option {
+	return func(h *Handler) {
+		h.publicURL = strings.TrimRight(publicURL, "/")
+	}
+}
+
+// WithTrustedProxies sets the reverse proxies whose X-Forwarded-For header is
+// believed. Without any, the client address is the connection's remote address.
+func WithTrustedProxies(proxies ...netip.Prefix) Option {
+	return func(h *Handler) {
+		h.trustedProxies = proxies
+	}
+}
+
+// WithCookieSessions gives browsers their access token in an HttpOnly cookie
+// instead of the response body, and requires a CSRF token on requests that
+// authenticate with that cookie. Bearer tokens keep working for API clients.
+func WithCookieSessions(enabled bool) Option {
+	return func(h *Handler) {
+		h.cookieSessions = enabled
+	}
 }
 
 // NewHandler creates a new API handler
-func NewHandler(store db.Store) *Handler {
-	return &Handler{
-		store: store,
+func NewHandler(store db.Store, opts ...Option) *Handler {
+	h := &Handler{
+		store:          store,
+		providers:      make(map[string]auth.Provider),
+		requestTimeout: defaultRequestTimeout,
+
+		linkEmailsByAddress: newRateLimiter(linkEmailsPerAddress, linkEmailWindow),
+		linkEmailsByIP:      newRateLimiter(linkEmailsPerIP, linkEmailWindow),
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 // handleMealPlans handles GET and POST requests for /api/meal-plans
@@ -36,48 +111,131 @@ func (h *Handler) handleMealPlans(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleMeals handles GET and POST requests for /api/meals
+// handleMeals handles GET and POST requests for /api/meals, the older form
+// of /api/meal-plans/{planId}/meals that takes the plan ID from the query
+// string or request body
 func (h *Handler) handleMeals(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		h.listMeals(w, r)
+		mealPlanID := r.URL.Query().Get("mealPlanId")
+		if mealPlanID == "" {
+			http.Error(w, "Meal plan ID is required", http.StatusBadRequest)
+			return
+		}
+		h.listMeals(w, r, mealPlanID)
 	case http.MethodPost:
-		h.createMeal(w, r)
+		var req struct {
+			models.MealRequest `json:"meal"`
+			MealPlanID         string `json:"mealPlanId"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.MealPlanID == "" {
+			http.Error(w, "Meal plan ID is required", http.StatusBadRequest)
+			return
+		}
+		h.createMeal(w, r, req.MealPlanID, req.MealRequest)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// handleMealByID handles GET, PUT, and DELETE requests for /api/meals/{id}
+// handleMealByID handles GET, PUT, and DELETE requests for /api/meals/{id},
+// an alias of /api/meal-plans/{planId}/meals/{id} for the meal's own plan
 func (h *Handler) handleMealByID(w http.ResponseWriter, r *http.Request) {
 	// Extract meal ID from URL
 	id := strings.TrimPrefix(r.URL.Path, "/api/meals/")
-	if id == "" {
+	if id == "" || strings.Contains(id, "/") {
 		http.Error(w, "Invalid meal ID", http.StatusBadRequest)
 		return
 	}
 
+	meal, err := h.store.GetMeal(r.Context(), id)
+	if err != nil {
+		if err == db.ErrMealNotFound {
+			http.Error(w, "Meal not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	h.serveMeal(w, r, meal.MealPlanID, id)
+}
+
+// serveMeal dispatches requests for a single meal within a plan
+func (h *Handler) serveMeal(w http.ResponseWriter, r *http.Request, mealPlanID, id string) {
 	switch r.Method {
 	case http.MethodGet:
-		h.getMeal(w, r, id)
+		h.getMeal(w, r, mealPlanID, id)
 	case http.MethodPut:
-		h.updateMeal(w, r, id)
+		h.updateMeal(w, r, mealPlanID, id)
 	case http.MethodDelete:
-		h.deleteMeal(w, r, id)
+		h.deleteMeal(w, r, mealPlanID, id)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-// handleMealPlanByID handles GET, PUT, and DELETE requests for /api/meal-plans/{id}
+// handleMealPlanByID handles requests under /api/meal-plans/{id}:
+//
+//	/api/meal-plans/{id}                    GET, PUT, DELETE the plan
+//	/api/meal-plans/{id}/meals              GET, POST the plan's meals
+//	/api/meal-plans/{id}/meals/{mealId}     GET, PUT, DELETE one meal
 func (h *Handler) handleMealPlanByID(w http.ResponseWriter, r *http.Request) {
-	// Extract meal plan ID from URL
-	id := strings.TrimPrefix(r.URL.Path, "/api/meal-plans/")
+	// Extract meal plan ID and any sub-resource from URL
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/meal-plans/"), "/")
+	id := parts[0]
 	if id == "" {
 		http.Error(w, "Invalid meal plan ID", http.StatusBadRequest)
 		return
 	}
 
+	switch {
+	case len(parts) == 1:
+	case len(parts) == 2 && parts[1] == "meals":
+		h.servePlanMeals(w, r, id)
+		return
+	case len(parts) == 3 && parts[1] == "meals" && parts[2] != "":
+		h.serveMeal(w, r, id, parts[2])
+		return
+	case len(parts) == 2 && parts[1] == "members":
+		h.serveMembers(w, r, id)
+		return
+	case len(parts) == 3 && parts[1] == "members" && parts[2] != "":
+		h.serveMember(w, r, id, parts[2])
+		return
+	case len(parts) == 2 && parts[1] == "transfer":
+		h.serveTransfer(w, r, id)
+		return
+	case len(parts) == 3 && parts[1] == "transfer" && parts[2] == "accept":
+		h.serveAcceptTransfer(w, r, id)
+		return
+	case len(parts) == 2 && parts[1] == "leave":
+		h.serveLeave(w, r, id)
+		return
+	case len(parts) == 2 && parts[1] == "invitations":
+		h.serveInvitations(w, r, id)
+		return
+	case len(parts) == 3 && parts[1] == "invitations" && parts[2] != "":
+		h.serveInvitation(w, r, id, parts[2])
+		return
+	case len(parts) == 2 && parts[1] == "share-codes":
+		h.serveShareCodes(w, r, id)
+		return
+	case len(parts) == 3 && parts[1] == "share-codes" && parts[2] != "":
+		h.serveShareCode(w, r, id, parts[2])
+		return
+	case len(parts) == 4 && parts[1] == "share-codes" && parts[2] != "" && parts[3] == "qr":
+		h.serveShareCodeQR(w, r, id, parts[2])
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		h.getMealPlan(w, r, id)
@@ -90,6 +248,23 @@ func (h *Handler) handleMealPlanByID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// servePlanMeals handles GET and POST requests for /api/meal-plans/{id}/meals
+func (h *Handler) servePlanMeals(w http.ResponseWriter, r *http.Request, mealPlanID string) {
+	switch r.Method {
+	case http.MethodGet:
+		h.listMeals(w, r, mealPlanID)
+	case http.MethodPost:
+		var req models.MealRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		h.createMeal(w, r, mealPlanID, req)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 // handleGenerateShareLink handles generating a sharing link for a meal plan
 func (h *Handler) handleGenerateShareLink(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -97,19 +272,15 @@ func (h *Handler) handleGenerateShareLink(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Validate token
-	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims, err := h.store.ValidateToken(tokenString)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
+	caller := principal(r)
 
 	// Parse request
 	var req struct {
 		MealPlanID string `json:"mealPlanId"`
 		Role       string `json:"role"`      // "editor" or "viewer"
 		ExpiresIn  int    `json:"expiresIn"` // expiration in hours (optional)
+		MaxUses    int    `json:"maxUses"`   // how many people can join with it (optional)
+		SingleUse  bool   `json:"singleUse"` // shorthand for maxUses of 1
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -133,37 +304,51 @@ func (h *Handler) handleGenerateShareLink(w http.ResponseWriter, r *http.Request
 		req.ExpiresIn = 7 * 24 // 7 days in hours
 	}
 
-	// Check if user has owner access to this meal plan
-	isOwner, err := h.store.CheckMealPlanOwnership(claims.UserID, req.MealPlanID)
-	if err != nil || !isOwner {
-		http.Error(w, "Only the owner can share a meal plan", http.StatusForbidden)
+	// Codes can be used any number of times unless limited
+	if req.MaxUses < 0 {
+		http.Error(w, "maxUses must not be negative", http.StatusBadRequest)
 		return
 	}
+	if req.SingleUse {
+		if req.MaxUses > 1 {
+			http.Error(w, "singleUse can't be combined with maxUses above 1", http.StatusBadRequest)
+			return
+		}
+		req.MaxUses = 1
+	}
 
-	// Generate a unique share code
-	code := uuid.New().String()
+	// Only owners can share the plan
+	if err := h.authorize(r.Context(), caller.UserID, req.MealPlanID, permSharePlan); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
 
 	// Calculate expiration time
 	expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Hour)
 
 	// Create share link information
 	shareCode := &models.ShareCode{
-		ID:         code,
 		MealPlanID: req.MealPlanID,
-		CreatedBy:  claims.UserID,
+		CreatedBy:  caller.UserID,
 		Role:       req.Role,
 		ExpiresAt:  expiresAt,
 		CreatedAt:  time.Now(),
+		MaxUses:    req.MaxUses,
 	}
 
-	// Store the share code
-	if err := h.store.CreateShareCode(shareCode); err != nil {
+	// Store the share code under a short code nobody else is using
+	err := h.store.WithTx(r.Context(), func(tx db.Store) error {
+		return createShortShareCode(r.Context(), tx, shareCode)
+	})
+	if err != nil {
 		http.Error(w, "Failed to create share code", http.StatusInternalServerError)
 		return
 	}
 
+	response := map[string]string{"code": shareCode.ID}
+	if link := h.joinURL(shareCode.ID); link != "" {
+		response["joinUrl"] = link
+	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"code": code,
-	})
+	json.NewEncoder(w).Encode(response)
 }
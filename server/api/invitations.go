@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"my-meal-planner/db"
+	"my-meal-planner/mailer"
+	"my-meal-planner/models"
+)
+
+// serveInvitations handles GET requests for /api/meal-plans/{id}/invitations,
+// listing the invitations nobody has signed in to accept yet
+func (h *Handler) serveInvitations(w http.ResponseWriter, r *http.Request, mealPlanID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	// Only owners can manage members, including future ones
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permManageMembers); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	invitations, err := h.store.ListInvitationsByPlan(r.Context(), mealPlanID)
+	if err != nil {
+		http.Error(w, "Failed to list invitations", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// serveInvitation handles DELETE requests for
+// /api/meal-plans/{id}/invitations/{invitationId}, cancelling an invitation
+func (h *Handler) serveInvitation(w http.ResponseWriter, r *http.Request, mealPlanID, invitationID string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permManageMembers); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	// Invitations to other plans are reported as missing
+	invitation, err := h.store.GetInvitation(r.Context(), invitationID)
+	if errors.Is(err, db.ErrInvitationNotFound) || (err == nil && invitation.MealPlanID != mealPlanID) {
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to cancel invitation", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.store.DeleteInvitation(r.Context(), invitationID); err != nil && !errors.Is(err, db.ErrInvitationNotFound) {
+		http.Error(w, "Failed to cancel invitation", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// inviteByEmail invites an email address without an account to a plan. The
+// invitation becomes access once someone signs in with that address.
+func inviteByEmail(r *http.Request, tx db.Store, mealPlanID, email, role string) (*models.Invitation, error) {
+	invitation := &models.Invitation{
+		MealPlanID: mealPlanID,
+		Email:      email,
+		Role:       role,
+		InvitedBy:  principal(r).UserID,
+	}
+	err := tx.CreateInvitation(r.Context(), invitation)
+	if errors.Is(err, db.ErrInvitationExists) {
+		return nil, newAPIError(http.StatusConflict, "This email address has already been invited")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// sendInvitation emails an invitation and reports whether it was sent. Without
+// a mailer nothing is sent, but the invitation still works.
+func (h *Handler) sendInvitation(ctx context.Context, invitation *models.Invitation) bool {
+	if h.mailer == nil {
+		return false
+	}
+
+	plan, err := h.store.GetMealPlan(ctx, invitation.MealPlanID)
+	if err != nil {
+		log.Printf("failed to send invitation %s: %v", invitation.ID, err)
+		return false
+	}
+	inviter := "Someone"
+	if user, err := h.store.GetUserByID(ctx, invitation.InvitedBy); err == nil && user.Name != "" {
+		inviter = user.Name
+	}
+	as := "a viewer"
+	if invitation.Role == "editor" {
+		as = "an editor"
+	}
+
+	err = h.mailer.Send(ctx, mailer.Message{
+		To:      invitation.Email,
+		Subject: "You're invited to a meal plan on My Meal Planner",
+		Body: fmt.Sprintf("%s invited you to join the meal plan %q as %s.\n\n"+
+			"Sign in or create an account with this email address to accept:\n\n%s\n",
+			inviter, plan.Name, as, frontendURL()),
+	})
+	if err != nil {
+		log.Printf("failed to send invitation %s: %v", invitation.ID, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"my-meal-planner/db"
+)
+
+const (
+	// defaultPageSize is the page size when a request for a later page has
+	// no limit
+	defaultPageSize = 100
+	// maxPageSize caps the limit a client can ask for
+	maxPageSize = 500
+)
+
+// nextCursorHeader carries the cursor for the next page of a list response.
+// It is absent on the last page.
+const nextCursorHeader = "X-Next-Cursor"
+
+// listOptions reads sort, filter and paging parameters from the query string.
+// Without a limit or cursor the whole list is returned, as it was before
+// paging; clients opt into pages by sending a limit.
+func listOptions(r *http.Request) (db.ListOptions, error) {
+	query := r.URL.Query()
+	opts := db.ListOptions{
+		Sort:     db.SortOrder(query.Get("sort")),
+		Day:      query.Get("day"),
+		MealType: query.Get("mealType"),
+		Text:     query.Get("text"),
+		Cursor:   query.Get("cursor"),
+	}
+	if opts.Cursor != "" {
+		opts.Limit = defaultPageSize
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxPageSize {
+			return opts, newAPIError(http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageSize))
+		}
+		opts.Limit = n
+	}
+	return opts, nil
+}
+
+// setNextCursor advertises the next page of a list response, if there is one
+func setNextCursor(w http.ResponseWriter, cursor string) {
+	if cursor != "" {
+		w.Header().Set(nextCursorHeader, cursor)
+	}
+}
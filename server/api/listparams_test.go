@@ -0,0 +1,44 @@
+package api_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestMealListsPageOnlyWhenAsked(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.seedUser("alice")
+	ts.seedPlan("plan-1", "alice")
+	for i := 0; i < 150; i++ {
+		ts.seedMeal(fmt.Sprintf("meal-%03d", i), "plan-1")
+	}
+	token := ts.accessToken(alice)
+
+	list := func(query string) (int, string) {
+		w := ts.serve(request(http.MethodGet, "/api/meal-plans/plan-1/meals"+query, token, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET meals%s = %d %q, want 200", query, w.Code, w.Body.String())
+		}
+		var meals []json.RawMessage
+		if err := json.NewDecoder(w.Body).Decode(&meals); err != nil {
+			t.Fatalf("decode meals: %v", err)
+		}
+		return len(meals), w.Header().Get("X-Next-Cursor")
+	}
+
+	// Clients that don't page get every meal
+	if n, cursor := list(""); n != 150 || cursor != "" {
+		t.Errorf("list without limit = %d meals, cursor %q; want all 150 and no cursor", n, cursor)
+	}
+
+	n, cursor := list("?limit=100")
+	if n != 100 || cursor == "" {
+		t.Fatalf("list with limit=100 = %d meals, cursor %q; want 100 and a cursor", n, cursor)
+	}
+	if n, next := list("?cursor=" + url.QueryEscape(cursor)); n != 50 || next != "" {
+		t.Errorf("next page = %d meals, cursor %q; want the other 50 and no cursor", n, next)
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"my-meal-planner/db"
+	"my-meal-planner/mailer"
+	"my-meal-planner/models"
+)
+
+// emailProvider prefixes the IDs of users created by signing in with a link
+const emailProvider = "email"
+
+// handleMagicLink handles POST requests for /auth/magic-link, emailing a
+// sign-in link. The response is the same whether or not the email belongs to
+// an account, so it can't be used to find out who has one. Sends are limited
+// per address and per client; see allowLinkEmail.
+func (h *Handler) handleMagicLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.mailer == nil {
+		http.Error(w, "Email sign-in is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	email, ok := normalizeEmail(req.Email)
+	if !ok {
+		http.Error(w, "A valid email address is required", http.StatusBadRequest)
+		return
+	}
+	if !h.allowLinkEmail(w, r, email) {
+		return
+	}
+
+	token, hash, err := db.NewMagicLinkToken()
+	if err != nil {
+		http.Error(w, "Failed to create sign-in link", http.StatusInternalServerError)
+		return
+	}
+	link := &models.MagicLink{TokenHash: hash, Email: email, ExpiresAt: time.Now().Add(db.MagicLinkTTL)}
+	if err := h.store.CreateMagicLink(r.Context(), link); err != nil {
+		http.Error(w, "Failed to create sign-in link", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.mailer.Send(r.Context(), mailer.Message{
+		To:      email,
+		Subject: "Sign in to My Meal Planner",
+		Body: fmt.Sprintf("Use this link to sign in to My Meal Planner:\n\n%s\n\n"+
+			"The link works once and expires in %d minutes. If you didn't ask to sign in, you can ignore this email.\n",
+			magicLinkURL(token), int(db.MagicLinkTTL.Minutes())),
+	})
+	if err != nil {
+		log.Printf("failed to send sign-in link: %v", err)
+		http.Error(w, "Failed to send sign-in email", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleMagicLinkVerify handles POST requests for /auth/magic-link/verify,
+// signing in with the token from an emailed link
+func (h *Handler) handleMagicLinkVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Whoever received the email controls the address, so the link signs in
+	// to the account with that email or creates one. Registration links also
+	// set the password the account was registered with. The link is used up
+	// only if signing in succeeds.
+	var user *models.User
+	err := h.store.WithTx(r.Context(), func(tx db.Store) error {
+		link, err := tx.ConsumeMagicLink(r.Context(), db.HashMagicLinkToken(req.Token))
+		if errors.Is(err, db.ErrMagicLinkNotFound) {
+			return newAPIError(http.StatusUnauthorized, "This sign-in link is invalid, has expired or has already been used")
+		}
+		if err != nil {
+			return err
+		}
+
+		user, err = tx.GetUserByEmail(r.Context(), link.Email)
+		switch {
+		case errors.Is(err, db.ErrUserNotFound):
+			user = newLinkUser(link)
+		case err != nil:
+			return err
+		case link.PasswordHash != "" && user.EmailVerified:
+			return newAPIError(http.StatusConflict, "An account with this email already exists")
+		case !user.EmailVerified && !isLocalUser(user):
+			// Someone signed in through a provider that didn't check they own
+			// this address, so the account can't be trusted with it
+			return newAPIError(http.StatusConflict, "An account with this email exists but its address was never confirmed; sign in with the provider you used before")
+		case !user.EmailVerified && link.PasswordHash == "":
+			return newAPIError(http.StatusConflict, "This account isn't confirmed yet; use the link from its confirmation email, or register again")
+		case !user.EmailVerified:
+			// Confirming an account registered earlier signs out whoever set
+			// its old password, which may not have been the owner
+			if err := signOutEverywhere(r.Context(), tx, user.ID); err != nil {
+				return err
+			}
+		}
+
+		user.EmailVerified = true
+		if err := tx.CreateOrUpdateUser(r.Context(), user); err != nil {
+			return err
+		}
+		if link.PasswordHash != "" {
+			if err := tx.SetPassword(r.Context(), user.ID, link.PasswordHash); err != nil {
+				return err
+			}
+		}
+		return tx.AcceptInvitations(r.Context(), user.ID)
+	})
+	if err != nil {
+		writeError(w, err, "Failed to sign in")
+		return
+	}
+
+	h.respondWithSession(w, r, user, http.StatusOK)
+}
+
+// newLinkUser returns the account a link creates for an email without one
+func newLinkUser(link *models.MagicLink) *models.User {
+	if link.PasswordHash != "" {
+		return &models.User{ID: localProvider + "|" + uuid.New().String(), Email: link.Email, Name: link.Name}
+	}
+	return &models.User{ID: emailProvider + "|" + uuid.New().String(), Email: link.Email, Name: link.Email}
+}
+
+// signOutEverywhere revokes all of a user's sessions and personal access tokens
+func signOutEverywhere(ctx context.Context, store db.Store, userID string) error {
+	sessions, err := store.ListSessionsByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := store.RevokeSession(ctx, session.ID); err != nil {
+			return err
+		}
+	}
+
+	tokens, err := store.ListAPITokensByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := store.DeleteAPIToken(ctx, token.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// magicLinkURL returns the address emailed for a link token. It opens the web
+// client, which posts the token back, so mail scanners that follow links
+// can't use it up.
+func magicLinkURL(token string) string {
+	return strings.TrimRight(frontendURL(), "/") + "/?magicToken=" + url.QueryEscape(token)
+}
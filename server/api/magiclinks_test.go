@@ -0,0 +1,47 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"my-meal-planner/db"
+	"my-meal-planner/models"
+)
+
+func TestFailedMagicLinkSignInKeepsTheLink(t *testing.T) {
+	ts := newTestServer(t)
+	ctx := context.Background()
+
+	// An account from a provider that never checked the address
+	unverified := &models.User{ID: "oidc|bob", Email: "bob@example.com", Name: "Bob"}
+	if err := ts.store.CreateOrUpdateUser(ctx, unverified); err != nil {
+		t.Fatalf("CreateOrUpdateUser: %v", err)
+	}
+	link := &models.MagicLink{TokenHash: db.HashMagicLinkToken("link-token"), Email: "bob@example.com", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := ts.store.CreateMagicLink(ctx, link); err != nil {
+		t.Fatalf("CreateMagicLink: %v", err)
+	}
+
+	verify := func() int {
+		return ts.serve(request(http.MethodPost, "/auth/magic-link/verify", "", map[string]string{"token": "link-token"})).Code
+	}
+	if code := verify(); code != http.StatusConflict {
+		t.Fatalf("verify = %d, want 409", code)
+	}
+	if code := verify(); code != http.StatusConflict {
+		t.Errorf("verify again = %d, want 409 since the failed sign-in didn't use the link up", code)
+	}
+
+	// Once the conflict is gone the same link signs in, and only once
+	if err := ts.store.DeleteUser(ctx, "oidc|bob"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	if code := verify(); code != http.StatusOK {
+		t.Errorf("verify after the conflict = %d, want 200", code)
+	}
+	if code := verify(); code != http.StatusUnauthorized {
+		t.Errorf("verify a used link = %d, want 401", code)
+	}
+}
@@ -1,39 +1,41 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"my-meal-planner/db"
 	"my-meal-planner/models"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// listMealPlans returns all meal plans the user has access to
+// listMealPlans returns a page of the meal plans the user has access to
 func (h *Handler) listMealPlans(w http.ResponseWriter, r *http.Request) {
-	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims, err := h.store.ValidateToken(tokenString)
+	caller := principal(r)
+
+	opts, err := listOptions(r)
 	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		writeError(w, err, "Invalid list parameters")
 		return
 	}
 
-	mealPlans := h.store.ListMealPlansByUser(claims.UserID)
+	mealPlans, next, err := h.store.ListMealPlansByUser(r.Context(), caller.UserID, opts)
+	if err != nil {
+		writeError(w, err, "Failed to list meal plans")
+		return
+	}
 
+	setNextCursor(w, next)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(mealPlans)
 }
 
 // createMealPlan creates a new meal plan
 func (h *Handler) createMealPlan(w http.ResponseWriter, r *http.Request) {
-	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims, err := h.store.ValidateToken(tokenString)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
+	caller := principal(r)
 
 	var req struct {
 		Name        string `json:"name"`
@@ -51,26 +53,32 @@ func (h *Handler) createMealPlan(w http.ResponseWriter, r *http.Request) {
 		Description: req.Description,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
-		CreatedBy:   claims.UserID,
+		CreatedBy:   caller.UserID,
 	}
 
-	if err := h.store.CreateMealPlan(mealPlan); err != nil {
-		http.Error(w, "Failed to create meal plan", http.StatusInternalServerError)
-		return
-	}
+	// Create the plan together with owner access for the creator
+	err := h.store.WithTx(r.Context(), func(tx db.Store) error {
+		if err := tx.CreateMealPlan(r.Context(), mealPlan); err != nil {
+			return newAPIError(http.StatusInternalServerError, "Failed to create meal plan")
+		}
 
-	// Create owner access for the creator
-	access := &models.MealPlanAccess{
-		UserID:     claims.UserID,
-		MealPlanID: mealPlan.ID,
-		Role:       "owner",
-	}
+		access := &models.MealPlanAccess{
+			UserID:     caller.UserID,
+			MealPlanID: mealPlan.ID,
+			Role:       "owner",
+		}
 
-	if err := h.store.CreateMealPlanAccess(access); err != nil {
-		http.Error(w, "Failed to create meal plan access", http.StatusInternalServerError)
+		if err := tx.CreateMealPlanAccess(r.Context(), access); err != nil {
+			return newAPIError(http.StatusInternalServerError, "Failed to create meal plan access")
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err, "Failed to create meal plan")
 		return
 	}
 
+	setETag(w, mealPlan.Version)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(mealPlan)
@@ -78,26 +86,15 @@ func (h *Handler) createMealPlan(w http.ResponseWriter, r *http.Request) {
 
 // getMealPlan returns a meal plan by ID
 func (h *Handler) getMealPlan(w http.ResponseWriter, r *http.Request, id string) {
-	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims, err := h.store.ValidateToken(tokenString)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
+	caller := principal(r)
 
-	// Check if user has access to this meal plan
-	hasAccess, err := h.store.CheckMealPlanAccess(claims.UserID, id)
-	if err != nil {
-		http.Error(w, "Failed to check access", http.StatusInternalServerError)
+	// Viewers and above can read the plan
+	if err := h.authorize(r.Context(), caller.UserID, id, permReadPlan); err != nil {
+		writeError(w, err, "Failed to check access")
 		return
 	}
 
-	if !hasAccess {
-		http.Error(w, "Access denied", http.StatusForbidden)
-		return
-	}
-
-	mealPlan, err := h.store.GetMealPlan(id)
+	mealPlan, err := h.store.GetMealPlan(r.Context(), id)
 	if err != nil {
 		if err == db.ErrMealPlanNotFound {
 			http.Error(w, "Meal plan not found", http.StatusNotFound)
@@ -107,28 +104,18 @@ func (h *Handler) getMealPlan(w http.ResponseWriter, r *http.Request, id string)
 		return
 	}
 
+	setETag(w, mealPlan.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(mealPlan)
 }
 
 // updateMealPlan updates a meal plan by ID
 func (h *Handler) updateMealPlan(w http.ResponseWriter, r *http.Request, id string) {
-	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims, err := h.store.ValidateToken(tokenString)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
+	caller := principal(r)
 
-	// Check if user has edit access to this meal plan
-	hasAccess, err := h.store.CheckMealPlanAccess(claims.UserID, id)
-	if err != nil {
-		http.Error(w, "Failed to check access", http.StatusInternalServerError)
-		return
-	}
-
-	if !hasAccess {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	// Editors and above can change the plan
+	if err := h.authorize(r.Context(), caller.UserID, id, permUpdatePlan); err != nil {
+		writeError(w, err, "Failed to check access")
 		return
 	}
 
@@ -143,7 +130,7 @@ func (h *Handler) updateMealPlan(w http.ResponseWriter, r *http.Request, id stri
 	}
 
 	// Get existing meal plan
-	existingPlan, err := h.store.GetMealPlan(id)
+	existingPlan, err := h.store.GetMealPlan(r.Context(), id)
 	if err != nil {
 		if err == db.ErrMealPlanNotFound {
 			http.Error(w, "Meal plan not found", http.StatusNotFound)
@@ -153,48 +140,61 @@ func (h *Handler) updateMealPlan(w http.ResponseWriter, r *http.Request, id stri
 		return
 	}
 
+	// Only replace the version the client last saw
+	existingPlan.Version, err = ifMatchVersion(r, existingPlan.Version)
+	if err != nil {
+		writeError(w, err, "Internal server error")
+		return
+	}
+
 	// Update meal plan fields
 	existingPlan.Name = req.Name
 	existingPlan.Description = req.Description
 	existingPlan.UpdatedAt = time.Now()
 
 	// Update the meal plan
-	if err := h.store.UpdateMealPlan(existingPlan); err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if err := h.store.UpdateMealPlan(r.Context(), existingPlan); err != nil {
+		writeError(w, versionConflict(err), "Internal server error")
 		return
 	}
 
+	setETag(w, existingPlan.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(existingPlan)
 }
 
 // deleteMealPlan deletes a meal plan by ID
 func (h *Handler) deleteMealPlan(w http.ResponseWriter, r *http.Request, id string) {
-	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims, err := h.store.ValidateToken(tokenString)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
+	caller := principal(r)
+
+	// Only owners can delete the plan
+	if err := h.authorize(r.Context(), caller.UserID, id, permDeletePlan); err != nil {
+		writeError(w, err, "Failed to check access")
 		return
 	}
 
-	// Check if user has owner access to this meal plan
-	hasAccess, err := h.store.CheckMealPlanAccess(claims.UserID, id)
+	mealPlan, err := h.store.GetMealPlan(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Failed to check access", http.StatusInternalServerError)
+		if err == db.ErrMealPlanNotFound {
+			http.Error(w, "Meal plan not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
 		return
 	}
 
-	if !hasAccess {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	version, err := ifMatchVersion(r, mealPlan.Version)
+	if err != nil {
+		writeError(w, err, "Internal server error")
 		return
 	}
 
-	err = h.store.DeleteMealPlan(id)
+	err = h.store.DeleteMealPlan(r.Context(), id, version)
 	if err != nil {
 		if err == db.ErrMealPlanNotFound {
 			http.Error(w, "Meal plan not found", http.StatusNotFound)
 		} else {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeError(w, versionConflict(err), "Internal server error")
 		}
 		return
 	}
@@ -202,20 +202,16 @@ func (h *Handler) deleteMealPlan(w http.ResponseWriter, r *http.Request, id stri
 	w.WriteHeader(http.StatusNoContent)
 }
 
-// handleShareMealPlan handles sharing a meal plan with another user
+// handleShareMealPlan handles sharing a meal plan with another user. Emails
+// without an account get an invitation, which is accepted when someone first
+// signs in with that address.
 func (h *Handler) handleShareMealPlan(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Validate token
-	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims, err := h.store.ValidateToken(tokenString)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
+	caller := principal(r)
 
 	// Parse request
 	var req struct {
@@ -234,6 +230,11 @@ func (h *Handler) handleShareMealPlan(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Meal plan ID and email are required", http.StatusBadRequest)
 		return
 	}
+	email, ok := normalizeEmail(req.Email)
+	if !ok {
+		http.Error(w, "A valid email address is required", http.StatusBadRequest)
+		return
+	}
 
 	// Validate role
 	if req.Role != "editor" && req.Role != "viewer" {
@@ -241,36 +242,58 @@ func (h *Handler) handleShareMealPlan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if user has owner access to this meal plan
-	isOwner, err := h.store.CheckMealPlanOwnership(claims.UserID, req.MealPlanID)
-	if err != nil || !isOwner {
-		http.Error(w, "Only the owner can share a meal plan", http.StatusForbidden)
+	// Only owners can share the plan
+	if err := h.authorize(r.Context(), caller.UserID, req.MealPlanID, permSharePlan); err != nil {
+		writeError(w, err, "Failed to check access")
 		return
 	}
 
-	// Find the user by email
-	user, err := h.store.GetUserByEmail(req.Email)
-	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
+	var invitation *models.Invitation
+	err := h.store.WithTx(r.Context(), func(tx db.Store) error {
+		// Find the user by email; people without an account yet are invited,
+		// as are accounts that never confirmed the address, so the access
+		// goes to whoever proves they own it
+		user, err := tx.GetUserByEmail(r.Context(), req.Email)
+		if errors.Is(err, db.ErrUserNotFound) && email != req.Email {
+			user, err = tx.GetUserByEmail(r.Context(), email)
+		}
+		if errors.Is(err, db.ErrUserNotFound) || (err == nil && !user.EmailVerified) {
+			invitation, err = inviteByEmail(r, tx, req.MealPlanID, email, req.Role)
+			return err
+		}
+		if err != nil {
+			return err
+		}
 
-	// Don't allow sharing with yourself
-	if user.ID == claims.UserID {
-		http.Error(w, "Cannot share with yourself", http.StatusBadRequest)
-		return
-	}
+		// Don't allow sharing with yourself
+		if user.ID == caller.UserID {
+			return newAPIError(http.StatusBadRequest, "Cannot share with yourself")
+		}
+
+		// Create access record
+		access := &models.MealPlanAccess{
+			ID:         uuid.New().String(),
+			UserID:     user.ID,
+			MealPlanID: req.MealPlanID,
+			Role:       req.Role,
+		}
 
-	// Create access record
-	access := &models.MealPlanAccess{
-		ID:         uuid.New().String(),
-		UserID:     user.ID,
-		MealPlanID: req.MealPlanID,
-		Role:       req.Role,
+		return tx.CreateMealPlanAccess(r.Context(), access)
+	})
+	if err != nil {
+		writeError(w, err, "Failed to share meal plan")
+		return
 	}
 
-	if err := h.store.CreateMealPlanAccess(access); err != nil {
-		http.Error(w, "Failed to share meal plan", http.StatusInternalServerError)
+	if invitation != nil {
+		sent := h.sendInvitation(r.Context(), invitation)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":    "Invitation created",
+			"invitation": invitation,
+			"emailSent":  sent,
+		})
 		return
 	}
 
@@ -287,13 +310,7 @@ func (h *Handler) handleJoinMealPlan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate token
-	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims, err := h.store.ValidateToken(tokenString)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
+	caller := principal(r)
 
 	// Parse request
 	var req struct {
@@ -311,57 +328,80 @@ func (h *Handler) handleJoinMealPlan(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get the share link information
-	shareLink, err := h.store.GetShareCode(req.Code)
+	shareLink, mealPlan, err := h.joinWithCode(r.Context(), caller.UserID, req.Code)
 	if err != nil {
-		http.Error(w, "Invalid share code", http.StatusNotFound)
+		writeError(w, err, "Failed to join meal plan")
 		return
 	}
 
-	// Check if the share link has expired
-	if shareLink.ExpiresAt.Before(time.Now()) {
-		http.Error(w, "Share link has expired", http.StatusForbidden)
-		return
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Successfully joined meal plan",
+		"mealPlan": mealPlan,
+		"role":     shareLink.Role,
+	})
+}
 
-	// Check if the user is the owner of the meal plan (can't join their own plan)
-	isOwner, _ := h.store.CheckMealPlanOwnership(claims.UserID, shareLink.MealPlanID)
-	if isOwner {
-		http.Error(w, "You already own this meal plan", http.StatusBadRequest)
-		return
-	}
+// joinWithCode gives userID the role a share code grants on its meal plan,
+// counting the use of the code
+func (h *Handler) joinWithCode(ctx context.Context, userID, code string) (*models.ShareCode, *models.MealPlan, error) {
+	var shareLink *models.ShareCode
+	var mealPlan *models.MealPlan
+	err := h.store.WithTx(ctx, func(tx db.Store) error {
+		// Get the share link information
+		var err error
+		shareLink, err = tx.GetShareCode(ctx, db.NormalizeShareCode(code))
+		if err != nil {
+			return newAPIError(http.StatusNotFound, "Invalid share code")
+		}
 
-	// Check if the user already has access to the meal plan
-	hasAccess, _ := h.store.CheckMealPlanAccess(claims.UserID, shareLink.MealPlanID)
-	if hasAccess {
-		http.Error(w, "You already have access to this meal plan", http.StatusBadRequest)
-		return
-	}
+		// Check if the share link has expired
+		if shareLink.ExpiresAt.Before(time.Now()) {
+			return newAPIError(http.StatusForbidden, "Share link has expired")
+		}
 
-	// Create access record
-	access := &models.MealPlanAccess{
-		ID:         uuid.New().String(),
-		UserID:     claims.UserID,
-		MealPlanID: shareLink.MealPlanID,
-		Role:       shareLink.Role,
-	}
+		// Check if the user is the owner of the meal plan (can't join their own plan)
+		isOwner, _ := tx.CheckMealPlanOwnership(ctx, userID, shareLink.MealPlanID)
+		if isOwner {
+			return newAPIError(http.StatusBadRequest, "You already own this meal plan")
+		}
 
-	if err := h.store.CreateMealPlanAccess(access); err != nil {
-		http.Error(w, "Failed to join meal plan", http.StatusInternalServerError)
-		return
-	}
+		// Check if the user already has access to the meal plan
+		hasAccess, _ := tx.CheckMealPlanAccess(ctx, userID, shareLink.MealPlanID)
+		if hasAccess {
+			return newAPIError(http.StatusBadRequest, "You already have access to this meal plan")
+		}
 
-	// Get the meal plan information to return to the client
-	mealPlan, err := h.store.GetMealPlan(shareLink.MealPlanID)
-	if err != nil {
-		http.Error(w, "Failed to get meal plan information", http.StatusInternalServerError)
-		return
-	}
+		// Count the use, which fails once a limited code has none left
+		err = tx.RedeemShareCode(ctx, shareLink.ID, userID, time.Now())
+		if errors.Is(err, db.ErrShareCodeUsedUp) {
+			return newAPIError(http.StatusForbidden, "Share link has already been used")
+		}
+		if err != nil {
+			return err
+		}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"message":  "Successfully joined meal plan",
-		"mealPlan": mealPlan,
-		"role":     shareLink.Role,
+		// Create access record
+		access := &models.MealPlanAccess{
+			ID:         uuid.New().String(),
+			UserID:     userID,
+			MealPlanID: shareLink.MealPlanID,
+			Role:       shareLink.Role,
+		}
+
+		if err := tx.CreateMealPlanAccess(ctx, access); err != nil {
+			return newAPIError(http.StatusInternalServerError, "Failed to join meal plan")
+		}
+
+		// Get the meal plan information to return to the client
+		mealPlan, err = tx.GetMealPlan(ctx, shareLink.MealPlanID)
+		if err != nil {
+			return newAPIError(http.StatusInternalServerError, "Failed to get meal plan information")
+		}
+		return nil
 	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return shareLink, mealPlan, nil
 }
@@ -1,88 +1,56 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"my-meal-planner/db"
 	"my-meal-planner/models"
 	"net/http"
-	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// listMeals returns all meals for a specific meal plan
-func (h *Handler) listMeals(w http.ResponseWriter, r *http.Request) {
-	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims, err := h.store.ValidateToken(tokenString)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
+// listMeals returns a page of the meals in a specific meal plan
+func (h *Handler) listMeals(w http.ResponseWriter, r *http.Request, mealPlanID string) {
+	caller := principal(r)
 
-	mealPlanID := r.URL.Query().Get("mealPlanId")
-	if mealPlanID == "" {
-		http.Error(w, "Meal plan ID is required", http.StatusBadRequest)
+	// Viewers and above can read meals
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permReadMeals); err != nil {
+		writeError(w, err, "Failed to check access")
 		return
 	}
 
-	// Check if user has access to this meal plan
-	hasAccess, err := h.store.CheckMealPlanAccess(claims.UserID, mealPlanID)
+	opts, err := listOptions(r)
 	if err != nil {
-		http.Error(w, "Failed to check access", http.StatusInternalServerError)
+		writeError(w, err, "Invalid list parameters")
 		return
 	}
 
-	if !hasAccess {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	meals, next, err := h.store.ListMealsByPlan(r.Context(), mealPlanID, opts)
+	if err != nil {
+		writeError(w, err, "Failed to list meals")
 		return
 	}
 
-	meals := h.store.ListMealsByPlan(mealPlanID)
-
+	setNextCursor(w, next)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(meals)
 }
 
 // createMeal creates a new meal in a meal plan
-func (h *Handler) createMeal(w http.ResponseWriter, r *http.Request) {
-	tokenString := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
-	claims, err := h.store.ValidateToken(tokenString)
-	if err != nil {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-
-	var req struct {
-		models.MealRequest `json:"meal"`
-		MealPlanID         string `json:"mealPlanId"`
-	}
-
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
+func (h *Handler) createMeal(w http.ResponseWriter, r *http.Request, mealPlanID string, req models.MealRequest) {
+	caller := principal(r)
 
-	if req.MealPlanID == "" {
-		http.Error(w, "Meal plan ID is required", http.StatusBadRequest)
-		return
-	}
-
-	// Check if user has edit access to this meal plan
-	hasAccess, err := h.store.CheckMealPlanAccess(claims.UserID, req.MealPlanID)
-	if err != nil {
-		http.Error(w, "Failed to check access", http.StatusInternalServerError)
-		return
-	}
-
-	if !hasAccess {
-		http.Error(w, "Access denied", http.StatusForbidden)
+	// Editors and above can add meals
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permWriteMeals); err != nil {
+		writeError(w, err, "Failed to check access")
 		return
 	}
 
 	meal := &models.Meal{
 		ID:          uuid.New().String(),
-		MealPlanID:  req.MealPlanID,
+		MealPlanID:  mealPlanID,
 		Name:        req.Name,
 		Description: req.Description,
 		Day:         req.Day,
@@ -91,34 +59,36 @@ func (h *Handler) createMeal(w http.ResponseWriter, r *http.Request) {
 		UpdatedAt:   time.Now(),
 	}
 
-	if err := h.store.CreateMeal(meal); err != nil {
+	if err := h.store.CreateMeal(r.Context(), meal); err != nil {
 		http.Error(w, "Failed to create meal", http.StatusInternalServerError)
 		return
 	}
 
+	setETag(w, meal.Version)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(meal)
 }
 
 // getMeal returns a meal by ID
-func (h *Handler) getMeal(w http.ResponseWriter, r *http.Request, id string) {
-	meal, err := h.store.GetMeal(id)
+func (h *Handler) getMeal(w http.ResponseWriter, r *http.Request, mealPlanID, id string) {
+	caller := principal(r)
+
+	meal, err := h.planMeal(r.Context(), caller.UserID, mealPlanID, id, permReadMeals)
 	if err != nil {
-		if err == db.ErrMealNotFound {
-			http.Error(w, "Meal not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+		writeError(w, err, "Internal server error")
 		return
 	}
 
+	setETag(w, meal.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(meal)
 }
 
 // updateMeal updates a meal by ID
-func (h *Handler) updateMeal(w http.ResponseWriter, r *http.Request, id string) {
+func (h *Handler) updateMeal(w http.ResponseWriter, r *http.Request, mealPlanID, id string) {
+	caller := principal(r)
+
 	var req models.MealRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -132,13 +102,16 @@ func (h *Handler) updateMeal(w http.ResponseWriter, r *http.Request, id string)
 	}
 
 	// Get existing meal
-	existingMeal, err := h.store.GetMeal(id)
+	existingMeal, err := h.planMeal(r.Context(), caller.UserID, mealPlanID, id, permWriteMeals)
 	if err != nil {
-		if err == db.ErrMealNotFound {
-			http.Error(w, "Meal not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
+		writeError(w, err, "Internal server error")
+		return
+	}
+
+	// Only replace the version the client last saw
+	existingMeal.Version, err = ifMatchVersion(r, existingMeal.Version)
+	if err != nil {
+		writeError(w, err, "Internal server error")
 		return
 	}
 
@@ -150,26 +123,59 @@ func (h *Handler) updateMeal(w http.ResponseWriter, r *http.Request, id string)
 	existingMeal.UpdatedAt = time.Now()
 
 	// Update the meal
-	if err := h.store.UpdateMeal(existingMeal); err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if err := h.store.UpdateMeal(r.Context(), existingMeal); err != nil {
+		writeError(w, versionConflict(err), "Internal server error")
 		return
 	}
 
+	setETag(w, existingMeal.Version)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(existingMeal)
 }
 
 // deleteMeal deletes a meal by ID
-func (h *Handler) deleteMeal(w http.ResponseWriter, r *http.Request, id string) {
-	err := h.store.DeleteMeal(id)
+func (h *Handler) deleteMeal(w http.ResponseWriter, r *http.Request, mealPlanID, id string) {
+	caller := principal(r)
+
+	meal, err := h.planMeal(r.Context(), caller.UserID, mealPlanID, id, permWriteMeals)
+	if err != nil {
+		writeError(w, err, "Internal server error")
+		return
+	}
+
+	version, err := ifMatchVersion(r, meal.Version)
+	if err != nil {
+		writeError(w, err, "Internal server error")
+		return
+	}
+
+	err = h.store.DeleteMeal(r.Context(), id, version)
 	if err != nil {
 		if err == db.ErrMealNotFound {
 			http.Error(w, "Meal not found", http.StatusNotFound)
 		} else {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			writeError(w, versionConflict(err), "Internal server error")
 		}
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// planMeal authorizes perm on a meal plan and returns one of its meals.
+// A meal that belongs to a different plan is reported as not found, so
+// access to one plan never reveals the meals of another.
+func (h *Handler) planMeal(ctx context.Context, userID, mealPlanID, id string, perm permission) (*models.Meal, error) {
+	if err := h.authorize(ctx, userID, mealPlanID, perm); err != nil {
+		return nil, err
+	}
+
+	meal, err := h.store.GetMeal(ctx, id)
+	if err == db.ErrMealNotFound || (err == nil && meal.MealPlanID != mealPlanID) {
+		return nil, newAPIError(http.StatusNotFound, "Meal not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return meal, nil
+}
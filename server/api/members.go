@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"my-meal-planner/db"
+	"my-meal-planner/models"
+)
+
+// serveMembers handles GET requests for /api/meal-plans/{id}/members
+func (h *Handler) serveMembers(w http.ResponseWriter, r *http.Request, mealPlanID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	// Anyone who can see the plan can see who else can
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permReadPlan); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	members, err := h.store.ListMealPlanMembers(r.Context(), mealPlanID)
+	if err != nil {
+		writeError(w, err, "Failed to list members")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+// serveMember handles PATCH and DELETE requests for
+// /api/meal-plans/{id}/members/{userId}, changing a member's role or removing
+// them from the plan
+func (h *Handler) serveMember(w http.ResponseWriter, r *http.Request, mealPlanID, userID string) {
+	switch r.Method {
+	case http.MethodPatch:
+		h.updateMember(w, r, mealPlanID, userID)
+	case http.MethodDelete:
+		h.removeMember(w, r, mealPlanID, userID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// updateMember changes a member's role to editor or viewer
+func (h *Handler) updateMember(w http.ResponseWriter, r *http.Request, mealPlanID, userID string) {
+	caller := principal(r)
+
+	// Only owners can manage members
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permManageMembers); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"` // "editor" or "viewer"
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role != "editor" && req.Role != "viewer" {
+		http.Error(w, "Invalid role. Must be 'editor' or 'viewer'", http.StatusBadRequest)
+		return
+	}
+
+	var member *models.MealPlanMember
+	err := h.store.WithTx(r.Context(), func(tx db.Store) error {
+		if err := checkManageableMember(r, tx, mealPlanID, userID); err != nil {
+			return err
+		}
+		if err := tx.SetMemberRole(r.Context(), mealPlanID, userID, req.Role); err != nil {
+			return memberNotFound(err)
+		}
+
+		members, err := tx.ListMealPlanMembers(r.Context(), mealPlanID)
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			if m.UserID == userID {
+				member = m
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, err, "Failed to update member")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(member)
+}
+
+// removeMember takes away a member's access to the plan
+func (h *Handler) removeMember(w http.ResponseWriter, r *http.Request, mealPlanID, userID string) {
+	caller := principal(r)
+
+	// Only owners can manage members
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permManageMembers); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	err := h.store.WithTx(r.Context(), func(tx db.Store) error {
+		if err := checkManageableMember(r, tx, mealPlanID, userID); err != nil {
+			return err
+		}
+		if err := tx.RemoveMember(r.Context(), mealPlanID, userID); err != nil {
+			return memberNotFound(err)
+		}
+		return withdrawTransferTo(r, tx, mealPlanID, userID)
+	})
+	if err != nil {
+		writeError(w, err, "Failed to remove member")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkManageableMember checks that userID is a member of the plan whose role
+// an owner may change. Owners themselves can't be demoted or removed, so a
+// plan always keeps one; ownership changes hands by transfer instead.
+func checkManageableMember(r *http.Request, tx db.Store, mealPlanID, userID string) error {
+	role, err := tx.GetRole(r.Context(), userID, mealPlanID)
+	if errors.Is(err, db.ErrAccessDenied) {
+		return newAPIError(http.StatusNotFound, "Member not found")
+	}
+	if err != nil {
+		return err
+	}
+	if role == "owner" {
+		return newAPIError(http.StatusConflict, "The plan's owner can't be changed or removed")
+	}
+	return nil
+}
+
+// memberNotFound converts a missing member into a 404 response
+func memberNotFound(err error) error {
+	if errors.Is(err, db.ErrMemberNotFound) {
+		return newAPIError(http.StatusNotFound, "Member not found")
+	}
+	return err
+}
@@ -0,0 +1,302 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"my-meal-planner/db"
+	"my-meal-planner/models"
+)
+
+// ownershipTransferTTL is how long the recipient has to accept a transfer
+const ownershipTransferTTL = 7 * 24 * time.Hour
+
+// incomingTransfer describes a transfer offered to the caller
+type incomingTransfer struct {
+	models.OwnershipTransfer
+	MealPlanName string `json:"mealPlanName"`
+	FromName     string `json:"fromName"`
+}
+
+// serveTransfer handles GET, POST and DELETE requests for
+// /api/meal-plans/{id}/transfer: seeing, offering and withdrawing or declining
+// the plan's pending ownership transfer
+func (h *Handler) serveTransfer(w http.ResponseWriter, r *http.Request, mealPlanID string) {
+	switch r.Method {
+	case http.MethodGet:
+		h.getTransfer(w, r, mealPlanID)
+	case http.MethodPost:
+		h.offerTransfer(w, r, mealPlanID)
+	case http.MethodDelete:
+		h.cancelTransfer(w, r, mealPlanID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getTransfer returns the plan's pending ownership transfer
+func (h *Handler) getTransfer(w http.ResponseWriter, r *http.Request, mealPlanID string) {
+	caller := principal(r)
+
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permReadPlan); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	transfer, err := pendingTransfer(r, h.store, mealPlanID)
+	if err != nil {
+		writeError(w, err, "Failed to get ownership transfer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(transfer)
+}
+
+// offerTransfer offers the plan to another member. It replaces any offer
+// already pending; the caller stays owner until the recipient accepts.
+func (h *Handler) offerTransfer(w http.ResponseWriter, r *http.Request, mealPlanID string) {
+	caller := principal(r)
+
+	// Only owners can give the plan away
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permTransferPlan); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	var req struct {
+		UserID string `json:"userId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == caller.UserID {
+		http.Error(w, "You already own this meal plan", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	transfer := &models.OwnershipTransfer{
+		MealPlanID: mealPlanID,
+		FromUserID: caller.UserID,
+		ToUserID:   req.UserID,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ownershipTransferTTL),
+	}
+	err := h.store.WithTx(r.Context(), func(tx db.Store) error {
+		// The plan can only go to someone who already has access to it
+		if _, err := tx.GetRole(r.Context(), req.UserID, mealPlanID); err != nil {
+			if errors.Is(err, db.ErrAccessDenied) {
+				return newAPIError(http.StatusBadRequest, "Ownership can only be transferred to a member of the plan")
+			}
+			return err
+		}
+		return tx.CreateOwnershipTransfer(r.Context(), transfer)
+	})
+	if err != nil {
+		writeError(w, err, "Failed to offer ownership transfer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(transfer)
+}
+
+// cancelTransfer lets the owner withdraw the pending transfer, or its
+// recipient decline it
+func (h *Handler) cancelTransfer(w http.ResponseWriter, r *http.Request, mealPlanID string) {
+	caller := principal(r)
+
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permReadPlan); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	err := h.store.WithTx(r.Context(), func(tx db.Store) error {
+		transfer, err := pendingTransfer(r, tx, mealPlanID)
+		if err != nil {
+			return err
+		}
+		if caller.UserID != transfer.ToUserID {
+			if ok, _ := tx.CheckMealPlanOwnership(r.Context(), caller.UserID, mealPlanID); !ok {
+				return newAPIError(http.StatusForbidden, "Only the owner or the recipient can cancel an ownership transfer")
+			}
+		}
+		return tx.DeleteOwnershipTransfer(r.Context(), mealPlanID)
+	})
+	if err != nil {
+		writeError(w, transferNotFound(err), "Failed to cancel ownership transfer")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveAcceptTransfer handles POST requests for
+// /api/meal-plans/{id}/transfer/accept, making the caller the plan's owner.
+// The previous owner stays on as an editor.
+func (h *Handler) serveAcceptTransfer(w http.ResponseWriter, r *http.Request, mealPlanID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	var mealPlan *models.MealPlan
+	err := h.store.WithTx(r.Context(), func(tx db.Store) error {
+		transfer, err := pendingTransfer(r, tx, mealPlanID)
+		if err != nil {
+			return err
+		}
+		if transfer.ToUserID != caller.UserID {
+			return newAPIError(http.StatusForbidden, "This ownership transfer was offered to someone else")
+		}
+
+		// The offer lapses if whoever made it is no longer the owner
+		if ok, _ := tx.CheckMealPlanOwnership(r.Context(), transfer.FromUserID, mealPlanID); !ok {
+			if err := tx.DeleteOwnershipTransfer(r.Context(), mealPlanID); err != nil {
+				return err
+			}
+			return newAPIError(http.StatusConflict, "The user who offered this meal plan no longer owns it")
+		}
+
+		if err := tx.SetMemberRole(r.Context(), mealPlanID, caller.UserID, "owner"); err != nil {
+			if errors.Is(err, db.ErrMemberNotFound) {
+				return newAPIError(http.StatusConflict, "You are no longer a member of this meal plan")
+			}
+			return err
+		}
+		if err := tx.SetMemberRole(r.Context(), mealPlanID, transfer.FromUserID, "editor"); err != nil {
+			return err
+		}
+		if err := tx.DeleteOwnershipTransfer(r.Context(), mealPlanID); err != nil {
+			return err
+		}
+
+		mealPlan, err = tx.GetMealPlan(r.Context(), mealPlanID)
+		return err
+	})
+	if err != nil {
+		writeError(w, err, "Failed to accept ownership transfer")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "You now own this meal plan",
+		"mealPlan": mealPlan,
+		"role":     "owner",
+	})
+}
+
+// handleIncomingTransfers handles GET requests for /api/ownership-transfers,
+// listing the pending transfers offered to the caller
+func (h *Handler) handleIncomingTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	transfers, err := h.store.ListOwnershipTransfersByRecipient(r.Context(), caller.UserID)
+	if err != nil {
+		http.Error(w, "Failed to list ownership transfers", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	resp := make([]incomingTransfer, 0, len(transfers))
+	for _, transfer := range transfers {
+		if transfer.Expired(now) {
+			continue
+		}
+		incoming := incomingTransfer{OwnershipTransfer: *transfer}
+		if plan, err := h.store.GetMealPlan(r.Context(), transfer.MealPlanID); err == nil {
+			incoming.MealPlanName = plan.Name
+		}
+		if user, err := h.store.GetUserByID(r.Context(), transfer.FromUserID); err == nil {
+			incoming.FromName = user.Name
+		}
+		resp = append(resp, incoming)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serveLeave handles POST requests for /api/meal-plans/{id}/leave, removing
+// the caller from a plan they don't own
+func (h *Handler) serveLeave(w http.ResponseWriter, r *http.Request, mealPlanID string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permReadPlan); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	err := h.store.WithTx(r.Context(), func(tx db.Store) error {
+		// A plan always keeps its owner
+		if ok, _ := tx.CheckMealPlanOwnership(r.Context(), caller.UserID, mealPlanID); ok {
+			return newAPIError(http.StatusConflict, "Transfer ownership of the meal plan before leaving it")
+		}
+		if err := tx.RemoveMember(r.Context(), mealPlanID, caller.UserID); err != nil {
+			return memberNotFound(err)
+		}
+		return withdrawTransferTo(r, tx, mealPlanID, caller.UserID)
+	})
+	if err != nil {
+		writeError(w, err, "Failed to leave meal plan")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pendingTransfer returns the plan's ownership transfer, reporting a missing
+// or expired one as a 404
+func pendingTransfer(r *http.Request, store db.Store, mealPlanID string) (*models.OwnershipTransfer, error) {
+	transfer, err := store.GetOwnershipTransfer(r.Context(), mealPlanID)
+	if err != nil {
+		return nil, transferNotFound(err)
+	}
+	if transfer.Expired(time.Now()) {
+		return nil, newAPIError(http.StatusNotFound, "No ownership transfer is pending for this meal plan")
+	}
+	return transfer, nil
+}
+
+// withdrawTransferTo deletes the plan's pending transfer if it was offered to
+// userID, who is losing access
+func withdrawTransferTo(r *http.Request, tx db.Store, mealPlanID, userID string) error {
+	transfer, err := tx.GetOwnershipTransfer(r.Context(), mealPlanID)
+	if errors.Is(err, db.ErrTransferNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if transfer.ToUserID != userID {
+		return nil
+	}
+	return tx.DeleteOwnershipTransfer(r.Context(), mealPlanID)
+}
+
+// transferNotFound converts a missing transfer into a 404 response
+func transferNotFound(err error) error {
+	if errors.Is(err, db.ErrTransferNotFound) {
+		return newAPIError(http.StatusNotFound, "No ownership transfer is pending for this meal plan")
+	}
+	return err
+}
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"my-meal-planner/auth"
+	"my-meal-planner/db"
+	"my-meal-planner/mailer"
+	"my-meal-planner/models"
+)
+
+// Failed logins before an account is locked, and for how long
+const (
+	maxFailedLogins = 5
+	lockoutDuration = 15 * time.Minute
+)
+
+// localProvider prefixes the IDs of users who sign in with a password
+const localProvider = "local"
+
+// invalidCredentials is the same for unknown emails and wrong passwords
+const invalidCredentials = "Invalid email or password"
+
+// dummyPasswordHash is verified against when there is no real hash, so a login
+// for an unknown email takes as long as one with a wrong password
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := auth.HashPassword("not a real password")
+	if err != nil {
+		log.Printf("failed to create dummy password hash: %v", err)
+	}
+	return hash
+})
+
+// handleRegister handles POST requests for /auth/register. Nothing is created
+// until the email address is confirmed: the name and password hash wait in an
+// emailed link, and using the link creates the account and signs it in.
+func (h *Handler) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.mailer == nil {
+		http.Error(w, "Registration is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Name     string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email, ok := normalizeEmail(req.Email)
+	if !ok {
+		http.Error(w, "A valid email address is required", http.StatusBadRequest)
+		return
+	}
+	if err := auth.CheckPassword(req.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		name = email
+	}
+
+	// A local account that was never confirmed can be registered again; the
+	// confirmation replaces whatever password it had
+	user, err := h.store.GetUserByEmail(r.Context(), email)
+	if err == nil && (user.EmailVerified || !isLocalUser(user)) {
+		http.Error(w, "An account with this email already exists", http.StatusConflict)
+		return
+	}
+	if err != nil && !errors.Is(err, db.ErrUserNotFound) {
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+	if !h.allowLinkEmail(w, r, email) {
+		return
+	}
+
+	hash, err := auth.HashPassword(req.Password)
+	if err != nil {
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+
+	token, tokenHash, err := db.NewMagicLinkToken()
+	if err != nil {
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+	link := &models.MagicLink{
+		TokenHash:    tokenHash,
+		Email:        email,
+		Name:         name,
+		PasswordHash: hash,
+		ExpiresAt:    time.Now().Add(db.MagicLinkTTL),
+	}
+	if err := h.store.CreateMagicLink(r.Context(), link); err != nil {
+		http.Error(w, "Failed to create account", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.mailer.Send(r.Context(), mailer.Message{
+		To:      email,
+		Subject: "Confirm your My Meal Planner account",
+		Body: fmt.Sprintf("Use this link to confirm your email address and finish creating your account:\n\n%s\n\n"+
+			"The link works once and expires in %d minutes. If you didn't create an account, you can ignore this email.\n",
+			magicLinkURL(token), int(db.MagicLinkTTL.Minutes())),
+	})
+	if err != nil {
+		log.Printf("failed to send confirmation link: %v", err)
+		http.Error(w, "Failed to send confirmation email", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleLogin handles POST requests for /auth/login. Repeated failures lock
+// the account for lockoutDuration.
+func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	email, _ := normalizeEmail(req.Email)
+
+	user, err := h.store.GetUserByEmail(r.Context(), email)
+	if errors.Is(err, db.ErrUserNotFound) {
+		auth.VerifyPassword(dummyPasswordHash(), req.Password)
+		http.Error(w, invalidCredentials, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	// Accounts created through an identity provider have no password
+	password, err := h.store.GetPassword(r.Context(), user.ID)
+	if errors.Is(err, db.ErrPasswordNotFound) {
+		auth.VerifyPassword(dummyPasswordHash(), req.Password)
+		http.Error(w, invalidCredentials, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	if password.Locked(now) {
+		retryAfter := int(password.LockedUntil.Sub(now).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		http.Error(w, "Too many failed logins; try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	ok, err := auth.VerifyPassword(password.Hash, req.Password)
+	if err != nil {
+		log.Printf("failed to verify password for user %s: %v", user.ID, err)
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		if err := h.store.RecordFailedLogin(r.Context(), user.ID, maxFailedLogins, now.Add(lockoutDuration)); err != nil {
+			log.Printf("failed to record failed login for user %s: %v", user.ID, err)
+		}
+		http.Error(w, invalidCredentials, http.StatusUnauthorized)
+		return
+	}
+
+	if password.FailedAttempts > 0 {
+		if err := h.store.ResetFailedLogins(r.Context(), user.ID); err != nil {
+			log.Printf("failed to reset failed logins for user %s: %v", user.ID, err)
+		}
+	}
+
+	// Checked only after the password, so it doesn't reveal who has an account
+	if !user.EmailVerified {
+		http.Error(w, "Confirm your email address before signing in; register again to get a new confirmation link", http.StatusForbidden)
+		return
+	}
+
+	h.respondWithSession(w, r, user, http.StatusOK)
+}
+
+// handleChangePassword handles POST requests for /api/account/password. The
+// caller's other sessions are signed out, since the old password may have
+// been used to start them.
+func (h *Handler) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	var req struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if err := auth.CheckPassword(req.NewPassword); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	password, err := h.store.GetPassword(r.Context(), caller.UserID)
+	if errors.Is(err, db.ErrPasswordNotFound) {
+		http.Error(w, "This account signs in through an identity provider and has no password", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	// A locked account can't be used to guess the current password either
+	now := time.Now()
+	if password.Locked(now) {
+		http.Error(w, "Too many failed logins; try again later", http.StatusTooManyRequests)
+		return
+	}
+	ok, err := auth.VerifyPassword(password.Hash, req.CurrentPassword)
+	if err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		if err := h.store.RecordFailedLogin(r.Context(), caller.UserID, maxFailedLogins, now.Add(lockoutDuration)); err != nil {
+			log.Printf("failed to record failed login for user %s: %v", caller.UserID, err)
+		}
+		http.Error(w, "Current password is incorrect", http.StatusForbidden)
+		return
+	}
+
+	hash, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+	if err := h.store.SetPassword(r.Context(), caller.UserID, hash); err != nil {
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	sessions, err := h.store.ListSessionsByUser(r.Context(), caller.UserID)
+	if err != nil {
+		http.Error(w, "Password changed, but other sessions could not be signed out", http.StatusInternalServerError)
+		return
+	}
+	for _, session := range sessions {
+		if session.ID == caller.SessionID || !session.Active(now) {
+			continue
+		}
+		if err := h.store.RevokeSession(r.Context(), session.ID); err != nil {
+			http.Error(w, "Password changed, but other sessions could not be signed out", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// isLocalUser reports whether a user signed up with a password rather than
+// through an identity provider or an emailed link
+func isLocalUser(user *models.User) bool {
+	return strings.HasPrefix(user.ID, localProvider+"|")
+}
+
+// normalizeEmail trims and lowercases an email address and reports whether
+// it is a plain address
+func normalizeEmail(email string) (string, bool) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return email, false
+	}
+	return email, true
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"net/http"
+)
+
+// Principal is the authenticated caller of a request, as established by
+// authMiddleware from the request's credentials
+type Principal struct {
+	UserID string
+	Email  string
+	// TokenID identifies the credential used, such as a JWT's jti claim
+	TokenID string
+	// SessionID is the session the credential was issued to
+	SessionID string
+	// Scopes limits what the credential may be used for; empty means unrestricted
+	Scopes []string
+}
+
+type principalKey struct{}
+
+// withPrincipal returns a copy of ctx carrying p
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFrom returns the principal stored in ctx by authMiddleware
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// principal returns the caller of a request that passed authMiddleware.
+// Handlers behind the middleware can rely on it being set.
+func principal(r *http.Request) *Principal {
+	p, ok := PrincipalFrom(r.Context())
+	if !ok {
+		panic("api: principal requested outside authMiddleware")
+	}
+	return p
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"my-meal-planner/auth"
+	"my-meal-planner/db"
+	"my-meal-planner/models"
+)
+
+// stateCookie carries the state, nonce and PKCE verifier of a sign-in from
+// the login redirect to the callback
+const stateCookie = "oauth_state"
+
+// legacyProvider signed users in before other providers existed. Its users
+// keep the provider's bare subject as their ID; everyone else's is prefixed
+// with the provider name so subjects from different issuers can't collide.
+const legacyProvider = "google"
+
+// handleProviders lists the identity providers users can sign in with
+func (h *Handler) handleProviders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type providerResponse struct {
+		Name     string `json:"name"`
+		LoginURL string `json:"loginUrl"`
+	}
+	resp := make([]providerResponse, 0, len(h.providers))
+	for name := range h.providers {
+		resp = append(resp, providerResponse{Name: name, LoginURL: "/auth/" + name + "/login"})
+	}
+	sort.Slice(resp, func(i, j int) bool { return resp[i].Name < resp[j].Name })
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleProviderAuth routes /auth/{provider}/login and /auth/{provider}/callback
+func (h *Handler) handleProviderAuth(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/auth/"), "/")
+	provider, exists := h.providers[name]
+	if !exists {
+		http.Error(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "login":
+		h.providerLogin(w, r, provider)
+	case "callback":
+		h.providerCallback(w, r, provider)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// providerLogin sends the user to the provider's login page
+func (h *Handler) providerLogin(w http.ResponseWriter, r *http.Request, provider auth.Provider) {
+	state, err := randomToken()
+	if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("sign-in with %s failed: %v", provider.Name(), err)
+		http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// Remember the sign-in for the callback, which only this browser can complete
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    strings.Join([]string{state, nonce, verifier}, "."),
+		Path:     "/auth/" + provider.Name(),
+		MaxAge:   int((5 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+}
+
+// providerCallback completes a sign-in: it checks the state, redeems the code
+// for the user's identity and starts a session
+func (h *Handler) providerCallback(w http.ResponseWriter, r *http.Request, provider auth.Provider) {
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		http.Error(w, "Sign-in was cancelled or refused: "+reason, http.StatusBadRequest)
+		return
+	}
+
+	cookie, err := r.Cookie(stateCookie)
+	if err != nil {
+		http.Error(w, "Sign-in expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookie,
+		Value:    "",
+		Path:     "/auth/" + provider.Name(),
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || query.Get("state") == "" || query.Get("state") != parts[0] {
+		http.Error(w, "State mismatch", http.StatusBadRequest)
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	code := query.Get("code")
+	if code == "" {
+		http.Error(w, "Authorization code missing", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), code, nonce, verifier)
+	if err != nil {
+		log.Printf("sign-in with %s failed: %v", provider.Name(), err)
+		http.Error(w, "Failed to verify sign-in", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := h.signIn(r.Context(), provider.Name(), identity)
+	if err != nil {
+		writeError(w, err, "Failed to save user")
+		return
+	}
+
+	// Start a session; the frontend exchanges its refresh token cookie for an
+	// access token, so no token ever appears in a URL
+	if _, err := h.startSession(w, r, user); err != nil {
+		http.Error(w, "Failed to start session: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Redirect back to the frontend
+	http.Redirect(w, r, frontendURL(), http.StatusTemporaryRedirect)
+}
+
+// frontendURL returns the address of the web client
+func frontendURL() string {
+	if url := os.Getenv("FRONTEND_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:5173" // fallback for local dev
+}
+
+// signIn creates or updates the user for an identity. An identity with a
+// verified email signs in to the account already using that email, so the
+// same person can use more than one provider, but only if that account has
+// confirmed the email too.
+func (h *Handler) signIn(ctx context.Context, providerName string, identity *auth.Identity) (*models.User, error) {
+	userID := providerName + "|" + identity.Subject
+	if providerName == legacyProvider {
+		userID = identity.Subject
+	}
+
+	// Addresses are stored lowercased, as local and magic-link accounts are
+	email, ok := normalizeEmail(identity.Email)
+	if !ok {
+		return nil, newAPIError(http.StatusBadRequest, "The identity provider didn't return a valid email address")
+	}
+
+	user, err := h.store.GetUserByID(ctx, userID)
+	if errors.Is(err, db.ErrUserNotFound) {
+		user, err = h.store.GetUserByEmail(ctx, email)
+		switch {
+		case errors.Is(err, db.ErrUserNotFound):
+			user, err = &models.User{ID: userID}, nil
+		case err == nil && !identity.EmailVerified:
+			return nil, newAPIError(http.StatusConflict, "An account with this email already exists; sign in with the provider you used before")
+		case err == nil && !user.EmailVerified:
+			return nil, newAPIError(http.StatusConflict, "An account with this email exists but its address was never confirmed")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user.Email = email
+	user.EmailVerified = identity.EmailVerified
+	user.Name = identity.Name
+	user.Picture = identity.Picture
+	err = h.store.WithTx(ctx, func(tx db.Store) error {
+		if err := tx.CreateOrUpdateUser(ctx, user); err != nil {
+			return err
+		}
+		// Invitations go to whoever owns the address, which only a provider
+		// that checked it can vouch for
+		if !user.EmailVerified {
+			return nil
+		}
+		return tx.AcceptInvitations(ctx, user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// randomToken returns 32 random bytes, base64url encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,52 @@
+package api_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"my-meal-planner/api"
+	"my-meal-planner/auth"
+	"my-meal-planner/models"
+)
+
+// fakeProvider signs in whichever identity it was given
+type fakeProvider struct {
+	identity *auth.Identity
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	return "https://idp.example.com/authorize", nil
+}
+
+func (p *fakeProvider) Exchange(ctx context.Context, code, nonce, codeVerifier string) (*auth.Identity, error) {
+	return p.identity, nil
+}
+
+func TestProviderSignInMatchesEmailsCaseInsensitively(t *testing.T) {
+	provider := &fakeProvider{identity: &auth.Identity{Subject: "42", Email: " Alice@Example.COM", EmailVerified: true, Name: "Alice"}}
+	ts := newTestServer(t, api.WithProviders(provider))
+	ctx := context.Background()
+
+	local := &models.User{ID: "local|alice", Email: "alice@example.com", Name: "Alice", EmailVerified: true}
+	if err := ts.store.CreateOrUpdateUser(ctx, local); err != nil {
+		t.Fatalf("CreateOrUpdateUser: %v", err)
+	}
+
+	r := request(http.MethodGet, "/auth/fake/callback?state=s&code=c", "", nil)
+	r.AddCookie(&http.Cookie{Name: "oauth_state", Value: "s.n.v"})
+	if w := ts.serve(r); w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("callback = %d %q, want a redirect to the client", w.Code, w.Body.String())
+	}
+
+	// The provider signed in to the existing account rather than a new one
+	if _, err := ts.store.GetUserByID(ctx, "fake|42"); err == nil {
+		t.Error("callback created a second account for alice@example.com")
+	}
+	user, err := ts.store.GetUserByEmail(ctx, "alice@example.com")
+	if err != nil || user.ID != "local|alice" {
+		t.Errorf("GetUserByEmail = %+v, %v; want the local account", user, err)
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits on emailing sign-in and confirmation links, so the endpoints can't
+// be used to flood someone's inbox or to send mail in bulk
+const (
+	linkEmailsPerAddress = 5
+	linkEmailsPerIP      = 20
+	linkEmailWindow      = time.Hour
+)
+
+// rateLimiter allows each key a number of events per sliding window. Counts
+// are kept in memory, so every server process limits on its own.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	events    map[string][]time.Time
+	lastPrune time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: make(map[string][]time.Time)}
+}
+
+// allow records an event for key if it is within the limit. Otherwise it
+// returns false and how long until the oldest counted event leaves the window.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastPrune) >= l.window {
+		for k, events := range l.events {
+			if recent := l.recent(events, now); len(recent) > 0 {
+				l.events[k] = recent
+			} else {
+				delete(l.events, k)
+			}
+		}
+		l.lastPrune = now
+	}
+
+	events := l.recent(l.events[key], now)
+	if len(events) >= l.limit {
+		l.events[key] = events
+		return false, events[0].Add(l.window).Sub(now)
+	}
+	l.events[key] = append(events, now)
+	return true, 0
+}
+
+// recent drops the events that have left the window
+func (l *rateLimiter) recent(events []time.Time, now time.Time) []time.Time {
+	cutoff := now.Add(-l.window)
+	for len(events) > 0 && !events[0].After(cutoff) {
+		events = events[1:]
+	}
+	return events
+}
+
+// allowLinkEmail checks the limits on emailing links to email from the
+// request's client, writing a 429 response when either is reached. The
+// response doesn't depend on whether the address has an account.
+func (h *Handler) allowLinkEmail(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+	ok, retryAfter := h.linkEmailsByIP.allow(h.clientIP(r), now)
+	if ok {
+		ok, retryAfter = h.linkEmailsByAddress.allow(email, now)
+	}
+	if ok {
+		return true
+	}
+
+	seconds := int(retryAfter.Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, fmt.Sprintf("Too many emails requested; try again in %d minutes", (seconds+59)/60), http.StatusTooManyRequests)
+	return false
+}
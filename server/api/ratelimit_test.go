@@ -0,0 +1,160 @@
+package api_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"my-meal-planner/api"
+	"my-meal-planner/mailer"
+)
+
+func TestRegistrationEmailsAreRateLimited(t *testing.T) {
+	ts := newTestServer(t, api.WithMailer(mailer.NewLogMailer(io.Discard, "test@example.com")))
+
+	register := func(email, ip string) int {
+		r := request(http.MethodPost, "/auth/register", "", map[string]string{"email": email, "password": "correct horse battery staple"})
+		r.RemoteAddr = ip + ":1234"
+		return ts.serve(r).Code
+	}
+
+	// Each address gets a handful of confirmations an hour, whoever asks
+	for i := 0; i < 5; i++ {
+		if code := register("alice@example.com", "192.0.2.1"); code != http.StatusAccepted {
+			t.Fatalf("registration %d for alice = %d, want 202", i+1, code)
+		}
+	}
+	r := request(http.MethodPost, "/auth/register", "", map[string]string{"email": "alice@example.com", "password": "correct horse battery staple"})
+	r.RemoteAddr = "192.0.2.2:1234"
+	w := ts.serve(r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("sixth registration for alice = %d with Retry-After %q, want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Each client can only reach so many addresses
+	sent := 0
+	for i := 0; i < 25; i++ {
+		if register("user"+string(rune('a'+i))+"@example.com", "198.51.100.7") == http.StatusAccepted {
+			sent++
+		}
+	}
+	if sent != 20 {
+		t.Errorf("one client sent %d confirmations, want 20", sent)
+	}
+}
+
+func TestMagicLinkSendsAreRateLimited(t *testing.T) {
+	ts := newTestServer(t, api.WithMailer(mailer.NewLogMailer(io.Discard, "test@example.com")))
+
+	send := func(email, ip string) int {
+		r := request(http.MethodPost, "/auth/magic-link", "", map[string]string{"email": email})
+		r.RemoteAddr = ip + ":1234"
+		return ts.serve(r).Code
+	}
+
+	// Each address gets a handful of links an hour, whoever asks
+	for i := 0; i < 5; i++ {
+		if code := send("alice@example.com", "192.0.2.1"); code != http.StatusAccepted {
+			t.Fatalf("send %d to alice = %d, want 202", i+1, code)
+		}
+	}
+	r := request(http.MethodPost, "/auth/magic-link", "", map[string]string{"email": "alice@example.com"})
+	r.RemoteAddr = "192.0.2.2:1234"
+	w := ts.serve(r)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("sixth send to alice = %d with Retry-After %q, want 429 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// Each client can only reach so many addresses
+	sent := 0
+	for i := 0; i < 25; i++ {
+		if send("user"+string(rune('a'+i))+"@example.com", "198.51.100.7") == http.StatusAccepted {
+			sent++
+		}
+	}
+	if sent != 20 {
+		t.Errorf("one client sent %d links, want 20", sent)
+	}
+
+	// Registration confirmations share the limit
+	r = request(http.MethodPost, "/auth/register", "", map[string]string{"email": "alice@example.com", "password": "correct horse battery staple"})
+	r.RemoteAddr = "203.0.113.9:1234"
+	if code := ts.serve(r).Code; code != http.StatusTooManyRequests {
+		t.Errorf("registering alice after her limit = %d, want 429", code)
+	}
+}
+
+func TestClientLimitUsesTrustedForwarding(t *testing.T) {
+	proxies, err := api.ParseTrustedProxies("10.0.0.0/8, 192.0.2.10")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+	ts := newTestServer(t,
+		api.WithMailer(mailer.NewLogMailer(io.Discard, "test@example.com")),
+		api.WithTrustedProxies(proxies...),
+	)
+
+	send := func(i int, remoteAddr, forwardedFor string) int {
+		r := request(http.MethodPost, "/auth/magic-link", "", map[string]string{"email": fmt.Sprintf("user%d@example.com", i)})
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		return ts.serve(r).Code
+	}
+	countSent := func(from func(i int) (string, string)) int {
+		sent := 0
+		for i := 0; i < 25; i++ {
+			remoteAddr, forwardedFor := from(i)
+			if send(i, remoteAddr, forwardedFor) == http.StatusAccepted {
+				sent++
+			}
+		}
+		return sent
+	}
+
+	// A client that isn't a trusted proxy can't pick its own address
+	sent := countSent(func(i int) (string, string) {
+		return "198.51.100.7:1234", fmt.Sprintf("203.0.113.%d", i)
+	})
+	if sent != 20 {
+		t.Errorf("untrusted client with made-up X-Forwarded-For sent %d links, want 20", sent)
+	}
+
+	// Behind trusted proxies the client is the right-most untrusted hop, and
+	// whatever it put left of that is ignored
+	sent = countSent(func(i int) (string, string) {
+		return "10.1.2.3:1234", fmt.Sprintf("203.0.113.%d, 198.51.100.8, 192.0.2.10", i)
+	})
+	if sent != 20 {
+		t.Errorf("client behind proxies with made-up X-Forwarded-For sent %d links, want 20", sent)
+	}
+
+	// Different clients behind the same proxy are limited separately
+	if code := send(100, "10.1.2.3:1234", "198.51.100.9"); code != http.StatusAccepted {
+		t.Errorf("another client behind the proxy = %d, want 202", code)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := api.ParseTrustedProxies(" 10.0.0.0/8,, ::ffff:192.0.2.1 ,2001:db8::/32")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.0.2.1/32", "2001:db8::/32"}
+	if len(proxies) != len(want) {
+		t.Fatalf("ParseTrustedProxies = %v, want %v", proxies, want)
+	}
+	for i := range want {
+		if proxies[i].String() != want[i] {
+			t.Errorf("proxy %d = %s, want %s", i, proxies[i], want[i])
+		}
+	}
+
+	for _, value := range []string{"10.0.0.0/33", "proxy.internal"} {
+		if _, err := api.ParseTrustedProxies(value); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -5,8 +5,22 @@ import "net/http"
 // RegisterRoutes registers all the API routes
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	// Auth routes
-	mux.HandleFunc("/auth/google/login", h.handleGoogleLogin)
-	mux.HandleFunc("/auth/google/callback", h.handleGoogleCallback)
+	mux.Handle("/auth/refresh", h.timeoutMiddleware(http.HandlerFunc(h.handleRefresh)))
+	mux.Handle("/auth/logout", h.timeoutMiddleware(http.HandlerFunc(h.handleLogout)))
+	mux.Handle("/auth/providers", h.timeoutMiddleware(http.HandlerFunc(h.handleProviders)))
+	mux.Handle("/auth/register", h.timeoutMiddleware(http.HandlerFunc(h.handleRegister)))
+	mux.Handle("/auth/login", h.timeoutMiddleware(http.HandlerFunc(h.handleLogin)))
+	mux.Handle("/auth/magic-link", h.timeoutMiddleware(http.HandlerFunc(h.handleMagicLink)))
+	mux.Handle("/auth/magic-link/verify", h.timeoutMiddleware(http.HandlerFunc(h.handleMagicLinkVerify)))
+
+	// Identity provider sign-in: /auth/{provider}/login and /auth/{provider}/callback
+	mux.Handle("/auth/", h.timeoutMiddleware(http.HandlerFunc(h.handleProviderAuth)))
+
+	// Share code links, which work before signing in
+	mux.Handle("/join/", h.timeoutMiddleware(http.HandlerFunc(h.handleJoinLink)))
+
+	// Public keys for verifying access tokens
+	mux.HandleFunc("/.well-known/jwks.json", h.handleJWKS)
 
 	// Protected routes
 	protected := http.NewServeMux()
@@ -22,5 +36,20 @@ func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	protected.HandleFunc("/api/meals", h.handleMeals)
 	protected.HandleFunc("/api/meals/", h.handleMealByID)
 
-	mux.Handle("/api/", h.authMiddleware(protected))
+	// Session routes
+	protected.HandleFunc("/api/sessions", h.handleSessions)
+	protected.HandleFunc("/api/sessions/", h.handleSessionByID)
+
+	// Account routes
+	protected.HandleFunc("/api/account", h.handleAccount)
+	protected.HandleFunc("/api/account/password", h.handleChangePassword)
+
+	// Ownership transfers offered to the caller
+	protected.HandleFunc("/api/ownership-transfers", h.handleIncomingTransfers)
+
+	// Personal access token routes
+	protected.HandleFunc("/api/tokens", h.handleAPITokens)
+	protected.HandleFunc("/api/tokens/", h.handleAPITokenByID)
+
+	mux.Handle("/api/", h.timeoutMiddleware(h.authMiddleware(protected)))
 }
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Scopes a personal access token can be limited to
+const (
+	ScopePlansRead  = "plans:read"
+	ScopePlansWrite = "plans:write"
+	ScopeMealsRead  = "meals:read"
+	ScopeMealsWrite = "meals:write"
+	// ScopePlansAdmin covers the owner-only actions: deleting a plan and
+	// managing who can access it, through members, invitations, share codes
+	// and offering ownership transfers
+	ScopePlansAdmin = "plans:admin"
+)
+
+// knownScopes lists every scope a token may be given
+var knownScopes = map[string]bool{
+	ScopePlansRead:  true,
+	ScopePlansWrite: true,
+	ScopeMealsRead:  true,
+	ScopeMealsWrite: true,
+	ScopePlansAdmin: true,
+}
+
+// adminRoutes maps the sub-resources of /api/meal-plans/{id} that change who
+// can access a plan to whether reading them needs plans:admin too. Members
+// and a pending transfer can be read with plans:read; share codes and
+// invitations carry join secrets, so even listing them needs plans:admin.
+// Leaving a plan and accepting a transfer aren't owner actions and only need
+// plans:write.
+var adminRoutes = map[string]bool{
+	"members":     false,
+	"transfer":    false,
+	"invitations": true,
+	"share-codes": true,
+}
+
+// adminPaths are the top-level meal plan routes that share plans. Joining one
+// with a share code only needs plans:write.
+var adminPaths = map[string]bool{
+	"/api/meal-plans/share":         true,
+	"/api/meal-plans/generate-link": true,
+}
+
+// HasScope reports whether the principal's credential allows scope. Browser
+// sessions are unrestricted and allow everything.
+func (p *Principal) HasScope(scope string) bool {
+	if len(p.Scopes) == 0 {
+		return true
+	}
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// requiredScope returns the scope a request needs. Owner-only meal plan
+// routes need plans:admin. Meal routes need a meals scope and other meal plan
+// routes a plans scope, read for GET and write for anything else. Everything
+// else, such as managing sessions and tokens, needs an unrestricted credential
+// and gets "".
+func requiredScope(r *http.Request) string {
+	path := r.URL.Path
+	read := r.Method == http.MethodGet || r.Method == http.MethodHead
+
+	switch {
+	case isAdminRoute(path, r.Method, read):
+		return ScopePlansAdmin
+	case path == "/api/meals" || strings.HasPrefix(path, "/api/meals/") ||
+		(strings.HasPrefix(path, "/api/meal-plans/") && strings.Contains(path, "/meals")):
+		if read {
+			return ScopeMealsRead
+		}
+		return ScopeMealsWrite
+	case path == "/api/meal-plans" || strings.HasPrefix(path, "/api/meal-plans/"):
+		if read {
+			return ScopePlansRead
+		}
+		return ScopePlansWrite
+	default:
+		return ""
+	}
+}
+
+// isAdminRoute reports whether a request is for an owner-only meal plan route
+func isAdminRoute(path, method string, read bool) bool {
+	if adminPaths[path] {
+		return true
+	}
+	rest, ok := strings.CutPrefix(path, "/api/meal-plans/")
+	if !ok {
+		return false
+	}
+	parts := strings.Split(rest, "/")
+	if len(parts) == 1 {
+		return method == http.MethodDelete
+	}
+	if len(parts) == 3 && parts[1] == "transfer" && parts[2] == "accept" {
+		return false
+	}
+	adminToRead, ok := adminRoutes[parts[1]]
+	return ok && (!read || adminToRead)
+}
+
+// allowedScope reports whether the principal may make request r
+func allowedScope(p *Principal, r *http.Request) bool {
+	if len(p.Scopes) == 0 {
+		return true
+	}
+	scope := requiredScope(r)
+	return scope != "" && p.HasScope(scope)
+}
@@ -0,0 +1,63 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"my-meal-planner/api"
+)
+
+func TestOwnerOnlyRoutesNeedAdminScope(t *testing.T) {
+	ts := newTestServer(t)
+	ts.seedUser("alice")
+	ts.seedUser("bob")
+	ts.seedPlan("plan-1", "alice")
+	ts.grant("bob", "plan-1", "editor")
+
+	writeToken := ts.apiToken("alice", api.ScopePlansRead, api.ScopePlansWrite, api.ScopeMealsWrite)
+	adminToken := ts.apiToken("alice", api.ScopePlansAdmin)
+
+	ownerOnly := []struct{ method, path string }{
+		{http.MethodDelete, "/api/meal-plans/plan-1"},
+		{http.MethodPost, "/api/meal-plans/plan-1/transfer"},
+		{http.MethodDelete, "/api/meal-plans/plan-1/transfer"},
+		{http.MethodPatch, "/api/meal-plans/plan-1/members/bob"},
+		{http.MethodDelete, "/api/meal-plans/plan-1/members/bob"},
+		{http.MethodGet, "/api/meal-plans/plan-1/invitations"},
+		{http.MethodDelete, "/api/meal-plans/plan-1/invitations/inv-1"},
+		{http.MethodGet, "/api/meal-plans/plan-1/share-codes"},
+		{http.MethodDelete, "/api/meal-plans/plan-1/share-codes/ABCD"},
+		{http.MethodGet, "/api/meal-plans/plan-1/share-codes/ABCD/qr"},
+		{http.MethodPost, "/api/meal-plans/share"},
+		{http.MethodPost, "/api/meal-plans/generate-link"},
+	}
+	for _, route := range ownerOnly {
+		w := ts.serve(request(route.method, route.path, writeToken, struct{}{}))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s with plans:write = %d, want 403", route.method, route.path, w.Code)
+		}
+		w = ts.serve(request(route.method, route.path, adminToken, struct{}{}))
+		if w.Code == http.StatusForbidden {
+			t.Errorf("%s %s with plans:admin = 403 %q, want the scope to allow it", route.method, route.path, w.Body.String())
+		}
+	}
+
+	// The write scope still covers editing, and reading members needs only plans:read
+	readToken := ts.apiToken("alice", api.ScopePlansRead)
+	allowed := []struct {
+		method, path, token string
+	}{
+		{http.MethodPut, "/api/meal-plans/plan-1", writeToken},
+		{http.MethodGet, "/api/meal-plans/plan-1/members", readToken},
+		{http.MethodGet, "/api/meal-plans/plan-1/transfer", readToken},
+		// Joining, leaving and accepting a transfer aren't owner actions
+		{http.MethodPost, "/api/meal-plans/join", writeToken},
+		{http.MethodPost, "/api/meal-plans/plan-1/leave", writeToken},
+		{http.MethodPost, "/api/meal-plans/plan-1/transfer/accept", writeToken},
+	}
+	for _, route := range allowed {
+		if w := ts.serve(request(route.method, route.path, route.token, struct{}{})); w.Code == http.StatusForbidden {
+			t.Errorf("%s %s = 403 %q, want the scope to allow it", route.method, route.path, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package api_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"my-meal-planner/api"
+	"my-meal-planner/db"
+	"my-meal-planner/models"
+)
+
+// testServer serves the API routes from a memory store
+type testServer struct {
+	t     *testing.T
+	store *db.MemoryStore
+	mux   *http.ServeMux
+}
+
+func newTestServer(t *testing.T, opts ...api.Option) *testServer {
+	t.Helper()
+
+	store := db.NewMemoryStore(db.NewHMACKeyring([]byte("test-secret")))
+	mux := http.NewServeMux()
+	api.NewHandler(store, opts...).RegisterRoutes(mux)
+	return &testServer{t: t, store: store, mux: mux}
+}
+
+// seedUser creates a verified user
+func (ts *testServer) seedUser(id string) *models.User {
+	ts.t.Helper()
+
+	user := &models.User{ID: id, Email: id + "@example.com", Name: id, EmailVerified: true}
+	if err := ts.store.CreateOrUpdateUser(context.Background(), user); err != nil {
+		ts.t.Fatalf("CreateOrUpdateUser(%s): %v", id, err)
+	}
+	return user
+}
+
+// seedPlan creates a plan owned by ownerID
+func (ts *testServer) seedPlan(id, ownerID string) *models.MealPlan {
+	ts.t.Helper()
+
+	plan := &models.MealPlan{ID: id, Name: "Plan " + id, CreatedBy: ownerID}
+	if err := ts.store.CreateMealPlan(context.Background(), plan); err != nil {
+		ts.t.Fatalf("CreateMealPlan(%s): %v", id, err)
+	}
+	ts.grant(ownerID, id, "owner")
+	return plan
+}
+
+// seedMeal creates a meal in a plan
+func (ts *testServer) seedMeal(id, planID string) *models.Meal {
+	ts.t.Helper()
+
+	meal := &models.Meal{ID: id, MealPlanID: planID, Name: "Meal " + id, Day: "Monday", MealType: "Lunch"}
+	if err := ts.store.CreateMeal(context.Background(), meal); err != nil {
+		ts.t.Fatalf("CreateMeal(%s): %v", id, err)
+	}
+	return meal
+}
+
+// grant gives a user a role on a plan
+func (ts *testServer) grant(userID, planID, role string) {
+	ts.t.Helper()
+
+	access := &models.MealPlanAccess{UserID: userID, MealPlanID: planID, Role: role}
+	if err := ts.store.CreateMealPlanAccess(context.Background(), access); err != nil {
+		ts.t.Fatalf("CreateMealPlanAccess(%s, %s): %v", userID, planID, err)
+	}
+}
+
+// accessToken signs a user in on a new session and returns its access token
+func (ts *testServer) accessToken(user *models.User) string {
+	ts.t.Helper()
+	ctx := context.Background()
+
+	session := &models.Session{ID: "session-" + user.ID, UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := ts.store.CreateSession(ctx, session); err != nil {
+		ts.t.Fatalf("CreateSession(%s): %v", user.ID, err)
+	}
+	token, err := ts.store.GenerateToken(ctx, user, session.ID)
+	if err != nil {
+		ts.t.Fatalf("GenerateToken(%s): %v", user.ID, err)
+	}
+	return token
+}
+
+// apiToken creates a personal access token for a user with the given scopes
+func (ts *testServer) apiToken(userID string, scopes ...string) string {
+	ts.t.Helper()
+
+	secret, hash, prefix, err := db.NewAPIToken()
+	if err != nil {
+		ts.t.Fatalf("NewAPIToken: %v", err)
+	}
+	token := &models.APIToken{UserID: userID, Name: "test", TokenHash: hash, Prefix: prefix, Scopes: scopes}
+	if err := ts.store.CreateAPIToken(context.Background(), token); err != nil {
+		ts.t.Fatalf("CreateAPIToken(%s): %v", userID, err)
+	}
+	return secret
+}
+
+// request builds a request with a JSON body, if any, and a bearer credential
+func request(method, path, credential string, body any) *http.Request {
+	var raw []byte
+	if body != nil {
+		raw, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(method, path, bytes.NewReader(raw))
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if credential != "" {
+		r.Header.Set("Authorization", "Bearer "+credential)
+	}
+	return r
+}
+
+// serve sends r to the server and returns the recorded response
+func (ts *testServer) serve(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ts.mux.ServeHTTP(w, r)
+	return w
+}
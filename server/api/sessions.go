@@ -0,0 +1,410 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"my-meal-planner/db"
+	"my-meal-planner/models"
+)
+
+// refreshCookie holds the refresh token of a browser session. It is only sent
+// to the /auth endpoints, never to the API.
+const refreshCookie = "refresh_token"
+
+// In cookie session mode the access token is kept in accessCookie, which is
+// only sent to /api and can't be read by scripts. Requests authenticated by
+// it that change anything must repeat the value of csrfCookie in csrfHeader;
+// another site can make the browser send the cookies but can't read them.
+const (
+	accessCookie = "access_token"
+	csrfCookie   = "csrf_token"
+	csrfHeader   = "X-CSRF-Token"
+)
+
+// tokenResponse is returned by /auth/refresh
+type tokenResponse struct {
+	// AccessToken is left out in cookie session mode, where browsers get it
+	// as a cookie and are sent CSRFToken instead
+	AccessToken string `json:"accessToken,omitempty"`
+	CSRFToken   string `json:"csrfToken,omitempty"`
+	TokenType   string `json:"tokenType"`
+	ExpiresIn   int    `json:"expiresIn"` // seconds
+	// RefreshToken is only returned to clients that sent theirs in the body;
+	// browsers get it as a cookie instead
+	RefreshToken string `json:"refreshToken,omitempty"`
+	// JoinedMealPlanID is set when a join link opened before signing in has
+	// just been redeemed
+	JoinedMealPlanID string `json:"joinedMealPlanId,omitempty"`
+}
+
+// sessionResponse describes one of the caller's sessions
+type sessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IPAddress  string    `json:"ipAddress"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"` // the session making the request
+}
+
+// startSession signs user in on a new session and sets its refresh token cookie
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, user *models.User) (*models.Session, error) {
+	session := &models.Session{
+		ID:        uuid.New().String(),
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IPAddress: h.clientIP(r),
+		ExpiresAt: time.Now().Add(db.RefreshTokenTTL),
+	}
+
+	refreshToken, hash, err := db.NewRefreshToken(session.ID)
+	if err != nil {
+		return nil, err
+	}
+	session.RefreshTokenHash = hash
+
+	if err := h.store.CreateSession(r.Context(), session); err != nil {
+		return nil, err
+	}
+
+	setRefreshCookie(w, r, refreshToken)
+	return session, nil
+}
+
+// respondWithSession starts a session for user and responds with its access
+// token. The refresh token is set as a cookie, as for identity provider sign-ins.
+func (h *Handler) respondWithSession(w http.ResponseWriter, r *http.Request, user *models.User, status int) {
+	session, err := h.startSession(w, r, user)
+	if err != nil {
+		http.Error(w, "Failed to start session", http.StatusInternalServerError)
+		return
+	}
+	accessToken, err := h.store.GenerateToken(r.Context(), user, session.ID)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	resp := tokenResponse{
+		AccessToken:      accessToken,
+		TokenType:        "Bearer",
+		ExpiresIn:        int(db.AccessTokenTTL.Seconds()),
+		JoinedMealPlanID: h.redeemPendingJoin(w, r, user.ID),
+	}
+	if h.cookieSessions {
+		if err := h.setAccessCookies(w, r, &resp); err != nil {
+			http.Error(w, "Failed to start session", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleRefresh exchanges a refresh token for a new access token and a new
+// refresh token. A refresh token that has already been exchanged revokes its
+// session, since only a copy held by someone else can be presented twice.
+func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	refreshToken, fromBody, err := readRefreshToken(r)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	sessionID, ok := db.RefreshTokenSessionID(refreshToken)
+	if !ok {
+		http.Error(w, "Refresh token missing or malformed", http.StatusUnauthorized)
+		return
+	}
+
+	newToken, newHash, err := db.NewRefreshToken(sessionID)
+	if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.store.RotateSession(r.Context(), sessionID, db.HashRefreshToken(refreshToken), newHash, time.Now().Add(db.RefreshTokenTTL))
+	switch {
+	case errors.Is(err, db.ErrRefreshTokenReused):
+		log.Printf("refresh token reused for session %s, revoking it", sessionID)
+		if err := h.store.RevokeSession(r.Context(), sessionID); err != nil {
+			log.Printf("failed to revoke session %s: %v", sessionID, err)
+		}
+		clearRefreshCookie(w, r)
+		http.Error(w, "Refresh token has already been used; the session has been signed out", http.StatusUnauthorized)
+		return
+	case errors.Is(err, db.ErrSessionNotFound):
+		clearRefreshCookie(w, r)
+		http.Error(w, "Session expired or signed out", http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+
+	session, err := h.store.GetSession(r.Context(), sessionID)
+	if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+	user, err := h.store.GetUserByID(r.Context(), session.UserID)
+	if err != nil {
+		http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+		return
+	}
+	accessToken, err := h.store.GenerateToken(r.Context(), user, sessionID)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	resp := tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(db.AccessTokenTTL.Seconds()),
+	}
+	if fromBody {
+		resp.RefreshToken = newToken
+	} else {
+		setRefreshCookie(w, r, newToken)
+		resp.JoinedMealPlanID = h.redeemPendingJoin(w, r, user.ID)
+		if h.cookieSessions {
+			if err := h.setAccessCookies(w, r, &resp); err != nil {
+				http.Error(w, "Failed to refresh session", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleLogout revokes the session a refresh token belongs to and clears the cookie
+func (h *Handler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	refreshToken, _, err := readRefreshToken(r)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	clearRefreshCookie(w, r)
+	clearAccessCookies(w, r)
+
+	// Only the holder of the current refresh token may end the session
+	if sessionID, ok := db.RefreshTokenSessionID(refreshToken); ok {
+		session, err := h.store.GetSession(r.Context(), sessionID)
+		if err == nil && session.RefreshTokenHash == db.HashRefreshToken(refreshToken) {
+			if err := h.store.RevokeSession(r.Context(), sessionID); err != nil {
+				http.Error(w, "Failed to sign out", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSessions handles GET requests for /api/sessions
+func (h *Handler) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	sessions, err := h.store.ListSessionsByUser(r.Context(), caller.UserID)
+	if err != nil {
+		http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	resp := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		if !session.Active(now) {
+			continue
+		}
+		resp = append(resp, sessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == caller.SessionID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleSessionByID handles DELETE requests for /api/sessions/{id}, signing
+// out one of the caller's sessions
+func (h *Handler) handleSessionByID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/sessions/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+
+	// Other users' sessions are reported as missing
+	session, err := h.store.GetSession(r.Context(), id)
+	if errors.Is(err, db.ErrSessionNotFound) || (err == nil && session.UserID != caller.UserID) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to sign out session", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.store.RevokeSession(r.Context(), id); err != nil {
+		http.Error(w, "Failed to sign out session", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readRefreshToken returns the refresh token from a JSON body, falling back
+// to the cookie, and reports whether it came from the body
+func readRefreshToken(r *http.Request) (token string, fromBody bool, err error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") && r.ContentLength != 0 {
+		var req struct {
+			RefreshToken string `json:"refreshToken"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return "", false, err
+		}
+		if req.RefreshToken != "" {
+			return req.RefreshToken, true, nil
+		}
+	}
+
+	if cookie, err := r.Cookie(refreshCookie); err == nil {
+		return cookie.Value, false, nil
+	}
+	return "", false, nil
+}
+
+func setRefreshCookie(w http.ResponseWriter, r *http.Request, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    token,
+		Path:     "/auth",
+		MaxAge:   int(db.RefreshTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearRefreshCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookie,
+		Value:    "",
+		Path:     "/auth",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// setAccessCookies moves the access token in resp into the access cookie and
+// replaces it with the CSRF token. The browser's existing CSRF token is kept,
+// so other tabs holding it keep working.
+func (h *Handler) setAccessCookies(w http.ResponseWriter, r *http.Request, resp *tokenResponse) error {
+	var csrfToken string
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+		csrfToken = cookie.Value
+	} else if csrfToken, err = randomToken(); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     accessCookie,
+		Value:    resp.AccessToken,
+		Path:     "/api",
+		MaxAge:   int(db.AccessTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		MaxAge:   int(db.RefreshTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	resp.AccessToken = ""
+	resp.CSRFToken = csrfToken
+	return nil
+}
+
+func clearAccessCookies(w http.ResponseWriter, r *http.Request) {
+	for name, path := range map[string]string{accessCookie: "/api", csrfCookie: "/"} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     path,
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   isHTTPS(r),
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+// validCSRF reports whether a request authenticated by cookie may proceed:
+// safe methods always may, anything else must repeat the CSRF cookie in the
+// CSRF header
+func validCSRF(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.Header.Get(csrfHeader))) == 1
+}
+
+// isHTTPS reports whether the client connected over HTTPS, directly or
+// through a proxy that terminates TLS
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,51 @@
+package api_test
+
+import (
+	"net/http"
+	"testing"
+
+	"my-meal-planner/api"
+)
+
+func TestCookieWritesNeedCSRFToken(t *testing.T) {
+	ts := newTestServer(t, api.WithCookieSessions(true))
+	alice := ts.seedUser("alice")
+	ts.seedPlan("plan-1", "alice")
+	token := ts.accessToken(alice)
+
+	body := map[string]string{"name": "Renamed"}
+	tests := []struct {
+		name           string
+		method         string
+		cookie, header string
+		want           int
+	}{
+		{"read without token", http.MethodGet, "", "", http.StatusOK},
+		{"write without token", http.MethodPut, "", "", http.StatusForbidden},
+		{"write without header", http.MethodPut, "csrf-1", "", http.StatusForbidden},
+		{"write with other header", http.MethodPut, "csrf-1", "csrf-2", http.StatusForbidden},
+		{"write with token", http.MethodPut, "csrf-1", "csrf-1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := request(tt.method, "/api/meal-plans/plan-1", "", body)
+		r.AddCookie(&http.Cookie{Name: "access_token", Value: token})
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "csrf_token", Value: tt.cookie})
+		}
+		if tt.header != "" {
+			r.Header.Set("X-CSRF-Token", tt.header)
+		}
+		r.Header.Set("If-Match", `"1"`)
+		if w := ts.serve(r); w.Code != tt.want {
+			t.Errorf("%s: %s = %d %q, want %d", tt.name, tt.method, w.Code, w.Body.String(), tt.want)
+		}
+	}
+
+	// A bearer token isn't sent by the browser on its own, so it needs no
+	// CSRF token
+	r := request(http.MethodPut, "/api/meal-plans/plan-1", token, body)
+	r.Header.Set("If-Match", `"2"`)
+	if w := ts.serve(r); w.Code != http.StatusOK {
+		t.Errorf("bearer write = %d %q, want 200", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,314 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"my-meal-planner/db"
+	"my-meal-planner/models"
+)
+
+// maxShareCodeAttempts bounds how many random codes are tried before giving
+// up on finding a free one
+const maxShareCodeAttempts = 5
+
+// pendingJoinCookie holds the code of a join link opened before signing in.
+// It is only sent to the /auth endpoints, so it survives the round trip
+// through an identity provider, and the next of them to hand out an access
+// token redeems it.
+const (
+	pendingJoinCookie = "pending_join"
+	pendingJoinTTL    = time.Hour
+)
+
+// Side lengths in pixels allowed for QR code images
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 1024
+)
+
+// sharedCode describes one of a plan's share codes and who has used it
+type sharedCode struct {
+	models.ShareCode
+	Redemptions []redeemer `json:"redemptions"`
+}
+
+// redeemer is a user who joined a plan with a share code
+type redeemer struct {
+	UserID     string    `json:"userId"`
+	Name       string    `json:"name"`
+	Email      string    `json:"email"`
+	RedeemedAt time.Time `json:"redeemedAt"`
+}
+
+// serveShareCodes handles GET requests for /api/meal-plans/{id}/share-codes,
+// listing the plan's share codes that haven't been swept away yet
+func (h *Handler) serveShareCodes(w http.ResponseWriter, r *http.Request, mealPlanID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	// Only those who can share the plan can see its codes
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permSharePlan); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	codes, err := h.store.ListShareCodesByPlan(r.Context(), mealPlanID)
+	if err != nil {
+		http.Error(w, "Failed to list share codes", http.StatusInternalServerError)
+		return
+	}
+	redemptions, err := h.store.ListShareCodeRedemptions(r.Context(), mealPlanID)
+	if err != nil {
+		http.Error(w, "Failed to list share codes", http.StatusInternalServerError)
+		return
+	}
+
+	// Look each redeemer up once; a failed lookup just leaves the name blank
+	users := make(map[string]*models.User)
+	byCode := make(map[string][]redeemer)
+	for _, redemption := range redemptions {
+		user, seen := users[redemption.UserID]
+		if !seen {
+			user, _ = h.store.GetUserByID(r.Context(), redemption.UserID)
+			users[redemption.UserID] = user
+		}
+		entry := redeemer{UserID: redemption.UserID, RedeemedAt: redemption.RedeemedAt}
+		if user != nil {
+			entry.Name = user.Name
+			entry.Email = user.Email
+		}
+		byCode[redemption.ShareCodeID] = append(byCode[redemption.ShareCodeID], entry)
+	}
+
+	resp := make([]sharedCode, 0, len(codes))
+	for _, code := range codes {
+		shared := sharedCode{ShareCode: *code, Redemptions: byCode[code.ID]}
+		if shared.Redemptions == nil {
+			shared.Redemptions = []redeemer{}
+		}
+		resp = append(resp, shared)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// serveShareCode handles DELETE requests for
+// /api/meal-plans/{id}/share-codes/{code}, revoking a share code
+func (h *Handler) serveShareCode(w http.ResponseWriter, r *http.Request, mealPlanID, codeID string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permSharePlan); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	// Codes of other plans are reported as missing
+	code, err := h.store.GetShareCode(r.Context(), codeID)
+	if errors.Is(err, db.ErrShareCodeNotFound) || (err == nil && code.MealPlanID != mealPlanID) {
+		http.Error(w, "Share code not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke share code", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.store.DeleteShareLink(r.Context(), codeID); err != nil && !errors.Is(err, db.ErrShareCodeNotFound) {
+		http.Error(w, "Failed to revoke share code", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveShareCodeQR handles GET requests for
+// /api/meal-plans/{id}/share-codes/{code}/qr, rendering the code's join link
+// as a QR code. The format parameter picks "png" (the default) or "svg" and
+// size sets the side length in pixels.
+func (h *Handler) serveShareCodeQR(w http.ResponseWriter, r *http.Request, mealPlanID, codeID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := principal(r)
+
+	if err := h.authorize(r.Context(), caller.UserID, mealPlanID, permSharePlan); err != nil {
+		writeError(w, err, "Failed to check access")
+		return
+	}
+
+	size := defaultQRSize
+	if value := r.URL.Query().Get("size"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < minQRSize || n > maxQRSize {
+			http.Error(w, fmt.Sprintf("size must be between %d and %d", minQRSize, maxQRSize), http.StatusBadRequest)
+			return
+		}
+		size = n
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "png" && format != "svg" {
+		http.Error(w, "format must be png or svg", http.StatusBadRequest)
+		return
+	}
+
+	code, err := h.store.GetShareCode(r.Context(), codeID)
+	if errors.Is(err, db.ErrShareCodeNotFound) || (err == nil && code.MealPlanID != mealPlanID) {
+		http.Error(w, "Share code not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+
+	link := h.joinURL(code.ID)
+	if link == "" {
+		http.Error(w, "Join links are not enabled", http.StatusNotFound)
+		return
+	}
+	qr, err := qrcode.New(link, qrcode.Medium)
+	if err != nil {
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(qrSVG(qr.Bitmap(), size))
+		return
+	}
+	png, err := qr.PNG(size)
+	if err != nil {
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+// qrSVG draws a QR code bitmap, quiet zone included, as an SVG image with
+// one unit per module
+func qrSVG(bitmap [][]bool, size int) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, len(bitmap), len(bitmap))
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, len(bitmap), len(bitmap))
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String())
+}
+
+// handleJoinLink handles GET requests for /join/{code}, the address share
+// codes are handed out as. It works before signing in: the code is kept in a
+// cookie and the browser is sent to the web client, where signing in or
+// refreshing the session joins the plan.
+func (h *Handler) handleJoinLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Codes are short codes or, from before those, UUIDs
+	code := db.NormalizeShareCode(strings.TrimPrefix(r.URL.Path, "/join/"))
+	if code == "" || len(code) > 36 || strings.Trim(code, "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-") != "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingJoinCookie,
+		Value:    code,
+		Path:     "/auth",
+		MaxAge:   int(pendingJoinTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, strings.TrimRight(frontendURL(), "/")+"/?join="+url.QueryEscape(code), http.StatusTemporaryRedirect)
+}
+
+// redeemPendingJoin joins userID to the plan of a join link opened before
+// signing in, if there is one, and returns the plan's ID. Codes that have
+// expired, been used up or lead to a plan the user is already in are dropped.
+func (h *Handler) redeemPendingJoin(w http.ResponseWriter, r *http.Request, userID string) string {
+	cookie, err := r.Cookie(pendingJoinCookie)
+	if err != nil {
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     pendingJoinCookie,
+		Value:    "",
+		Path:     "/auth",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	_, plan, err := h.joinWithCode(r.Context(), userID, cookie.Value)
+	if err != nil {
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) {
+			log.Printf("failed to join with pending share code for user %s: %v", userID, err)
+		}
+		return ""
+	}
+	return plan.ID
+}
+
+// createShortShareCode stores code under a random short code that isn't
+// already taken
+func createShortShareCode(ctx context.Context, tx db.Store, code *models.ShareCode) error {
+	for attempt := 0; attempt < maxShareCodeAttempts; attempt++ {
+		id, err := db.NewShareCode()
+		if err != nil {
+			return err
+		}
+		_, err = tx.GetShareCode(ctx, id)
+		if errors.Is(err, db.ErrShareCodeNotFound) {
+			code.ID = id
+			return tx.CreateShareCode(ctx, code)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return errors.New("no free share code found")
+}
+
+// joinURL returns the link that joins a plan with a share code, or "" when no
+// public URL is configured. The link is never built from the request's Host
+// or forwarding headers, which the client controls.
+func (h *Handler) joinURL(code string) string {
+	if h.publicURL == "" {
+		return ""
+	}
+	return h.publicURL + "/join/" + url.PathEscape(code)
+}
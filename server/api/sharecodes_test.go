@@ -0,0 +1,53 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"my-meal-planner/api"
+)
+
+func TestJoinURLUsesPublicURL(t *testing.T) {
+	generate := func(ts *testServer, token string) map[string]string {
+		t.Helper()
+
+		r := request(http.MethodPost, "/api/meal-plans/generate-link", token, map[string]string{"mealPlanId": "plan-1", "role": "viewer"})
+		r.Host = "attacker.example"
+		r.Header.Set("X-Forwarded-Proto", "https")
+		w := ts.serve(r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("generate-link = %d %q, want 200", w.Code, w.Body.String())
+		}
+		var response map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		return response
+	}
+
+	ts := newTestServer(t, api.WithPublicURL("https://meals.example/"))
+	alice := ts.seedUser("alice")
+	ts.seedPlan("plan-1", "alice")
+	token := ts.accessToken(alice)
+
+	response := generate(ts, token)
+	if want := "https://meals.example/join/" + response["code"]; response["joinUrl"] != want {
+		t.Errorf("joinUrl = %q, want %q", response["joinUrl"], want)
+	}
+
+	ts = newTestServer(t)
+	alice = ts.seedUser("alice")
+	ts.seedPlan("plan-1", "alice")
+	token = ts.accessToken(alice)
+
+	response = generate(ts, token)
+	if link, ok := response["joinUrl"]; ok {
+		t.Errorf("joinUrl = %q without a public URL, want it omitted", link)
+	}
+	w := ts.serve(request(http.MethodGet, "/api/meal-plans/plan-1/share-codes/"+response["code"]+"/qr", token, nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "not enabled") {
+		t.Errorf("QR code without a public URL = %d %q, want 404", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"context"
+	"net/http"
+)
+
+// timeoutMiddleware cancels the request context once the configured timeout elapses
+func (h *Handler) timeoutMiddleware(next http.Handler) http.Handler {
+	if h.requestTimeout <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), h.requestTimeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
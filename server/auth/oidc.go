@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCProvider signs users in with any OpenID Connect issuer, such as Google,
+// Keycloak or Authentik. The issuer's endpoints and keys are found through
+// discovery on first use, so an unreachable issuer doesn't stop the server.
+type OIDCProvider struct {
+	config ProviderConfig
+
+	mutex    sync.Mutex
+	oauth    *oauth2.Config
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCProvider creates a provider from its config
+func NewOIDCProvider(config ProviderConfig) *OIDCProvider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	return &OIDCProvider{config: config}
+}
+
+// Name returns the provider's configured name
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+// discover fetches the issuer's configuration, retrying on later calls if it fails
+func (p *OIDCProvider) discover(ctx context.Context) (*oauth2.Config, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.oauth != nil {
+		return p.oauth, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovery for %s failed: %w", p.config.Name, err)
+	}
+	p.provider = provider
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.config.ClientID})
+	p.oauth = &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       p.config.Scopes,
+	}
+	return p.oauth, nil
+}
+
+// AuthCodeURL returns the issuer's login URL with the nonce and PKCE challenge
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	config, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+// Exchange redeems the code, verifies the ID token and returns its identity
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce, codeVerifier string) (*Identity, error) {
+	config, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+		Picture       string `json:"picture"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+
+	// Some issuers leave the profile out of the ID token
+	if claims.Email == "" {
+		userInfo, err := p.provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user info: %w", err)
+		}
+		if userInfo.Subject != idToken.Subject {
+			return nil, errors.New("user info is for a different subject")
+		}
+		if err := userInfo.Claims(&claims); err != nil {
+			return nil, fmt.Errorf("invalid user info: %w", err)
+		}
+		claims.Email = userInfo.Email
+		claims.EmailVerified = userInfo.EmailVerified
+	}
+	if claims.Email == "" {
+		return nil, errors.New("provider didn't share an email address")
+	}
+
+	return &Identity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		Picture:       claims.Picture,
+	}, nil
+}
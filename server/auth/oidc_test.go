@@ -0,0 +1,310 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+
+	"my-meal-planner/auth"
+)
+
+// fakeIssuer is a minimal OpenID Connect provider. Codes are issued by
+// authorize, standing in for the user signing in on the provider's page.
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mutex sync.Mutex
+	codes map[string]grant
+}
+
+// grant is what the provider remembers about an authorization code
+type grant struct {
+	nonce     string
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	f := &fakeIssuer{key: key, codes: make(map[string]grant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                f.URL,
+			"authorization_endpoint":                f.URL + "/authorize",
+			"token_endpoint":                        f.URL + "/token",
+			"jwks_uri":                              f.URL + "/keys",
+			"userinfo_endpoint":                     f.URL + "/userinfo",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", f.handleToken)
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sub":            "user-1",
+			"email":          "info@example.com",
+			"email_verified": true,
+			"name":           "From User Info",
+		})
+	})
+
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+// handleToken redeems a code once, checking the PKCE verifier
+func (f *fakeIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mutex.Lock()
+	g, exists := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	f.mutex.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !exists || base64.RawURLEncoding.EncodeToString(sum[:]) != g.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":   f.URL,
+		"aud":   "meal-planner",
+		"sub":   "user-1",
+		"nonce": g.nonce,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Minute).Unix(),
+	}
+	for name, value := range g.claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	idToken, err := token.SignedString(f.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     idToken,
+	})
+}
+
+// authorize checks the login URL a provider produced and issues a code for it,
+// as the provider would once the user signs in
+func (f *fakeIssuer) authorize(t *testing.T, loginURL string, claims jwt.MapClaims) (code, state string) {
+	t.Helper()
+
+	u, err := url.Parse(loginURL)
+	if err != nil {
+		t.Fatalf("login URL %q: %v", loginURL, err)
+	}
+	if !strings.HasPrefix(loginURL, f.URL+"/authorize") {
+		t.Fatalf("login URL %q doesn't point at the issuer", loginURL)
+	}
+	q := u.Query()
+	if q.Get("client_id") != "meal-planner" || q.Get("response_type") != "code" {
+		t.Errorf("login URL query = %v, want client_id meal-planner and response_type code", q)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Errorf("login URL has no S256 PKCE challenge: %v", q)
+	}
+	if q.Get("nonce") == "" {
+		t.Errorf("login URL has no nonce: %v", q)
+	}
+	if scopes := q.Get("scope"); scopes != "openid email profile" {
+		t.Errorf("scopes = %q, want openid email profile", scopes)
+	}
+
+	code = "code-" + q.Get("state")
+	f.mutex.Lock()
+	f.codes[code] = grant{nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), claims: claims}
+	f.mutex.Unlock()
+	return code, q.Get("state")
+}
+
+func newProvider(f *fakeIssuer) *auth.OIDCProvider {
+	return auth.NewOIDCProvider(auth.ProviderConfig{
+		Name:         "fake",
+		Issuer:       f.URL,
+		ClientID:     "meal-planner",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/auth/fake/callback",
+	})
+}
+
+func TestOIDCProviderSignIn(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeIssuer(t)
+	p := newProvider(f)
+
+	verifier := oauth2.GenerateVerifier()
+	loginURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code, state := f.authorize(t, loginURL, jwt.MapClaims{
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	})
+	if state != "state-1" {
+		t.Errorf("state = %q, want state-1", state)
+	}
+
+	identity, err := p.Exchange(ctx, code, "nonce-1", verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	want := auth.Identity{Subject: "user-1", Email: "alice@example.com", EmailVerified: true, Name: "Alice"}
+	if *identity != want {
+		t.Errorf("identity = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestOIDCProviderRejectsMismatches(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeIssuer(t)
+	p := newProvider(f)
+	claims := jwt.MapClaims{"email": "alice@example.com"}
+
+	// The ID token must carry the nonce of this sign-in
+	verifier := oauth2.GenerateVerifier()
+	loginURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code, _ := f.authorize(t, loginURL, claims)
+	if _, err := p.Exchange(ctx, code, "other-nonce", verifier); err == nil {
+		t.Error("Exchange(wrong nonce) succeeded, want an error")
+	}
+
+	// The code can only be redeemed with the matching PKCE verifier
+	loginURL, err = p.AuthCodeURL(ctx, "state-2", "nonce-2", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code, _ = f.authorize(t, loginURL, claims)
+	if _, err := p.Exchange(ctx, code, "nonce-2", oauth2.GenerateVerifier()); err == nil {
+		t.Error("Exchange(wrong verifier) succeeded, want an error")
+	}
+
+	// ID tokens for another client are refused
+	loginURL, err = p.AuthCodeURL(ctx, "state-3", "nonce-3", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code, _ = f.authorize(t, loginURL, jwt.MapClaims{"email": "alice@example.com", "aud": "someone-else"})
+	if _, err := p.Exchange(ctx, code, "nonce-3", verifier); err == nil {
+		t.Error("Exchange(wrong audience) succeeded, want an error")
+	}
+}
+
+func TestOIDCProviderUserInfoFallback(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeIssuer(t)
+	p := newProvider(f)
+
+	verifier := oauth2.GenerateVerifier()
+	loginURL, err := p.AuthCodeURL(ctx, "state-1", "nonce-1", verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code, _ := f.authorize(t, loginURL, nil)
+
+	identity, err := p.Exchange(ctx, code, "nonce-1", verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if identity.Email != "info@example.com" || !identity.EmailVerified || identity.Name != "From User Info" {
+		t.Errorf("identity = %+v, want the user info profile", identity)
+	}
+}
+
+func TestOIDCProviderDiscoveryFailure(t *testing.T) {
+	f := newFakeIssuer(t)
+	p := auth.NewOIDCProvider(auth.ProviderConfig{Name: "fake", Issuer: f.URL + "/missing", ClientID: "meal-planner"})
+
+	if _, err := p.AuthCodeURL(context.Background(), "s", "n", "v"); err == nil {
+		t.Error("AuthCodeURL with a broken issuer succeeded, want an error")
+	}
+}
+
+func TestParseProviderConfigs(t *testing.T) {
+	configs, err := auth.ParseProviderConfigs(`[
+		{"name": "keycloak", "issuer": "https://sso.example.com/realms/home", "clientId": "planner", "clientSecret": "s"},
+		{"name": "authentik", "issuer": "https://auth.example.com", "clientId": "planner", "redirectUrl": "https://planner.example.com/cb"}
+	]`, "https://planner.example.com")
+	if err != nil {
+		t.Fatalf("ParseProviderConfigs: %v", err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("got %d configs, want 2", len(configs))
+	}
+	if got := configs[0].RedirectURL; got != "https://planner.example.com/auth/keycloak/callback" {
+		t.Errorf("default redirect URL = %q", got)
+	}
+	if got := configs[1].RedirectURL; got != "https://planner.example.com/cb" {
+		t.Errorf("explicit redirect URL = %q", got)
+	}
+
+	invalid := map[string]string{
+		"not JSON":       `{`,
+		"bad name":       `[{"name": "Key Cloak", "issuer": "https://x", "clientId": "c"}]`,
+		"duplicate":      `[{"name": "a", "issuer": "https://x", "clientId": "c"}, {"name": "a", "issuer": "https://y", "clientId": "c"}]`,
+		"missing issuer": `[{"name": "a", "clientId": "c"}]`,
+	}
+	for name, data := range invalid {
+		if _, err := auth.ParseProviderConfigs(data, "http://localhost:8080"); err == nil {
+			t.Errorf("ParseProviderConfigs(%s) succeeded, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Password length limits. The upper bound stops very long inputs from being
+// used to make hashing expensive.
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+)
+
+var ErrWeakPassword = fmt.Errorf("password must be between %d and %d characters", MinPasswordLength, MaxPasswordLength)
+
+// argon2Params are the argon2id cost settings, following the second
+// recommended option of RFC 9106
+type argon2Params struct {
+	memory  uint32 // KiB
+	time    uint32
+	threads uint8
+	keyLen  uint32
+	saltLen int
+}
+
+var defaultParams = argon2Params{memory: 64 * 1024, time: 3, threads: 4, keyLen: 32, saltLen: 16}
+
+// CheckPassword reports whether a new password is acceptable
+func CheckPassword(password string) error {
+	if n := utf8.RuneCountInString(password); n < MinPasswordLength || n > MaxPasswordLength {
+		return ErrWeakPassword
+	}
+	return nil
+}
+
+// HashPassword hashes a password with argon2id and a random salt. The result
+// is in the PHC string format, so it records the parameters it was made with:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func HashPassword(password string) (string, error) {
+	p := defaultParams
+	salt := make([]byte, p.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.memory, p.time, p.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// VerifyPassword reports whether password matches a hash made by HashPassword
+func VerifyPassword(hash, password string) (bool, error) {
+	p, salt, key, err := decodeHash(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.time, p.memory, p.threads, p.keyLen)
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+// decodeHash parses a PHC formatted argon2id hash
+func decodeHash(hash string) (p argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, errors.New("unsupported password hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid argon2 parameters: %w", err)
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid salt: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return p, nil, nil, fmt.Errorf("invalid key: %w", err)
+	}
+	p.keyLen = uint32(len(key))
+	return p, salt, key, nil
+}
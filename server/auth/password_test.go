@@ -0,0 +1,66 @@
+package auth_test
+
+import (
+	"strings"
+	"testing"
+
+	"my-meal-planner/auth"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := auth.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=4$") {
+		t.Errorf("hash = %q, want a PHC argon2id string", hash)
+	}
+
+	ok, err := auth.VerifyPassword(hash, "correct horse battery staple")
+	if err != nil || !ok {
+		t.Errorf("VerifyPassword(right password) = %v, %v; want true", ok, err)
+	}
+	ok, err = auth.VerifyPassword(hash, "Correct horse battery staple")
+	if err != nil || ok {
+		t.Errorf("VerifyPassword(wrong password) = %v, %v; want false", ok, err)
+	}
+
+	// Salts are random, so the same password never hashes the same way twice
+	again, err := auth.HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if again == hash {
+		t.Error("two hashes of the same password are identical")
+	}
+}
+
+func TestVerifyPasswordRejectsBadHashes(t *testing.T) {
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$2a$10$abcdefghijklmnopqrstuv",
+		"$argon2i$v=19$m=65536,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=16$m=65536,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=x,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=65536,t=3,p=4$!!!$a2V5",
+	} {
+		if ok, err := auth.VerifyPassword(hash, "password"); err == nil || ok {
+			t.Errorf("VerifyPassword(%q) = %v, %v; want an error", hash, ok, err)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	for password, valid := range map[string]bool{
+		"short":                  false,
+		"12345678":               true,
+		"päßwörd!":               true,
+		strings.Repeat("x", 128): true,
+		strings.Repeat("x", 129): false,
+	} {
+		if err := auth.CheckPassword(password); (err == nil) != valid {
+			t.Errorf("CheckPassword(%q) = %v, want valid %v", password, err, valid)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Package auth signs users in through external identity providers
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// Identity is the user an identity provider vouches for
+type Identity struct {
+	Subject       string // the provider's stable ID for the user
+	Email         string
+	EmailVerified bool
+	Name          string
+	Picture       string
+}
+
+// Provider is an external identity provider users can sign in with
+type Provider interface {
+	// Name identifies the provider in /auth/{name}/login and /auth/{name}/callback
+	Name() string
+	// AuthCodeURL returns the provider's login page for a new sign-in. The
+	// nonce must come back in the ID token, and codeVerifier is the PKCE
+	// secret whose challenge is sent along.
+	AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error)
+	// Exchange redeems the code returned to the callback for the user's identity
+	Exchange(ctx context.Context, code, nonce, codeVerifier string) (*Identity, error)
+}
+
+// ProviderConfig configures an OpenID Connect provider
+type ProviderConfig struct {
+	Name         string   `json:"name"`
+	Issuer       string   `json:"issuer"`
+	ClientID     string   `json:"clientId"`
+	ClientSecret string   `json:"clientSecret"`
+	RedirectURL  string   `json:"redirectUrl,omitempty"` // defaults to {PUBLIC_URL}/auth/{name}/callback
+	Scopes       []string `json:"scopes,omitempty"`      // defaults to openid, email and profile
+}
+
+var providerName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// ParseProviderConfigs parses a JSON array of provider configs, such as the
+// OIDC_PROVIDERS setting, filling in each redirect URL from publicURL
+func ParseProviderConfigs(data, publicURL string) ([]ProviderConfig, error) {
+	var configs []ProviderConfig
+	if err := json.Unmarshal([]byte(data), &configs); err != nil {
+		return nil, fmt.Errorf("invalid provider config: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i := range configs {
+		c := &configs[i]
+		if !providerName.MatchString(c.Name) {
+			return nil, fmt.Errorf("invalid provider name %q: use lowercase letters, digits and dashes", c.Name)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("provider %q is configured twice", c.Name)
+		}
+		seen[c.Name] = true
+		if c.Issuer == "" || c.ClientID == "" {
+			return nil, fmt.Errorf("provider %q needs an issuer and a clientId", c.Name)
+		}
+		if c.RedirectURL == "" {
+			c.RedirectURL = publicURL + "/auth/" + c.Name + "/callback"
+		}
+	}
+	return configs, nil
+}
@@ -0,0 +1,36 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strings"
+)
+
+// APITokenPrefix starts every personal access token, so they are easy to
+// recognise in requests and to find with secret scanners
+const APITokenPrefix = "mmp_"
+
+// apiTokenHintLength is how much of a token is kept in the clear to identify it
+const apiTokenHintLength = len(APITokenPrefix) + 4
+
+// NewAPIToken returns a new random personal access token together with the
+// hash to store and the prefix shown to identify it
+func NewAPIToken() (token, hash, prefix string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+	token = APITokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	return token, HashAPIToken(token), token[:apiTokenHintLength], nil
+}
+
+// HashAPIToken returns the hash stored for a personal access token
+func HashAPIToken(token string) string {
+	return HashRefreshToken(token)
+}
+
+// IsAPIToken reports whether a bearer credential is a personal access token
+// rather than a JWT
+func IsAPIToken(credential string) bool {
+	return strings.HasPrefix(credential, APITokenPrefix)
+}
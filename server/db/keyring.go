@@ -0,0 +1,203 @@
+package db
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is one key in a Keyring. Keys loaded from a public key file can
+// only verify tokens.
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private interface{} // *rsa.PrivateKey, ed25519.PrivateKey or []byte; nil if verify-only
+	public  interface{} // *rsa.PublicKey, ed25519.PublicKey or []byte
+}
+
+// Keyring holds the keys used to sign and verify tokens. New tokens are signed
+// with the active key and carry its ID in the kid header; every key in the
+// ring can verify, so tokens signed before a rotation stay valid until they
+// expire.
+type Keyring struct {
+	active *signingKey
+	keys   map[string]*signingKey
+}
+
+// NewHMACKeyring creates a keyring that signs with a shared HS256 secret.
+// Its key can't be published, so the JWKS is empty.
+func NewHMACKeyring(secret []byte) *Keyring {
+	key := &signingKey{id: "hmac", method: jwt.SigningMethodHS256, private: secret, public: secret}
+	return &Keyring{active: key, keys: map[string]*signingKey{key.id: key}}
+}
+
+// LoadKeyring loads every *.pem file in dir. Each file name, minus the
+// extension, is that key's ID. RSA keys sign with RS256 and Ed25519 keys with
+// EdDSA; files holding only a public key can verify but not sign.
+//
+// The active key is activeID if set, otherwise the private key whose ID sorts
+// last, so naming keys by date makes the newest one active. To rotate, add a
+// new key and restart; remove the old one once its tokens have expired.
+func LoadKeyring(dir, activeID string) (*Keyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	ring := &Keyring{keys: make(map[string]*signingKey)}
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %w", path, err)
+		}
+		ring.keys[key.id] = key
+		if key.private != nil && activeID == "" {
+			ring.active = key
+		}
+	}
+
+	if activeID != "" {
+		key, exists := ring.keys[activeID]
+		if !exists || key.private == nil {
+			return nil, fmt.Errorf("no private key %q in %s", activeID, dir)
+		}
+		ring.active = key
+	}
+	if ring.active == nil {
+		return nil, fmt.Errorf("no private keys in %s", dir)
+	}
+	return ring, nil
+}
+
+// loadKey parses a PEM file holding a PKCS#8 or PKCS#1 private key or a PKIX public key
+func loadKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{id: strings.TrimSuffix(filepath.Base(path), ".pem")}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, expected RSA or Ed25519", parsed)
+	}
+	return key, nil
+}
+
+// sign signs claims with the active key and sets the kid header
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.method, claims)
+	token.Header["kid"] = k.active.id
+	return token.SignedString(k.active.private)
+}
+
+// verificationKey is a jwt.Keyfunc that finds the key named by a token's kid
+// header and checks the token uses that key's algorithm
+func (k *Keyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, exists := k.keys[kid]
+	if !exists {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("key %q doesn't sign with %s", kid, token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// methods returns the signing algorithms of the keys in the ring
+func (k *Keyring) methods() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range k.keys {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// JSONWebKey is the public half of a signing key in JWK form (RFC 7517)
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA keys
+	Modulus  string `json:"n,omitempty"`
+	Exponent string `json:"e,omitempty"`
+	// Ed25519 keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, as served from /.well-known/jwks.json
+type JWKS struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys that verify tokens, ordered by key ID.
+// Shared HMAC secrets are never included.
+func (k *Keyring) JWKS() JWKS {
+	set := JWKS{Keys: []JSONWebKey{}}
+	for _, key := range k.keys {
+		jwk := JSONWebKey{KeyID: key.id, Use: "sig", Algorithm: key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.Modulus = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.Exponent = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyID < set.Keys[j].KeyID
+	})
+	return set
+}
@@ -0,0 +1,244 @@
+package db_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"my-meal-planner/db"
+	"my-meal-planner/models"
+)
+
+// writeKey writes a private key, or its public half, as a PEM file named id.pem
+func writeKey(t *testing.T, dir, id string, key interface{}, publicOnly bool) {
+	t.Helper()
+
+	var block *pem.Block
+	if publicOnly {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			t.Fatalf("MarshalPKIXPublicKey: %v", err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+".pem"), pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+// storeWithSession returns a memory store using keys with alice signed in on session "s1"
+func storeWithSession(t *testing.T, keys *db.Keyring) (*db.MemoryStore, *models.User) {
+	t.Helper()
+
+	ctx := context.Background()
+	s := db.NewMemoryStore(keys)
+	alice := &models.User{ID: "alice", Email: "alice@example.com"}
+	if err := s.CreateOrUpdateUser(ctx, alice); err != nil {
+		t.Fatalf("CreateOrUpdateUser: %v", err)
+	}
+	if err := s.CreateSession(ctx, &models.Session{ID: "s1", UserID: "alice", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	return s, alice
+}
+
+// tokenHeader returns the alg and kid headers of a token without verifying it
+func tokenHeader(t *testing.T, token string) (alg, kid string) {
+	t.Helper()
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &db.TokenClaims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified: %v", err)
+	}
+	kid, _ = parsed.Header["kid"].(string)
+	return parsed.Method.Alg(), kid
+}
+
+func TestKeyringRotation(t *testing.T) {
+	ctx := context.Background()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	// Before the rotation only the RSA key exists
+	dir := t.TempDir()
+	writeKey(t, dir, "2026-01", rsaKey, false)
+	before, err := db.LoadKeyring(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	oldStore, alice := storeWithSession(t, before)
+	oldToken, err := oldStore.GenerateToken(ctx, alice, "s1")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	// Adding a newer key makes it active
+	writeKey(t, dir, "2026-02", edKey, false)
+	after, err := db.LoadKeyring(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	newStore, alice := storeWithSession(t, after)
+	newToken, err := newStore.GenerateToken(ctx, alice, "s1")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	if alg, kid := tokenHeader(t, oldToken); alg != "RS256" || kid != "2026-01" {
+		t.Errorf("old token header = %s/%s, want RS256/2026-01", alg, kid)
+	}
+	if alg, kid := tokenHeader(t, newToken); alg != "EdDSA" || kid != "2026-02" {
+		t.Errorf("new token header = %s/%s, want EdDSA/2026-02", alg, kid)
+	}
+
+	// Tokens signed before the rotation still verify
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, err := newStore.ValidateToken(ctx, token); err != nil {
+			t.Errorf("ValidateToken(%s token): %v", name, err)
+		}
+	}
+
+	// Once the old key is removed its tokens stop verifying
+	if err := os.Remove(filepath.Join(dir, "2026-01.pem")); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	retired, err := db.LoadKeyring(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	retiredStore, _ := storeWithSession(t, retired)
+	if _, err := retiredStore.ValidateToken(ctx, oldToken); !errors.Is(err, db.ErrInvalidToken) {
+		t.Errorf("ValidateToken(retired key) error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestKeyringJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	edPublic, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	dir := t.TempDir()
+	writeKey(t, dir, "a-rsa", rsaKey, false)
+	writeKey(t, dir, "b-ed", edKey, false)
+	writeKey(t, dir, "c-retired", edPublic, true)
+
+	// A verify-only key can't be active, so the newest private key is
+	keys, err := db.LoadKeyring(dir, "")
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+	s, alice := storeWithSession(t, keys)
+	token, err := s.GenerateToken(context.Background(), alice, "s1")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	if alg, kid := tokenHeader(t, token); alg != "EdDSA" || kid != "b-ed" {
+		t.Errorf("token header = %s/%s, want EdDSA/b-ed", alg, kid)
+	}
+
+	set := keys.JWKS()
+	if len(set.Keys) != 3 {
+		t.Fatalf("JWKS has %d keys, want 3", len(set.Keys))
+	}
+	want := []struct{ kid, kty, alg string }{
+		{"a-rsa", "RSA", "RS256"},
+		{"b-ed", "OKP", "EdDSA"},
+		{"c-retired", "OKP", "EdDSA"},
+	}
+	for i, w := range want {
+		got := set.Keys[i]
+		if got.KeyID != w.kid || got.KeyType != w.kty || got.Algorithm != w.alg || got.Use != "sig" {
+			t.Errorf("JWKS key %d = %+v, want %s %s %s", i, got, w.kid, w.kty, w.alg)
+		}
+	}
+	if set.Keys[0].Modulus == "" || set.Keys[0].Exponent != "AQAB" {
+		t.Errorf("RSA JWK = %+v, want modulus and exponent AQAB", set.Keys[0])
+	}
+	if set.Keys[1].Curve != "Ed25519" || set.Keys[1].X == "" {
+		t.Errorf("Ed25519 JWK = %+v, want crv Ed25519 and x", set.Keys[1])
+	}
+
+	if got := db.NewHMACKeyring([]byte("secret")).JWKS(); len(got.Keys) != 0 {
+		t.Errorf("HMAC JWKS = %+v, want no keys", got)
+	}
+}
+
+func TestKeyringRejectsForeignTokens(t *testing.T) {
+	ctx := context.Background()
+	s, alice := storeWithSession(t, db.NewHMACKeyring([]byte("secret")))
+
+	other, _ := storeWithSession(t, db.NewHMACKeyring([]byte("other-secret")))
+	forged, err := other.GenerateToken(ctx, alice, "s1")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	if _, err := s.ValidateToken(ctx, forged); !errors.Is(err, db.ErrInvalidToken) {
+		t.Errorf("ValidateToken(other secret) error = %v, want ErrInvalidToken", err)
+	}
+
+	// A token without a kid header matches no key
+	claims := &db.TokenClaims{
+		UserID:    alice.ID,
+		SessionID: "s1",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+	unkeyed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	if _, err := s.ValidateToken(ctx, unkeyed); !errors.Is(err, db.ErrInvalidToken) {
+		t.Errorf("ValidateToken(no kid) error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestLoadKeyringErrors(t *testing.T) {
+	if _, err := db.LoadKeyring(t.TempDir(), ""); err == nil {
+		t.Error("LoadKeyring(empty dir) succeeded, want an error")
+	}
+
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	dir := t.TempDir()
+	writeKey(t, dir, "public-only", edPublic, true)
+	if _, err := db.LoadKeyring(dir, "public-only"); err == nil {
+		t.Error("LoadKeyring(public key active) succeeded, want an error")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "junk.pem"), []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := db.LoadKeyring(dir, ""); err == nil {
+		t.Error("LoadKeyring(junk file) succeeded, want an error")
+	}
+}
@@ -0,0 +1,258 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"my-meal-planner/models"
+)
+
+// ErrInvalidListOptions is returned when list options or a cursor are malformed
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// SortOrder names a stable order for list results
+type SortOrder string
+
+const (
+	// SortByDay orders meals Monday to Sunday, then breakfast, lunch and dinner
+	SortByDay SortOrder = "day"
+	// SortByUpdated puts the most recently updated records first
+	SortByUpdated SortOrder = "updatedAt"
+	// SortByName orders records by name, ignoring case
+	SortByName SortOrder = "name"
+)
+
+// ListOptions controls the order, filters and page returned by the list methods.
+// Ties are always broken by ID, so every order is stable across pages.
+type ListOptions struct {
+	// Sort is the result order; empty uses SortByDay for meals and SortByUpdated for plans
+	Sort SortOrder
+	// Day keeps only meals on this day
+	Day string
+	// MealType keeps only meals of this type
+	MealType string
+	// Text keeps records whose name or description contains it, ignoring case
+	Text string
+	// Cursor continues after the last record of a previous page
+	Cursor string
+	// Limit caps the page size; zero returns every remaining record
+	Limit int
+}
+
+var dayOrder = map[string]int{
+	"Monday":    0,
+	"Tuesday":   1,
+	"Wednesday": 2,
+	"Thursday":  3,
+	"Friday":    4,
+	"Saturday":  5,
+	"Sunday":    6,
+}
+
+var mealTypeOrder = map[string]int{
+	"Breakfast": 0,
+	"Lunch":     1,
+	"Dinner":    2,
+}
+
+// mealKeys maps each order meals can be listed in to a meal's sort key
+var mealKeys = map[SortOrder]func(*models.Meal) []string{
+	SortByDay: func(m *models.Meal) []string {
+		return []string{rank(dayOrder, m.Day), m.Day, rank(mealTypeOrder, m.MealType), m.MealType, m.ID}
+	},
+	SortByUpdated: func(m *models.Meal) []string { return []string{newestFirst(m.UpdatedAt), m.ID} },
+	SortByName:    func(m *models.Meal) []string { return []string{strings.ToLower(m.Name), m.ID} },
+}
+
+// mealPlanKeys maps each order meal plans can be listed in to a plan's sort key
+var mealPlanKeys = map[SortOrder]func(*models.MealPlan) []string{
+	SortByUpdated: func(p *models.MealPlan) []string { return []string{newestFirst(p.UpdatedAt), p.ID} },
+	SortByName:    func(p *models.MealPlan) []string { return []string{strings.ToLower(p.Name), p.ID} },
+}
+
+// mealListOptions fills in the default order for meals and checks the options
+func mealListOptions(opts ListOptions) (ListOptions, error) {
+	if opts.Sort == "" {
+		opts.Sort = SortByDay
+	}
+	if _, ok := mealKeys[opts.Sort]; !ok {
+		return opts, fmt.Errorf("%w: unknown sort %q", ErrInvalidListOptions, opts.Sort)
+	}
+	if opts.Limit < 0 {
+		return opts, fmt.Errorf("%w: negative limit", ErrInvalidListOptions)
+	}
+	return opts, nil
+}
+
+// mealPlanListOptions fills in the default order for meal plans and checks the options
+func mealPlanListOptions(opts ListOptions) (ListOptions, error) {
+	if opts.Sort == "" {
+		opts.Sort = SortByUpdated
+	}
+	if opts.Day != "" || opts.MealType != "" {
+		return opts, fmt.Errorf("%w: meal plans can't be filtered by day or meal type", ErrInvalidListOptions)
+	}
+	if _, ok := mealPlanKeys[opts.Sort]; !ok {
+		return opts, fmt.Errorf("%w: meal plans can't be sorted by %q", ErrInvalidListOptions, opts.Sort)
+	}
+	if opts.Limit < 0 {
+		return opts, fmt.Errorf("%w: negative limit", ErrInvalidListOptions)
+	}
+	return opts, nil
+}
+
+// pageMeals filters, sorts and pages the meals of a plan
+func pageMeals(meals []*models.Meal, opts ListOptions) ([]*models.Meal, string, error) {
+	opts, err := mealListOptions(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	match := func(m *models.Meal) bool {
+		return (opts.Day == "" || m.Day == opts.Day) &&
+			(opts.MealType == "" || m.MealType == opts.MealType) &&
+			containsText(opts.Text, m.Name, m.Description)
+	}
+	return page(meals, match, mealKeys[opts.Sort], opts)
+}
+
+// pageMealPlans filters, sorts and pages a user's meal plans
+func pageMealPlans(plans []*models.MealPlan, opts ListOptions) ([]*models.MealPlan, string, error) {
+	opts, err := mealPlanListOptions(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	match := func(p *models.MealPlan) bool {
+		return containsText(opts.Text, p.Name, p.Description)
+	}
+	return page(plans, match, mealPlanKeys[opts.Sort], opts)
+}
+
+// listCursor is the decoded form of a cursor: the sort it was issued for and
+// the sort key of the last record returned
+type listCursor struct {
+	Sort SortOrder `json:"s"`
+	Key  []string  `json:"k"`
+}
+
+// decodeCursor returns the sort key held by a cursor issued for sort, or nil
+// when there is no cursor
+func decodeCursor(cursor string, sort SortOrder) ([]string, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidListOptions)
+	}
+	var decoded listCursor
+	if err := json.Unmarshal(raw, &decoded); err != nil || decoded.Sort != sort {
+		return nil, fmt.Errorf("%w: cursor doesn't match this query", ErrInvalidListOptions)
+	}
+	return decoded.Key, nil
+}
+
+// encodeCursor returns the cursor that continues after the record with key
+func encodeCursor(sort SortOrder, key []string) (string, error) {
+	raw, err := json.Marshal(listCursor{Sort: sort, Key: key})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// page keeps the records that match, sorts them by key and returns the ones
+// after the cursor, together with the cursor for the following page. Cursors
+// hold a sort key rather than a position, so records added or removed between
+// requests don't shift the pages.
+func page[T any](records []*T, match func(*T) bool, key func(*T) []string, opts ListOptions) ([]*T, string, error) {
+	after, err := decodeCursor(opts.Cursor, opts.Sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	type keyed struct {
+		record *T
+		key    []string
+	}
+	var matched []keyed
+	for _, record := range records {
+		if !match(record) {
+			continue
+		}
+		k := key(record)
+		if after != nil && compareKeys(k, after) <= 0 {
+			continue
+		}
+		matched = append(matched, keyed{record, k})
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return compareKeys(matched[i].key, matched[j].key) < 0
+	})
+
+	next := ""
+	if opts.Limit > 0 && len(matched) > opts.Limit {
+		matched = matched[:opts.Limit]
+		next, err = encodeCursor(opts.Sort, matched[len(matched)-1].key)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	result := make([]*T, 0, len(matched))
+	for _, m := range matched {
+		result = append(result, m.record)
+	}
+	return result, next, nil
+}
+
+// compareKeys orders two sort keys element by element
+func compareKeys(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// rank returns a fixed-width key for a value's position in order, placing
+// unknown values after the known ones
+func rank(order map[string]int, value string) string {
+	position, known := order[value]
+	if !known {
+		position = len(order)
+	}
+	return fmt.Sprintf("%02d", position)
+}
+
+// newestFirst returns a fixed-width key that sorts later timestamps first.
+// Times before 1970, including the zero time, sort last.
+func newestFirst(t time.Time) string {
+	var unixNano int64
+	if t.After(time.Unix(0, 0)) {
+		unixNano = t.UnixNano()
+	}
+	return fmt.Sprintf("%020d", math.MaxInt64-unixNano)
+}
+
+// containsText reports whether any field contains text, ignoring case
+func containsText(text string, fields ...string) bool {
+	if text == "" {
+		return true
+	}
+	text = strings.ToLower(text)
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), text) {
+			return true
+		}
+	}
+	return false
+}
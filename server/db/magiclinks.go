@@ -0,0 +1,26 @@
+package db
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+)
+
+// MagicLinkTTL is how long an emailed sign-in link can be used
+const MagicLinkTTL = 15 * time.Minute
+
+// NewMagicLinkToken returns a new random sign-in link token together with
+// the hash to store
+func NewMagicLinkToken() (token, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(secret)
+	return token, HashMagicLinkToken(token), nil
+}
+
+// HashMagicLinkToken returns the hash stored for a sign-in link token
+func HashMagicLinkToken(token string) string {
+	return HashRefreshToken(token)
+}
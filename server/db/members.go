@@ -0,0 +1,67 @@
+package db
+
+import (
+	"sort"
+	"strings"
+
+	"my-meal-planner/models"
+)
+
+// RoleRank orders access roles from least to most privileged, so each role
+// includes the ones ranked below it. Unknown roles rank lowest, at 0.
+func RoleRank(role string) int {
+	switch role {
+	case "viewer":
+		return 1
+	case "editor":
+		return 2
+	case "owner":
+		return 3
+	default:
+		return 0
+	}
+}
+
+// collectMembers merges the members of a plan so each user appears once with
+// their highest role and earliest join time, and sorts them owners first,
+// then by name
+func collectMembers(members []*models.MealPlanMember) []*models.MealPlanMember {
+	byUser := make(map[string]*models.MealPlanMember)
+	merged := make([]*models.MealPlanMember, 0, len(members))
+	for _, member := range members {
+		if existing, ok := byUser[member.UserID]; ok {
+			if RoleRank(member.Role) > RoleRank(existing.Role) {
+				existing.Role = member.Role
+			}
+			if member.JoinedAt.Before(existing.JoinedAt) {
+				existing.JoinedAt = member.JoinedAt
+			}
+			continue
+		}
+		byUser[member.UserID] = member
+		merged = append(merged, member)
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		a, b := merged[i], merged[j]
+		if RoleRank(a.Role) != RoleRank(b.Role) {
+			return RoleRank(a.Role) > RoleRank(b.Role)
+		}
+		if nameA, nameB := strings.ToLower(a.Name), strings.ToLower(b.Name); nameA != nameB {
+			return nameA < nameB
+		}
+		return a.UserID < b.UserID
+	})
+	return merged
+}
+
+func toMember(user *models.User, access *models.MealPlanAccess) *models.MealPlanMember {
+	return &models.MealPlanMember{
+		UserID:   user.ID,
+		Name:     user.Name,
+		Email:    user.Email,
+		Picture:  user.Picture,
+		Role:     access.Role,
+		JoinedAt: access.CreatedAt,
+	}
+}
@@ -0,0 +1,517 @@
+package db
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"my-meal-planner/models"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	journalFile  = "journal.log"
+)
+
+// Table names used in journal entries
+const (
+	tableUsers          = "users"
+	tableMeals          = "meals"
+	tableMealPlans      = "meal_plans"
+	tableMealPlanAccess = "meal_plan_access"
+	tableShareCodes     = "share_codes"
+	tableSessions       = "sessions"
+	tablePasswords      = "passwords"
+	tableMagicLinks     = "magic_links"
+	tableAPITokens      = "api_tokens"
+	tableTransfers      = "ownership_transfers"
+	tableRedemptions    = "share_code_redemptions"
+	tableInvitations    = "invitations"
+)
+
+// FsyncPolicy controls when journal writes are flushed to disk
+type FsyncPolicy string
+
+const (
+	// FsyncAlways syncs after every write; nothing acknowledged is ever lost
+	FsyncAlways FsyncPolicy = "always"
+	// FsyncInterval syncs once per second; a crash loses at most a second of writes
+	FsyncInterval FsyncPolicy = "interval"
+	// FsyncNever leaves flushing to the operating system
+	FsyncNever FsyncPolicy = "never"
+)
+
+// ParseFsyncPolicy parses an fsync policy name, defaulting to FsyncInterval
+func ParseFsyncPolicy(name string) (FsyncPolicy, error) {
+	switch FsyncPolicy(name) {
+	case "":
+		return FsyncInterval, nil
+	case FsyncAlways, FsyncInterval, FsyncNever:
+		return FsyncPolicy(name), nil
+	default:
+		return "", fmt.Errorf("unknown fsync policy %q, expected always, interval or never", name)
+	}
+}
+
+// PersistenceOptions configures where and how a MemoryStore is persisted
+type PersistenceOptions struct {
+	// Dir holds the snapshot and journal files
+	Dir string
+	// Fsync controls when journal writes reach the disk
+	Fsync FsyncPolicy
+	// SnapshotInterval is how often the journal is compacted into a new
+	// snapshot. Zero disables periodic snapshots; one is still taken on Close.
+	SnapshotInterval time.Duration
+}
+
+// journalChange is a single put or delete of a record
+type journalChange struct {
+	Op    string          `json:"op"` // "put" or "delete"
+	Table string          `json:"table"`
+	ID    string          `json:"id"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// journalEntry is one line of the journal. All changes in an entry belong to
+// the same write or transaction and are replayed together or not at all.
+type journalEntry struct {
+	Changes []journalChange `json:"changes"`
+}
+
+// memorySnapshot is the on-disk form of every MemoryStore table
+type memorySnapshot struct {
+	Users          map[string]*models.User                `json:"users"`
+	Meals          map[string]*models.Meal                `json:"meals"`
+	MealPlans      map[string]*models.MealPlan            `json:"mealPlans"`
+	MealPlanAccess map[string]*models.MealPlanAccess      `json:"mealPlanAccess"`
+	ShareCodes     map[string]*models.ShareCode           `json:"shareCodes"`
+	Sessions       map[string]*models.Session             `json:"sessions"`
+	Passwords      map[string]*models.Password            `json:"passwords"`
+	MagicLinks     map[string]*models.MagicLink           `json:"magicLinks"`
+	APITokens      map[string]*models.APIToken            `json:"apiTokens"`
+	Transfers      map[string]*models.OwnershipTransfer   `json:"ownershipTransfers"`
+	Redemptions    map[string]*models.ShareCodeRedemption `json:"shareCodeRedemptions"`
+	Invitations    map[string]*models.Invitation          `json:"invitations"`
+}
+
+// journal appends entries to the write-ahead log in a data directory
+type journal struct {
+	dir    string
+	fsync  FsyncPolicy
+	file   *os.File
+	mutex  sync.Mutex
+	size   int64 // offset just past the last complete entry
+	dirty  bool  // unsynced writes pending under FsyncInterval
+	broken error // set when a failed write couldn't be cut off again
+}
+
+// append writes the changes as a single journal entry. If the write fails,
+// whatever part of it reached the file is cut off, so the journal always ends
+// with a complete entry.
+func (j *journal) append(changes []journalChange) error {
+	line, err := json.Marshal(journalEntry{Changes: changes})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	// Entries written after a torn one would be lost on replay
+	if j.broken != nil {
+		return j.broken
+	}
+
+	_, err = j.file.Write(line)
+	if err == nil && j.fsync == FsyncAlways {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		if truncErr := j.file.Truncate(j.size); truncErr != nil {
+			j.broken = fmt.Errorf("journal has a torn entry until the next snapshot: %w", truncErr)
+		}
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	j.size += int64(len(line))
+	if j.fsync == FsyncInterval {
+		j.dirty = true
+	}
+	return nil
+}
+
+// sync flushes pending writes to disk
+func (j *journal) sync() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if !j.dirty {
+		return nil
+	}
+	j.dirty = false
+	return j.file.Sync()
+}
+
+// truncate empties the journal once its contents are covered by a snapshot
+func (j *journal) truncate() error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if err := j.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	j.size = 0
+	j.dirty = false
+	j.broken = nil
+	return j.file.Sync()
+}
+
+// recordPut journals the new value of a record before it is stored
+func (s *MemoryStore) recordPut(table, id string, value interface{}) error {
+	s.recordUndo(table, id)
+	if s.journal == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return s.record(journalChange{Op: "put", Table: table, ID: id, Value: raw})
+}
+
+// recordDelete journals the removal of a record before it is deleted
+func (s *MemoryStore) recordDelete(table, id string) error {
+	s.recordUndo(table, id)
+	if s.journal == nil {
+		return nil
+	}
+	return s.record(journalChange{Op: "delete", Table: table, ID: id})
+}
+
+// recordUndo saves a record's current state inside WithTx, so the change
+// about to be made can be rolled back
+func (s *MemoryStore) recordUndo(table, id string) {
+	if s.inTx {
+		s.undo = append(s.undo, undoEntry{table: table, id: id, old: s.row(table, id)})
+	}
+}
+
+// record writes a change to the journal, or buffers it until commit inside WithTx
+func (s *MemoryStore) record(change journalChange) error {
+	if s.inTx {
+		s.pending = append(s.pending, change)
+		return nil
+	}
+	return s.journal.append([]journalChange{change})
+}
+
+// OpenMemoryStore creates an in-memory store persisted to opts.Dir. The last
+// snapshot is loaded and the journal replayed before the store is returned.
+// Call Close to write a final snapshot and release the journal.
+func OpenMemoryStore(keys *Keyring, opts PersistenceOptions) (*MemoryStore, error) {
+	if err := os.MkdirAll(opts.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create data dir: %w", err)
+	}
+
+	s := NewMemoryStore(keys)
+	if err := s.loadSnapshot(filepath.Join(opts.Dir, snapshotFile)); err != nil {
+		return nil, err
+	}
+
+	journalPath := filepath.Join(opts.Dir, journalFile)
+	replayed, size, err := s.replayJournal(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	s.reindex()
+
+	file, err := os.OpenFile(journalPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	// Drop a torn entry left by a crash, so new entries don't follow it
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	if err := syncDir(opts.Dir); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	s.journal = &journal{dir: opts.Dir, fsync: opts.Fsync, file: file, size: size}
+
+	// Fold the replayed entries into a fresh snapshot so the journal starts empty
+	if replayed > 0 {
+		if err := s.Snapshot(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	s.done = make(chan struct{})
+	s.stopped = make(chan struct{})
+	go s.persistLoop(opts)
+
+	return s, nil
+}
+
+// persistLoop syncs the journal and takes periodic snapshots until Close
+func (s *MemoryStore) persistLoop(opts PersistenceOptions) {
+	defer close(s.stopped)
+
+	syncTicker := time.NewTicker(time.Second)
+	defer syncTicker.Stop()
+
+	var snapshots <-chan time.Time
+	if opts.SnapshotInterval > 0 {
+		snapshotTicker := time.NewTicker(opts.SnapshotInterval)
+		defer snapshotTicker.Stop()
+		snapshots = snapshotTicker.C
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-syncTicker.C:
+			if err := s.journal.sync(); err != nil {
+				log.Printf("failed to sync journal: %v", err)
+			}
+		case <-snapshots:
+			if err := s.Snapshot(); err != nil {
+				log.Printf("failed to write snapshot: %v", err)
+			}
+		}
+	}
+}
+
+// Snapshot writes every table to the snapshot file and truncates the journal
+func (s *MemoryStore) Snapshot() error {
+	if s.journal == nil {
+		return errors.New("store is not persistent")
+	}
+
+	// Hold the write lock so no change lands between the snapshot and the truncate
+	s.lock()
+	defer s.unlock()
+
+	data, err := json.Marshal(memorySnapshot{
+		Users:          s.users,
+		Meals:          s.meals,
+		MealPlans:      s.mealPlans,
+		MealPlanAccess: s.mealPlanAccess,
+		ShareCodes:     s.shareCodes,
+		Sessions:       s.sessions,
+		Passwords:      s.passwords,
+		MagicLinks:     s.magicLinks,
+		APITokens:      s.apiTokens,
+		Transfers:      s.transfers,
+		Redemptions:    s.redemptions,
+		Invitations:    s.invitations,
+	})
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.journal.dir, snapshotFile)
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return s.journal.truncate()
+}
+
+// Close stops background persistence, writes a final snapshot and closes the journal
+func (s *MemoryStore) Close() error {
+	if s.journal == nil {
+		return nil
+	}
+
+	close(s.done)
+	<-s.stopped
+
+	err := s.Snapshot()
+	if closeErr := s.journal.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// loadSnapshot populates the tables from a snapshot file, if one exists
+func (s *MemoryStore) loadSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse snapshot: %w", err)
+	}
+
+	copyInto(s.users, snapshot.Users)
+	copyInto(s.meals, snapshot.Meals)
+	copyInto(s.mealPlans, snapshot.MealPlans)
+	copyInto(s.mealPlanAccess, snapshot.MealPlanAccess)
+	copyInto(s.shareCodes, snapshot.ShareCodes)
+	copyInto(s.sessions, snapshot.Sessions)
+	copyInto(s.passwords, snapshot.Passwords)
+	copyInto(s.magicLinks, snapshot.MagicLinks)
+	copyInto(s.apiTokens, snapshot.APITokens)
+	copyInto(s.transfers, snapshot.Transfers)
+	copyInto(s.redemptions, snapshot.Redemptions)
+	copyInto(s.invitations, snapshot.Invitations)
+	return nil
+}
+
+// replayJournal applies every complete journal entry. It returns how many
+// were applied and the offset just past the last one. A torn final entry from
+// a crash mid-write is reported and ignored; corruption before the end is an
+// error.
+func (s *MemoryStore) replayJournal(path string) (int, int64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	count := 0
+	var size int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("ignoring incomplete journal entry after %d entries", count)
+			}
+			return count, size, nil
+		}
+		if err != nil {
+			return count, size, fmt.Errorf("failed to read journal: %w", err)
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				log.Printf("ignoring torn journal entry after %d entries: %v", count, err)
+				return count, size, nil
+			}
+			return count, size, fmt.Errorf("corrupt journal entry %d: %w", count+1, err)
+		}
+		for _, change := range entry.Changes {
+			if err := s.apply(change); err != nil {
+				return count, size, fmt.Errorf("journal entry %d: %w", count+1, err)
+			}
+		}
+		count++
+		size += int64(len(line))
+	}
+}
+
+// apply replays a single journal change against the tables
+func (s *MemoryStore) apply(change journalChange) error {
+	switch change.Table {
+	case tableUsers:
+		return applyChange(s.users, change)
+	case tableMeals:
+		return applyChange(s.meals, change)
+	case tableMealPlans:
+		return applyChange(s.mealPlans, change)
+	case tableMealPlanAccess:
+		return applyChange(s.mealPlanAccess, change)
+	case tableShareCodes:
+		return applyChange(s.shareCodes, change)
+	case tableSessions:
+		return applyChange(s.sessions, change)
+	case tablePasswords:
+		return applyChange(s.passwords, change)
+	case tableMagicLinks:
+		return applyChange(s.magicLinks, change)
+	case tableAPITokens:
+		return applyChange(s.apiTokens, change)
+	case tableTransfers:
+		return applyChange(s.transfers, change)
+	case tableRedemptions:
+		return applyChange(s.redemptions, change)
+	case tableInvitations:
+		return applyChange(s.invitations, change)
+	default:
+		return fmt.Errorf("unknown table %q", change.Table)
+	}
+}
+
+func applyChange[T any](m map[string]*T, change journalChange) error {
+	switch change.Op {
+	case "put":
+		record := new(T)
+		if err := json.Unmarshal(change.Value, record); err != nil {
+			return err
+		}
+		m[change.ID] = record
+	case "delete":
+		delete(m, change.ID)
+	default:
+		return fmt.Errorf("unknown journal op %q", change.Op)
+	}
+	return nil
+}
+
+func copyInto[T any](dst, src map[string]*T) {
+	for key, value := range src {
+		dst[key] = value
+	}
+}
+
+// writeFileAtomic writes data to a temporary file and renames it into place,
+// syncing both so the new file is on disk before it returns
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// The rename only survives a crash once the directory is synced too
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes a directory's entries, such as a new or renamed file, to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,188 @@
+package db_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"my-meal-planner/db"
+	"my-meal-planner/db/storetest"
+	"my-meal-planner/models"
+)
+
+func TestMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.Store {
+		return db.NewMemoryStore(db.NewHMACKeyring([]byte("test-secret")))
+	})
+}
+
+func TestPersistentMemoryStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.Store {
+		s, err := db.OpenMemoryStore(db.NewHMACKeyring([]byte("test-secret")), db.PersistenceOptions{
+			Dir:   t.TempDir(),
+			Fsync: db.FsyncNever,
+		})
+		if err != nil {
+			t.Fatalf("OpenMemoryStore: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestMemoryStoreReopen(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	opts := db.PersistenceOptions{Dir: dir, Fsync: db.FsyncAlways}
+
+	s, err := db.OpenMemoryStore(db.NewHMACKeyring([]byte("test-secret")), opts)
+	if err != nil {
+		t.Fatalf("OpenMemoryStore: %v", err)
+	}
+	if err := s.CreateOrUpdateUser(ctx, &models.User{ID: "alice", Email: "alice@example.com"}); err != nil {
+		t.Fatalf("CreateOrUpdateUser: %v", err)
+	}
+	if err := s.CreateMealPlan(ctx, &models.MealPlan{ID: "plan-1", Name: "Plan", CreatedBy: "alice"}); err != nil {
+		t.Fatalf("CreateMealPlan: %v", err)
+	}
+	if err := s.Snapshot(); err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	// Changes after the snapshot only live in the journal
+	if err := s.UpdateMealPlan(ctx, &models.MealPlan{ID: "plan-1", Name: "Renamed", Version: 1}); err != nil {
+		t.Fatalf("UpdateMealPlan: %v", err)
+	}
+	if err := s.CreateMeal(ctx, &models.Meal{ID: "meal-1", MealPlanID: "plan-1", Name: "Soup"}); err != nil {
+		t.Fatalf("CreateMeal: %v", err)
+	}
+	if err := s.CreateSession(ctx, &models.Session{ID: "session-1", UserID: "alice", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := s.SetPassword(ctx, "alice", "hash"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	if err := s.CreateOrUpdateUser(ctx, &models.User{ID: "bob", Email: "bob@example.com"}); err != nil {
+		t.Fatalf("CreateOrUpdateUser: %v", err)
+	}
+	transfer := &models.OwnershipTransfer{MealPlanID: "plan-1", FromUserID: "alice", ToUserID: "bob", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.CreateOwnershipTransfer(ctx, transfer); err != nil {
+		t.Fatalf("CreateOwnershipTransfer: %v", err)
+	}
+	code := &models.ShareCode{ID: "code-1", MealPlanID: "plan-1", CreatedBy: "alice", Role: "viewer", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.CreateShareCode(ctx, code); err != nil {
+		t.Fatalf("CreateShareCode: %v", err)
+	}
+	if err := s.RedeemShareCode(ctx, "code-1", "bob", time.Now()); err != nil {
+		t.Fatalf("RedeemShareCode: %v", err)
+	}
+	invitation := &models.Invitation{MealPlanID: "plan-1", Email: "carol@example.com", Role: "viewer", InvitedBy: "alice"}
+	if err := s.CreateInvitation(ctx, invitation); err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened, err := db.OpenMemoryStore(db.NewHMACKeyring([]byte("test-secret")), opts)
+	if err != nil {
+		t.Fatalf("OpenMemoryStore after close: %v", err)
+	}
+	defer reopened.Close()
+
+	plan, err := reopened.GetMealPlan(ctx, "plan-1")
+	if err != nil {
+		t.Fatalf("GetMealPlan: %v", err)
+	}
+	if plan.Name != "Renamed" {
+		t.Errorf("plan name = %q, want Renamed", plan.Name)
+	}
+	if _, err := reopened.GetMeal(ctx, "meal-1"); err != nil {
+		t.Errorf("GetMeal: %v", err)
+	}
+	if _, err := reopened.GetUserByID(ctx, "alice"); err != nil {
+		t.Errorf("GetUserByID: %v", err)
+	}
+	if password, err := reopened.GetPassword(ctx, "alice"); err != nil || password.Hash != "hash" {
+		t.Errorf("GetPassword = %+v, %v; want the stored hash", password, err)
+	}
+
+	// Secondary indexes are rebuilt from the loaded tables
+	if _, err := reopened.GetUserByEmail(ctx, "alice@example.com"); err != nil {
+		t.Errorf("GetUserByEmail: %v", err)
+	}
+	if transfers, err := reopened.ListOwnershipTransfersByRecipient(ctx, "bob"); err != nil || len(transfers) != 1 {
+		t.Errorf("ListOwnershipTransfersByRecipient = %d transfers, %v; want 1", len(transfers), err)
+	}
+	if redemptions, err := reopened.ListShareCodeRedemptions(ctx, "plan-1"); err != nil || len(redemptions) != 1 {
+		t.Errorf("ListShareCodeRedemptions = %d redemptions, %v; want 1", len(redemptions), err)
+	}
+	if invitations, err := reopened.ListInvitationsByPlan(ctx, "plan-1"); err != nil || len(invitations) != 1 {
+		t.Errorf("ListInvitationsByPlan = %d invitations, %v; want 1", len(invitations), err)
+	}
+	meals, _, err := reopened.ListMealsByPlan(ctx, "plan-1", db.ListOptions{})
+	if err != nil || len(meals) != 1 {
+		t.Errorf("ListMealsByPlan = %d meals, %v; want 1", len(meals), err)
+	}
+	sessions, err := reopened.ListSessionsByUser(ctx, "alice")
+	if err != nil || len(sessions) != 1 {
+		t.Errorf("ListSessionsByUser = %d sessions, %v; want 1", len(sessions), err)
+	}
+}
+
+func TestMemoryStoreTornJournal(t *testing.T) {
+	ctx := context.Background()
+	alice := `{"changes":[{"op":"put","table":"users","id":"alice","value":{"id":"alice","email":"alice@example.com"}}]}` + "\n"
+
+	// openWithJournal opens a store whose journal holds the given entries, as
+	// left behind by a crash
+	openWithJournal := func(t *testing.T, dir, journal string) (*db.MemoryStore, error) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, "journal.log"), []byte(journal), 0o600); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+		return db.OpenMemoryStore(db.NewHMACKeyring([]byte("test-secret")), db.PersistenceOptions{Dir: dir, Fsync: db.FsyncAlways})
+	}
+
+	t.Run("corrupt final entry", func(t *testing.T) {
+		s, err := openWithJournal(t, t.TempDir(), alice+`{"changes":[{"op":"put","tab`+"\n")
+		if err != nil {
+			t.Fatalf("OpenMemoryStore: %v", err)
+		}
+		defer s.Close()
+		if _, err := s.GetUserByID(ctx, "alice"); err != nil {
+			t.Errorf("GetUserByID: %v", err)
+		}
+	})
+
+	t.Run("writes after a torn entry", func(t *testing.T) {
+		dir := t.TempDir()
+		s, err := openWithJournal(t, dir, `{"changes":[{"op":"put","tab`)
+		if err != nil {
+			t.Fatalf("OpenMemoryStore: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		if err := s.CreateOrUpdateUser(ctx, &models.User{ID: "bob", Email: "bob@example.com"}); err != nil {
+			t.Fatalf("CreateOrUpdateUser: %v", err)
+		}
+
+		// Reopen without closing, as after a crash, so bob only lives in the journal
+		reopened, err := db.OpenMemoryStore(db.NewHMACKeyring([]byte("test-secret")), db.PersistenceOptions{Dir: dir, Fsync: db.FsyncAlways})
+		if err != nil {
+			t.Fatalf("OpenMemoryStore after crash: %v", err)
+		}
+		defer reopened.Close()
+		if _, err := reopened.GetUserByID(ctx, "bob"); err != nil {
+			t.Errorf("GetUserByID(bob): %v", err)
+		}
+	})
+
+	t.Run("corrupt entry before the end", func(t *testing.T) {
+		_, err := openWithJournal(t, t.TempDir(), `{"changes":[{"op":"put","tab`+"\n"+alice)
+		if err == nil {
+			t.Error("OpenMemoryStore succeeded, want an error for a corrupt journal")
+		}
+	})
+}
@@ -0,0 +1,664 @@
+package db
+
+import "my-meal-planner/models"
+
+// memoryTables holds every table of a MemoryStore together with the
+// secondary indexes that stand in for the SQL schema's foreign keys
+type memoryTables struct {
+	users          map[string]*models.User
+	meals          map[string]*models.Meal
+	mealPlans      map[string]*models.MealPlan
+	mealPlanAccess map[string]*models.MealPlanAccess
+	shareCodes     map[string]*models.ShareCode
+	sessions       map[string]*models.Session
+	passwords      map[string]*models.Password  // keyed by user ID
+	magicLinks     map[string]*models.MagicLink // keyed by token hash
+	apiTokens      map[string]*models.APIToken
+	transfers      map[string]*models.OwnershipTransfer   // keyed by plan ID
+	redemptions    map[string]*models.ShareCodeRedemption // keyed by redemptionKey
+	invitations    map[string]*models.Invitation
+
+	usersByEmail       map[string]string // email -> user ID
+	mealsByPlan        index             // plan ID -> meal IDs
+	accessByPlan       index             // plan ID -> access IDs
+	accessByUser       index             // user ID -> access IDs
+	shareCodesByPlan   index             // plan ID -> share code IDs
+	sessionsByUser     index             // user ID -> session IDs
+	apiTokensByUser    index             // user ID -> API token IDs
+	apiTokensByHash    map[string]string // token hash -> API token ID
+	transfersByUser    index             // recipient ID -> plan IDs
+	redemptionsByCode  index             // share code ID -> redemption keys
+	redemptionsByUser  index             // user ID -> redemption keys
+	invitationsByPlan  index             // plan ID -> invitation IDs
+	invitationsByEmail index             // email -> invitation IDs
+}
+
+func newMemoryTables() memoryTables {
+	return memoryTables{
+		users:              make(map[string]*models.User),
+		meals:              make(map[string]*models.Meal),
+		mealPlans:          make(map[string]*models.MealPlan),
+		mealPlanAccess:     make(map[string]*models.MealPlanAccess),
+		shareCodes:         make(map[string]*models.ShareCode),
+		sessions:           make(map[string]*models.Session),
+		passwords:          make(map[string]*models.Password),
+		magicLinks:         make(map[string]*models.MagicLink),
+		apiTokens:          make(map[string]*models.APIToken),
+		transfers:          make(map[string]*models.OwnershipTransfer),
+		redemptions:        make(map[string]*models.ShareCodeRedemption),
+		invitations:        make(map[string]*models.Invitation),
+		usersByEmail:       make(map[string]string),
+		mealsByPlan:        make(index),
+		accessByPlan:       make(index),
+		accessByUser:       make(index),
+		shareCodesByPlan:   make(index),
+		sessionsByUser:     make(index),
+		apiTokensByUser:    make(index),
+		apiTokensByHash:    make(map[string]string),
+		transfersByUser:    make(index),
+		redemptionsByCode:  make(index),
+		redemptionsByUser:  make(index),
+		invitationsByPlan:  make(index),
+		invitationsByEmail: make(index),
+	}
+}
+
+// reindex rebuilds the secondary indexes from the tables, after a snapshot
+// load or journal replay filled the tables directly
+func (t *memoryTables) reindex() {
+	t.usersByEmail = make(map[string]string, len(t.users))
+	t.mealsByPlan = make(index)
+	t.accessByPlan = make(index)
+	t.accessByUser = make(index)
+	t.shareCodesByPlan = make(index)
+	t.sessionsByUser = make(index)
+	t.apiTokensByUser = make(index)
+	t.apiTokensByHash = make(map[string]string, len(t.apiTokens))
+	t.transfersByUser = make(index)
+	t.redemptionsByCode = make(index)
+	t.redemptionsByUser = make(index)
+	t.invitationsByPlan = make(index)
+	t.invitationsByEmail = make(index)
+
+	for id, user := range t.users {
+		t.usersByEmail[user.Email] = id
+	}
+	for id, meal := range t.meals {
+		t.mealsByPlan.add(meal.MealPlanID, id)
+	}
+	for id, access := range t.mealPlanAccess {
+		t.accessByPlan.add(access.MealPlanID, id)
+		t.accessByUser.add(access.UserID, id)
+	}
+	for id, code := range t.shareCodes {
+		t.shareCodesByPlan.add(code.MealPlanID, id)
+	}
+	for id, session := range t.sessions {
+		t.sessionsByUser.add(session.UserID, id)
+	}
+	for id, token := range t.apiTokens {
+		t.apiTokensByUser.add(token.UserID, id)
+		t.apiTokensByHash[token.TokenHash] = id
+	}
+	for planID, transfer := range t.transfers {
+		t.transfersByUser.add(transfer.ToUserID, planID)
+	}
+	for key, redemption := range t.redemptions {
+		t.redemptionsByCode.add(redemption.ShareCodeID, key)
+		t.redemptionsByUser.add(redemption.UserID, key)
+	}
+	for id, invitation := range t.invitations {
+		t.invitationsByPlan.add(invitation.MealPlanID, id)
+		t.invitationsByEmail.add(invitation.Email, id)
+	}
+}
+
+// index maps a key, such as a plan ID, to the IDs of the records referencing it
+type index map[string]map[string]struct{}
+
+func (ix index) add(key, id string) {
+	ids, exists := ix[key]
+	if !exists {
+		ids = make(map[string]struct{})
+		ix[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+func (ix index) remove(key, id string) {
+	ids := ix[key]
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(ix, key)
+	}
+}
+
+// ids returns the record IDs stored under key
+func (ix index) ids(key string) []string {
+	ids := make([]string, 0, len(ix[key]))
+	for id := range ix[key] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// undoEntry is the state of a record before a transaction first changed it.
+// old is nil if the record didn't exist.
+type undoEntry struct {
+	table string
+	id    string
+	old   any
+}
+
+// row returns the stored record in table with id, or nil if there is none
+func (t *memoryTables) row(table, id string) any {
+	switch table {
+	case tableUsers:
+		return rowOf(t.users, id)
+	case tableMeals:
+		return rowOf(t.meals, id)
+	case tableMealPlans:
+		return rowOf(t.mealPlans, id)
+	case tableMealPlanAccess:
+		return rowOf(t.mealPlanAccess, id)
+	case tableShareCodes:
+		return rowOf(t.shareCodes, id)
+	case tableSessions:
+		return rowOf(t.sessions, id)
+	case tablePasswords:
+		return rowOf(t.passwords, id)
+	case tableMagicLinks:
+		return rowOf(t.magicLinks, id)
+	case tableAPITokens:
+		return rowOf(t.apiTokens, id)
+	case tableTransfers:
+		return rowOf(t.transfers, id)
+	case tableRedemptions:
+		return rowOf(t.redemptions, id)
+	case tableInvitations:
+		return rowOf(t.invitations, id)
+	default:
+		return nil
+	}
+}
+
+// rowOf returns m[id] as an untyped nil when it is missing, so callers can
+// compare the result with nil
+func rowOf[T any](m map[string]*T, id string) any {
+	if record, exists := m[id]; exists {
+		return record
+	}
+	return nil
+}
+
+// rollback undoes a failed transaction's changes, newest first, so every
+// record and index is back as it was when the transaction began. Stored
+// records are never modified in place, so the saved pointers still hold the
+// old values.
+func (s *MemoryStore) rollback() {
+	undo := s.undo
+	// Outside a transaction and without a journal, the helpers that restore
+	// the records neither journal nor log anything, and can't fail
+	s.inTx, s.journal, s.undo = false, nil, nil
+	for i := len(undo) - 1; i >= 0; i-- {
+		s.restore(undo[i])
+	}
+}
+
+// restore puts back or removes a single record. Removing cascades, but by
+// the time a record created in the transaction is removed, everything the
+// transaction made refer to it has already been undone.
+func (s *MemoryStore) restore(e undoEntry) {
+	if e.old == nil {
+		switch e.table {
+		case tableUsers:
+			s.removeUser(e.id)
+		case tableMeals:
+			s.removeMeal(e.id)
+		case tableMealPlans:
+			s.removeMealPlan(e.id)
+		case tableMealPlanAccess:
+			s.removeMealPlanAccess(e.id)
+		case tableShareCodes:
+			s.removeShareCode(e.id)
+		case tableSessions:
+			s.removeSession(e.id)
+		case tablePasswords:
+			s.removePassword(e.id)
+		case tableMagicLinks:
+			s.removeMagicLink(e.id)
+		case tableAPITokens:
+			s.removeAPIToken(e.id)
+		case tableTransfers:
+			s.removeOwnershipTransfer(e.id)
+		case tableRedemptions:
+			s.removeRedemption(e.id)
+		case tableInvitations:
+			s.removeInvitation(e.id)
+		}
+		return
+	}
+
+	switch old := e.old.(type) {
+	case *models.User:
+		s.putUser(old)
+	case *models.Meal:
+		s.putMeal(old)
+	case *models.MealPlan:
+		s.putMealPlan(old)
+	case *models.MealPlanAccess:
+		s.putMealPlanAccess(old)
+	case *models.ShareCode:
+		s.putShareCode(old)
+	case *models.Session:
+		s.putSession(old)
+	case *models.Password:
+		s.putPassword(old)
+	case *models.MagicLink:
+		s.putMagicLink(old)
+	case *models.APIToken:
+		s.putAPIToken(old)
+	case *models.OwnershipTransfer:
+		s.putOwnershipTransfer(old)
+	case *models.ShareCodeRedemption:
+		s.putRedemption(old)
+	case *models.Invitation:
+		s.putInvitation(old)
+	}
+}
+
+// The put and remove helpers below are the only places that modify the
+// tables. They journal the change first, then keep the indexes in step.
+
+func (s *MemoryStore) putUser(user *models.User) error {
+	if err := s.recordPut(tableUsers, user.ID, user); err != nil {
+		return err
+	}
+	if existing, exists := s.users[user.ID]; exists {
+		delete(s.usersByEmail, existing.Email)
+	}
+	s.users[user.ID] = user
+	s.usersByEmail[user.Email] = user.ID
+	return nil
+}
+
+func (s *MemoryStore) putMealPlan(plan *models.MealPlan) error {
+	if err := s.recordPut(tableMealPlans, plan.ID, plan); err != nil {
+		return err
+	}
+	s.mealPlans[plan.ID] = plan
+	return nil
+}
+
+// removeUser deletes a user and cascades to their sessions, password, API
+// tokens, access rows, ownership transfers, share code redemptions and the
+// invitations they sent, as ON DELETE CASCADE does in the SQL schema
+func (s *MemoryStore) removeUser(id string) error {
+	user, exists := s.users[id]
+	if !exists {
+		return nil
+	}
+	for _, sessionID := range s.sessionsByUser.ids(id) {
+		if err := s.removeSession(sessionID); err != nil {
+			return err
+		}
+	}
+	if err := s.removePassword(id); err != nil {
+		return err
+	}
+	for _, tokenID := range s.apiTokensByUser.ids(id) {
+		if err := s.removeAPIToken(tokenID); err != nil {
+			return err
+		}
+	}
+	for _, accessID := range s.accessByUser.ids(id) {
+		if err := s.removeMealPlanAccess(accessID); err != nil {
+			return err
+		}
+	}
+	for planID, transfer := range s.transfers {
+		if transfer.FromUserID == id || transfer.ToUserID == id {
+			if err := s.removeOwnershipTransfer(planID); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range s.redemptionsByUser.ids(id) {
+		if err := s.removeRedemption(key); err != nil {
+			return err
+		}
+	}
+	for invitationID, invitation := range s.invitations {
+		if invitation.InvitedBy == id {
+			if err := s.removeInvitation(invitationID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.recordDelete(tableUsers, id); err != nil {
+		return err
+	}
+	delete(s.users, id)
+	delete(s.usersByEmail, user.Email)
+	return nil
+}
+
+// removeMealPlan deletes a plan and cascades to its meals, access rows,
+// share codes, invitations and ownership transfer, as ON DELETE CASCADE does
+// in the SQL schema
+func (s *MemoryStore) removeMealPlan(id string) error {
+	for _, mealID := range s.mealsByPlan.ids(id) {
+		if err := s.removeMeal(mealID); err != nil {
+			return err
+		}
+	}
+	for _, accessID := range s.accessByPlan.ids(id) {
+		if err := s.removeMealPlanAccess(accessID); err != nil {
+			return err
+		}
+	}
+	for _, codeID := range s.shareCodesByPlan.ids(id) {
+		if err := s.removeShareCode(codeID); err != nil {
+			return err
+		}
+	}
+	for _, invitationID := range s.invitationsByPlan.ids(id) {
+		if err := s.removeInvitation(invitationID); err != nil {
+			return err
+		}
+	}
+	if err := s.removeOwnershipTransfer(id); err != nil {
+		return err
+	}
+
+	if err := s.recordDelete(tableMealPlans, id); err != nil {
+		return err
+	}
+	delete(s.mealPlans, id)
+	return nil
+}
+
+func (s *MemoryStore) putMeal(meal *models.Meal) error {
+	if err := s.recordPut(tableMeals, meal.ID, meal); err != nil {
+		return err
+	}
+	if existing, exists := s.meals[meal.ID]; exists {
+		s.mealsByPlan.remove(existing.MealPlanID, meal.ID)
+	}
+	s.meals[meal.ID] = meal
+	s.mealsByPlan.add(meal.MealPlanID, meal.ID)
+	return nil
+}
+
+func (s *MemoryStore) removeMeal(id string) error {
+	meal, exists := s.meals[id]
+	if !exists {
+		return nil
+	}
+	if err := s.recordDelete(tableMeals, id); err != nil {
+		return err
+	}
+	delete(s.meals, id)
+	s.mealsByPlan.remove(meal.MealPlanID, id)
+	return nil
+}
+
+func (s *MemoryStore) putMealPlanAccess(access *models.MealPlanAccess) error {
+	if err := s.recordPut(tableMealPlanAccess, access.ID, access); err != nil {
+		return err
+	}
+	if existing, exists := s.mealPlanAccess[access.ID]; exists {
+		s.accessByPlan.remove(existing.MealPlanID, access.ID)
+		s.accessByUser.remove(existing.UserID, access.ID)
+	}
+	s.mealPlanAccess[access.ID] = access
+	s.accessByPlan.add(access.MealPlanID, access.ID)
+	s.accessByUser.add(access.UserID, access.ID)
+	return nil
+}
+
+func (s *MemoryStore) removeMealPlanAccess(id string) error {
+	access, exists := s.mealPlanAccess[id]
+	if !exists {
+		return nil
+	}
+	if err := s.recordDelete(tableMealPlanAccess, id); err != nil {
+		return err
+	}
+	delete(s.mealPlanAccess, id)
+	s.accessByPlan.remove(access.MealPlanID, id)
+	s.accessByUser.remove(access.UserID, id)
+	return nil
+}
+
+func (s *MemoryStore) putShareCode(code *models.ShareCode) error {
+	if err := s.recordPut(tableShareCodes, code.ID, code); err != nil {
+		return err
+	}
+	if existing, exists := s.shareCodes[code.ID]; exists {
+		s.shareCodesByPlan.remove(existing.MealPlanID, code.ID)
+	}
+	s.shareCodes[code.ID] = code
+	s.shareCodesByPlan.add(code.MealPlanID, code.ID)
+	return nil
+}
+
+// removeShareCode deletes a share code and cascades to its redemptions
+func (s *MemoryStore) removeShareCode(id string) error {
+	code, exists := s.shareCodes[id]
+	if !exists {
+		return nil
+	}
+	for _, key := range s.redemptionsByCode.ids(id) {
+		if err := s.removeRedemption(key); err != nil {
+			return err
+		}
+	}
+	if err := s.recordDelete(tableShareCodes, id); err != nil {
+		return err
+	}
+	delete(s.shareCodes, id)
+	s.shareCodesByPlan.remove(code.MealPlanID, id)
+	return nil
+}
+
+func (s *MemoryStore) putSession(session *models.Session) error {
+	if err := s.recordPut(tableSessions, session.ID, session); err != nil {
+		return err
+	}
+	s.sessions[session.ID] = session
+	s.sessionsByUser.add(session.UserID, session.ID)
+	return nil
+}
+
+func (s *MemoryStore) removeSession(id string) error {
+	session, exists := s.sessions[id]
+	if !exists {
+		return nil
+	}
+	if err := s.recordDelete(tableSessions, id); err != nil {
+		return err
+	}
+	delete(s.sessions, id)
+	s.sessionsByUser.remove(session.UserID, id)
+	return nil
+}
+
+func (s *MemoryStore) putPassword(password *models.Password) error {
+	if err := s.recordPut(tablePasswords, password.UserID, password); err != nil {
+		return err
+	}
+	s.passwords[password.UserID] = password
+	return nil
+}
+
+func (s *MemoryStore) removePassword(userID string) error {
+	if _, exists := s.passwords[userID]; !exists {
+		return nil
+	}
+	if err := s.recordDelete(tablePasswords, userID); err != nil {
+		return err
+	}
+	delete(s.passwords, userID)
+	return nil
+}
+
+func (s *MemoryStore) putMagicLink(link *models.MagicLink) error {
+	if err := s.recordPut(tableMagicLinks, link.TokenHash, link); err != nil {
+		return err
+	}
+	s.magicLinks[link.TokenHash] = link
+	return nil
+}
+
+func (s *MemoryStore) removeMagicLink(tokenHash string) error {
+	if _, exists := s.magicLinks[tokenHash]; !exists {
+		return nil
+	}
+	if err := s.recordDelete(tableMagicLinks, tokenHash); err != nil {
+		return err
+	}
+	delete(s.magicLinks, tokenHash)
+	return nil
+}
+
+func (s *MemoryStore) putAPIToken(token *models.APIToken) error {
+	if err := s.recordPut(tableAPITokens, token.ID, token); err != nil {
+		return err
+	}
+	s.apiTokens[token.ID] = token
+	s.apiTokensByUser.add(token.UserID, token.ID)
+	s.apiTokensByHash[token.TokenHash] = token.ID
+	return nil
+}
+
+func (s *MemoryStore) removeAPIToken(id string) error {
+	token, exists := s.apiTokens[id]
+	if !exists {
+		return nil
+	}
+	if err := s.recordDelete(tableAPITokens, id); err != nil {
+		return err
+	}
+	delete(s.apiTokens, id)
+	s.apiTokensByUser.remove(token.UserID, id)
+	delete(s.apiTokensByHash, token.TokenHash)
+	return nil
+}
+
+func (s *MemoryStore) putOwnershipTransfer(transfer *models.OwnershipTransfer) error {
+	if err := s.recordPut(tableTransfers, transfer.MealPlanID, transfer); err != nil {
+		return err
+	}
+	if existing, exists := s.transfers[transfer.MealPlanID]; exists {
+		s.transfersByUser.remove(existing.ToUserID, transfer.MealPlanID)
+	}
+	s.transfers[transfer.MealPlanID] = transfer
+	s.transfersByUser.add(transfer.ToUserID, transfer.MealPlanID)
+	return nil
+}
+
+func (s *MemoryStore) removeOwnershipTransfer(mealPlanID string) error {
+	transfer, exists := s.transfers[mealPlanID]
+	if !exists {
+		return nil
+	}
+	if err := s.recordDelete(tableTransfers, mealPlanID); err != nil {
+		return err
+	}
+	delete(s.transfers, mealPlanID)
+	s.transfersByUser.remove(transfer.ToUserID, mealPlanID)
+	return nil
+}
+
+// redemptionKey identifies a redemption, since each user is recorded once per code
+func redemptionKey(shareCodeID, userID string) string {
+	return shareCodeID + "|" + userID
+}
+
+func (s *MemoryStore) putRedemption(redemption *models.ShareCodeRedemption) error {
+	key := redemptionKey(redemption.ShareCodeID, redemption.UserID)
+	if err := s.recordPut(tableRedemptions, key, redemption); err != nil {
+		return err
+	}
+	s.redemptions[key] = redemption
+	s.redemptionsByCode.add(redemption.ShareCodeID, key)
+	s.redemptionsByUser.add(redemption.UserID, key)
+	return nil
+}
+
+func (s *MemoryStore) removeRedemption(key string) error {
+	redemption, exists := s.redemptions[key]
+	if !exists {
+		return nil
+	}
+	if err := s.recordDelete(tableRedemptions, key); err != nil {
+		return err
+	}
+	delete(s.redemptions, key)
+	s.redemptionsByCode.remove(redemption.ShareCodeID, key)
+	s.redemptionsByUser.remove(redemption.UserID, key)
+	return nil
+}
+
+func (s *MemoryStore) putInvitation(invitation *models.Invitation) error {
+	if err := s.recordPut(tableInvitations, invitation.ID, invitation); err != nil {
+		return err
+	}
+	s.invitations[invitation.ID] = invitation
+	s.invitationsByPlan.add(invitation.MealPlanID, invitation.ID)
+	s.invitationsByEmail.add(invitation.Email, invitation.ID)
+	return nil
+}
+
+func (s *MemoryStore) removeInvitation(id string) error {
+	invitation, exists := s.invitations[id]
+	if !exists {
+		return nil
+	}
+	if err := s.recordDelete(tableInvitations, id); err != nil {
+		return err
+	}
+	delete(s.invitations, id)
+	s.invitationsByPlan.remove(invitation.MealPlanID, id)
+	s.invitationsByEmail.remove(invitation.Email, id)
+	return nil
+}
+
+// userAccess returns the access row granting userID access to mealPlanID, if any
+func (s *MemoryStore) userAccess(userID, mealPlanID string) *models.MealPlanAccess {
+	for id := range s.accessByUser[userID] {
+		if access := s.mealPlanAccess[id]; access.MealPlanID == mealPlanID {
+			return access
+		}
+	}
+	return nil
+}
+
+// hasRole reports whether userID has an access row with role on mealPlanID
+func (s *MemoryStore) hasRole(userID, mealPlanID, role string) bool {
+	for id := range s.accessByUser[userID] {
+		if access := s.mealPlanAccess[id]; access.MealPlanID == mealPlanID && access.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// otherOwner returns the longest-standing owner row of mealPlanID that
+// doesn't belong to userID, if any
+func (s *MemoryStore) otherOwner(mealPlanID, userID string) *models.MealPlanAccess {
+	var owner *models.MealPlanAccess
+	for id := range s.accessByPlan[mealPlanID] {
+		access := s.mealPlanAccess[id]
+		if access.Role != "owner" || access.UserID == userID {
+			continue
+		}
+		if owner == nil || access.CreatedAt.Before(owner.CreatedAt) ||
+			(access.CreatedAt.Equal(owner.CreatedAt) && access.ID < owner.ID) {
+			owner = access
+		}
+	}
+	return owner
+}
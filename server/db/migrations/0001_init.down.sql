@@ -0,0 +1,5 @@
+DROP TABLE share_links;
+DROP TABLE meal_plan_access;
+DROP TABLE meals;
+DROP TABLE meal_plans;
+DROP TABLE users;
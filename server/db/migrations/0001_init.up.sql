@@ -1,24 +1,21 @@
--- name: CreateUser :exec
 CREATE TABLE users (
   id TEXT PRIMARY KEY,
   email TEXT NOT NULL UNIQUE,
   name TEXT NOT NULL,
   picture TEXT,
-  created_at TIMESTAMP DEFAULT now(),
-  updated_at TIMESTAMP DEFAULT now()
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
--- name: CreateMealPlan :exec
 CREATE TABLE meal_plans (
   id TEXT PRIMARY KEY,
   name TEXT NOT NULL,
   description TEXT,
   created_by TEXT NOT NULL REFERENCES users(id),
-  created_at TIMESTAMP DEFAULT now(),
-  updated_at TIMESTAMP DEFAULT now()
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
--- name: CreateMeal :exec
 CREATE TABLE meals (
   id TEXT PRIMARY KEY,
   meal_plan_id TEXT NOT NULL REFERENCES meal_plans(id) ON DELETE CASCADE,
@@ -26,11 +23,10 @@ CREATE TABLE meals (
   description TEXT,
   day TEXT NOT NULL,
   meal_type TEXT NOT NULL CHECK (meal_type IN ('Breakfast', 'Lunch', 'Dinner')),
-  created_at TIMESTAMP DEFAULT now(),
-  updated_at TIMESTAMP DEFAULT now()
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
--- name: CreateMealPlanAccess :exec
 CREATE TABLE meal_plan_access (
   id TEXT PRIMARY KEY,
   user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
@@ -38,12 +34,11 @@ CREATE TABLE meal_plan_access (
   role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer'))
 );
 
--- name: CreateShareLink :exec
 CREATE TABLE share_links (
   id TEXT PRIMARY KEY,
   meal_plan_id TEXT NOT NULL REFERENCES meal_plans(id) ON DELETE CASCADE,
   created_by TEXT NOT NULL REFERENCES users(id),
   role TEXT NOT NULL CHECK (role IN ('editor', 'viewer')),
   expires_at TIMESTAMP NOT NULL,
-  created_at TIMESTAMP DEFAULT now()
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
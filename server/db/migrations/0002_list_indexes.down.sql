@@ -0,0 +1,4 @@
+DROP INDEX idx_share_links_meal_plan_id;
+DROP INDEX idx_meal_plan_access_meal_plan_id;
+DROP INDEX idx_meal_plan_access_user_id;
+DROP INDEX idx_meals_meal_plan_id;
@@ -0,0 +1,4 @@
+CREATE INDEX idx_meals_meal_plan_id ON meals (meal_plan_id);
+CREATE INDEX idx_meal_plan_access_user_id ON meal_plan_access (user_id);
+CREATE INDEX idx_meal_plan_access_meal_plan_id ON meal_plan_access (meal_plan_id);
+CREATE INDEX idx_share_links_meal_plan_id ON share_links (meal_plan_id);
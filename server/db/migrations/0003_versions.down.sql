@@ -0,0 +1,2 @@
+ALTER TABLE meals DROP COLUMN version;
+ALTER TABLE meal_plans DROP COLUMN version;
@@ -0,0 +1,2 @@
+ALTER TABLE meal_plans ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE meals ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
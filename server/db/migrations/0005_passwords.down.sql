@@ -0,0 +1,2 @@
+DROP TABLE passwords;
+ALTER TABLE users DROP COLUMN email_verified;
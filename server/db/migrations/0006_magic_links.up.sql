@@ -0,0 +1,11 @@
+-- Registrations wait in their confirmation link, with the name and password
+-- hash, until it is used
+CREATE TABLE magic_links (
+  token_hash TEXT PRIMARY KEY,
+  email TEXT NOT NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at TIMESTAMP NOT NULL,
+  used_at TIMESTAMP,
+  name TEXT,
+  password_hash TEXT
+);
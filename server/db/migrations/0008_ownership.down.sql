@@ -0,0 +1,3 @@
+DROP TABLE ownership_transfers;
+DELETE FROM meal_plan_access WHERE id = 'owner-' || meal_plan_id;
+ALTER TABLE meal_plan_access DROP COLUMN created_at;
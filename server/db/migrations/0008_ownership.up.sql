@@ -0,0 +1,23 @@
+-- SQLite can't add a column with a CURRENT_TIMESTAMP default, so existing
+-- rows are filled in and new rows always set created_at
+ALTER TABLE meal_plan_access ADD COLUMN created_at TIMESTAMP;
+UPDATE meal_plan_access SET created_at = CURRENT_TIMESTAMP;
+
+-- Ownership now lives only in access rows, so give every plan's creator one
+INSERT INTO meal_plan_access (id, user_id, meal_plan_id, role, created_at)
+SELECT 'owner-' || mp.id, mp.created_by, mp.id, 'owner', COALESCE(mp.created_at, CURRENT_TIMESTAMP)
+FROM meal_plans mp
+WHERE NOT EXISTS (
+  SELECT 1 FROM meal_plan_access mpa
+  WHERE mpa.meal_plan_id = mp.id AND mpa.user_id = mp.created_by AND mpa.role = 'owner'
+);
+
+CREATE TABLE ownership_transfers (
+  meal_plan_id TEXT PRIMARY KEY REFERENCES meal_plans(id) ON DELETE CASCADE,
+  from_user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  to_user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_ownership_transfers_to_user_id ON ownership_transfers (to_user_id);
@@ -0,0 +1,3 @@
+DROP TABLE share_link_redemptions;
+ALTER TABLE share_links DROP COLUMN uses;
+ALTER TABLE share_links DROP COLUMN max_uses;
@@ -0,0 +1,12 @@
+-- A max_uses of 0 means the code can be used any number of times
+ALTER TABLE share_links ADD COLUMN max_uses INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE share_links ADD COLUMN uses INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE share_link_redemptions (
+  share_link_id TEXT NOT NULL REFERENCES share_links(id) ON DELETE CASCADE,
+  user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  redeemed_at TIMESTAMP NOT NULL,
+  PRIMARY KEY (share_link_id, user_id)
+);
+
+CREATE INDEX idx_share_link_redemptions_user_id ON share_link_redemptions (user_id);
@@ -0,0 +1,13 @@
+-- Invitations for people without an account yet. Emails are stored lower
+-- case and become access rows when a user with that email signs in.
+CREATE TABLE invitations (
+  id TEXT PRIMARY KEY,
+  meal_plan_id TEXT NOT NULL REFERENCES meal_plans(id) ON DELETE CASCADE,
+  email TEXT NOT NULL,
+  role TEXT NOT NULL CHECK (role IN ('editor', 'viewer')),
+  invited_by TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (meal_plan_id, email)
+);
+
+CREATE INDEX idx_invitations_email ON invitations (email);
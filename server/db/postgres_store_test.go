@@ -0,0 +1,70 @@
+package db_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	_ "github.com/lib/pq"
+
+	"my-meal-planner/db"
+	"my-meal-planner/db/storetest"
+)
+
+// TestPostgresStore runs against the database at TEST_DATABASE_URL. Each test
+// gets its own schema, which is dropped afterwards.
+func TestPostgresStore(t *testing.T) {
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	admin, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer admin.Close()
+
+	storetest.Run(t, func(t *testing.T) db.Store {
+		ctx := context.Background()
+		schema := "storetest_" + uuid.New().String()[:8]
+		if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+			t.Fatalf("create schema: %v", err)
+		}
+		t.Cleanup(func() {
+			admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		})
+
+		conn, err := sql.Open("postgres", withSearchPath(connStr, schema))
+		if err != nil {
+			t.Fatalf("sql.Open: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		migrator, err := db.NewMigrator(conn)
+		if err != nil {
+			t.Fatalf("NewMigrator: %v", err)
+		}
+		if _, err := migrator.Up(ctx); err != nil {
+			t.Fatalf("migrate up: %v", err)
+		}
+		return db.NewPostgresStore(conn, db.NewHMACKeyring([]byte("test-secret")))
+	})
+}
+
+// withSearchPath points every connection made from connStr at schema
+func withSearchPath(connStr, schema string) string {
+	u, err := url.Parse(connStr)
+	if err != nil || u.Scheme == "" {
+		// key=value connection strings
+		return fmt.Sprintf("%s search_path=%s", connStr, schema)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -12,77 +12,333 @@ VALUES ($1, $2, $3, $4);
 
 -- name: UpdateUser :exec
 UPDATE users 
-SET name = $2, picture = $3, updated_at = now()
+SET name = $2, picture = $3, updated_at = CURRENT_TIMESTAMP
 WHERE id = $1;
 
+-- name: UpsertUser :exec
+INSERT INTO users (id, email, name, picture, email_verified)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id) DO UPDATE
+SET email = EXCLUDED.email, name = EXCLUDED.name, email_verified = EXCLUDED.email_verified, updated_at = CURRENT_TIMESTAMP;
+
+-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1;
+
+-- name: DeleteOwnerlessMealPlans :exec
+-- Plans the user owns alone, or created and nobody owns, go with the user
+DELETE FROM meal_plans
+WHERE (created_by = sqlc.arg(user_id) OR id IN (
+    SELECT meal_plan_id FROM meal_plan_access
+    WHERE user_id = sqlc.arg(user_id) AND role = 'owner'))
+  AND id NOT IN (
+    SELECT meal_plan_id FROM meal_plan_access
+    WHERE user_id <> sqlc.arg(user_id) AND role = 'owner');
+
+-- name: ReassignMealPlanCreator :exec
+UPDATE meal_plans
+SET created_by = (
+  SELECT mpa.user_id FROM meal_plan_access mpa
+  WHERE mpa.meal_plan_id = meal_plans.id AND mpa.role = 'owner' AND mpa.user_id <> sqlc.arg(user_id)
+  ORDER BY mpa.created_at, mpa.id
+  LIMIT 1)
+WHERE created_by = sqlc.arg(user_id);
+
+-- name: DeleteShareLinksByCreator :exec
+DELETE FROM share_links WHERE created_by = $1;
+
 
 -- Meal Plan Queries
 
 -- name: CreateMealPlan :exec
-INSERT INTO meal_plans (id, name, description, created_by)
-VALUES ($1, $2, $3, $4);
+INSERT INTO meal_plans (id, name, description, created_by, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $5);
 
 -- name: GetMealPlanByID :one
 SELECT * FROM meal_plans WHERE id = $1;
 
--- name: GetMealPlansByUser :many
-SELECT mp.*
-FROM meal_plans mp
-JOIN meal_plan_access mpa ON mpa.meal_plan_id = mp.id
-WHERE mpa.user_id = $1;
+-- name: ListMealPlansByUpdated :many
+SELECT * FROM meal_plans
+WHERE id IN (SELECT meal_plan_id FROM meal_plan_access WHERE user_id = sqlc.arg(user_id))
+  AND (sqlc.arg(pattern) = '' OR LOWER(name) LIKE sqlc.arg(pattern) ESCAPE '\' OR LOWER(description) LIKE sqlc.arg(pattern) ESCAPE '\')
+  AND (NOT sqlc.arg(has_cursor)
+    OR updated_at < sqlc.arg(after_updated_at)
+    OR (updated_at = sqlc.arg(after_updated_at) AND id > sqlc.arg(after_id)))
+ORDER BY updated_at DESC, id
+LIMIT sqlc.arg(row_limit);
 
--- name: UpdateMealPlan :exec
+-- name: ListMealPlansByName :many
+SELECT *, LOWER(name) AS sort_name FROM meal_plans
+WHERE id IN (SELECT meal_plan_id FROM meal_plan_access WHERE user_id = sqlc.arg(user_id))
+  AND (sqlc.arg(pattern) = '' OR LOWER(name) LIKE sqlc.arg(pattern) ESCAPE '\' OR LOWER(description) LIKE sqlc.arg(pattern) ESCAPE '\')
+  AND (NOT sqlc.arg(has_cursor) OR (LOWER(name), id) > (sqlc.arg(after_name), sqlc.arg(after_id)))
+ORDER BY LOWER(name), id
+LIMIT sqlc.arg(row_limit);
+
+-- name: UpdateMealPlan :execrows
 UPDATE meal_plans
-SET name = $2, description = $3, updated_at = now()
-WHERE id = $1;
+SET name = $2, description = $3, updated_at = $5, version = version + 1
+WHERE id = $1 AND version = $4;
 
--- name: DeleteMealPlan :exec
-DELETE FROM meal_plans WHERE id = $1;
+-- name: DeleteMealPlan :execrows
+DELETE FROM meal_plans WHERE id = $1 AND version = $2;
 
 
 -- Meal Queries
 
 -- name: CreateMeal :exec
-INSERT INTO meals (id, meal_plan_id, name, description, day, meal_type)
-VALUES ($1, $2, $3, $4, $5, $6);
+INSERT INTO meals (id, meal_plan_id, name, description, day, meal_type, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $7);
+
+-- name: ListMealsByDay :many
+SELECT * FROM meals
+WHERE meal_plan_id = sqlc.arg(meal_plan_id)
+  AND (sqlc.arg(day) = '' OR day = sqlc.arg(day))
+  AND (sqlc.arg(meal_type) = '' OR meal_type = sqlc.arg(meal_type))
+  AND (sqlc.arg(pattern) = '' OR LOWER(name) LIKE sqlc.arg(pattern) ESCAPE '\' OR LOWER(description) LIKE sqlc.arg(pattern) ESCAPE '\')
+  AND (NOT sqlc.arg(has_cursor) OR (
+    CASE day
+      WHEN 'Monday' THEN 0 WHEN 'Tuesday' THEN 1 WHEN 'Wednesday' THEN 2 WHEN 'Thursday' THEN 3
+      WHEN 'Friday' THEN 4 WHEN 'Saturday' THEN 5 WHEN 'Sunday' THEN 6 ELSE 7 END,
+    day,
+    CASE meal_type WHEN 'Breakfast' THEN 0 WHEN 'Lunch' THEN 1 WHEN 'Dinner' THEN 2 ELSE 3 END,
+    meal_type,
+    id
+  ) > (
+    CAST(sqlc.arg(after_day_rank) AS INTEGER), sqlc.arg(after_day),
+    CAST(sqlc.arg(after_meal_type_rank) AS INTEGER), sqlc.arg(after_meal_type),
+    sqlc.arg(after_id)
+  ))
+ORDER BY
+  CASE day
+    WHEN 'Monday' THEN 0 WHEN 'Tuesday' THEN 1 WHEN 'Wednesday' THEN 2 WHEN 'Thursday' THEN 3
+    WHEN 'Friday' THEN 4 WHEN 'Saturday' THEN 5 WHEN 'Sunday' THEN 6 ELSE 7 END,
+  day,
+  CASE meal_type WHEN 'Breakfast' THEN 0 WHEN 'Lunch' THEN 1 WHEN 'Dinner' THEN 2 ELSE 3 END,
+  meal_type,
+  id
+LIMIT sqlc.arg(row_limit);
 
--- name: GetMealsByPlanID :many
-SELECT * FROM meals WHERE meal_plan_id = $1;
+-- name: ListMealsByUpdated :many
+SELECT * FROM meals
+WHERE meal_plan_id = sqlc.arg(meal_plan_id)
+  AND (sqlc.arg(day) = '' OR day = sqlc.arg(day))
+  AND (sqlc.arg(meal_type) = '' OR meal_type = sqlc.arg(meal_type))
+  AND (sqlc.arg(pattern) = '' OR LOWER(name) LIKE sqlc.arg(pattern) ESCAPE '\' OR LOWER(description) LIKE sqlc.arg(pattern) ESCAPE '\')
+  AND (NOT sqlc.arg(has_cursor)
+    OR updated_at < sqlc.arg(after_updated_at)
+    OR (updated_at = sqlc.arg(after_updated_at) AND id > sqlc.arg(after_id)))
+ORDER BY updated_at DESC, id
+LIMIT sqlc.arg(row_limit);
+
+-- name: ListMealsByName :many
+SELECT *, LOWER(name) AS sort_name FROM meals
+WHERE meal_plan_id = sqlc.arg(meal_plan_id)
+  AND (sqlc.arg(day) = '' OR day = sqlc.arg(day))
+  AND (sqlc.arg(meal_type) = '' OR meal_type = sqlc.arg(meal_type))
+  AND (sqlc.arg(pattern) = '' OR LOWER(name) LIKE sqlc.arg(pattern) ESCAPE '\' OR LOWER(description) LIKE sqlc.arg(pattern) ESCAPE '\')
+  AND (NOT sqlc.arg(has_cursor) OR (LOWER(name), id) > (sqlc.arg(after_name), sqlc.arg(after_id)))
+ORDER BY LOWER(name), id
+LIMIT sqlc.arg(row_limit);
 
 -- name: GetMealByID :one
 SELECT * FROM meals WHERE id = $1;
 
--- name: UpdateMeal :exec
+-- name: UpdateMeal :execrows
 UPDATE meals
-SET name = $2, description = $3, day = $4, meal_type = $5, updated_at = now()
-WHERE id = $1;
+SET name = $2, description = $3, day = $4, meal_type = $5, updated_at = $7, version = version + 1
+WHERE id = $1 AND version = $6;
 
--- name: DeleteMeal :exec
-DELETE FROM meals WHERE id = $1;
+-- name: DeleteMeal :execrows
+DELETE FROM meals WHERE id = $1 AND version = $2;
 
 
 -- MealPlanAccess Queries
 
 -- name: GrantMealPlanAccess :exec
-INSERT INTO meal_plan_access (id, user_id, meal_plan_id, role)
-VALUES ($1, $2, $3, $4);
+INSERT INTO meal_plan_access (id, user_id, meal_plan_id, role, created_at)
+VALUES ($1, $2, $3, $4, $5);
 
 -- name: GetMealPlanAccess :many
-SELECT * FROM meal_plan_access WHERE meal_plan_id = $1;
+SELECT mpa.role, mpa.created_at, u.id AS user_id, u.name, u.email, u.picture
+FROM meal_plan_access mpa
+JOIN users u ON u.id = mpa.user_id
+WHERE mpa.meal_plan_id = $1;
+
+-- name: SetMealPlanAccessRole :execrows
+UPDATE meal_plan_access SET role = $3
+WHERE meal_plan_id = $1 AND user_id = $2;
+
+-- name: DeleteMealPlanAccess :execrows
+DELETE FROM meal_plan_access
+WHERE meal_plan_id = $1 AND user_id = $2;
+
+
+-- Ownership Transfer Queries
+
+-- name: UpsertOwnershipTransfer :exec
+INSERT INTO ownership_transfers (meal_plan_id, from_user_id, to_user_id, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (meal_plan_id) DO UPDATE
+SET from_user_id = EXCLUDED.from_user_id, to_user_id = EXCLUDED.to_user_id,
+    created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at;
+
+-- name: GetOwnershipTransfer :one
+SELECT * FROM ownership_transfers WHERE meal_plan_id = $1;
+
+-- name: ListOwnershipTransfersByRecipient :many
+SELECT * FROM ownership_transfers
+WHERE to_user_id = $1
+ORDER BY created_at, meal_plan_id;
+
+-- name: DeleteOwnershipTransfer :execrows
+DELETE FROM ownership_transfers WHERE meal_plan_id = $1;
 
 -- name: GetUserMealPlanAccess :one
-SELECT * FROM meal_plan_access 
-WHERE user_id = $1 AND meal_plan_id = $2;
+SELECT * FROM meal_plan_access
+WHERE user_id = $1 AND meal_plan_id = $2
+ORDER BY CASE role WHEN 'owner' THEN 0 WHEN 'editor' THEN 1 ELSE 2 END
+LIMIT 1;
 
 
 -- Share Link Queries
 
 -- name: CreateShareLink :exec
-INSERT INTO share_links (id, meal_plan_id, created_by, role, expires_at)
-VALUES ($1, $2, $3, $4, $5);
+INSERT INTO share_links (id, meal_plan_id, created_by, role, expires_at, max_uses)
+VALUES ($1, $2, $3, $4, $5, $6);
 
 -- name: GetShareLinkByID :one
 SELECT * FROM share_links WHERE id = $1;
 
--- name: DeleteExpiredShareLinks :exec
-DELETE FROM share_links WHERE expires_at < now();
+-- name: GetShareLinksByMealPlan :many
+SELECT * FROM share_links WHERE meal_plan_id = $1 ORDER BY created_at, id;
+
+-- name: UseShareLink :execrows
+UPDATE share_links SET uses = uses + 1
+WHERE id = $1 AND (max_uses = 0 OR uses < max_uses);
+
+-- name: RecordShareLinkRedemption :exec
+INSERT INTO share_link_redemptions (share_link_id, user_id, redeemed_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (share_link_id, user_id) DO UPDATE SET redeemed_at = EXCLUDED.redeemed_at;
+
+-- name: GetShareLinkRedemptionsByMealPlan :many
+SELECT r.* FROM share_link_redemptions r
+JOIN share_links sl ON sl.id = r.share_link_id
+WHERE sl.meal_plan_id = $1
+ORDER BY r.redeemed_at, r.user_id;
+
+-- name: DeleteShareLink :execrows
+DELETE FROM share_links WHERE id = $1;
+
+-- name: DeleteExpiredShareLinks :execrows
+DELETE FROM share_links WHERE expires_at < sqlc.arg(now);
+
+
+-- Invitation Queries
+
+-- name: CreateInvitation :exec
+INSERT INTO invitations (id, meal_plan_id, email, role, invited_by, created_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: GetInvitationByID :one
+SELECT * FROM invitations WHERE id = $1;
+
+-- name: GetInvitationsByMealPlan :many
+SELECT * FROM invitations WHERE meal_plan_id = $1 ORDER BY created_at, id;
+
+-- name: DeleteInvitation :execrows
+DELETE FROM invitations WHERE id = $1;
+
+-- name: AcceptInvitations :exec
+-- Invitations to plans the user can already see add nothing
+INSERT INTO meal_plan_access (id, user_id, meal_plan_id, role, created_at)
+SELECT 'invitation-' || i.id, u.id, i.meal_plan_id, i.role, CURRENT_TIMESTAMP
+FROM invitations i
+JOIN users u ON u.id = sqlc.arg(user_id)
+WHERE i.email = LOWER(u.email)
+  AND NOT EXISTS (
+    SELECT 1 FROM meal_plan_access mpa
+    WHERE mpa.meal_plan_id = i.meal_plan_id AND mpa.user_id = u.id);
+
+-- name: DeleteInvitationsByEmail :exec
+DELETE FROM invitations WHERE email = LOWER(sqlc.arg(email));
+
+
+-- Session Queries
+
+-- name: CreateSession :exec
+INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip_address, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6);
+
+-- name: GetSessionByID :one
+SELECT * FROM sessions WHERE id = $1;
+
+-- name: GetSessionsByUser :many
+SELECT * FROM sessions WHERE user_id = $1 ORDER BY created_at;
+
+-- name: RotateSession :execrows
+UPDATE sessions
+SET refresh_token_hash = sqlc.arg(new_hash), expires_at = sqlc.arg(expires_at), last_used_at = CURRENT_TIMESTAMP
+WHERE id = sqlc.arg(id) AND refresh_token_hash = sqlc.arg(old_hash) AND revoked_at IS NULL;
+
+-- name: RevokeSession :execrows
+UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions WHERE expires_at < sqlc.arg(now);
+
+-- Password Queries
+
+-- name: UpsertPassword :exec
+INSERT INTO passwords (user_id, password_hash)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET password_hash = EXCLUDED.password_hash, failed_attempts = 0, locked_until = NULL, updated_at = CURRENT_TIMESTAMP;
+
+-- name: GetPassword :one
+SELECT * FROM passwords WHERE user_id = $1;
+
+-- name: RecordFailedLogin :execrows
+UPDATE passwords
+SET failed_attempts = CASE WHEN failed_attempts + 1 >= sqlc.arg(max_attempts) THEN 0 ELSE failed_attempts + 1 END,
+    locked_until = CASE WHEN failed_attempts + 1 >= sqlc.arg(max_attempts) THEN sqlc.arg(locked_until) ELSE locked_until END
+WHERE user_id = sqlc.arg(user_id);
+
+-- name: ResetFailedLogins :execrows
+UPDATE passwords SET failed_attempts = 0, locked_until = NULL WHERE user_id = $1;
+
+-- Magic Link Queries
+
+-- name: CreateMagicLink :exec
+INSERT INTO magic_links (token_hash, email, name, password_hash, expires_at)
+VALUES ($1, $2, $3, $4, $5);
+
+-- name: ConsumeMagicLink :one
+UPDATE magic_links SET used_at = CURRENT_TIMESTAMP
+WHERE token_hash = sqlc.arg(token_hash) AND used_at IS NULL AND expires_at > sqlc.arg(now)
+RETURNING *;
+
+-- name: DeleteExpiredMagicLinks :execrows
+DELETE FROM magic_links WHERE expires_at < sqlc.arg(now);
+
+-- API Token Queries
+
+-- name: CreateAPIToken :exec
+INSERT INTO api_tokens (id, user_id, name, token_hash, prefix, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7);
+
+-- name: GetAPITokenByID :one
+SELECT * FROM api_tokens WHERE id = $1;
+
+-- name: GetAPITokenByHash :one
+SELECT * FROM api_tokens WHERE token_hash = $1;
+
+-- name: GetAPITokensByUser :many
+SELECT * FROM api_tokens WHERE user_id = $1 ORDER BY created_at;
+
+-- name: TouchAPIToken :execrows
+UPDATE api_tokens SET last_used_at = sqlc.arg(last_used_at) WHERE id = sqlc.arg(id);
+
+-- name: DeleteAPIToken :execrows
+DELETE FROM api_tokens WHERE id = $1;
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// RefreshTokenTTL is how long a session lasts without being used. Every
+// refresh pushes the expiry back by this much.
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// NewRefreshToken returns a new random refresh token for a session together
+// with the hash to store. The token starts with the session ID so the session
+// can be found without storing the token itself.
+func NewRefreshToken(sessionID string) (token, hash string, err error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token = sessionID + "." + base64.RawURLEncoding.EncodeToString(secret)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken returns the hash stored for a refresh token
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RefreshTokenSessionID returns the session ID a refresh token was issued for
+func RefreshTokenSessionID(token string) (string, bool) {
+	sessionID, secret, found := strings.Cut(token, ".")
+	if !found || sessionID == "" || secret == "" {
+		return "", false
+	}
+	return sessionID, true
+}
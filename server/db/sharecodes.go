@@ -0,0 +1,55 @@
+package db
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// ShareCodeLength is the number of characters in a share code
+const ShareCodeLength = 8
+
+// shareCodeAlphabet is Crockford's base32 alphabet. It leaves out I, L, O and
+// U, so a code read aloud or copied by hand can't be mistaken for another.
+const shareCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewShareCode returns a random share code. Callers must check that it isn't
+// taken, since eight characters leave room for collisions.
+func NewShareCode() (string, error) {
+	b := make([]byte, ShareCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// 32 divides 256, so every character is equally likely
+	for i := range b {
+		b[i] = shareCodeAlphabet[b[i]%32]
+	}
+	return string(b), nil
+}
+
+// NormalizeShareCode turns a code as someone typed it into the stored form:
+// upper case, without spaces or dashes, and with O read as 0 and I and L as
+// 1. Anything that isn't a short code, such as an older UUID code, is only
+// trimmed.
+func NormalizeShareCode(code string) string {
+	code = strings.TrimSpace(code)
+
+	var b strings.Builder
+	for _, c := range strings.ToUpper(code) {
+		switch c {
+		case ' ', '-':
+			continue
+		case 'O':
+			c = '0'
+		case 'I', 'L':
+			c = '1'
+		}
+		if !strings.ContainsRune(shareCodeAlphabet, c) {
+			return code
+		}
+		b.WriteRune(c)
+	}
+	if b.Len() != ShareCodeLength {
+		return code
+	}
+	return b.String()
+}
@@ -0,0 +1,44 @@
+package db_test
+
+import (
+	"strings"
+	"testing"
+
+	"my-meal-planner/db"
+)
+
+func TestNewShareCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code, err := db.NewShareCode()
+		if err != nil {
+			t.Fatalf("NewShareCode: %v", err)
+		}
+		if len(code) != db.ShareCodeLength || strings.ContainsAny(code, "ILOU") || code != strings.ToUpper(code) {
+			t.Errorf("NewShareCode = %q, want %d Crockford base32 characters", code, db.ShareCodeLength)
+		}
+		if db.NormalizeShareCode(code) != code {
+			t.Errorf("NormalizeShareCode(%q) changed a generated code", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("NewShareCode returned %d distinct codes out of 100", len(seen))
+	}
+}
+
+func TestNormalizeShareCode(t *testing.T) {
+	for _, tc := range []struct{ in, want string }{
+		{"ABCD2345", "ABCD2345"},
+		{" abcd-2345 ", "ABCD2345"},
+		{"abcd 2345", "ABCD2345"},
+		{"OIL0abcd", "0110ABCD"},
+		{"96b566fd-0c0a-4bc8-9240-fd8a96dc7bae", "96b566fd-0c0a-4bc8-9240-fd8a96dc7bae"},
+		{"ABCD234", "ABCD234"},
+		{"ABCDU345", "ABCDU345"},
+	} {
+		if got := db.NormalizeShareCode(tc.in); got != tc.want {
+			t.Errorf("NormalizeShareCode(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,1317 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
+	sqlc "my-meal-planner/internal/db"
+	"my-meal-planner/models"
+)
+
+// SQLStore implements the Store interface on top of the sqlc-generated
+// queries. The same queries run against both PostgreSQL and SQLite.
+type SQLStore struct {
+	db      *sql.DB
+	tx      *sql.Tx // set on the copy used inside WithTx
+	queries *sqlc.Queries
+	tokenIssuer
+}
+
+// NewPostgresStore creates a new store backed by a PostgreSQL connection
+func NewPostgresStore(conn *sql.DB, keys *Keyring) *SQLStore {
+	return &SQLStore{
+		db:          conn,
+		queries:     sqlc.New(conn),
+		tokenIssuer: tokenIssuer{keys: keys},
+	}
+}
+
+// WithTx runs fn inside a database transaction, committing only if fn succeeds
+func (s *SQLStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	txStore := &SQLStore{
+		db:          s.db,
+		tx:          tx,
+		queries:     s.queries.WithTx(tx),
+		tokenIssuer: s.tokenIssuer,
+	}
+	if err := fn(txStore); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// generateID generates a unique ID for records created without one
+func (s *SQLStore) generateID() string {
+	return uuid.New().String()
+}
+
+// CreateOrUpdateUser creates a new user or updates an existing one
+func (s *SQLStore) CreateOrUpdateUser(ctx context.Context, user *models.User) error {
+	return s.queries.UpsertUser(ctx, sqlc.UpsertUserParams{
+		ID:            user.ID,
+		Email:         user.Email,
+		Name:          user.Name,
+		Picture:       nullString(user.Picture),
+		EmailVerified: user.EmailVerified,
+	})
+}
+
+// AcceptInvitations grants a user the access they were invited to by email
+func (s *SQLStore) AcceptInvitations(ctx context.Context, userID string) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		q := tx.(*SQLStore).queries
+		user, err := q.GetUserByID(ctx, userID)
+		if err != nil {
+			return notFound(err, ErrUserNotFound)
+		}
+		if err := q.AcceptInvitations(ctx, userID); err != nil {
+			return err
+		}
+		return q.DeleteInvitationsByEmail(ctx, user.Email)
+	})
+}
+
+// GetUserByID retrieves a user by their ID
+func (s *SQLStore) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	row, err := s.queries.GetUserByID(ctx, id)
+	if err != nil {
+		return nil, notFound(err, ErrUserNotFound)
+	}
+	return toUser(row), nil
+}
+
+// GetUserByEmail returns a user by email
+func (s *SQLStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	row, err := s.queries.GetUserByEmail(ctx, email)
+	if err != nil {
+		return nil, notFound(err, ErrUserNotFound)
+	}
+	return toUser(row), nil
+}
+
+// DeleteUser removes a user; their sessions, credentials and access rows
+// cascade
+func (s *SQLStore) DeleteUser(ctx context.Context, id string) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		q := tx.(*SQLStore).queries
+		if err := q.DeleteOwnerlessMealPlans(ctx, id); err != nil {
+			return err
+		}
+		if err := q.ReassignMealPlanCreator(ctx, id); err != nil {
+			return err
+		}
+		if err := q.DeleteShareLinksByCreator(ctx, id); err != nil {
+			return err
+		}
+		n, err := q.DeleteUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+// CreateMealPlan creates a new meal plan
+func (s *SQLStore) CreateMealPlan(ctx context.Context, plan *models.MealPlan) error {
+	if plan.ID == "" {
+		plan.ID = s.generateID()
+	}
+	now := timestamp()
+	err := s.queries.CreateMealPlan(ctx, sqlc.CreateMealPlanParams{
+		ID:          plan.ID,
+		Name:        plan.Name,
+		Description: nullString(plan.Description),
+		CreatedBy:   plan.CreatedBy,
+		CreatedAt:   sql.NullTime{Time: now, Valid: true},
+	})
+	if isForeignKeyViolation(err) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	plan.Version = 1
+	plan.CreatedAt = now
+	plan.UpdatedAt = now
+	return nil
+}
+
+// GetMealPlan retrieves a meal plan by ID
+func (s *SQLStore) GetMealPlan(ctx context.Context, id string) (*models.MealPlan, error) {
+	row, err := s.queries.GetMealPlanByID(ctx, id)
+	if err != nil {
+		return nil, notFound(err, ErrMealPlanNotFound)
+	}
+	return toMealPlan(row), nil
+}
+
+// UpdateMealPlan updates an existing meal plan
+func (s *SQLStore) UpdateMealPlan(ctx context.Context, plan *models.MealPlan) error {
+	n, err := s.queries.UpdateMealPlan(ctx, sqlc.UpdateMealPlanParams{
+		ID:          plan.ID,
+		Name:        plan.Name,
+		Description: nullString(plan.Description),
+		Version:     plan.Version,
+		UpdatedAt:   sql.NullTime{Time: timestamp(), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := s.queries.GetMealPlanByID(ctx, plan.ID)
+		return staleOrMissing(err, ErrMealPlanNotFound)
+	}
+	plan.Version++
+	return nil
+}
+
+// DeleteMealPlan removes a meal plan at the given version; meals, access rows
+// and share links cascade
+func (s *SQLStore) DeleteMealPlan(ctx context.Context, id string, version int64) error {
+	n, err := s.queries.DeleteMealPlan(ctx, sqlc.DeleteMealPlanParams{ID: id, Version: version})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := s.queries.GetMealPlanByID(ctx, id)
+		return staleOrMissing(err, ErrMealPlanNotFound)
+	}
+	return nil
+}
+
+// ListMealPlansByUser returns a page of the meal plans a user has access to.
+// The database filters, orders and limits the rows; one row past the limit
+// tells whether there is another page.
+func (s *SQLStore) ListMealPlansByUser(ctx context.Context, userID string, opts ListOptions) ([]*models.MealPlan, string, error) {
+	opts, err := mealPlanListOptions(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	after, err := decodeCursor(opts.Cursor, opts.Sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var plans []*models.MealPlan
+	var keys [][]string
+	switch opts.Sort {
+	case SortByUpdated:
+		params := sqlc.ListMealPlansByUpdatedParams{
+			UserID:   userID,
+			Pattern:  likePattern(opts.Text),
+			RowLimit: rowLimit(opts.Limit),
+		}
+		if after != nil {
+			params.HasCursor = true
+			params.AfterUpdatedAt, params.AfterID, err = updatedCursor(after)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		rows, err := s.queries.ListMealPlansByUpdated(ctx, params)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, row := range rows {
+			plan := toMealPlan(row)
+			plans = append(plans, plan)
+			keys = append(keys, mealPlanKeys[SortByUpdated](plan))
+		}
+	case SortByName:
+		params := sqlc.ListMealPlansByNameParams{
+			UserID:   userID,
+			Pattern:  likePattern(opts.Text),
+			RowLimit: rowLimit(opts.Limit),
+		}
+		if after != nil {
+			params.HasCursor = true
+			params.AfterName, params.AfterID, err = nameCursor(after)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		rows, err := s.queries.ListMealPlansByName(ctx, params)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, row := range rows {
+			plans = append(plans, toMealPlan(sqlc.MealPlan{
+				ID:          row.ID,
+				Name:        row.Name,
+				Description: row.Description,
+				CreatedBy:   row.CreatedBy,
+				CreatedAt:   row.CreatedAt,
+				UpdatedAt:   row.UpdatedAt,
+				Version:     row.Version,
+			}))
+			keys = append(keys, []string{row.SortName, row.ID})
+		}
+	}
+	return sqlPage(plans, keys, opts)
+}
+
+// CreateMealPlanAccess creates a new meal plan access record
+func (s *SQLStore) CreateMealPlanAccess(ctx context.Context, access *models.MealPlanAccess) error {
+	if access.ID == "" {
+		access.ID = s.generateID()
+	}
+	if access.CreatedAt.IsZero() {
+		access.CreatedAt = time.Now()
+	}
+	err := s.queries.GrantMealPlanAccess(ctx, sqlc.GrantMealPlanAccessParams{
+		ID:         access.ID,
+		UserID:     access.UserID,
+		MealPlanID: access.MealPlanID,
+		Role:       access.Role,
+		CreatedAt:  sql.NullTime{Time: access.CreatedAt.UTC(), Valid: true},
+	})
+	return s.missingReference(ctx, err, access.MealPlanID)
+}
+
+// GetRole returns the user's highest role on a meal plan
+func (s *SQLStore) GetRole(ctx context.Context, userID, mealPlanID string) (string, error) {
+	if _, err := s.queries.GetMealPlanByID(ctx, mealPlanID); err != nil {
+		return "", notFound(err, ErrMealPlanNotFound)
+	}
+
+	access, err := s.queries.GetUserMealPlanAccess(ctx, sqlc.GetUserMealPlanAccessParams{
+		UserID:     userID,
+		MealPlanID: mealPlanID,
+	})
+	if err != nil {
+		return "", notFound(err, ErrAccessDenied)
+	}
+	return access.Role, nil
+}
+
+// CheckMealPlanAccess checks if a user has access to a meal plan
+func (s *SQLStore) CheckMealPlanAccess(ctx context.Context, userID, mealPlanID string) (bool, error) {
+	_, err := s.queries.GetUserMealPlanAccess(ctx, sqlc.GetUserMealPlanAccessParams{
+		UserID:     userID,
+		MealPlanID: mealPlanID,
+	})
+	if err != nil {
+		return false, notFound(err, ErrAccessDenied)
+	}
+	return true, nil
+}
+
+// CheckMealPlanOwnership checks if a user is the owner of a meal plan
+func (s *SQLStore) CheckMealPlanOwnership(ctx context.Context, userID, mealPlanID string) (bool, error) {
+	access, err := s.queries.GetUserMealPlanAccess(ctx, sqlc.GetUserMealPlanAccessParams{
+		UserID:     userID,
+		MealPlanID: mealPlanID,
+	})
+	if err != nil {
+		return false, notFound(err, ErrAccessDenied)
+	}
+	if access.Role != "owner" {
+		return false, ErrAccessDenied
+	}
+	return true, nil
+}
+
+// ListMealPlanMembers returns everyone with access to a meal plan
+func (s *SQLStore) ListMealPlanMembers(ctx context.Context, mealPlanID string) ([]*models.MealPlanMember, error) {
+	if _, err := s.queries.GetMealPlanByID(ctx, mealPlanID); err != nil {
+		return nil, notFound(err, ErrMealPlanNotFound)
+	}
+	rows, err := s.queries.GetMealPlanAccess(ctx, mealPlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]*models.MealPlanMember, 0, len(rows))
+	for _, row := range rows {
+		members = append(members, &models.MealPlanMember{
+			UserID:   row.UserID,
+			Name:     row.Name,
+			Email:    row.Email,
+			Picture:  row.Picture.String,
+			Role:     row.Role,
+			JoinedAt: nullTime(row.CreatedAt),
+		})
+	}
+	return collectMembers(members), nil
+}
+
+// SetMemberRole changes a user's role on a meal plan
+func (s *SQLStore) SetMemberRole(ctx context.Context, mealPlanID, userID, role string) error {
+	n, err := s.queries.SetMealPlanAccessRole(ctx, sqlc.SetMealPlanAccessRoleParams{
+		MealPlanID: mealPlanID,
+		UserID:     userID,
+		Role:       role,
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+// RemoveMember deletes a user's access to a meal plan
+func (s *SQLStore) RemoveMember(ctx context.Context, mealPlanID, userID string) error {
+	n, err := s.queries.DeleteMealPlanAccess(ctx, sqlc.DeleteMealPlanAccessParams{
+		MealPlanID: mealPlanID,
+		UserID:     userID,
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+// CreateOwnershipTransfer offers a meal plan to another user
+func (s *SQLStore) CreateOwnershipTransfer(ctx context.Context, transfer *models.OwnershipTransfer) error {
+	if transfer.CreatedAt.IsZero() {
+		transfer.CreatedAt = time.Now()
+	}
+	err := s.queries.UpsertOwnershipTransfer(ctx, sqlc.UpsertOwnershipTransferParams{
+		MealPlanID: transfer.MealPlanID,
+		FromUserID: transfer.FromUserID,
+		ToUserID:   transfer.ToUserID,
+		CreatedAt:  transfer.CreatedAt.UTC(),
+		ExpiresAt:  transfer.ExpiresAt.UTC(),
+	})
+	return s.missingReference(ctx, err, transfer.MealPlanID)
+}
+
+// GetOwnershipTransfer retrieves the pending transfer of a meal plan
+func (s *SQLStore) GetOwnershipTransfer(ctx context.Context, mealPlanID string) (*models.OwnershipTransfer, error) {
+	row, err := s.queries.GetOwnershipTransfer(ctx, mealPlanID)
+	if err != nil {
+		return nil, notFound(err, ErrTransferNotFound)
+	}
+	return toOwnershipTransfer(row), nil
+}
+
+// ListOwnershipTransfersByRecipient returns the transfers offered to a user,
+// oldest first
+func (s *SQLStore) ListOwnershipTransfersByRecipient(ctx context.Context, userID string) ([]*models.OwnershipTransfer, error) {
+	rows, err := s.queries.ListOwnershipTransfersByRecipient(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	transfers := make([]*models.OwnershipTransfer, 0, len(rows))
+	for _, row := range rows {
+		transfers = append(transfers, toOwnershipTransfer(row))
+	}
+	return transfers, nil
+}
+
+// DeleteOwnershipTransfer withdraws the pending transfer of a meal plan
+func (s *SQLStore) DeleteOwnershipTransfer(ctx context.Context, mealPlanID string) error {
+	n, err := s.queries.DeleteOwnershipTransfer(ctx, mealPlanID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTransferNotFound
+	}
+	return nil
+}
+
+// CreateShareCode creates a new share code
+func (s *SQLStore) CreateShareCode(ctx context.Context, code *models.ShareCode) error {
+	if code.ID == "" {
+		code.ID = s.generateID()
+	}
+	err := s.queries.CreateShareLink(ctx, sqlc.CreateShareLinkParams{
+		ID:         code.ID,
+		MealPlanID: code.MealPlanID,
+		CreatedBy:  code.CreatedBy,
+		Role:       code.Role,
+		ExpiresAt:  code.ExpiresAt.UTC(),
+		MaxUses:    int32(code.MaxUses),
+	})
+	return s.missingReference(ctx, err, code.MealPlanID)
+}
+
+// GetShareCode retrieves a share code by ID
+func (s *SQLStore) GetShareCode(ctx context.Context, id string) (*models.ShareCode, error) {
+	row, err := s.queries.GetShareLinkByID(ctx, id)
+	if err != nil {
+		return nil, notFound(err, ErrShareCodeNotFound)
+	}
+	return toShareCode(row), nil
+}
+
+// CreateInvitation invites an email address to a meal plan
+func (s *SQLStore) CreateInvitation(ctx context.Context, invitation *models.Invitation) error {
+	if invitation.ID == "" {
+		invitation.ID = s.generateID()
+	}
+	if invitation.CreatedAt.IsZero() {
+		invitation.CreatedAt = time.Now()
+	}
+	err := s.queries.CreateInvitation(ctx, sqlc.CreateInvitationParams{
+		ID:         invitation.ID,
+		MealPlanID: invitation.MealPlanID,
+		Email:      invitation.Email,
+		Role:       invitation.Role,
+		InvitedBy:  invitation.InvitedBy,
+		CreatedAt:  invitation.CreatedAt.UTC(),
+	})
+	if isUniqueViolation(err) {
+		return ErrInvitationExists
+	}
+	return s.missingReference(ctx, err, invitation.MealPlanID)
+}
+
+// GetInvitation retrieves an invitation by ID
+func (s *SQLStore) GetInvitation(ctx context.Context, id string) (*models.Invitation, error) {
+	row, err := s.queries.GetInvitationByID(ctx, id)
+	if err != nil {
+		return nil, notFound(err, ErrInvitationNotFound)
+	}
+	return toInvitation(row), nil
+}
+
+// ListInvitationsByPlan returns a meal plan's pending invitations, oldest first
+func (s *SQLStore) ListInvitationsByPlan(ctx context.Context, mealPlanID string) ([]*models.Invitation, error) {
+	rows, err := s.queries.GetInvitationsByMealPlan(ctx, mealPlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations := make([]*models.Invitation, 0, len(rows))
+	for _, row := range rows {
+		invitations = append(invitations, toInvitation(row))
+	}
+	return invitations, nil
+}
+
+// DeleteInvitation cancels an invitation
+func (s *SQLStore) DeleteInvitation(ctx context.Context, id string) error {
+	n, err := s.queries.DeleteInvitation(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// ListShareCodesByPlan returns a meal plan's share codes, oldest first
+func (s *SQLStore) ListShareCodesByPlan(ctx context.Context, mealPlanID string) ([]*models.ShareCode, error) {
+	rows, err := s.queries.GetShareLinksByMealPlan(ctx, mealPlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]*models.ShareCode, 0, len(rows))
+	for _, row := range rows {
+		codes = append(codes, toShareCode(row))
+	}
+	return codes, nil
+}
+
+// RedeemShareCode counts a use of a share code and records who used it
+func (s *SQLStore) RedeemShareCode(ctx context.Context, id, userID string, at time.Time) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		q := tx.(*SQLStore).queries
+		n, err := q.UseShareLink(ctx, id)
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			if _, err := q.GetShareLinkByID(ctx, id); err != nil {
+				return notFound(err, ErrShareCodeNotFound)
+			}
+			return ErrShareCodeUsedUp
+		}
+
+		err = q.RecordShareLinkRedemption(ctx, sqlc.RecordShareLinkRedemptionParams{
+			ShareLinkID: id,
+			UserID:      userID,
+			RedeemedAt:  at.UTC(),
+		})
+		if isForeignKeyViolation(err) {
+			return ErrUserNotFound
+		}
+		return err
+	})
+}
+
+// ListShareCodeRedemptions returns who used each of a plan's share codes
+func (s *SQLStore) ListShareCodeRedemptions(ctx context.Context, mealPlanID string) ([]*models.ShareCodeRedemption, error) {
+	rows, err := s.queries.GetShareLinkRedemptionsByMealPlan(ctx, mealPlanID)
+	if err != nil {
+		return nil, err
+	}
+
+	redemptions := make([]*models.ShareCodeRedemption, 0, len(rows))
+	for _, row := range rows {
+		redemptions = append(redemptions, &models.ShareCodeRedemption{
+			ShareCodeID: row.ShareLinkID,
+			UserID:      row.UserID,
+			RedeemedAt:  row.RedeemedAt,
+		})
+	}
+	return redemptions, nil
+}
+
+// DeleteShareLink removes a share code from the store
+func (s *SQLStore) DeleteShareLink(ctx context.Context, id string) error {
+	n, err := s.queries.DeleteShareLink(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrShareCodeNotFound
+	}
+	return nil
+}
+
+// DeleteExpiredShareCodes removes every expired share code
+func (s *SQLStore) DeleteExpiredShareCodes(ctx context.Context) (int64, error) {
+	return s.queries.DeleteExpiredShareLinks(ctx, time.Now().UTC())
+}
+
+// ValidateToken validates an access token and returns its claims
+func (s *SQLStore) ValidateToken(ctx context.Context, tokenString string) (*TokenClaims, error) {
+	return s.validateToken(ctx, tokenString, s.GetSession)
+}
+
+// CreateSession stores a new session
+func (s *SQLStore) CreateSession(ctx context.Context, session *models.Session) error {
+	if session.ID == "" {
+		session.ID = s.generateID()
+	}
+	err := s.queries.CreateSession(ctx, sqlc.CreateSessionParams{
+		ID:               session.ID,
+		UserID:           session.UserID,
+		RefreshTokenHash: session.RefreshTokenHash,
+		UserAgent:        session.UserAgent,
+		IpAddress:        session.IPAddress,
+		ExpiresAt:        session.ExpiresAt.UTC(),
+	})
+	if isForeignKeyViolation(err) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// GetSession retrieves a session by ID
+func (s *SQLStore) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	row, err := s.queries.GetSessionByID(ctx, id)
+	if err != nil {
+		return nil, notFound(err, ErrSessionNotFound)
+	}
+	return toSession(row), nil
+}
+
+// ListSessionsByUser returns a user's sessions, oldest first
+func (s *SQLStore) ListSessionsByUser(ctx context.Context, userID string) ([]*models.Session, error) {
+	rows, err := s.queries.GetSessionsByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*models.Session, 0, len(rows))
+	for _, row := range rows {
+		sessions = append(sessions, toSession(row))
+	}
+	return sessions, nil
+}
+
+// RotateSession replaces a session's refresh token hash if oldHash is current
+func (s *SQLStore) RotateSession(ctx context.Context, id, oldHash, newHash string, expiresAt time.Time) error {
+	session, err := s.GetSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !session.Active(time.Now()) {
+		return ErrSessionNotFound
+	}
+
+	n, err := s.queries.RotateSession(ctx, sqlc.RotateSessionParams{
+		ID:        id,
+		OldHash:   oldHash,
+		NewHash:   newHash,
+		ExpiresAt: expiresAt.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRefreshTokenReused
+	}
+	return nil
+}
+
+// RevokeSession marks a session as revoked; revoking it again has no effect
+func (s *SQLStore) RevokeSession(ctx context.Context, id string) error {
+	n, err := s.queries.RevokeSession(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := s.queries.GetSessionByID(ctx, id)
+		return notFound(err, ErrSessionNotFound)
+	}
+	return nil
+}
+
+// DeleteExpiredSessions removes every expired session
+func (s *SQLStore) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	return s.queries.DeleteExpiredSessions(ctx, time.Now().UTC())
+}
+
+// SetPassword stores a user's password hash and clears any lockout
+func (s *SQLStore) SetPassword(ctx context.Context, userID, hash string) error {
+	err := s.queries.UpsertPassword(ctx, sqlc.UpsertPasswordParams{
+		UserID:       userID,
+		PasswordHash: hash,
+	})
+	if isForeignKeyViolation(err) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// GetPassword retrieves a user's password
+func (s *SQLStore) GetPassword(ctx context.Context, userID string) (*models.Password, error) {
+	row, err := s.queries.GetPassword(ctx, userID)
+	if err != nil {
+		return nil, notFound(err, ErrPasswordNotFound)
+	}
+	return toPassword(row), nil
+}
+
+// RecordFailedLogin counts a failed login, locking the password once
+// maxAttempts is reached
+func (s *SQLStore) RecordFailedLogin(ctx context.Context, userID string, maxAttempts int, lockedUntil time.Time) error {
+	n, err := s.queries.RecordFailedLogin(ctx, sqlc.RecordFailedLoginParams{
+		UserID:      userID,
+		MaxAttempts: int32(maxAttempts),
+		LockedUntil: sql.NullTime{Time: lockedUntil.UTC(), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPasswordNotFound
+	}
+	return nil
+}
+
+// ResetFailedLogins clears a password's failed login count and lockout
+func (s *SQLStore) ResetFailedLogins(ctx context.Context, userID string) error {
+	n, err := s.queries.ResetFailedLogins(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrPasswordNotFound
+	}
+	return nil
+}
+
+// CreateMagicLink stores a new sign-in link
+func (s *SQLStore) CreateMagicLink(ctx context.Context, link *models.MagicLink) error {
+	return s.queries.CreateMagicLink(ctx, sqlc.CreateMagicLinkParams{
+		TokenHash:    link.TokenHash,
+		Email:        link.Email,
+		Name:         nullString(link.Name),
+		PasswordHash: nullString(link.PasswordHash),
+		ExpiresAt:    link.ExpiresAt.UTC(),
+	})
+}
+
+// ConsumeMagicLink marks an unused, unexpired sign-in link as used
+func (s *SQLStore) ConsumeMagicLink(ctx context.Context, tokenHash string) (*models.MagicLink, error) {
+	row, err := s.queries.ConsumeMagicLink(ctx, sqlc.ConsumeMagicLinkParams{
+		TokenHash: tokenHash,
+		Now:       time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, notFound(err, ErrMagicLinkNotFound)
+	}
+	return toMagicLink(row), nil
+}
+
+// DeleteExpiredMagicLinks removes every expired sign-in link
+func (s *SQLStore) DeleteExpiredMagicLinks(ctx context.Context) (int64, error) {
+	return s.queries.DeleteExpiredMagicLinks(ctx, time.Now().UTC())
+}
+
+// CreateAPIToken stores a new personal access token
+func (s *SQLStore) CreateAPIToken(ctx context.Context, token *models.APIToken) error {
+	if token.ID == "" {
+		token.ID = s.generateID()
+	}
+	var expiresAt sql.NullTime
+	if token.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: token.ExpiresAt.UTC(), Valid: true}
+	}
+	err := s.queries.CreateAPIToken(ctx, sqlc.CreateAPITokenParams{
+		ID:        token.ID,
+		UserID:    token.UserID,
+		Name:      token.Name,
+		TokenHash: token.TokenHash,
+		Prefix:    token.Prefix,
+		Scopes:    strings.Join(token.Scopes, " "),
+		ExpiresAt: expiresAt,
+	})
+	if isForeignKeyViolation(err) {
+		return ErrUserNotFound
+	}
+	return err
+}
+
+// GetAPIToken retrieves a personal access token by ID
+func (s *SQLStore) GetAPIToken(ctx context.Context, id string) (*models.APIToken, error) {
+	row, err := s.queries.GetAPITokenByID(ctx, id)
+	if err != nil {
+		return nil, notFound(err, ErrAPITokenNotFound)
+	}
+	return toAPIToken(row), nil
+}
+
+// GetAPITokenByHash retrieves a personal access token by the hash of its value
+func (s *SQLStore) GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	row, err := s.queries.GetAPITokenByHash(ctx, tokenHash)
+	if err != nil {
+		return nil, notFound(err, ErrAPITokenNotFound)
+	}
+	return toAPIToken(row), nil
+}
+
+// ListAPITokensByUser returns a user's personal access tokens, oldest first
+func (s *SQLStore) ListAPITokensByUser(ctx context.Context, userID string) ([]*models.APIToken, error) {
+	rows, err := s.queries.GetAPITokensByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*models.APIToken, 0, len(rows))
+	for _, row := range rows {
+		tokens = append(tokens, toAPIToken(row))
+	}
+	return tokens, nil
+}
+
+// TouchAPIToken records when a personal access token was last used
+func (s *SQLStore) TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error {
+	n, err := s.queries.TouchAPIToken(ctx, sqlc.TouchAPITokenParams{
+		ID:         id,
+		LastUsedAt: sql.NullTime{Time: usedAt.UTC(), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// DeleteAPIToken revokes a personal access token by removing it
+func (s *SQLStore) DeleteAPIToken(ctx context.Context, id string) error {
+	n, err := s.queries.DeleteAPIToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// CreateMeal adds a new meal to the store
+func (s *SQLStore) CreateMeal(ctx context.Context, meal *models.Meal) error {
+	if meal.ID == "" {
+		meal.ID = s.generateID()
+	}
+	now := timestamp()
+	err := s.queries.CreateMeal(ctx, sqlc.CreateMealParams{
+		ID:          meal.ID,
+		MealPlanID:  meal.MealPlanID,
+		Name:        meal.Name,
+		Description: nullString(meal.Description),
+		Day:         meal.Day,
+		MealType:    meal.MealType,
+		CreatedAt:   sql.NullTime{Time: now, Valid: true},
+	})
+	if isForeignKeyViolation(err) {
+		return ErrMealPlanNotFound
+	}
+	if err != nil {
+		return err
+	}
+	meal.Version = 1
+	meal.CreatedAt = now
+	meal.UpdatedAt = now
+	return nil
+}
+
+// GetMeal retrieves a meal by ID
+func (s *SQLStore) GetMeal(ctx context.Context, id string) (*models.Meal, error) {
+	row, err := s.queries.GetMealByID(ctx, id)
+	if err != nil {
+		return nil, notFound(err, ErrMealNotFound)
+	}
+	return toMeal(row), nil
+}
+
+// UpdateMeal updates an existing meal
+func (s *SQLStore) UpdateMeal(ctx context.Context, meal *models.Meal) error {
+	n, err := s.queries.UpdateMeal(ctx, sqlc.UpdateMealParams{
+		ID:          meal.ID,
+		Name:        meal.Name,
+		Description: nullString(meal.Description),
+		Day:         meal.Day,
+		MealType:    meal.MealType,
+		Version:     meal.Version,
+		UpdatedAt:   sql.NullTime{Time: timestamp(), Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := s.queries.GetMealByID(ctx, meal.ID)
+		return staleOrMissing(err, ErrMealNotFound)
+	}
+	meal.Version++
+	return nil
+}
+
+// DeleteMeal removes a meal from the store if it is still at the given version
+func (s *SQLStore) DeleteMeal(ctx context.Context, id string, version int64) error {
+	n, err := s.queries.DeleteMeal(ctx, sqlc.DeleteMealParams{ID: id, Version: version})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := s.queries.GetMealByID(ctx, id)
+		return staleOrMissing(err, ErrMealNotFound)
+	}
+	return nil
+}
+
+// ListMealsByPlan returns a page of the meals in a specific meal plan
+func (s *SQLStore) ListMealsByPlan(ctx context.Context, mealPlanID string, opts ListOptions) ([]*models.Meal, string, error) {
+	opts, err := mealListOptions(opts)
+	if err != nil {
+		return nil, "", err
+	}
+	after, err := decodeCursor(opts.Cursor, opts.Sort)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var meals []*models.Meal
+	var keys [][]string
+	switch opts.Sort {
+	case SortByDay:
+		params := sqlc.ListMealsByDayParams{
+			MealPlanID: mealPlanID,
+			Day:        opts.Day,
+			MealType:   opts.MealType,
+			Pattern:    likePattern(opts.Text),
+			RowLimit:   rowLimit(opts.Limit),
+		}
+		if after != nil {
+			params.HasCursor = true
+			params.AfterDayRank, params.AfterDay, params.AfterMealTypeRank, params.AfterMealType, params.AfterID, err = dayCursor(after)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		rows, err := s.queries.ListMealsByDay(ctx, params)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, row := range rows {
+			meal := toMeal(row)
+			meals = append(meals, meal)
+			keys = append(keys, mealKeys[SortByDay](meal))
+		}
+	case SortByUpdated:
+		params := sqlc.ListMealsByUpdatedParams{
+			MealPlanID: mealPlanID,
+			Day:        opts.Day,
+			MealType:   opts.MealType,
+			Pattern:    likePattern(opts.Text),
+			RowLimit:   rowLimit(opts.Limit),
+		}
+		if after != nil {
+			params.HasCursor = true
+			params.AfterUpdatedAt, params.AfterID, err = updatedCursor(after)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		rows, err := s.queries.ListMealsByUpdated(ctx, params)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, row := range rows {
+			meal := toMeal(row)
+			meals = append(meals, meal)
+			keys = append(keys, mealKeys[SortByUpdated](meal))
+		}
+	case SortByName:
+		params := sqlc.ListMealsByNameParams{
+			MealPlanID: mealPlanID,
+			Day:        opts.Day,
+			MealType:   opts.MealType,
+			Pattern:    likePattern(opts.Text),
+			RowLimit:   rowLimit(opts.Limit),
+		}
+		if after != nil {
+			params.HasCursor = true
+			params.AfterName, params.AfterID, err = nameCursor(after)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		rows, err := s.queries.ListMealsByName(ctx, params)
+		if err != nil {
+			return nil, "", err
+		}
+		for _, row := range rows {
+			meals = append(meals, toMeal(sqlc.Meal{
+				ID:          row.ID,
+				MealPlanID:  row.MealPlanID,
+				Name:        row.Name,
+				Description: row.Description,
+				Day:         row.Day,
+				MealType:    row.MealType,
+				CreatedAt:   row.CreatedAt,
+				UpdatedAt:   row.UpdatedAt,
+				Version:     row.Version,
+			}))
+			keys = append(keys, []string{row.SortName, row.ID})
+		}
+	}
+	return sqlPage(meals, keys, opts)
+}
+
+// sqlPage trims the extra row a list query fetches past the limit and returns
+// the cursor that continues after the last record kept
+func sqlPage[T any](records []*T, keys [][]string, opts ListOptions) ([]*T, string, error) {
+	if opts.Limit == 0 || len(records) <= opts.Limit {
+		return records, "", nil
+	}
+	next, err := encodeCursor(opts.Sort, keys[opts.Limit-1])
+	if err != nil {
+		return nil, "", err
+	}
+	return records[:opts.Limit], next, nil
+}
+
+// errCursorMismatch reports a cursor whose key doesn't fit the query's order
+var errCursorMismatch = fmt.Errorf("%w: cursor doesn't match this query", ErrInvalidListOptions)
+
+// dayCursor splits a SortByDay key into the query's cursor arguments
+func dayCursor(key []string) (dayRank int32, day string, mealTypeRank int32, mealType, id string, err error) {
+	if len(key) != 5 {
+		return 0, "", 0, "", "", errCursorMismatch
+	}
+	dayPosition, dayErr := strconv.ParseInt(key[0], 10, 32)
+	typePosition, typeErr := strconv.ParseInt(key[2], 10, 32)
+	if dayErr != nil || typeErr != nil {
+		return 0, "", 0, "", "", errCursorMismatch
+	}
+	return int32(dayPosition), key[1], int32(typePosition), key[3], key[4], nil
+}
+
+// updatedCursor splits a SortByUpdated key into the query's cursor arguments
+func updatedCursor(key []string) (sql.NullTime, string, error) {
+	if len(key) != 2 {
+		return sql.NullTime{}, "", errCursorMismatch
+	}
+	remaining, err := strconv.ParseInt(key[0], 10, 64)
+	if err != nil || remaining < 0 {
+		return sql.NullTime{}, "", errCursorMismatch
+	}
+	updatedAt := time.Unix(0, math.MaxInt64-remaining).UTC()
+	return sql.NullTime{Time: updatedAt, Valid: true}, key[1], nil
+}
+
+// nameCursor splits a SortByName key into the query's cursor arguments
+func nameCursor(key []string) (name, id string, err error) {
+	if len(key) != 2 {
+		return "", "", errCursorMismatch
+	}
+	return key[0], key[1], nil
+}
+
+// rowLimit is the LIMIT for a page of limit records: one more than the page,
+// so the query shows whether another page follows
+func rowLimit(limit int) int32 {
+	if limit == 0 || limit >= math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(limit) + 1
+}
+
+// likePattern turns a text filter into a LIKE pattern that matches the
+// lowercased text anywhere, or "" for no filter
+func likePattern(text string) string {
+	if text == "" {
+		return ""
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(text))
+	return "%" + escaped + "%"
+}
+
+// timestamp returns the current time at the microsecond precision PostgreSQL
+// keeps. Writing the same values to SQLite lets list cursors match stored
+// timestamps exactly.
+func timestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// notFound translates sql.ErrNoRows into the given store error
+func notFound(err, sentinel error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return sentinel
+	}
+	return err
+}
+
+// staleOrMissing explains an update or delete that matched no rows, given the
+// result of looking the record up again: either it is gone or its version moved on
+func staleOrMissing(lookupErr, sentinel error) error {
+	if lookupErr != nil {
+		return notFound(lookupErr, sentinel)
+	}
+	return ErrVersionConflict
+}
+
+// isForeignKeyViolation reports whether err is a foreign key failure from
+// either PostgreSQL or SQLite
+func isForeignKeyViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23503"
+	}
+	return isSQLiteForeignKeyViolation(err)
+}
+
+// isUniqueViolation reports whether err is the database rejecting a row that
+// duplicates a unique key
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	return isSQLiteUniqueViolation(err)
+}
+
+// missingReference maps a foreign key failure on a row that references both a
+// meal plan and a user to the not-found error for whichever one is missing
+func (s *SQLStore) missingReference(ctx context.Context, err error, mealPlanID string) error {
+	if !isForeignKeyViolation(err) {
+		return err
+	}
+
+	// PostgreSQL names the violated constraint
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		if strings.HasSuffix(pqErr.Constraint, "meal_plan_id_fkey") {
+			return ErrMealPlanNotFound
+		}
+		return ErrUserNotFound
+	}
+
+	// SQLite doesn't, so check whether the plan is there
+	if _, err := s.queries.GetMealPlanByID(ctx, mealPlanID); errors.Is(err, sql.ErrNoRows) {
+		return ErrMealPlanNotFound
+	}
+	return ErrUserNotFound
+}
+
+// nullString maps an empty string to SQL NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// nullTime returns the time or the zero time when NULL
+func nullTime(t sql.NullTime) time.Time {
+	if !t.Valid {
+		return time.Time{}
+	}
+	return t.Time
+}
+
+// nullTimeString formats a nullable timestamp the way models.User expects it
+func nullTimeString(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.Format(time.RFC3339)
+}
+
+func toUser(row sqlc.User) *models.User {
+	return &models.User{
+		ID:            row.ID,
+		Email:         row.Email,
+		Name:          row.Name,
+		Picture:       row.Picture.String,
+		EmailVerified: row.EmailVerified,
+		CreateAt:      nullTimeString(row.CreatedAt),
+		UpdateAt:      nullTimeString(row.UpdatedAt),
+	}
+}
+
+func toMealPlan(row sqlc.MealPlan) *models.MealPlan {
+	return &models.MealPlan{
+		ID:          row.ID,
+		Name:        row.Name,
+		Description: row.Description.String,
+		Version:     row.Version,
+		CreatedAt:   nullTime(row.CreatedAt),
+		UpdatedAt:   nullTime(row.UpdatedAt),
+		CreatedBy:   row.CreatedBy,
+	}
+}
+
+func toMeal(row sqlc.Meal) *models.Meal {
+	return &models.Meal{
+		ID:          row.ID,
+		MealPlanID:  row.MealPlanID,
+		Name:        row.Name,
+		Description: row.Description.String,
+		Day:         row.Day,
+		MealType:    row.MealType,
+		Version:     row.Version,
+		CreatedAt:   nullTime(row.CreatedAt),
+		UpdatedAt:   nullTime(row.UpdatedAt),
+	}
+}
+
+func toShareCode(row sqlc.ShareLink) *models.ShareCode {
+	return &models.ShareCode{
+		ID:         row.ID,
+		MealPlanID: row.MealPlanID,
+		CreatedBy:  row.CreatedBy,
+		Role:       row.Role,
+		ExpiresAt:  row.ExpiresAt,
+		CreatedAt:  nullTime(row.CreatedAt),
+		MaxUses:    int(row.MaxUses),
+		Uses:       int(row.Uses),
+	}
+}
+
+func toSession(row sqlc.Session) *models.Session {
+	session := &models.Session{
+		ID:               row.ID,
+		UserID:           row.UserID,
+		RefreshTokenHash: row.RefreshTokenHash,
+		UserAgent:        row.UserAgent,
+		IPAddress:        row.IpAddress,
+		CreatedAt:        row.CreatedAt,
+		LastUsedAt:       row.LastUsedAt,
+		ExpiresAt:        row.ExpiresAt,
+	}
+	if row.RevokedAt.Valid {
+		revokedAt := row.RevokedAt.Time
+		session.RevokedAt = &revokedAt
+	}
+	return session
+}
+
+func toPassword(row sqlc.Password) *models.Password {
+	password := &models.Password{
+		UserID:         row.UserID,
+		Hash:           row.PasswordHash,
+		FailedAttempts: int(row.FailedAttempts),
+		UpdatedAt:      row.UpdatedAt,
+	}
+	if row.LockedUntil.Valid {
+		lockedUntil := row.LockedUntil.Time
+		password.LockedUntil = &lockedUntil
+	}
+	return password
+}
+
+func toMagicLink(row sqlc.MagicLink) *models.MagicLink {
+	link := &models.MagicLink{
+		TokenHash:    row.TokenHash,
+		Email:        row.Email,
+		Name:         row.Name.String,
+		PasswordHash: row.PasswordHash.String,
+		CreatedAt:    row.CreatedAt,
+		ExpiresAt:    row.ExpiresAt,
+	}
+	if row.UsedAt.Valid {
+		usedAt := row.UsedAt.Time
+		link.UsedAt = &usedAt
+	}
+	return link
+}
+
+func toOwnershipTransfer(row sqlc.OwnershipTransfer) *models.OwnershipTransfer {
+	return &models.OwnershipTransfer{
+		MealPlanID: row.MealPlanID,
+		FromUserID: row.FromUserID,
+		ToUserID:   row.ToUserID,
+		CreatedAt:  row.CreatedAt,
+		ExpiresAt:  row.ExpiresAt,
+	}
+}
+
+func toInvitation(row sqlc.Invitation) *models.Invitation {
+	return &models.Invitation{
+		ID:         row.ID,
+		MealPlanID: row.MealPlanID,
+		Email:      row.Email,
+		Role:       row.Role,
+		InvitedBy:  row.InvitedBy,
+		CreatedAt:  row.CreatedAt,
+	}
+}
+
+func toAPIToken(row sqlc.ApiToken) *models.APIToken {
+	token := &models.APIToken{
+		ID:        row.ID,
+		UserID:    row.UserID,
+		Name:      row.Name,
+		TokenHash: row.TokenHash,
+		Prefix:    row.Prefix,
+		Scopes:    strings.Fields(row.Scopes),
+		CreatedAt: row.CreatedAt,
+	}
+	if row.LastUsedAt.Valid {
+		lastUsedAt := row.LastUsedAt.Time
+		token.LastUsedAt = &lastUsedAt
+	}
+	if row.ExpiresAt.Valid {
+		expiresAt := row.ExpiresAt.Time
+		token.ExpiresAt = &expiresAt
+	}
+	return token
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	sqlc "my-meal-planner/internal/db"
+)
+
+// OpenSQLite opens a SQLite database file for use with NewSQLiteStore and
+// NewMigrator. Foreign keys are enforced so ON DELETE CASCADE behaves as on
+// PostgreSQL, and timestamps are stored in SQLite's own text format so that
+// comparisons with CURRENT_TIMESTAMP work.
+func OpenSQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path +
+		"?_pragma=foreign_keys(1)" +
+		"&_pragma=busy_timeout(5000)" +
+		"&_pragma=journal_mode(WAL)" +
+		"&_time_format=sqlite"
+
+	conn, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite db: %w", err)
+	}
+
+	// SQLite allows a single writer, so share one connection rather than
+	// letting concurrent transactions fail with SQLITE_BUSY
+	conn.SetMaxOpenConns(1)
+
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open sqlite db: %w", err)
+	}
+	return conn, nil
+}
+
+// NewSQLiteStore creates a new store backed by a connection from OpenSQLite
+func NewSQLiteStore(conn *sql.DB, keys *Keyring) *SQLStore {
+	return &SQLStore{
+		db:          conn,
+		queries:     sqlc.New(conn),
+		tokenIssuer: tokenIssuer{keys: keys},
+	}
+}
+
+// isSQLiteForeignKeyViolation reports whether err is SQLite rejecting a row
+// whose foreign key references a missing record
+func isSQLiteForeignKeyViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY
+}
+
+// isSQLiteUniqueViolation reports whether err is SQLite rejecting a row that
+// duplicates a unique key
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
@@ -0,0 +1,78 @@
+package db_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"my-meal-planner/db"
+	"my-meal-planner/db/storetest"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) db.Store {
+		conn, err := db.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("OpenSQLite: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		migrator, err := db.NewMigrator(conn)
+		if err != nil {
+			t.Fatalf("NewMigrator: %v", err)
+		}
+		if _, err := migrator.Up(context.Background()); err != nil {
+			t.Fatalf("migrate up: %v", err)
+		}
+		return db.NewSQLiteStore(conn, db.NewHMACKeyring([]byte("test-secret")))
+	})
+}
+
+func TestMigrateUpDown(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	defer conn.Close()
+
+	migrator, err := db.NewMigrator(conn)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+
+	count, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if count != len(statuses) {
+		t.Errorf("Up applied %d migrations, want %d", count, len(statuses))
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Errorf("migration %d not applied after Up", status.Version)
+		}
+	}
+
+	// Running Up again is a no-op
+	if count, err := migrator.Up(ctx); err != nil || count != 0 {
+		t.Errorf("second Up = %d, %v, want 0, nil", count, err)
+	}
+
+	// Roll everything back and apply it again
+	for range statuses {
+		if _, err := migrator.Down(ctx); err != nil {
+			t.Fatalf("Down: %v", err)
+		}
+	}
+	if migration, err := migrator.Down(ctx); err != nil || migration != nil {
+		t.Errorf("Down with nothing applied = %v, %v, want nil, nil", migration, err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		t.Fatalf("Up after Down: %v", err)
+	}
+}
@@ -1,440 +1,1460 @@
 package db
 
 import (
+	"context"
 	"errors"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"golang.org/x/oauth2"
 
 	"my-meal-planner/models"
 )
 
 var (
-	ErrMealNotFound     = errors.New("meal not found")
-	ErrMealPlanNotFound = errors.New("meal plan not found")
-	ErrUserNotFound     = errors.New("user not found")
-	ErrInvalidToken     = errors.New("invalid token")
-	ErrAccessDenied     = errors.New("access denied")
+	ErrMealNotFound       = errors.New("meal not found")
+	ErrMealPlanNotFound   = errors.New("meal plan not found")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrAccessDenied       = errors.New("access denied")
+	ErrShareCodeNotFound  = errors.New("share code not found")
+	ErrShareCodeUsedUp    = errors.New("share code used up")
+	ErrVersionConflict    = errors.New("version conflict")
+	ErrSessionNotFound    = errors.New("session not found")
+	ErrPasswordNotFound   = errors.New("password not found")
+	ErrMagicLinkNotFound  = errors.New("magic link not found")
+	ErrAPITokenNotFound   = errors.New("API token not found")
+	ErrMemberNotFound     = errors.New("member not found")
+	ErrTransferNotFound   = errors.New("ownership transfer not found")
+	ErrInvitationExists   = errors.New("invitation already exists")
+	ErrInvitationNotFound = errors.New("invitation not found")
+	// ErrRefreshTokenReused means a refresh token was presented after it had
+	// already been exchanged, which suggests it was stolen
+	ErrRefreshTokenReused = errors.New("refresh token reused")
 )
 
 // Store defines the interface for data storage operations
 type Store interface {
+	// WithTx runs fn against a transactional view of the store. The changes
+	// made through tx are committed if fn returns nil and discarded otherwise.
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+
 	// User operations
-	CreateOrUpdateUser(user *models.User) error
-	GetUserByID(id string) (*models.User, error)
-	GetUserByEmail(email string) (*models.User, error)
+	CreateOrUpdateUser(ctx context.Context, user *models.User) error
+	// AcceptInvitations turns the invitations to a user's email address into
+	// access to the invited plans. Call it only once the user has shown they
+	// own that address.
+	AcceptInvitations(ctx context.Context, userID string) error
+	GetUserByID(ctx context.Context, id string) (*models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	// DeleteUser removes a user with their sessions, credentials and access.
+	// Plans they own alone are deleted; plans they created but someone else
+	// owns are credited to that owner, and their share codes are deleted.
+	DeleteUser(ctx context.Context, id string) error
 
 	// Token operations
-	GenerateToken(userID string) (string, error)
-	ValidateToken(tokenString string) (*TokenClaims, error)
-
-	// OAuth operations
-	GetOAuthConfig() *oauth2.Config
+	GenerateToken(ctx context.Context, user *models.User, sessionID string) (string, error)
+	// ValidateToken checks an access token and rejects it once its session
+	// has been revoked or has expired
+	ValidateToken(ctx context.Context, tokenString string) (*TokenClaims, error)
+	// JWKS returns the public keys that verify access tokens
+	JWKS() JWKS
+
+	// Session operations
+	CreateSession(ctx context.Context, session *models.Session) error
+	GetSession(ctx context.Context, id string) (*models.Session, error)
+	ListSessionsByUser(ctx context.Context, userID string) ([]*models.Session, error)
+	// RotateSession replaces an active session's refresh token hash and
+	// extends its expiry. It returns ErrRefreshTokenReused if oldHash is no
+	// longer the session's current hash.
+	RotateSession(ctx context.Context, id, oldHash, newHash string, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, id string) error
+	// DeleteExpiredSessions removes every expired session, revoked or not,
+	// and returns how many there were
+	DeleteExpiredSessions(ctx context.Context) (int64, error)
+
+	// Password operations
+	// SetPassword stores a user's password hash and clears any lockout
+	SetPassword(ctx context.Context, userID, hash string) error
+	GetPassword(ctx context.Context, userID string) (*models.Password, error)
+	// RecordFailedLogin counts a failed login. The attempt that reaches
+	// maxAttempts locks the password until lockedUntil and restarts the count.
+	RecordFailedLogin(ctx context.Context, userID string, maxAttempts int, lockedUntil time.Time) error
+	ResetFailedLogins(ctx context.Context, userID string) error
+
+	// Magic link operations
+	CreateMagicLink(ctx context.Context, link *models.MagicLink) error
+	// ConsumeMagicLink marks a link as used and returns it. It returns
+	// ErrMagicLinkNotFound if the link is unknown, already used or expired.
+	ConsumeMagicLink(ctx context.Context, tokenHash string) (*models.MagicLink, error)
+	// DeleteExpiredMagicLinks removes every expired sign-in link, used or
+	// not, and returns how many there were
+	DeleteExpiredMagicLinks(ctx context.Context) (int64, error)
+
+	// API token operations
+	CreateAPIToken(ctx context.Context, token *models.APIToken) error
+	GetAPIToken(ctx context.Context, id string) (*models.APIToken, error)
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error)
+	ListAPITokensByUser(ctx context.Context, userID string) ([]*models.APIToken, error)
+	// TouchAPIToken records when a token was last used
+	TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error
+	DeleteAPIToken(ctx context.Context, id string) error
 
 	// Meal plan operations
-	CreateMealPlan(plan *models.MealPlan) error
-	GetMealPlan(id string) (*models.MealPlan, error)
-	UpdateMealPlan(plan *models.MealPlan) error
-	DeleteMealPlan(id string) error
-	ListMealPlansByUser(userID string) []*models.MealPlan
-	CreateMealPlanAccess(access *models.MealPlanAccess) error
-	CheckMealPlanAccess(userID, mealPlanID string) (bool, error)
-	CheckMealPlanOwnership(userID, mealPlanID string) (bool, error)
+	CreateMealPlan(ctx context.Context, plan *models.MealPlan) error
+	GetMealPlan(ctx context.Context, id string) (*models.MealPlan, error)
+	// UpdateMealPlan saves plan only if plan.Version matches the stored
+	// version, then sets plan.Version to the new one
+	UpdateMealPlan(ctx context.Context, plan *models.MealPlan) error
+	DeleteMealPlan(ctx context.Context, id string, version int64) error
+	ListMealPlansByUser(ctx context.Context, userID string, opts ListOptions) (plans []*models.MealPlan, nextCursor string, err error)
+	CreateMealPlanAccess(ctx context.Context, access *models.MealPlanAccess) error
+	CheckMealPlanAccess(ctx context.Context, userID, mealPlanID string) (bool, error)
+	// GetRole returns the user's highest role on a plan: "owner", "editor" or
+	// "viewer". It returns ErrAccessDenied when the user has no access.
+	GetRole(ctx context.Context, userID, mealPlanID string) (string, error)
+	CheckMealPlanOwnership(ctx context.Context, userID, mealPlanID string) (bool, error)
+	// ListMealPlanMembers returns everyone with access to a plan, once each
+	// with their highest role, owners first
+	ListMealPlanMembers(ctx context.Context, mealPlanID string) ([]*models.MealPlanMember, error)
+	// SetMemberRole changes the role of every access record a user has on a
+	// plan. It returns ErrMemberNotFound if there are none.
+	SetMemberRole(ctx context.Context, mealPlanID, userID, role string) error
+	RemoveMember(ctx context.Context, mealPlanID, userID string) error
+
+	// Ownership transfer operations
+	// CreateOwnershipTransfer offers a plan to another user, replacing any
+	// offer already pending for it
+	CreateOwnershipTransfer(ctx context.Context, transfer *models.OwnershipTransfer) error
+	GetOwnershipTransfer(ctx context.Context, mealPlanID string) (*models.OwnershipTransfer, error)
+	ListOwnershipTransfersByRecipient(ctx context.Context, userID string) ([]*models.OwnershipTransfer, error)
+	DeleteOwnershipTransfer(ctx context.Context, mealPlanID string) error
+
+	// Invitation operations
+	// CreateInvitation invites an email address to a plan. It returns
+	// ErrInvitationExists if that address is already invited to it.
+	CreateInvitation(ctx context.Context, invitation *models.Invitation) error
+	GetInvitation(ctx context.Context, id string) (*models.Invitation, error)
+	ListInvitationsByPlan(ctx context.Context, mealPlanID string) ([]*models.Invitation, error)
+	DeleteInvitation(ctx context.Context, id string) error
 
 	// Share link operations
-	CreateShareCode(link *models.ShareCode) error
-	GetShareCode(id string) (*models.ShareCode, error)
-	DeleteShareLink(id string) error
+	CreateShareCode(ctx context.Context, link *models.ShareCode) error
+	GetShareCode(ctx context.Context, id string) (*models.ShareCode, error)
+	ListShareCodesByPlan(ctx context.Context, mealPlanID string) ([]*models.ShareCode, error)
+	// RedeemShareCode counts a use of a share code and records that userID
+	// used it. It returns ErrShareCodeUsedUp once the code has no uses left.
+	RedeemShareCode(ctx context.Context, id, userID string, at time.Time) error
+	// ListShareCodeRedemptions returns who used each of a plan's share codes,
+	// oldest first
+	ListShareCodeRedemptions(ctx context.Context, mealPlanID string) ([]*models.ShareCodeRedemption, error)
+	DeleteShareLink(ctx context.Context, id string) error
+	// DeleteExpiredShareCodes removes every expired share code and returns
+	// how many there were
+	DeleteExpiredShareCodes(ctx context.Context) (int64, error)
 
 	// Meal operations
-	CreateMeal(meal *models.Meal) error
-	GetMeal(id string) (*models.Meal, error)
-	UpdateMeal(meal *models.Meal) error
-	DeleteMeal(id string) error
-	ListMealsByPlan(mealPlanID string) []*models.Meal
-}
-
-// TokenClaims represents the claims in a JWT token
-type TokenClaims struct {
-	UserID string `json:"userId"`
-	jwt.RegisteredClaims
+	CreateMeal(ctx context.Context, meal *models.Meal) error
+	GetMeal(ctx context.Context, id string) (*models.Meal, error)
+	// UpdateMeal saves meal only if meal.Version matches the stored
+	// version, then sets meal.Version to the new one
+	UpdateMeal(ctx context.Context, meal *models.Meal) error
+	DeleteMeal(ctx context.Context, id string, version int64) error
+	ListMealsByPlan(ctx context.Context, mealPlanID string, opts ListOptions) (meals []*models.Meal, nextCursor string, err error)
 }
 
 // MemoryStore implements the Store interface using in-memory storage
 type MemoryStore struct {
-	users          map[string]*models.User
-	meals          map[string]*models.Meal
-	mealPlans      map[string]*models.MealPlan
-	mealPlanAccess map[string]*models.MealPlanAccess
-	shareCodes     map[string]*models.ShareCode
-	mutex          sync.RWMutex
-	oauthConfig    *oauth2.Config
-	jwtSecret      []byte
-}
-
-// NewMemoryStore creates a new in-memory store
-func NewMemoryStore(oauthConfig *oauth2.Config, jwtSecret []byte) *MemoryStore {
+	memoryTables
+	mutex   sync.RWMutex
+	inTx    bool        // set on the copy used by WithTx, whose parent already holds the lock
+	undo    []undoEntry // state of the records changed inside WithTx, oldest first
+	journal *journal
+	pending []journalChange // changes recorded inside WithTx, written on commit
+	done    chan struct{}   // closed by Close to stop background persistence
+	stopped chan struct{}   // closed once background persistence has stopped
+	tokenIssuer
+}
+
+// NewMemoryStore creates a new in-memory store that signs tokens with keys
+func NewMemoryStore(keys *Keyring) *MemoryStore {
 	return &MemoryStore{
-		users:          make(map[string]*models.User),
-		meals:          make(map[string]*models.Meal),
-		mealPlans:      make(map[string]*models.MealPlan),
-		mealPlanAccess: make(map[string]*models.MealPlanAccess),
-		shareCodes:     make(map[string]*models.ShareCode),
-		oauthConfig:    oauthConfig,
-		jwtSecret:      jwtSecret,
+		memoryTables: newMemoryTables(),
+		tokenIssuer:  tokenIssuer{keys: keys},
 	}
 }
 
-// generateID generates a unique ID
-func (s *MemoryStore) generateID() string {
-	return time.Now().Format("20060102150405") + "-" + uuid.New().String()
+// lock, unlock, rlock and runlock guard the maps unless the store is a
+// transaction copy, in which case the parent store's write lock is held.
+func (s *MemoryStore) lock() {
+	if !s.inTx {
+		s.mutex.Lock()
+	}
 }
 
-// GetOAuthConfig returns the OAuth2 config
-func (s *MemoryStore) GetOAuthConfig() *oauth2.Config {
-	return s.oauthConfig
+func (s *MemoryStore) unlock() {
+	if !s.inTx {
+		s.mutex.Unlock()
+	}
 }
 
-// CreateMeal adds a new meal to the store
-func (s *MemoryStore) CreateMeal(meal *models.Meal) error {
+func (s *MemoryStore) rlock() {
+	if !s.inTx {
+		s.mutex.RLock()
+	}
+}
+
+func (s *MemoryStore) runlock() {
+	if !s.inTx {
+		s.mutex.RUnlock()
+	}
+}
+
+// WithTx runs fn while holding the write lock. Changes fn makes are applied
+// as it goes and undone if it fails, so a transaction costs as much as the
+// records it touches.
+func (s *MemoryStore) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	// The tables are shared with the transaction, which only adds an undo log
+	tx := &MemoryStore{
+		memoryTables: s.memoryTables,
+		inTx:         true,
+		journal:      s.journal,
+		tokenIssuer:  s.tokenIssuer,
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tx.rollback()
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.journal != nil && len(tx.pending) > 0 {
+		if err := s.journal.append(tx.pending); err != nil {
+			return err
+		}
+	}
+	committed = true
+	return nil
+}
+
+// generateID generates a unique ID
+func (s *MemoryStore) generateID() string {
+	return time.Now().Format("20060102150405") + "-" + uuid.New().String()
+}
+
+// CreateMeal adds a new meal to the store
+func (s *MemoryStore) CreateMeal(ctx context.Context, meal *models.Meal) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	// Meals must belong to an existing plan, like the meals.meal_plan_id foreign key
+	if _, exists := s.mealPlans[meal.MealPlanID]; !exists {
+		return ErrMealPlanNotFound
+	}
+
 	if meal.ID == "" {
 		meal.ID = s.generateID()
 	}
-	s.meals[meal.ID] = meal
-	return nil
+	meal.Version = 1
+	created := *meal
+	return s.putMeal(&created)
 }
 
 // GetMeal retrieves a meal by ID
-func (s *MemoryStore) GetMeal(id string) (*models.Meal, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+func (s *MemoryStore) GetMeal(ctx context.Context, id string) (*models.Meal, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
 
 	meal, exists := s.meals[id]
 	if !exists {
 		return nil, ErrMealNotFound
 	}
-	return meal, nil
+	copied := *meal
+	return &copied, nil
 }
 
 // UpdateMeal updates an existing meal
-func (s *MemoryStore) UpdateMeal(meal *models.Meal) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *MemoryStore) UpdateMeal(ctx context.Context, meal *models.Meal) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
 
 	existingMeal, exists := s.meals[meal.ID]
 	if !exists {
 		return ErrMealNotFound
 	}
+	if existingMeal.Version != meal.Version {
+		return ErrVersionConflict
+	}
 
-	existingMeal.Name = meal.Name
-	existingMeal.Description = meal.Description
-	existingMeal.Day = meal.Day
-	existingMeal.MealType = meal.MealType
-	existingMeal.UpdatedAt = time.Now()
-
-	s.meals[meal.ID] = existingMeal
-	return nil
-}
-
-// DeleteMeal removes a meal from the store
-func (s *MemoryStore) DeleteMeal(id string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	updated := *existingMeal
+	updated.Name = meal.Name
+	updated.Description = meal.Description
+	updated.Day = meal.Day
+	updated.MealType = meal.MealType
+	updated.UpdatedAt = time.Now()
+	updated.Version++
 
-	if _, exists := s.meals[id]; !exists {
-		return ErrMealNotFound
+	if err := s.putMeal(&updated); err != nil {
+		return err
 	}
-
-	delete(s.meals, id)
+	meal.Version = updated.Version
 	return nil
 }
 
-// ListMealsByPlan returns all meals in a specific meal plan
-func (s *MemoryStore) ListMealsByPlan(mealPlanID string) []*models.Meal {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
-	var meals []*models.Meal
-	for _, meal := range s.meals {
-		if meal.MealPlanID == mealPlanID {
-			meals = append(meals, meal)
-		}
+// DeleteMeal removes a meal from the store if it is still at the given version
+func (s *MemoryStore) DeleteMeal(ctx context.Context, id string, version int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return meals
-}
 
-// CreateOrGetUser creates a new user or returns an existing one
-func (s *MemoryStore) CreateOrGetUser(user models.User) (*models.User, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s.lock()
+	defer s.unlock()
 
-	// Check if user already exists by Google ID
-	if existingUser, exists := s.users[user.ID]; exists {
-		return existingUser, nil
+	meal, exists := s.meals[id]
+	if !exists {
+		return ErrMealNotFound
+	}
+	if meal.Version != version {
+		return ErrVersionConflict
 	}
 
-	// Create new user
-	userID := s.generateID()
-	user.ID = userID
-	s.users[userID] = &user
-
-	return &user, nil
+	return s.removeMeal(id)
 }
 
-// GenerateToken creates a new JWT token for a user
-func (s *MemoryStore) GenerateToken(userID string) (string, error) {
-	claims := &TokenClaims{
-		UserID: userID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		},
+// ListMealsByPlan returns a page of the meals in a specific meal plan
+func (s *MemoryStore) ListMealsByPlan(ctx context.Context, mealPlanID string, opts ListOptions) ([]*models.Meal, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
-}
+	s.rlock()
+	defer s.runlock()
 
-// ValidateToken validates a JWT token and returns the claims
-func (s *MemoryStore) ValidateToken(tokenString string) (*TokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return s.jwtSecret, nil
-	})
-
-	if err != nil {
-		return nil, ErrInvalidToken
+	var meals []*models.Meal
+	for _, id := range s.mealsByPlan.ids(mealPlanID) {
+		copied := *s.meals[id]
+		meals = append(meals, &copied)
 	}
+	return pageMeals(meals, opts)
+}
 
-	if claims, ok := token.Claims.(*TokenClaims); ok && token.Valid {
-		return claims, nil
+// CreateMealPlan creates a new meal plan
+func (s *MemoryStore) CreateMealPlan(ctx context.Context, plan *models.MealPlan) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	return nil, ErrInvalidToken
-}
+	s.lock()
+	defer s.unlock()
 
-// CreateMealPlan creates a new meal plan
-func (s *MemoryStore) CreateMealPlan(plan *models.MealPlan) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	// The creator must exist, like the meal_plans.created_by foreign key
+	if _, exists := s.users[plan.CreatedBy]; !exists {
+		return ErrUserNotFound
+	}
 
 	if plan.ID == "" {
 		plan.ID = s.generateID()
 	}
-	s.mealPlans[plan.ID] = plan
-	return nil
+	plan.Version = 1
+	created := *plan
+	return s.putMealPlan(&created)
 }
 
 // GetMealPlan retrieves a meal plan by ID
-func (s *MemoryStore) GetMealPlan(id string) (*models.MealPlan, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+func (s *MemoryStore) GetMealPlan(ctx context.Context, id string) (*models.MealPlan, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
 
 	plan, exists := s.mealPlans[id]
 	if !exists {
 		return nil, ErrMealPlanNotFound
 	}
-	return plan, nil
+	copied := *plan
+	return &copied, nil
 }
 
 // UpdateMealPlan updates an existing meal plan
-func (s *MemoryStore) UpdateMealPlan(plan *models.MealPlan) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *MemoryStore) UpdateMealPlan(ctx context.Context, plan *models.MealPlan) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
 
 	existingPlan, exists := s.mealPlans[plan.ID]
 	if !exists {
 		return ErrMealPlanNotFound
 	}
+	if existingPlan.Version != plan.Version {
+		return ErrVersionConflict
+	}
 
-	existingPlan.Name = plan.Name
-	existingPlan.Description = plan.Description
-	existingPlan.UpdatedAt = time.Now()
+	updated := *existingPlan
+	updated.Name = plan.Name
+	updated.Description = plan.Description
+	updated.UpdatedAt = time.Now()
+	updated.Version++
 
-	s.mealPlans[plan.ID] = existingPlan
+	if err := s.putMealPlan(&updated); err != nil {
+		return err
+	}
+	plan.Version = updated.Version
 	return nil
 }
 
-// DeleteMealPlan removes a meal plan from the store
-func (s *MemoryStore) DeleteMealPlan(id string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// DeleteMealPlan removes a meal plan at the given version along with its
+// meals, access records and share codes
+func (s *MemoryStore) DeleteMealPlan(ctx context.Context, id string, version int64) error {
+	// Run the cascade as a transaction so it is journaled and applied as a whole
+	return s.WithTx(ctx, func(tx Store) error {
+		m := tx.(*MemoryStore)
+		plan, exists := m.mealPlans[id]
+		if !exists {
+			return ErrMealPlanNotFound
+		}
+		if plan.Version != version {
+			return ErrVersionConflict
+		}
+		return m.removeMealPlan(id)
+	})
+}
 
-	if _, exists := s.mealPlans[id]; !exists {
-		return ErrMealPlanNotFound
+// ListMealPlansByUser returns a page of the meal plans a user has access to
+func (s *MemoryStore) ListMealPlansByUser(ctx context.Context, userID string, opts ListOptions) ([]*models.MealPlan, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
 	}
 
-	delete(s.mealPlans, id)
-	return nil
-}
-
-// ListMealPlansByUser returns all meal plans a user has access to
-func (s *MemoryStore) ListMealPlansByUser(userID string) []*models.MealPlan {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	s.rlock()
+	defer s.runlock()
 
 	var plans []*models.MealPlan
-	for _, access := range s.mealPlanAccess {
-		if access.UserID == userID {
-			if plan, exists := s.mealPlans[access.MealPlanID]; exists {
-				plans = append(plans, plan)
-			}
+	seen := make(map[string]bool)
+	for _, accessID := range s.accessByUser.ids(userID) {
+		planID := s.mealPlanAccess[accessID].MealPlanID
+		if seen[planID] {
+			continue
 		}
+		seen[planID] = true
+		copied := *s.mealPlans[planID]
+		plans = append(plans, &copied)
 	}
-	return plans
+	return pageMealPlans(plans, opts)
 }
 
 // CreateMealPlanAccess creates a new meal plan access record
-func (s *MemoryStore) CreateMealPlanAccess(access *models.MealPlanAccess) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *MemoryStore) CreateMealPlanAccess(ctx context.Context, access *models.MealPlanAccess) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.users[access.UserID]; !exists {
+		return ErrUserNotFound
+	}
+	if _, exists := s.mealPlans[access.MealPlanID]; !exists {
+		return ErrMealPlanNotFound
+	}
 
 	if access.ID == "" {
 		access.ID = s.generateID()
 	}
-	s.mealPlanAccess[access.ID] = access
-	return nil
+	if access.CreatedAt.IsZero() {
+		access.CreatedAt = time.Now()
+	}
+	copied := *access
+	return s.putMealPlanAccess(&copied)
 }
 
 // CheckMealPlanAccess checks if a user has access to a meal plan
 // If checkOwner is true, it only returns true if the user is the owner
-func (s *MemoryStore) CheckMealPlanAccess(userID, mealPlanID string) (bool, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
-	// First check if the user created the meal plan (which makes them an owner)
-	plan, exists := s.mealPlans[mealPlanID]
-	if exists && plan.CreatedBy == userID {
-		return true, nil
+func (s *MemoryStore) CheckMealPlanAccess(ctx context.Context, userID, mealPlanID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
 	}
 
-	// Then check for explicit access grants
-	for _, access := range s.mealPlanAccess {
-		if access.UserID == userID && access.MealPlanID == mealPlanID {
-			return true, nil
-		}
+	s.rlock()
+	defer s.runlock()
+
+	if s.userAccess(userID, mealPlanID) != nil {
+		return true, nil
 	}
 
 	return false, ErrAccessDenied
 }
 
-// CheckMealPlanOwnership checks if a user is the owner of a meal plan
-func (s *MemoryStore) CheckMealPlanOwnership(userID, mealPlanID string) (bool, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+// GetRole returns the user's highest role on a meal plan
+func (s *MemoryStore) GetRole(ctx context.Context, userID, mealPlanID string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 
-	// Check if the user created the meal plan
-	plan, exists := s.mealPlans[mealPlanID]
-	if exists && plan.CreatedBy == userID {
-		return true, nil
+	s.rlock()
+	defer s.runlock()
+
+	if _, exists := s.mealPlans[mealPlanID]; !exists {
+		return "", ErrMealPlanNotFound
 	}
 
-	// Check for explicit owner access
-	for _, access := range s.mealPlanAccess {
-		if access.UserID == userID && access.MealPlanID == mealPlanID && access.Role == "owner" {
-			return true, nil
+	role := ""
+	for id := range s.accessByUser[userID] {
+		access := s.mealPlanAccess[id]
+		if access.MealPlanID == mealPlanID && RoleRank(access.Role) > RoleRank(role) {
+			role = access.Role
 		}
 	}
+	if role == "" {
+		return "", ErrAccessDenied
+	}
+	return role, nil
+}
+
+// CheckMealPlanOwnership checks if a user is the owner of a meal plan
+func (s *MemoryStore) CheckMealPlanOwnership(ctx context.Context, userID, mealPlanID string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	if s.hasRole(userID, mealPlanID, "owner") {
+		return true, nil
+	}
 
 	return false, ErrAccessDenied
 }
 
-// GetUserByGoogleID retrieves a user by their Google ID
-func (s *MemoryStore) GetUserByGoogleID(googleID string) (*models.User, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+// ListMealPlanMembers returns everyone with access to a meal plan
+func (s *MemoryStore) ListMealPlanMembers(ctx context.Context, mealPlanID string) ([]*models.MealPlanMember, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	if _, exists := s.mealPlans[mealPlanID]; !exists {
+		return nil, ErrMealPlanNotFound
+	}
 
-	for _, user := range s.users {
-		if user.ID == googleID {
-			return user, nil
+	var members []*models.MealPlanMember
+	for _, accessID := range s.accessByPlan.ids(mealPlanID) {
+		access := s.mealPlanAccess[accessID]
+		if user, exists := s.users[access.UserID]; exists {
+			members = append(members, toMember(user, access))
 		}
 	}
-	return nil, ErrUserNotFound
+	return collectMembers(members), nil
+}
+
+// SetMemberRole changes a user's role on a meal plan
+func (s *MemoryStore) SetMemberRole(ctx context.Context, mealPlanID, userID, role string) error {
+	// A user may have several access rows; change them all or none
+	return s.WithTx(ctx, func(tx Store) error {
+		m := tx.(*MemoryStore)
+		found := false
+		for _, id := range m.accessByUser.ids(userID) {
+			access := m.mealPlanAccess[id]
+			if access.MealPlanID != mealPlanID {
+				continue
+			}
+			found = true
+			updated := *access
+			updated.Role = role
+			if err := m.putMealPlanAccess(&updated); err != nil {
+				return err
+			}
+		}
+		if !found {
+			return ErrMemberNotFound
+		}
+		return nil
+	})
+}
+
+// RemoveMember deletes a user's access to a meal plan
+func (s *MemoryStore) RemoveMember(ctx context.Context, mealPlanID, userID string) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		m := tx.(*MemoryStore)
+		found := false
+		for _, id := range m.accessByUser.ids(userID) {
+			if m.mealPlanAccess[id].MealPlanID != mealPlanID {
+				continue
+			}
+			found = true
+			if err := m.removeMealPlanAccess(id); err != nil {
+				return err
+			}
+		}
+		if !found {
+			return ErrMemberNotFound
+		}
+		return nil
+	})
 }
 
 // GetUserByID retrieves a user by their ID
-func (s *MemoryStore) GetUserByID(id string) (*models.User, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+func (s *MemoryStore) GetUserByID(ctx context.Context, id string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
 
 	user, exists := s.users[id]
 	if !exists {
 		return nil, ErrUserNotFound
 	}
-	return user, nil
+	copied := *user
+	return &copied, nil
 }
 
 // CreateOrUpdateUser creates a new user or updates an existing one
-func (s *MemoryStore) CreateOrUpdateUser(user *models.User) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *MemoryStore) CreateOrUpdateUser(ctx context.Context, user *models.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
 
 	// Check if user exists by ID
 	if existingUser, exists := s.users[user.ID]; exists {
 		// Update existing user
-		existingUser.Email = user.Email
-		existingUser.Name = user.Name
-		s.users[user.ID] = existingUser
-		return nil
+		updated := *existingUser
+		updated.Email = user.Email
+		updated.Name = user.Name
+		updated.EmailVerified = user.EmailVerified
+		return s.putUser(&updated)
 	}
 
 	// Create new user
-	s.users[user.ID] = user
-	return nil
+	created := *user
+	return s.putUser(&created)
+}
+
+// AcceptInvitations grants a user the access they were invited to by email
+func (s *MemoryStore) AcceptInvitations(ctx context.Context, userID string) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		m := tx.(*MemoryStore)
+		user, exists := m.users[userID]
+		if !exists {
+			return ErrUserNotFound
+		}
+
+		for _, id := range m.invitationsByEmail.ids(strings.ToLower(user.Email)) {
+			invitation := m.invitations[id]
+			if m.userAccess(user.ID, invitation.MealPlanID) == nil {
+				err := m.putMealPlanAccess(&models.MealPlanAccess{
+					ID:         "invitation-" + invitation.ID,
+					UserID:     user.ID,
+					MealPlanID: invitation.MealPlanID,
+					Role:       invitation.Role,
+					CreatedAt:  time.Now(),
+				})
+				if err != nil {
+					return err
+				}
+			}
+			if err := m.removeInvitation(id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 // GetUserByEmail returns a user by email
-func (s *MemoryStore) GetUserByEmail(email string) (*models.User, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *MemoryStore) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	id, exists := s.usersByEmail[email]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	copied := *s.users[id]
+	return &copied, nil
+}
+
+// DeleteUser removes a user along with everything that belongs to them
+func (s *MemoryStore) DeleteUser(ctx context.Context, id string) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		m := tx.(*MemoryStore)
+		if _, exists := m.users[id]; !exists {
+			return ErrUserNotFound
+		}
+
+		for planID, plan := range m.mealPlans {
+			owner := m.otherOwner(planID, id)
+			switch {
+			case owner == nil && (plan.CreatedBy == id || m.hasRole(id, planID, "owner")):
+				if err := m.removeMealPlan(planID); err != nil {
+					return err
+				}
+			case plan.CreatedBy == id:
+				updated := *plan
+				updated.CreatedBy = owner.UserID
+				if err := m.putMealPlan(&updated); err != nil {
+					return err
+				}
+			}
+		}
+		for codeID, code := range m.shareCodes {
+			if code.CreatedBy == id {
+				if err := m.removeShareCode(codeID); err != nil {
+					return err
+				}
+			}
+		}
+		return m.removeUser(id)
+	})
+}
+
+// CreateOwnershipTransfer offers a meal plan to another user
+func (s *MemoryStore) CreateOwnershipTransfer(ctx context.Context, transfer *models.OwnershipTransfer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
 
-	// Find user by email
-	for _, user := range s.users {
-		if user.Email == email {
-			return user, nil
+	if _, exists := s.mealPlans[transfer.MealPlanID]; !exists {
+		return ErrMealPlanNotFound
+	}
+	for _, userID := range []string{transfer.FromUserID, transfer.ToUserID} {
+		if _, exists := s.users[userID]; !exists {
+			return ErrUserNotFound
 		}
 	}
 
-	return nil, ErrUserNotFound
+	if transfer.CreatedAt.IsZero() {
+		transfer.CreatedAt = time.Now()
+	}
+	copied := *transfer
+	return s.putOwnershipTransfer(&copied)
+}
+
+// GetOwnershipTransfer retrieves the pending transfer of a meal plan
+func (s *MemoryStore) GetOwnershipTransfer(ctx context.Context, mealPlanID string) (*models.OwnershipTransfer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	transfer, exists := s.transfers[mealPlanID]
+	if !exists {
+		return nil, ErrTransferNotFound
+	}
+	copied := *transfer
+	return &copied, nil
+}
+
+// ListOwnershipTransfersByRecipient returns the transfers offered to a user,
+// oldest first
+func (s *MemoryStore) ListOwnershipTransfersByRecipient(ctx context.Context, userID string) ([]*models.OwnershipTransfer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	transfers := make([]*models.OwnershipTransfer, 0, len(s.transfersByUser[userID]))
+	for _, planID := range s.transfersByUser.ids(userID) {
+		copied := *s.transfers[planID]
+		transfers = append(transfers, &copied)
+	}
+	sort.Slice(transfers, func(i, j int) bool {
+		if !transfers[i].CreatedAt.Equal(transfers[j].CreatedAt) {
+			return transfers[i].CreatedAt.Before(transfers[j].CreatedAt)
+		}
+		return transfers[i].MealPlanID < transfers[j].MealPlanID
+	})
+	return transfers, nil
+}
+
+// DeleteOwnershipTransfer withdraws the pending transfer of a meal plan
+func (s *MemoryStore) DeleteOwnershipTransfer(ctx context.Context, mealPlanID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.transfers[mealPlanID]; !exists {
+		return ErrTransferNotFound
+	}
+	return s.removeOwnershipTransfer(mealPlanID)
 }
 
 // CreateShareLink creates a new share link
-func (s *MemoryStore) CreateShareCode(code *models.ShareCode) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *MemoryStore) CreateShareCode(ctx context.Context, code *models.ShareCode) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.mealPlans[code.MealPlanID]; !exists {
+		return ErrMealPlanNotFound
+	}
+	if _, exists := s.users[code.CreatedBy]; !exists {
+		return ErrUserNotFound
+	}
 
 	if code.ID == "" {
 		code.ID = s.generateID()
 	}
-	s.shareCodes[code.ID] = code
-	return nil
+	code.CreatedAt = time.Now()
+	created := *code
+	return s.putShareCode(&created)
 }
 
 // GetShareLink retrieves a share link by ID
-func (s *MemoryStore) GetShareCode(id string) (*models.ShareCode, error) {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+func (s *MemoryStore) GetShareCode(ctx context.Context, id string) (*models.ShareCode, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
 
 	code, exists := s.shareCodes[id]
 	if !exists {
-		return nil, errors.New("share code not found")
+		return nil, ErrShareCodeNotFound
+	}
+	copied := *code
+	return &copied, nil
+}
+
+// CreateInvitation invites an email address to a meal plan
+func (s *MemoryStore) CreateInvitation(ctx context.Context, invitation *models.Invitation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.mealPlans[invitation.MealPlanID]; !exists {
+		return ErrMealPlanNotFound
+	}
+	if _, exists := s.users[invitation.InvitedBy]; !exists {
+		return ErrUserNotFound
+	}
+	for _, id := range s.invitationsByEmail.ids(invitation.Email) {
+		if s.invitations[id].MealPlanID == invitation.MealPlanID {
+			return ErrInvitationExists
+		}
+	}
+
+	if invitation.ID == "" {
+		invitation.ID = s.generateID()
+	}
+	if invitation.CreatedAt.IsZero() {
+		invitation.CreatedAt = time.Now()
+	}
+	copied := *invitation
+	return s.putInvitation(&copied)
+}
+
+// GetInvitation retrieves an invitation by ID
+func (s *MemoryStore) GetInvitation(ctx context.Context, id string) (*models.Invitation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	invitation, exists := s.invitations[id]
+	if !exists {
+		return nil, ErrInvitationNotFound
+	}
+	copied := *invitation
+	return &copied, nil
+}
+
+// ListInvitationsByPlan returns a meal plan's pending invitations, oldest first
+func (s *MemoryStore) ListInvitationsByPlan(ctx context.Context, mealPlanID string) ([]*models.Invitation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	invitations := make([]*models.Invitation, 0, len(s.invitationsByPlan[mealPlanID]))
+	for _, id := range s.invitationsByPlan.ids(mealPlanID) {
+		copied := *s.invitations[id]
+		invitations = append(invitations, &copied)
+	}
+	sort.Slice(invitations, func(i, j int) bool {
+		if !invitations[i].CreatedAt.Equal(invitations[j].CreatedAt) {
+			return invitations[i].CreatedAt.Before(invitations[j].CreatedAt)
+		}
+		return invitations[i].ID < invitations[j].ID
+	})
+	return invitations, nil
+}
+
+// DeleteInvitation cancels an invitation
+func (s *MemoryStore) DeleteInvitation(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.invitations[id]; !exists {
+		return ErrInvitationNotFound
+	}
+	return s.removeInvitation(id)
+}
+
+// ListShareCodesByPlan returns a meal plan's share codes, oldest first
+func (s *MemoryStore) ListShareCodesByPlan(ctx context.Context, mealPlanID string) ([]*models.ShareCode, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	codes := make([]*models.ShareCode, 0, len(s.shareCodesByPlan[mealPlanID]))
+	for _, id := range s.shareCodesByPlan.ids(mealPlanID) {
+		copied := *s.shareCodes[id]
+		codes = append(codes, &copied)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if !codes[i].CreatedAt.Equal(codes[j].CreatedAt) {
+			return codes[i].CreatedAt.Before(codes[j].CreatedAt)
+		}
+		return codes[i].ID < codes[j].ID
+	})
+	return codes, nil
+}
+
+// RedeemShareCode counts a use of a share code and records who used it
+func (s *MemoryStore) RedeemShareCode(ctx context.Context, id, userID string, at time.Time) error {
+	return s.WithTx(ctx, func(tx Store) error {
+		m := tx.(*MemoryStore)
+		code, exists := m.shareCodes[id]
+		if !exists {
+			return ErrShareCodeNotFound
+		}
+		if _, exists := m.users[userID]; !exists {
+			return ErrUserNotFound
+		}
+		if code.UsedUp() {
+			return ErrShareCodeUsedUp
+		}
+
+		used := *code
+		used.Uses++
+		if err := m.putShareCode(&used); err != nil {
+			return err
+		}
+		return m.putRedemption(&models.ShareCodeRedemption{ShareCodeID: id, UserID: userID, RedeemedAt: at})
+	})
+}
+
+// ListShareCodeRedemptions returns who used each of a plan's share codes
+func (s *MemoryStore) ListShareCodeRedemptions(ctx context.Context, mealPlanID string) ([]*models.ShareCodeRedemption, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
-	return code, nil
+
+	s.rlock()
+	defer s.runlock()
+
+	var redemptions []*models.ShareCodeRedemption
+	for _, codeID := range s.shareCodesByPlan.ids(mealPlanID) {
+		for _, key := range s.redemptionsByCode.ids(codeID) {
+			copied := *s.redemptions[key]
+			redemptions = append(redemptions, &copied)
+		}
+	}
+	sort.Slice(redemptions, func(i, j int) bool {
+		if !redemptions[i].RedeemedAt.Equal(redemptions[j].RedeemedAt) {
+			return redemptions[i].RedeemedAt.Before(redemptions[j].RedeemedAt)
+		}
+		return redemptions[i].UserID < redemptions[j].UserID
+	})
+	return redemptions, nil
 }
 
 // DeleteShareLink removes a share link from the store
-func (s *MemoryStore) DeleteShareLink(id string) error {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+func (s *MemoryStore) DeleteShareLink(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
 
 	if _, exists := s.shareCodes[id]; !exists {
-		return errors.New("share code not found")
+		return ErrShareCodeNotFound
 	}
 
-	delete(s.shareCodes, id)
-	return nil
+	return s.removeShareCode(id)
+}
+
+// DeleteExpiredShareCodes removes every expired share code
+func (s *MemoryStore) DeleteExpiredShareCodes(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.WithTx(ctx, func(tx Store) error {
+		m := tx.(*MemoryStore)
+		now := time.Now()
+		for id, code := range m.shareCodes {
+			if code.ExpiresAt.Before(now) {
+				if err := m.removeShareCode(id); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ValidateToken validates an access token and returns its claims
+func (s *MemoryStore) ValidateToken(ctx context.Context, tokenString string) (*TokenClaims, error) {
+	return s.validateToken(ctx, tokenString, s.GetSession)
+}
+
+// CreateSession stores a new session
+func (s *MemoryStore) CreateSession(ctx context.Context, session *models.Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.users[session.UserID]; !exists {
+		return ErrUserNotFound
+	}
+
+	if session.ID == "" {
+		session.ID = s.generateID()
+	}
+	now := time.Now()
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	created := *session
+	return s.putSession(&created)
+}
+
+// GetSession retrieves a session by ID
+func (s *MemoryStore) GetSession(ctx context.Context, id string) (*models.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	session, exists := s.sessions[id]
+	if !exists {
+		return nil, ErrSessionNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+// ListSessionsByUser returns a user's sessions, oldest first
+func (s *MemoryStore) ListSessionsByUser(ctx context.Context, userID string) ([]*models.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	sessions := make([]*models.Session, 0, len(s.sessionsByUser[userID]))
+	for _, id := range s.sessionsByUser.ids(userID) {
+		copied := *s.sessions[id]
+		sessions = append(sessions, &copied)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+	})
+	return sessions, nil
+}
+
+// RotateSession replaces a session's refresh token hash if oldHash is current
+func (s *MemoryStore) RotateSession(ctx context.Context, id, oldHash, newHash string, expiresAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	session, exists := s.sessions[id]
+	if !exists || !session.Active(time.Now()) {
+		return ErrSessionNotFound
+	}
+	if session.RefreshTokenHash != oldHash {
+		return ErrRefreshTokenReused
+	}
+
+	updated := *session
+	updated.RefreshTokenHash = newHash
+	updated.ExpiresAt = expiresAt
+	updated.LastUsedAt = time.Now()
+	return s.putSession(&updated)
+}
+
+// RevokeSession marks a session as revoked; revoking it again has no effect
+func (s *MemoryStore) RevokeSession(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	session, exists := s.sessions[id]
+	if !exists {
+		return ErrSessionNotFound
+	}
+	if session.RevokedAt != nil {
+		return nil
+	}
+
+	updated := *session
+	now := time.Now()
+	updated.RevokedAt = &now
+	return s.putSession(&updated)
+}
+
+// DeleteExpiredSessions removes every expired session
+func (s *MemoryStore) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.WithTx(ctx, func(tx Store) error {
+		m := tx.(*MemoryStore)
+		now := time.Now()
+		for id, session := range m.sessions {
+			if session.ExpiresAt.Before(now) {
+				if err := m.removeSession(id); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// SetPassword stores a user's password hash and clears any lockout
+func (s *MemoryStore) SetPassword(ctx context.Context, userID, hash string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.users[userID]; !exists {
+		return ErrUserNotFound
+	}
+	return s.putPassword(&models.Password{UserID: userID, Hash: hash, UpdatedAt: time.Now()})
+}
+
+// GetPassword retrieves a user's password
+func (s *MemoryStore) GetPassword(ctx context.Context, userID string) (*models.Password, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	password, exists := s.passwords[userID]
+	if !exists {
+		return nil, ErrPasswordNotFound
+	}
+	copied := *password
+	return &copied, nil
+}
+
+// RecordFailedLogin counts a failed login, locking the password once
+// maxAttempts is reached
+func (s *MemoryStore) RecordFailedLogin(ctx context.Context, userID string, maxAttempts int, lockedUntil time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	password, exists := s.passwords[userID]
+	if !exists {
+		return ErrPasswordNotFound
+	}
+
+	updated := *password
+	updated.FailedAttempts++
+	if updated.FailedAttempts >= maxAttempts {
+		updated.FailedAttempts = 0
+		updated.LockedUntil = &lockedUntil
+	}
+	return s.putPassword(&updated)
+}
+
+// ResetFailedLogins clears a password's failed login count and lockout
+func (s *MemoryStore) ResetFailedLogins(ctx context.Context, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	password, exists := s.passwords[userID]
+	if !exists {
+		return ErrPasswordNotFound
+	}
+	if password.FailedAttempts == 0 && password.LockedUntil == nil {
+		return nil
+	}
+
+	updated := *password
+	updated.FailedAttempts = 0
+	updated.LockedUntil = nil
+	return s.putPassword(&updated)
+}
+
+// CreateMagicLink stores a new sign-in link
+func (s *MemoryStore) CreateMagicLink(ctx context.Context, link *models.MagicLink) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	link.CreatedAt = time.Now()
+	created := *link
+	return s.putMagicLink(&created)
+}
+
+// ConsumeMagicLink marks an unused, unexpired sign-in link as used
+func (s *MemoryStore) ConsumeMagicLink(ctx context.Context, tokenHash string) (*models.MagicLink, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	now := time.Now()
+	link, exists := s.magicLinks[tokenHash]
+	if !exists || link.UsedAt != nil || !now.Before(link.ExpiresAt) {
+		return nil, ErrMagicLinkNotFound
+	}
+
+	updated := *link
+	updated.UsedAt = &now
+	if err := s.putMagicLink(&updated); err != nil {
+		return nil, err
+	}
+	copied := updated
+	return &copied, nil
+}
+
+// DeleteExpiredMagicLinks removes every expired sign-in link
+func (s *MemoryStore) DeleteExpiredMagicLinks(ctx context.Context) (int64, error) {
+	var n int64
+	err := s.WithTx(ctx, func(tx Store) error {
+		m := tx.(*MemoryStore)
+		now := time.Now()
+		for tokenHash, link := range m.magicLinks {
+			if link.ExpiresAt.Before(now) {
+				if err := m.removeMagicLink(tokenHash); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// CreateAPIToken stores a new personal access token
+func (s *MemoryStore) CreateAPIToken(ctx context.Context, token *models.APIToken) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.users[token.UserID]; !exists {
+		return ErrUserNotFound
+	}
+
+	if token.ID == "" {
+		token.ID = s.generateID()
+	}
+	token.CreatedAt = time.Now()
+	created := *token
+	created.Scopes = append([]string(nil), token.Scopes...)
+	return s.putAPIToken(&created)
+}
+
+// GetAPIToken retrieves a personal access token by ID
+func (s *MemoryStore) GetAPIToken(ctx context.Context, id string) (*models.APIToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	token, exists := s.apiTokens[id]
+	if !exists {
+		return nil, ErrAPITokenNotFound
+	}
+	return copyAPIToken(token), nil
+}
+
+// GetAPITokenByHash retrieves a personal access token by the hash of its value
+func (s *MemoryStore) GetAPITokenByHash(ctx context.Context, tokenHash string) (*models.APIToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	id, exists := s.apiTokensByHash[tokenHash]
+	if !exists {
+		return nil, ErrAPITokenNotFound
+	}
+	return copyAPIToken(s.apiTokens[id]), nil
+}
+
+// ListAPITokensByUser returns a user's personal access tokens, oldest first
+func (s *MemoryStore) ListAPITokensByUser(ctx context.Context, userID string) ([]*models.APIToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.runlock()
+
+	tokens := make([]*models.APIToken, 0, len(s.apiTokensByUser[userID]))
+	for _, id := range s.apiTokensByUser.ids(userID) {
+		tokens = append(tokens, copyAPIToken(s.apiTokens[id]))
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens, nil
+}
+
+// TouchAPIToken records when a personal access token was last used
+func (s *MemoryStore) TouchAPIToken(ctx context.Context, id string, usedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	token, exists := s.apiTokens[id]
+	if !exists {
+		return ErrAPITokenNotFound
+	}
+
+	updated := *token
+	updated.LastUsedAt = &usedAt
+	return s.putAPIToken(&updated)
+}
+
+// DeleteAPIToken revokes a personal access token by removing it
+func (s *MemoryStore) DeleteAPIToken(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock()
+	defer s.unlock()
+
+	if _, exists := s.apiTokens[id]; !exists {
+		return ErrAPITokenNotFound
+	}
+	return s.removeAPIToken(id)
+}
+
+// copyAPIToken copies a token, including its scopes
+func copyAPIToken(token *models.APIToken) *models.APIToken {
+	copied := *token
+	copied.Scopes = append([]string(nil), token.Scopes...)
+	return &copied
 }
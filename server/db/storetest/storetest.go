@@ -0,0 +1,1732 @@
+// Package storetest provides a conformance suite for db.Store implementations.
+// Every backend runs the same suite so handlers behave identically whichever
+// store they are given.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"my-meal-planner/db"
+	"my-meal-planner/models"
+)
+
+// Factory returns a new, empty store for a single test
+type Factory func(t *testing.T) db.Store
+
+// Run exercises every db.Store method against stores created by newStore
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s db.Store)
+	}{
+		{"Users", testUsers},
+		{"Tokens", testTokens},
+		{"Sessions", testSessions},
+		{"Passwords", testPasswords},
+		{"MagicLinks", testMagicLinks},
+		{"APITokens", testAPITokens},
+		{"MealPlans", testMealPlans},
+		{"ListMealPlansByUser", testListMealPlansByUser},
+		{"Access", testAccess},
+		{"Ownership", testOwnership},
+		{"Roles", testRoles},
+		{"Members", testMembers},
+		{"OwnershipTransfers", testOwnershipTransfers},
+		{"DeleteUser", testDeleteUser},
+		{"ShareCodes", testShareCodes},
+		{"ShareCodeRedemptions", testShareCodeRedemptions},
+		{"Invitations", testInvitations},
+		{"Meals", testMeals},
+		{"VersionConflicts", testVersionConflicts},
+		{"ListMealsByPlan", testListMealsByPlan},
+		{"ListMealsOrderAndFilters", testListMealsOrderAndFilters},
+		{"ListPagination", testListPagination},
+		{"ListMealsPagination", testListMealsPagination},
+		{"ListInvalidOptions", testListInvalidOptions},
+		{"DeleteMealPlanCascades", testDeleteMealPlanCascades},
+		{"MissingReferences", testMissingReferences},
+		{"WithTxCommit", testWithTxCommit},
+		{"WithTxRollback", testWithTxRollback},
+		{"CanceledContext", testCanceledContext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+// seedUser creates a user, which SQL stores require before it owns anything
+func seedUser(t *testing.T, s db.Store, id string) *models.User {
+	t.Helper()
+
+	user := &models.User{ID: id, Email: id + "@example.com", Name: "User " + id}
+	if err := s.CreateOrUpdateUser(context.Background(), user); err != nil {
+		t.Fatalf("CreateOrUpdateUser(%s): %v", id, err)
+	}
+	return user
+}
+
+// seedPlan creates a plan owned by userID, with the owner access row the handlers create
+func seedPlan(t *testing.T, s db.Store, id, userID string) *models.MealPlan {
+	t.Helper()
+	ctx := context.Background()
+
+	plan := &models.MealPlan{
+		ID:          id,
+		Name:        "Plan " + id,
+		Description: "Description " + id,
+		CreatedBy:   userID,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := s.CreateMealPlan(ctx, plan); err != nil {
+		t.Fatalf("CreateMealPlan(%s): %v", id, err)
+	}
+	grant(t, s, userID, id, "owner")
+	return plan
+}
+
+// grant gives a user a role on a plan
+func grant(t *testing.T, s db.Store, userID, planID, role string) {
+	t.Helper()
+
+	access := &models.MealPlanAccess{UserID: userID, MealPlanID: planID, Role: role}
+	if err := s.CreateMealPlanAccess(context.Background(), access); err != nil {
+		t.Fatalf("CreateMealPlanAccess(%s, %s): %v", userID, planID, err)
+	}
+	if access.ID == "" {
+		t.Fatalf("CreateMealPlanAccess did not assign an ID")
+	}
+}
+
+// seedMeal creates a meal in a plan
+func seedMeal(t *testing.T, s db.Store, id, planID, day, mealType string) *models.Meal {
+	t.Helper()
+
+	meal := &models.Meal{
+		ID:          id,
+		MealPlanID:  planID,
+		Name:        "Meal " + id,
+		Description: "Description " + id,
+		Day:         day,
+		MealType:    mealType,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if err := s.CreateMeal(context.Background(), meal); err != nil {
+		t.Fatalf("CreateMeal(%s): %v", id, err)
+	}
+	return meal
+}
+
+func testUsers(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+
+	user, err := s.GetUserByID(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if user.Email != "alice@example.com" || user.Name != "User alice" {
+		t.Errorf("GetUserByID = %+v", user)
+	}
+
+	user, err = s.GetUserByEmail(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("GetUserByEmail: %v", err)
+	}
+	if user.ID != "alice" {
+		t.Errorf("GetUserByEmail returned user %q", user.ID)
+	}
+
+	if user.EmailVerified {
+		t.Errorf("GetUserByEmail = %+v, want an unverified email", user)
+	}
+
+	// Users handed out are copies; changing one doesn't change the store
+	user.Name = "Changed"
+	if got, _ := s.GetUserByID(ctx, "alice"); got.Name != "User alice" {
+		t.Errorf("GetUserByID after changing a returned user = %+v, want the stored name", got)
+	}
+
+	// Signing in again updates the profile in place
+	updated := &models.User{ID: "alice", Email: "alice@example.org", Name: "Alice", EmailVerified: true}
+	if err := s.CreateOrUpdateUser(ctx, updated); err != nil {
+		t.Fatalf("CreateOrUpdateUser update: %v", err)
+	}
+	user, err = s.GetUserByID(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetUserByID after update: %v", err)
+	}
+	if user.Email != "alice@example.org" || user.Name != "Alice" || !user.EmailVerified {
+		t.Errorf("user after update = %+v", user)
+	}
+	if _, err := s.GetUserByEmail(ctx, "alice@example.com"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("GetUserByEmail(old email) error = %v, want ErrUserNotFound", err)
+	}
+
+	if _, err := s.GetUserByID(ctx, "nobody"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("GetUserByID(missing) error = %v, want ErrUserNotFound", err)
+	}
+	if _, err := s.GetUserByEmail(ctx, "nobody@example.com"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("GetUserByEmail(missing) error = %v, want ErrUserNotFound", err)
+	}
+}
+
+// seedSession creates an active session for a user
+func seedSession(t *testing.T, s db.Store, id, userID string) *models.Session {
+	t.Helper()
+
+	session := &models.Session{
+		ID:               id,
+		UserID:           userID,
+		RefreshTokenHash: db.HashRefreshToken(id + ".secret"),
+		UserAgent:        "storetest",
+		ExpiresAt:        time.Now().Add(time.Hour),
+	}
+	if err := s.CreateSession(context.Background(), session); err != nil {
+		t.Fatalf("CreateSession(%s): %v", id, err)
+	}
+	return session
+}
+
+func testTokens(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	alice := seedUser(t, s, "alice")
+	seedSession(t, s, "laptop", "alice")
+
+	token, err := s.GenerateToken(ctx, alice, "laptop")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+
+	claims, err := s.ValidateToken(ctx, token)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.UserID != "alice" || claims.Email != "alice@example.com" || claims.SessionID != "laptop" {
+		t.Errorf("claims = %q <%s> session %q, want alice <alice@example.com> session laptop",
+			claims.UserID, claims.Email, claims.SessionID)
+	}
+
+	// Every token gets its own ID
+	other, err := s.GenerateToken(ctx, alice, "laptop")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	otherClaims, err := s.ValidateToken(ctx, other)
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.ID == "" || claims.ID == otherClaims.ID {
+		t.Errorf("token IDs %q and %q, want distinct non-empty IDs", claims.ID, otherClaims.ID)
+	}
+
+	if _, err := s.ValidateToken(ctx, token+"x"); !errors.Is(err, db.ErrInvalidToken) {
+		t.Errorf("ValidateToken(tampered) error = %v, want ErrInvalidToken", err)
+	}
+	if _, err := s.ValidateToken(ctx, "not-a-token"); !errors.Is(err, db.ErrInvalidToken) {
+		t.Errorf("ValidateToken(garbage) error = %v, want ErrInvalidToken", err)
+	}
+
+	// Tokens are only good while their session is
+	orphan, err := s.GenerateToken(ctx, alice, "no-such-session")
+	if err != nil {
+		t.Fatalf("GenerateToken: %v", err)
+	}
+	if _, err := s.ValidateToken(ctx, orphan); !errors.Is(err, db.ErrInvalidToken) {
+		t.Errorf("ValidateToken(unknown session) error = %v, want ErrInvalidToken", err)
+	}
+
+	if err := s.RevokeSession(ctx, "laptop"); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if _, err := s.ValidateToken(ctx, token); !errors.Is(err, db.ErrInvalidToken) {
+		t.Errorf("ValidateToken(revoked session) error = %v, want ErrInvalidToken", err)
+	}
+}
+
+func testSessions(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedUser(t, s, "bob")
+	laptop := seedSession(t, s, "laptop", "alice")
+	seedSession(t, s, "phone", "alice")
+	seedSession(t, s, "desktop", "bob")
+
+	got, err := s.GetSession(ctx, "laptop")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.UserID != "alice" || got.RefreshTokenHash != laptop.RefreshTokenHash || got.UserAgent != "storetest" {
+		t.Errorf("GetSession = %+v, want alice's laptop session", got)
+	}
+	if !got.Active(time.Now()) {
+		t.Errorf("new session is not active: %+v", got)
+	}
+
+	sessions, err := s.ListSessionsByUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ListSessionsByUser: %v", err)
+	}
+	var ids []string
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+	}
+	sort.Strings(ids)
+	if !equal(ids, []string{"laptop", "phone"}) {
+		t.Errorf("ListSessionsByUser(alice) = %v, want [laptop phone]", ids)
+	}
+
+	// Rotation succeeds once with the current hash
+	oldHash := laptop.RefreshTokenHash
+	newHash := db.HashRefreshToken("laptop.rotated")
+	if err := s.RotateSession(ctx, "laptop", oldHash, newHash, time.Now().Add(2*time.Hour)); err != nil {
+		t.Fatalf("RotateSession: %v", err)
+	}
+	got, err = s.GetSession(ctx, "laptop")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.RefreshTokenHash != newHash {
+		t.Errorf("hash after rotation = %q, want %q", got.RefreshTokenHash, newHash)
+	}
+	if !got.ExpiresAt.After(time.Now().Add(time.Hour)) {
+		t.Errorf("expiry after rotation = %v, want it extended", got.ExpiresAt)
+	}
+
+	// Presenting the old hash again is reuse
+	if err := s.RotateSession(ctx, "laptop", oldHash, db.HashRefreshToken("laptop.again"), time.Now().Add(time.Hour)); !errors.Is(err, db.ErrRefreshTokenReused) {
+		t.Errorf("RotateSession(old hash) error = %v, want ErrRefreshTokenReused", err)
+	}
+
+	// Revoked sessions can't be rotated, and revoking twice is harmless
+	if err := s.RevokeSession(ctx, "laptop"); err != nil {
+		t.Fatalf("RevokeSession: %v", err)
+	}
+	if err := s.RevokeSession(ctx, "laptop"); err != nil {
+		t.Errorf("RevokeSession(again) error = %v, want nil", err)
+	}
+	got, err = s.GetSession(ctx, "laptop")
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if got.RevokedAt == nil || got.Active(time.Now()) {
+		t.Errorf("revoked session = %+v, want RevokedAt set and inactive", got)
+	}
+	if err := s.RotateSession(ctx, "laptop", newHash, db.HashRefreshToken("laptop.late"), time.Now().Add(time.Hour)); !errors.Is(err, db.ErrSessionNotFound) {
+		t.Errorf("RotateSession(revoked) error = %v, want ErrSessionNotFound", err)
+	}
+
+	// The other sessions are untouched
+	phone, err := s.GetSession(ctx, "phone")
+	if err != nil {
+		t.Fatalf("GetSession(phone): %v", err)
+	}
+	if !phone.Active(time.Now()) {
+		t.Errorf("phone session inactive after revoking laptop")
+	}
+
+	if _, err := s.GetSession(ctx, "nope"); !errors.Is(err, db.ErrSessionNotFound) {
+		t.Errorf("GetSession(missing) error = %v, want ErrSessionNotFound", err)
+	}
+	if err := s.RevokeSession(ctx, "nope"); !errors.Is(err, db.ErrSessionNotFound) {
+		t.Errorf("RevokeSession(missing) error = %v, want ErrSessionNotFound", err)
+	}
+	if err := s.RotateSession(ctx, "nope", "a", "b", time.Now().Add(time.Hour)); !errors.Is(err, db.ErrSessionNotFound) {
+		t.Errorf("RotateSession(missing) error = %v, want ErrSessionNotFound", err)
+	}
+	err = s.CreateSession(ctx, &models.Session{ID: "ghost", UserID: "nobody", RefreshTokenHash: "x", ExpiresAt: time.Now().Add(time.Hour)})
+	if !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("CreateSession(unknown user) error = %v, want ErrUserNotFound", err)
+	}
+
+	// Expired sessions are swept; revoked ones stay until they expire
+	expired := &models.Session{ID: "old", UserID: "bob", RefreshTokenHash: db.HashRefreshToken("old.secret"), ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := s.CreateSession(ctx, expired); err != nil {
+		t.Fatalf("CreateSession(expired): %v", err)
+	}
+	n, err := s.DeleteExpiredSessions(ctx)
+	if err != nil {
+		t.Fatalf("DeleteExpiredSessions: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteExpiredSessions removed %d sessions, want 1", n)
+	}
+	if _, err := s.GetSession(ctx, "old"); !errors.Is(err, db.ErrSessionNotFound) {
+		t.Errorf("GetSession(expired) error = %v, want ErrSessionNotFound", err)
+	}
+	for _, id := range []string{"laptop", "phone", "desktop"} {
+		if _, err := s.GetSession(ctx, id); err != nil {
+			t.Errorf("GetSession(%s) after sweep: %v", id, err)
+		}
+	}
+}
+
+func testPasswords(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+
+	if _, err := s.GetPassword(ctx, "alice"); !errors.Is(err, db.ErrPasswordNotFound) {
+		t.Errorf("GetPassword(no password) error = %v, want ErrPasswordNotFound", err)
+	}
+	if err := s.SetPassword(ctx, "alice", "hash-1"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	got, err := s.GetPassword(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetPassword: %v", err)
+	}
+	if got.UserID != "alice" || got.Hash != "hash-1" || got.FailedAttempts != 0 || got.Locked(time.Now()) {
+		t.Errorf("GetPassword = %+v, want hash-1 with no failures", got)
+	}
+
+	// Failures count up until the limit, which locks the password and restarts the count
+	lockedUntil := time.Now().Add(time.Hour)
+	for i := 1; i <= 2; i++ {
+		if err := s.RecordFailedLogin(ctx, "alice", 3, lockedUntil); err != nil {
+			t.Fatalf("RecordFailedLogin: %v", err)
+		}
+		if got, _ := s.GetPassword(ctx, "alice"); got.FailedAttempts != i || got.Locked(time.Now()) {
+			t.Errorf("after %d failures: %+v, want %d attempts and unlocked", i, got, i)
+		}
+	}
+	if err := s.RecordFailedLogin(ctx, "alice", 3, lockedUntil); err != nil {
+		t.Fatalf("RecordFailedLogin: %v", err)
+	}
+	got, err = s.GetPassword(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetPassword: %v", err)
+	}
+	if got.FailedAttempts != 0 || !got.Locked(time.Now()) || got.Locked(lockedUntil.Add(time.Second)) {
+		t.Errorf("after 3 failures: %+v, want locked until %v", got, lockedUntil)
+	}
+
+	if err := s.ResetFailedLogins(ctx, "alice"); err != nil {
+		t.Fatalf("ResetFailedLogins: %v", err)
+	}
+	if got, _ := s.GetPassword(ctx, "alice"); got.FailedAttempts != 0 || got.LockedUntil != nil {
+		t.Errorf("after reset: %+v, want no failures or lock", got)
+	}
+
+	// Setting a new password also clears failures
+	if err := s.RecordFailedLogin(ctx, "alice", 3, lockedUntil); err != nil {
+		t.Fatalf("RecordFailedLogin: %v", err)
+	}
+	if err := s.SetPassword(ctx, "alice", "hash-2"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	if got, _ := s.GetPassword(ctx, "alice"); got.Hash != "hash-2" || got.FailedAttempts != 0 {
+		t.Errorf("after SetPassword: %+v, want hash-2 with no failures", got)
+	}
+
+	if err := s.SetPassword(ctx, "nobody", "hash"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("SetPassword(unknown user) error = %v, want ErrUserNotFound", err)
+	}
+	if err := s.RecordFailedLogin(ctx, "nobody", 3, lockedUntil); !errors.Is(err, db.ErrPasswordNotFound) {
+		t.Errorf("RecordFailedLogin(no password) error = %v, want ErrPasswordNotFound", err)
+	}
+	if err := s.ResetFailedLogins(ctx, "nobody"); !errors.Is(err, db.ErrPasswordNotFound) {
+		t.Errorf("ResetFailedLogins(no password) error = %v, want ErrPasswordNotFound", err)
+	}
+}
+
+func testMagicLinks(t *testing.T, s db.Store) {
+	ctx := context.Background()
+
+	_, hash, err := db.NewMagicLinkToken()
+	if err != nil {
+		t.Fatalf("NewMagicLinkToken: %v", err)
+	}
+	link := &models.MagicLink{TokenHash: hash, Email: "alice@example.com", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.CreateMagicLink(ctx, link); err != nil {
+		t.Fatalf("CreateMagicLink: %v", err)
+	}
+
+	got, err := s.ConsumeMagicLink(ctx, hash)
+	if err != nil {
+		t.Fatalf("ConsumeMagicLink: %v", err)
+	}
+	if got.Email != "alice@example.com" || got.UsedAt == nil {
+		t.Errorf("ConsumeMagicLink = %+v, want alice's link marked used", got)
+	}
+
+	// Links work once
+	if _, err := s.ConsumeMagicLink(ctx, hash); !errors.Is(err, db.ErrMagicLinkNotFound) {
+		t.Errorf("ConsumeMagicLink(used) error = %v, want ErrMagicLinkNotFound", err)
+	}
+
+	expired := &models.MagicLink{TokenHash: db.HashMagicLinkToken("expired"), Email: "bob@example.com", ExpiresAt: time.Now().Add(-time.Minute)}
+	if err := s.CreateMagicLink(ctx, expired); err != nil {
+		t.Fatalf("CreateMagicLink(expired): %v", err)
+	}
+	if _, err := s.ConsumeMagicLink(ctx, expired.TokenHash); !errors.Is(err, db.ErrMagicLinkNotFound) {
+		t.Errorf("ConsumeMagicLink(expired) error = %v, want ErrMagicLinkNotFound", err)
+	}
+	if _, err := s.ConsumeMagicLink(ctx, db.HashMagicLinkToken("unknown")); !errors.Is(err, db.ErrMagicLinkNotFound) {
+		t.Errorf("ConsumeMagicLink(unknown) error = %v, want ErrMagicLinkNotFound", err)
+	}
+
+	// Registration links carry the account to create
+	registration := &models.MagicLink{
+		TokenHash:    db.HashMagicLinkToken("registration"),
+		Email:        "carol@example.com",
+		Name:         "Carol",
+		PasswordHash: "argon2id-hash",
+		ExpiresAt:    time.Now().Add(time.Hour),
+	}
+	if err := s.CreateMagicLink(ctx, registration); err != nil {
+		t.Fatalf("CreateMagicLink(registration): %v", err)
+	}
+	got, err = s.ConsumeMagicLink(ctx, registration.TokenHash)
+	if err != nil {
+		t.Fatalf("ConsumeMagicLink(registration): %v", err)
+	}
+	if got.Name != "Carol" || got.PasswordHash != "argon2id-hash" {
+		t.Errorf("ConsumeMagicLink(registration) = %+v, want Carol's name and password hash", got)
+	}
+
+	// Expired links are swept; pending ones stay usable
+	pending := &models.MagicLink{TokenHash: db.HashMagicLinkToken("pending"), Email: "dana@example.com", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.CreateMagicLink(ctx, pending); err != nil {
+		t.Fatalf("CreateMagicLink(pending): %v", err)
+	}
+	n, err := s.DeleteExpiredMagicLinks(ctx)
+	if err != nil {
+		t.Fatalf("DeleteExpiredMagicLinks: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteExpiredMagicLinks removed %d links, want only bob's expired link", n)
+	}
+	if _, err := s.ConsumeMagicLink(ctx, pending.TokenHash); err != nil {
+		t.Errorf("ConsumeMagicLink(pending) after sweep: %v", err)
+	}
+}
+
+func testAPITokens(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedUser(t, s, "bob")
+
+	value, hash, prefix, err := db.NewAPIToken()
+	if err != nil {
+		t.Fatalf("NewAPIToken: %v", err)
+	}
+	if !db.IsAPIToken(value) || !strings.HasPrefix(value, prefix) || db.HashAPIToken(value) != hash {
+		t.Errorf("NewAPIToken = %q, %q, %q; want a prefixed token with its hash", value, hash, prefix)
+	}
+
+	expiresAt := time.Now().Add(time.Hour)
+	token := &models.APIToken{ID: "script", UserID: "alice", Name: "Home Assistant", TokenHash: hash, Prefix: prefix,
+		Scopes: []string{"meals:read", "meals:write"}, ExpiresAt: &expiresAt}
+	if err := s.CreateAPIToken(ctx, token); err != nil {
+		t.Fatalf("CreateAPIToken: %v", err)
+	}
+	if err := s.CreateAPIToken(ctx, &models.APIToken{ID: "other", UserID: "bob", Name: "cron", TokenHash: db.HashAPIToken("mmp_bob"), Prefix: "mmp_bob", Scopes: []string{"plans:read"}}); err != nil {
+		t.Fatalf("CreateAPIToken(bob): %v", err)
+	}
+
+	got, err := s.GetAPITokenByHash(ctx, hash)
+	if err != nil {
+		t.Fatalf("GetAPITokenByHash: %v", err)
+	}
+	if got.ID != "script" || got.UserID != "alice" || got.Name != "Home Assistant" || got.Prefix != prefix ||
+		!equal(got.Scopes, []string{"meals:read", "meals:write"}) || got.LastUsedAt != nil || got.ExpiresAt == nil {
+		t.Errorf("GetAPITokenByHash = %+v, want alice's Home Assistant token", got)
+	}
+	if got.Expired(time.Now()) || !got.Expired(expiresAt.Add(time.Second)) {
+		t.Errorf("token expiry = %v, want %v", got.ExpiresAt, expiresAt)
+	}
+
+	usedAt := time.Now()
+	if err := s.TouchAPIToken(ctx, "script", usedAt); err != nil {
+		t.Fatalf("TouchAPIToken: %v", err)
+	}
+	got, err = s.GetAPIToken(ctx, "script")
+	if err != nil {
+		t.Fatalf("GetAPIToken: %v", err)
+	}
+	if got.LastUsedAt == nil || got.LastUsedAt.Sub(usedAt).Abs() > time.Second {
+		t.Errorf("LastUsedAt = %v, want %v", got.LastUsedAt, usedAt)
+	}
+
+	tokens, err := s.ListAPITokensByUser(ctx, "alice")
+	if err != nil {
+		t.Fatalf("ListAPITokensByUser: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != "script" {
+		t.Errorf("ListAPITokensByUser(alice) = %+v, want only the script token", tokens)
+	}
+
+	if err := s.DeleteAPIToken(ctx, "script"); err != nil {
+		t.Fatalf("DeleteAPIToken: %v", err)
+	}
+	if _, err := s.GetAPITokenByHash(ctx, hash); !errors.Is(err, db.ErrAPITokenNotFound) {
+		t.Errorf("GetAPITokenByHash(deleted) error = %v, want ErrAPITokenNotFound", err)
+	}
+	if err := s.DeleteAPIToken(ctx, "script"); !errors.Is(err, db.ErrAPITokenNotFound) {
+		t.Errorf("DeleteAPIToken(deleted) error = %v, want ErrAPITokenNotFound", err)
+	}
+	if err := s.TouchAPIToken(ctx, "script", usedAt); !errors.Is(err, db.ErrAPITokenNotFound) {
+		t.Errorf("TouchAPIToken(deleted) error = %v, want ErrAPITokenNotFound", err)
+	}
+	if _, err := s.GetAPIToken(ctx, "other"); err != nil {
+		t.Errorf("GetAPIToken(bob's token) after deleting alice's: %v", err)
+	}
+
+	err = s.CreateAPIToken(ctx, &models.APIToken{ID: "ghost", UserID: "nobody", TokenHash: "x", Prefix: "mmp_", Scopes: []string{"plans:read"}})
+	if !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("CreateAPIToken(unknown user) error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func testMealPlans(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedPlan(t, s, "plan-1", "alice")
+
+	plan, err := s.GetMealPlan(ctx, "plan-1")
+	if err != nil {
+		t.Fatalf("GetMealPlan: %v", err)
+	}
+	if plan.Name != "Plan plan-1" || plan.Description != "Description plan-1" || plan.CreatedBy != "alice" {
+		t.Errorf("GetMealPlan = %+v", plan)
+	}
+
+	if plan.Version != 1 {
+		t.Errorf("new plan version = %d, want 1", plan.Version)
+	}
+
+	update := &models.MealPlan{ID: "plan-1", Name: "Renamed", Description: "Changed", Version: 1}
+	if err := s.UpdateMealPlan(ctx, update); err != nil {
+		t.Fatalf("UpdateMealPlan: %v", err)
+	}
+	if update.Version != 2 {
+		t.Errorf("UpdateMealPlan set version %d, want 2", update.Version)
+	}
+	plan, err = s.GetMealPlan(ctx, "plan-1")
+	if err != nil {
+		t.Fatalf("GetMealPlan after update: %v", err)
+	}
+	if plan.Name != "Renamed" || plan.Description != "Changed" || plan.CreatedBy != "alice" || plan.Version != 2 {
+		t.Errorf("plan after update = %+v", plan)
+	}
+
+	if err := s.DeleteMealPlan(ctx, "plan-1", 2); err != nil {
+		t.Fatalf("DeleteMealPlan: %v", err)
+	}
+	if _, err := s.GetMealPlan(ctx, "plan-1"); !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("GetMealPlan(deleted) error = %v, want ErrMealPlanNotFound", err)
+	}
+
+	missing := &models.MealPlan{ID: "missing", Name: "x"}
+	if err := s.UpdateMealPlan(ctx, missing); !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("UpdateMealPlan(missing) error = %v, want ErrMealPlanNotFound", err)
+	}
+	if err := s.DeleteMealPlan(ctx, "missing", 1); !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("DeleteMealPlan(missing) error = %v, want ErrMealPlanNotFound", err)
+	}
+
+	// Plans created without an ID get one assigned
+	generated := &models.MealPlan{Name: "Generated", CreatedBy: "alice"}
+	if err := s.CreateMealPlan(ctx, generated); err != nil {
+		t.Fatalf("CreateMealPlan without ID: %v", err)
+	}
+	if generated.ID == "" {
+		t.Errorf("CreateMealPlan did not assign an ID")
+	}
+}
+
+func testListMealPlansByUser(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedUser(t, s, "bob")
+	seedPlan(t, s, "alice-1", "alice")
+	seedPlan(t, s, "alice-2", "alice")
+	seedPlan(t, s, "bob-1", "bob")
+	grant(t, s, "bob", "alice-1", "viewer")
+
+	tests := []struct {
+		userID string
+		want   []string
+	}{
+		{"alice", []string{"alice-1", "alice-2"}},
+		{"bob", []string{"alice-1", "bob-1"}},
+		{"carol", nil},
+	}
+	for _, tt := range tests {
+		plans, _, err := s.ListMealPlansByUser(ctx, tt.userID, db.ListOptions{})
+		if err != nil {
+			t.Fatalf("ListMealPlansByUser(%s): %v", tt.userID, err)
+		}
+
+		var ids []string
+		for _, plan := range plans {
+			ids = append(ids, plan.ID)
+		}
+		sort.Strings(ids)
+		if !equal(ids, tt.want) {
+			t.Errorf("ListMealPlansByUser(%s) = %v, want %v", tt.userID, ids, tt.want)
+		}
+	}
+}
+
+func testAccess(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedUser(t, s, "bob")
+	seedUser(t, s, "carol")
+	seedPlan(t, s, "plan-1", "alice")
+	grant(t, s, "bob", "plan-1", "viewer")
+
+	for _, userID := range []string{"alice", "bob"} {
+		ok, err := s.CheckMealPlanAccess(ctx, userID, "plan-1")
+		if err != nil || !ok {
+			t.Errorf("CheckMealPlanAccess(%s) = %v, %v, want true", userID, ok, err)
+		}
+	}
+
+	ok, err := s.CheckMealPlanAccess(ctx, "carol", "plan-1")
+	if ok || !errors.Is(err, db.ErrAccessDenied) {
+		t.Errorf("CheckMealPlanAccess(stranger) = %v, %v, want false, ErrAccessDenied", ok, err)
+	}
+
+	ok, err = s.CheckMealPlanAccess(ctx, "alice", "missing")
+	if ok || !errors.Is(err, db.ErrAccessDenied) {
+		t.Errorf("CheckMealPlanAccess(missing plan) = %v, %v, want false, ErrAccessDenied", ok, err)
+	}
+}
+
+func testOwnership(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedUser(t, s, "bob")
+	seedUser(t, s, "carol")
+	seedPlan(t, s, "plan-1", "alice")
+	grant(t, s, "bob", "plan-1", "editor")
+
+	ok, err := s.CheckMealPlanOwnership(ctx, "alice", "plan-1")
+	if err != nil || !ok {
+		t.Errorf("CheckMealPlanOwnership(owner) = %v, %v, want true", ok, err)
+	}
+
+	for _, userID := range []string{"bob", "carol"} {
+		ok, err := s.CheckMealPlanOwnership(ctx, userID, "plan-1")
+		if ok || !errors.Is(err, db.ErrAccessDenied) {
+			t.Errorf("CheckMealPlanOwnership(%s) = %v, %v, want false, ErrAccessDenied", userID, ok, err)
+		}
+	}
+}
+
+func testRoles(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	for _, id := range []string{"alice", "bob", "carol", "dave"} {
+		seedUser(t, s, id)
+	}
+	seedPlan(t, s, "plan-1", "alice")
+	grant(t, s, "bob", "plan-1", "editor")
+	grant(t, s, "carol", "plan-1", "viewer")
+	// A user granted twice gets the higher role
+	grant(t, s, "carol", "plan-1", "editor")
+
+	tests := []struct {
+		userID string
+		want   string
+	}{
+		{"alice", "owner"},
+		{"bob", "editor"},
+		{"carol", "editor"},
+	}
+	for _, tt := range tests {
+		role, err := s.GetRole(ctx, tt.userID, "plan-1")
+		if err != nil {
+			t.Fatalf("GetRole(%s): %v", tt.userID, err)
+		}
+		if role != tt.want {
+			t.Errorf("GetRole(%s) = %q, want %q", tt.userID, role, tt.want)
+		}
+	}
+
+	if _, err := s.GetRole(ctx, "dave", "plan-1"); !errors.Is(err, db.ErrAccessDenied) {
+		t.Errorf("GetRole(no access) error = %v, want ErrAccessDenied", err)
+	}
+	if _, err := s.GetRole(ctx, "alice", "missing"); !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("GetRole(missing plan) error = %v, want ErrMealPlanNotFound", err)
+	}
+}
+
+func testMembers(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	for _, id := range []string{"alice", "bob", "carol", "dave"} {
+		seedUser(t, s, id)
+	}
+	seedPlan(t, s, "plan-1", "alice")
+	grant(t, s, "carol", "plan-1", "viewer")
+	grant(t, s, "bob", "plan-1", "viewer")
+	grant(t, s, "carol", "plan-1", "editor")
+
+	memberRoles := func() []string {
+		t.Helper()
+		members, err := s.ListMealPlanMembers(ctx, "plan-1")
+		if err != nil {
+			t.Fatalf("ListMealPlanMembers: %v", err)
+		}
+		var got []string
+		for _, m := range members {
+			got = append(got, m.UserID+":"+m.Role)
+		}
+		return got
+	}
+
+	// Owners first, then by role and name, once per user
+	members, err := s.ListMealPlanMembers(ctx, "plan-1")
+	if err != nil {
+		t.Fatalf("ListMealPlanMembers: %v", err)
+	}
+	if len(members) == 0 || members[0].Email != "alice@example.com" || members[0].Name != "User alice" {
+		t.Errorf("ListMealPlanMembers()[0] = %+v, want alice's name and email", members)
+	}
+	if got, want := memberRoles(), []string{"alice:owner", "carol:editor", "bob:viewer"}; !equal(got, want) {
+		t.Errorf("members = %v, want %v", got, want)
+	}
+
+	if err := s.SetMemberRole(ctx, "plan-1", "carol", "viewer"); err != nil {
+		t.Fatalf("SetMemberRole: %v", err)
+	}
+	if role, _ := s.GetRole(ctx, "carol", "plan-1"); role != "viewer" {
+		t.Errorf("GetRole after SetMemberRole = %q, want viewer", role)
+	}
+	if err := s.SetMemberRole(ctx, "plan-1", "dave", "editor"); !errors.Is(err, db.ErrMemberNotFound) {
+		t.Errorf("SetMemberRole(non-member) error = %v, want ErrMemberNotFound", err)
+	}
+
+	if err := s.RemoveMember(ctx, "plan-1", "bob"); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+	if _, err := s.GetRole(ctx, "bob", "plan-1"); !errors.Is(err, db.ErrAccessDenied) {
+		t.Errorf("GetRole after RemoveMember error = %v, want ErrAccessDenied", err)
+	}
+	if err := s.RemoveMember(ctx, "plan-1", "bob"); !errors.Is(err, db.ErrMemberNotFound) {
+		t.Errorf("RemoveMember(twice) error = %v, want ErrMemberNotFound", err)
+	}
+	if got, want := memberRoles(), []string{"alice:owner", "carol:viewer"}; !equal(got, want) {
+		t.Errorf("members = %v, want %v", got, want)
+	}
+
+	if _, err := s.ListMealPlanMembers(ctx, "missing"); !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("ListMealPlanMembers(missing plan) error = %v, want ErrMealPlanNotFound", err)
+	}
+}
+
+func testOwnershipTransfers(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	for _, id := range []string{"alice", "bob", "carol"} {
+		seedUser(t, s, id)
+	}
+	seedPlan(t, s, "plan-1", "alice")
+	seedPlan(t, s, "plan-2", "alice")
+
+	if _, err := s.GetOwnershipTransfer(ctx, "plan-1"); !errors.Is(err, db.ErrTransferNotFound) {
+		t.Errorf("GetOwnershipTransfer(none) error = %v, want ErrTransferNotFound", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).Truncate(time.Second)
+	for _, transfer := range []*models.OwnershipTransfer{
+		{MealPlanID: "plan-1", FromUserID: "alice", ToUserID: "bob", ExpiresAt: expiresAt},
+		{MealPlanID: "plan-2", FromUserID: "alice", ToUserID: "bob", ExpiresAt: expiresAt},
+		// A new offer replaces the pending one
+		{MealPlanID: "plan-2", FromUserID: "alice", ToUserID: "carol", ExpiresAt: expiresAt},
+	} {
+		if err := s.CreateOwnershipTransfer(ctx, transfer); err != nil {
+			t.Fatalf("CreateOwnershipTransfer(%s, %s): %v", transfer.MealPlanID, transfer.ToUserID, err)
+		}
+	}
+
+	got, err := s.GetOwnershipTransfer(ctx, "plan-2")
+	if err != nil {
+		t.Fatalf("GetOwnershipTransfer: %v", err)
+	}
+	if got.FromUserID != "alice" || got.ToUserID != "carol" || !got.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("GetOwnershipTransfer = %+v, want the offer to carol", got)
+	}
+
+	for userID, want := range map[string][]string{"bob": {"plan-1"}, "carol": {"plan-2"}, "alice": nil} {
+		transfers, err := s.ListOwnershipTransfersByRecipient(ctx, userID)
+		if err != nil {
+			t.Fatalf("ListOwnershipTransfersByRecipient(%s): %v", userID, err)
+		}
+		var plans []string
+		for _, transfer := range transfers {
+			plans = append(plans, transfer.MealPlanID)
+		}
+		if !equal(plans, want) {
+			t.Errorf("ListOwnershipTransfersByRecipient(%s) = %v, want %v", userID, plans, want)
+		}
+	}
+
+	if err := s.DeleteOwnershipTransfer(ctx, "plan-1"); err != nil {
+		t.Fatalf("DeleteOwnershipTransfer: %v", err)
+	}
+	if err := s.DeleteOwnershipTransfer(ctx, "plan-1"); !errors.Is(err, db.ErrTransferNotFound) {
+		t.Errorf("DeleteOwnershipTransfer(twice) error = %v, want ErrTransferNotFound", err)
+	}
+
+	err = s.CreateOwnershipTransfer(ctx, &models.OwnershipTransfer{MealPlanID: "missing", FromUserID: "alice", ToUserID: "bob", ExpiresAt: expiresAt})
+	if !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("CreateOwnershipTransfer(missing plan) error = %v, want ErrMealPlanNotFound", err)
+	}
+
+	// Deleting the plan withdraws its offer
+	plan, err := s.GetMealPlan(ctx, "plan-2")
+	if err != nil {
+		t.Fatalf("GetMealPlan: %v", err)
+	}
+	if err := s.DeleteMealPlan(ctx, "plan-2", plan.Version); err != nil {
+		t.Fatalf("DeleteMealPlan: %v", err)
+	}
+	if _, err := s.GetOwnershipTransfer(ctx, "plan-2"); !errors.Is(err, db.ErrTransferNotFound) {
+		t.Errorf("GetOwnershipTransfer(deleted plan) error = %v, want ErrTransferNotFound", err)
+	}
+}
+
+func testDeleteUser(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	for _, id := range []string{"alice", "bob", "carol"} {
+		seedUser(t, s, id)
+	}
+	// alice owns plan-1 alone and created plan-2, which carol now owns
+	seedPlan(t, s, "plan-1", "alice")
+	grant(t, s, "bob", "plan-1", "viewer")
+	seedPlan(t, s, "plan-2", "alice")
+	grant(t, s, "carol", "plan-2", "owner")
+	if err := s.SetMemberRole(ctx, "plan-2", "alice", "editor"); err != nil {
+		t.Fatalf("SetMemberRole: %v", err)
+	}
+	seedPlan(t, s, "plan-3", "bob")
+	grant(t, s, "alice", "plan-3", "editor")
+
+	seedSession(t, s, "session-1", "alice")
+	if err := s.SetPassword(ctx, "alice", "hash"); err != nil {
+		t.Fatalf("SetPassword: %v", err)
+	}
+	code := &models.ShareCode{ID: "code-1", MealPlanID: "plan-2", CreatedBy: "alice", Role: "viewer", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := s.CreateShareCode(ctx, code); err != nil {
+		t.Fatalf("CreateShareCode: %v", err)
+	}
+
+	if err := s.DeleteUser(ctx, "alice"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+
+	if _, err := s.GetUserByID(ctx, "alice"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("GetUserByID(deleted) error = %v, want ErrUserNotFound", err)
+	}
+	if _, err := s.GetSession(ctx, "session-1"); !errors.Is(err, db.ErrSessionNotFound) {
+		t.Errorf("GetSession(deleted user) error = %v, want ErrSessionNotFound", err)
+	}
+	if _, err := s.GetPassword(ctx, "alice"); !errors.Is(err, db.ErrPasswordNotFound) {
+		t.Errorf("GetPassword(deleted user) error = %v, want ErrPasswordNotFound", err)
+	}
+	if _, err := s.GetMealPlan(ctx, "plan-1"); !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("GetMealPlan(solely owned) error = %v, want ErrMealPlanNotFound", err)
+	}
+	plan, err := s.GetMealPlan(ctx, "plan-2")
+	if err != nil {
+		t.Fatalf("GetMealPlan(plan with another owner): %v", err)
+	}
+	if plan.CreatedBy != "carol" {
+		t.Errorf("plan-2 CreatedBy = %q, want carol", plan.CreatedBy)
+	}
+	if _, err := s.GetShareCode(ctx, "code-1"); !errors.Is(err, db.ErrShareCodeNotFound) {
+		t.Errorf("GetShareCode(created by deleted user) error = %v, want ErrShareCodeNotFound", err)
+	}
+	members, err := s.ListMealPlanMembers(ctx, "plan-3")
+	if err != nil || len(members) != 1 || members[0].UserID != "bob" {
+		t.Errorf("ListMealPlanMembers(plan-3) = %+v, %v; want only bob", members, err)
+	}
+
+	if err := s.DeleteUser(ctx, "alice"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("DeleteUser(twice) error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func testShareCodes(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedPlan(t, s, "plan-1", "alice")
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	code := &models.ShareCode{
+		ID:         "code-1",
+		MealPlanID: "plan-1",
+		CreatedBy:  "alice",
+		Role:       "editor",
+		ExpiresAt:  expiresAt,
+		CreatedAt:  time.Now(),
+	}
+	if err := s.CreateShareCode(ctx, code); err != nil {
+		t.Fatalf("CreateShareCode: %v", err)
+	}
+
+	got, err := s.GetShareCode(ctx, "code-1")
+	if err != nil {
+		t.Fatalf("GetShareCode: %v", err)
+	}
+	if got.MealPlanID != "plan-1" || got.CreatedBy != "alice" || got.Role != "editor" {
+		t.Errorf("GetShareCode = %+v", got)
+	}
+	if diff := got.ExpiresAt.Sub(expiresAt); diff < -time.Second || diff > time.Second {
+		t.Errorf("ExpiresAt = %v, want %v", got.ExpiresAt, expiresAt)
+	}
+
+	// Neither the code passed in nor the one handed out is kept by the store
+	code.Role = "owner"
+	got.Role = "owner"
+	if got, _ := s.GetShareCode(ctx, "code-1"); got.Role != "editor" {
+		t.Errorf("GetShareCode after changing copies = %+v, want the stored role", got)
+	}
+
+	if err := s.DeleteShareLink(ctx, "code-1"); err != nil {
+		t.Fatalf("DeleteShareLink: %v", err)
+	}
+	if _, err := s.GetShareCode(ctx, "code-1"); !errors.Is(err, db.ErrShareCodeNotFound) {
+		t.Errorf("GetShareCode(deleted) error = %v, want ErrShareCodeNotFound", err)
+	}
+	if err := s.DeleteShareLink(ctx, "code-1"); !errors.Is(err, db.ErrShareCodeNotFound) {
+		t.Errorf("DeleteShareLink(deleted) error = %v, want ErrShareCodeNotFound", err)
+	}
+}
+
+func testShareCodeRedemptions(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedUser(t, s, "bob")
+	seedUser(t, s, "carol")
+	seedPlan(t, s, "plan-1", "alice")
+
+	for _, code := range []*models.ShareCode{
+		{ID: "once", MealPlanID: "plan-1", CreatedBy: "alice", Role: "viewer", ExpiresAt: time.Now().Add(time.Hour), MaxUses: 1},
+		{ID: "open", MealPlanID: "plan-1", CreatedBy: "alice", Role: "editor", ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "stale", MealPlanID: "plan-1", CreatedBy: "alice", Role: "viewer", ExpiresAt: time.Now().Add(-48 * time.Hour)},
+	} {
+		if err := s.CreateShareCode(ctx, code); err != nil {
+			t.Fatalf("CreateShareCode(%s): %v", code.ID, err)
+		}
+	}
+
+	codes, err := s.ListShareCodesByPlan(ctx, "plan-1")
+	if err != nil {
+		t.Fatalf("ListShareCodesByPlan: %v", err)
+	}
+	if len(codes) != 3 {
+		t.Fatalf("ListShareCodesByPlan returned %d codes, want 3", len(codes))
+	}
+
+	now := time.Now()
+	if err := s.RedeemShareCode(ctx, "once", "bob", now); err != nil {
+		t.Fatalf("RedeemShareCode: %v", err)
+	}
+	if err := s.RedeemShareCode(ctx, "once", "carol", now); !errors.Is(err, db.ErrShareCodeUsedUp) {
+		t.Errorf("RedeemShareCode(used up) error = %v, want ErrShareCodeUsedUp", err)
+	}
+	if err := s.RedeemShareCode(ctx, "open", "carol", now.Add(time.Second)); err != nil {
+		t.Fatalf("RedeemShareCode(open): %v", err)
+	}
+	if err := s.RedeemShareCode(ctx, "missing", "carol", now); !errors.Is(err, db.ErrShareCodeNotFound) {
+		t.Errorf("RedeemShareCode(missing) error = %v, want ErrShareCodeNotFound", err)
+	}
+	if err := s.RedeemShareCode(ctx, "open", "nobody", now); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("RedeemShareCode(unknown user) error = %v, want ErrUserNotFound", err)
+	}
+
+	once, err := s.GetShareCode(ctx, "once")
+	if err != nil {
+		t.Fatalf("GetShareCode: %v", err)
+	}
+	if once.Uses != 1 || once.MaxUses != 1 || !once.UsedUp() {
+		t.Errorf("single-use code = %+v, want it used up", once)
+	}
+	open, err := s.GetShareCode(ctx, "open")
+	if err != nil {
+		t.Fatalf("GetShareCode: %v", err)
+	}
+	if open.Uses != 1 || open.UsedUp() {
+		t.Errorf("unlimited code = %+v, want one use", open)
+	}
+
+	redemptions, err := s.ListShareCodeRedemptions(ctx, "plan-1")
+	if err != nil {
+		t.Fatalf("ListShareCodeRedemptions: %v", err)
+	}
+	if len(redemptions) != 2 ||
+		redemptions[0].ShareCodeID != "once" || redemptions[0].UserID != "bob" ||
+		redemptions[1].ShareCodeID != "open" || redemptions[1].UserID != "carol" {
+		t.Errorf("ListShareCodeRedemptions = %+v, want bob then carol", redemptions)
+	}
+
+	// A code that expired a moment ago is swept too, whatever the backend's
+	// clock resolution
+	justExpired := &models.ShareCode{ID: "just-expired", MealPlanID: "plan-1", CreatedBy: "alice", Role: "viewer", ExpiresAt: time.Now().Add(-time.Millisecond)}
+	if err := s.CreateShareCode(ctx, justExpired); err != nil {
+		t.Fatalf("CreateShareCode(just-expired): %v", err)
+	}
+	n, err := s.DeleteExpiredShareCodes(ctx)
+	if err != nil {
+		t.Fatalf("DeleteExpiredShareCodes: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("DeleteExpiredShareCodes removed %d codes, want 2", n)
+	}
+	for _, id := range []string{"stale", "just-expired"} {
+		if _, err := s.GetShareCode(ctx, id); !errors.Is(err, db.ErrShareCodeNotFound) {
+			t.Errorf("GetShareCode(%s) error = %v, want ErrShareCodeNotFound", id, err)
+		}
+	}
+
+	// Deleting a code or a user takes their redemptions with them
+	if err := s.DeleteShareLink(ctx, "once"); err != nil {
+		t.Fatalf("DeleteShareLink: %v", err)
+	}
+	if err := s.DeleteUser(ctx, "carol"); err != nil {
+		t.Fatalf("DeleteUser: %v", err)
+	}
+	redemptions, err = s.ListShareCodeRedemptions(ctx, "plan-1")
+	if err != nil {
+		t.Fatalf("ListShareCodeRedemptions: %v", err)
+	}
+	if len(redemptions) != 0 {
+		t.Errorf("ListShareCodeRedemptions after deletes = %+v, want none", redemptions)
+	}
+}
+
+func testInvitations(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedPlan(t, s, "plan-1", "alice")
+	seedPlan(t, s, "plan-2", "alice")
+	seedPlan(t, s, "plan-3", "alice")
+
+	for _, invitation := range []*models.Invitation{
+		{ID: "inv-1", MealPlanID: "plan-1", Email: "dana@example.com", Role: "editor", InvitedBy: "alice"},
+		{ID: "inv-2", MealPlanID: "plan-2", Email: "dana@example.com", Role: "viewer", InvitedBy: "alice"},
+		{ID: "inv-3", MealPlanID: "plan-3", Email: "erin@example.com", Role: "viewer", InvitedBy: "alice"},
+	} {
+		if err := s.CreateInvitation(ctx, invitation); err != nil {
+			t.Fatalf("CreateInvitation(%s): %v", invitation.ID, err)
+		}
+	}
+	err := s.CreateInvitation(ctx, &models.Invitation{ID: "inv-4", MealPlanID: "plan-1", Email: "dana@example.com", Role: "viewer", InvitedBy: "alice"})
+	if !errors.Is(err, db.ErrInvitationExists) {
+		t.Errorf("CreateInvitation(duplicate) error = %v, want ErrInvitationExists", err)
+	}
+	err = s.CreateInvitation(ctx, &models.Invitation{ID: "inv-5", MealPlanID: "missing", Email: "dana@example.com", Role: "viewer", InvitedBy: "alice"})
+	if !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("CreateInvitation(unknown plan) error = %v, want ErrMealPlanNotFound", err)
+	}
+
+	got, err := s.GetInvitation(ctx, "inv-1")
+	if err != nil {
+		t.Fatalf("GetInvitation: %v", err)
+	}
+	if got.MealPlanID != "plan-1" || got.Email != "dana@example.com" || got.Role != "editor" || got.InvitedBy != "alice" {
+		t.Errorf("GetInvitation = %+v", got)
+	}
+	invitations, err := s.ListInvitationsByPlan(ctx, "plan-1")
+	if err != nil {
+		t.Fatalf("ListInvitationsByPlan: %v", err)
+	}
+	if len(invitations) != 1 || invitations[0].ID != "inv-1" {
+		t.Errorf("ListInvitationsByPlan = %+v, want inv-1", invitations)
+	}
+
+	// Signing in doesn't accept invitations by itself, since the address may
+	// not have been verified
+	seedUser(t, s, "dana")
+	if err := s.CreateMealPlanAccess(ctx, &models.MealPlanAccess{ID: "dana-2", UserID: "dana", MealPlanID: "plan-2", Role: "editor"}); err != nil {
+		t.Fatalf("CreateMealPlanAccess: %v", err)
+	}
+	dana := &models.User{ID: "dana", Email: "Dana@Example.com", Name: "Dana"}
+	if err := s.CreateOrUpdateUser(ctx, dana); err != nil {
+		t.Fatalf("CreateOrUpdateUser: %v", err)
+	}
+	if _, err := s.GetRole(ctx, "dana", "plan-1"); !errors.Is(err, db.ErrAccessDenied) {
+		t.Errorf("GetRole(invited plan before accepting) error = %v, want ErrAccessDenied", err)
+	}
+	if _, err := s.GetInvitation(ctx, "inv-1"); err != nil {
+		t.Errorf("GetInvitation(before accepting): %v", err)
+	}
+
+	// Dana can already see plan-2, so only plan-1 adds access. Emails are
+	// matched without regard to case.
+	if err := s.AcceptInvitations(ctx, "dana"); err != nil {
+		t.Fatalf("AcceptInvitations: %v", err)
+	}
+	if role, err := s.GetRole(ctx, "dana", "plan-1"); err != nil || role != "editor" {
+		t.Errorf("GetRole(invited plan) = %q, %v; want editor", role, err)
+	}
+	if role, err := s.GetRole(ctx, "dana", "plan-2"); err != nil || role != "editor" {
+		t.Errorf("GetRole(already shared plan) = %q, %v; want editor", role, err)
+	}
+	for _, id := range []string{"inv-1", "inv-2"} {
+		if _, err := s.GetInvitation(ctx, id); !errors.Is(err, db.ErrInvitationNotFound) {
+			t.Errorf("GetInvitation(%s after accepting) error = %v, want ErrInvitationNotFound", id, err)
+		}
+	}
+	if err := s.AcceptInvitations(ctx, "nobody"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("AcceptInvitations(unknown user) error = %v, want ErrUserNotFound", err)
+	}
+
+	if err := s.DeleteInvitation(ctx, "inv-3"); err != nil {
+		t.Fatalf("DeleteInvitation: %v", err)
+	}
+	if err := s.DeleteInvitation(ctx, "inv-3"); !errors.Is(err, db.ErrInvitationNotFound) {
+		t.Errorf("DeleteInvitation(deleted) error = %v, want ErrInvitationNotFound", err)
+	}
+	seedUser(t, s, "erin")
+	if err := s.AcceptInvitations(ctx, "erin"); err != nil {
+		t.Fatalf("AcceptInvitations(erin): %v", err)
+	}
+	if _, err := s.GetRole(ctx, "erin", "plan-3"); !errors.Is(err, db.ErrAccessDenied) {
+		t.Errorf("GetRole(cancelled invitation) error = %v, want ErrAccessDenied", err)
+	}
+
+	// Invitations go with their plan
+	if err := s.CreateInvitation(ctx, &models.Invitation{ID: "inv-6", MealPlanID: "plan-3", Email: "frank@example.com", Role: "viewer", InvitedBy: "alice"}); err != nil {
+		t.Fatalf("CreateInvitation: %v", err)
+	}
+	if err := s.DeleteMealPlan(ctx, "plan-3", 1); err != nil {
+		t.Fatalf("DeleteMealPlan: %v", err)
+	}
+	if _, err := s.GetInvitation(ctx, "inv-6"); !errors.Is(err, db.ErrInvitationNotFound) {
+		t.Errorf("GetInvitation(plan deleted) error = %v, want ErrInvitationNotFound", err)
+	}
+}
+
+func testMeals(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedPlan(t, s, "plan-1", "alice")
+	seedMeal(t, s, "meal-1", "plan-1", "Monday", "Dinner")
+
+	meal, err := s.GetMeal(ctx, "meal-1")
+	if err != nil {
+		t.Fatalf("GetMeal: %v", err)
+	}
+	if meal.MealPlanID != "plan-1" || meal.Name != "Meal meal-1" || meal.Day != "Monday" || meal.MealType != "Dinner" {
+		t.Errorf("GetMeal = %+v", meal)
+	}
+
+	update := &models.Meal{
+		ID:          "meal-1",
+		MealPlanID:  "plan-1",
+		Name:        "Tacos",
+		Description: "With salsa",
+		Day:         "Tuesday",
+		MealType:    "Lunch",
+		Version:     1,
+	}
+	if err := s.UpdateMeal(ctx, update); err != nil {
+		t.Fatalf("UpdateMeal: %v", err)
+	}
+	if update.Version != 2 {
+		t.Errorf("UpdateMeal set version %d, want 2", update.Version)
+	}
+	meal, err = s.GetMeal(ctx, "meal-1")
+	if err != nil {
+		t.Fatalf("GetMeal after update: %v", err)
+	}
+	if meal.Name != "Tacos" || meal.Description != "With salsa" || meal.Day != "Tuesday" || meal.MealType != "Lunch" || meal.Version != 2 {
+		t.Errorf("meal after update = %+v", meal)
+	}
+
+	if err := s.DeleteMeal(ctx, "meal-1", 2); err != nil {
+		t.Fatalf("DeleteMeal: %v", err)
+	}
+	if _, err := s.GetMeal(ctx, "meal-1"); !errors.Is(err, db.ErrMealNotFound) {
+		t.Errorf("GetMeal(deleted) error = %v, want ErrMealNotFound", err)
+	}
+	if err := s.DeleteMeal(ctx, "meal-1", 2); !errors.Is(err, db.ErrMealNotFound) {
+		t.Errorf("DeleteMeal(deleted) error = %v, want ErrMealNotFound", err)
+	}
+	if err := s.UpdateMeal(ctx, update); !errors.Is(err, db.ErrMealNotFound) {
+		t.Errorf("UpdateMeal(deleted) error = %v, want ErrMealNotFound", err)
+	}
+}
+
+func testVersionConflicts(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedPlan(t, s, "plan-1", "alice")
+	seedMeal(t, s, "meal-1", "plan-1", "Monday", "Dinner")
+
+	// Two editors read version 1; the first write wins
+	first := &models.Meal{ID: "meal-1", MealPlanID: "plan-1", Name: "First", Day: "Monday", MealType: "Dinner", Version: 1}
+	second := &models.Meal{ID: "meal-1", MealPlanID: "plan-1", Name: "Second", Day: "Monday", MealType: "Dinner", Version: 1}
+	if err := s.UpdateMeal(ctx, first); err != nil {
+		t.Fatalf("UpdateMeal(first): %v", err)
+	}
+	if err := s.UpdateMeal(ctx, second); !errors.Is(err, db.ErrVersionConflict) {
+		t.Errorf("UpdateMeal(stale) error = %v, want ErrVersionConflict", err)
+	}
+	meal, err := s.GetMeal(ctx, "meal-1")
+	if err != nil {
+		t.Fatalf("GetMeal: %v", err)
+	}
+	if meal.Name != "First" || meal.Version != 2 {
+		t.Errorf("meal after conflicting writes = %+v", meal)
+	}
+	if err := s.DeleteMeal(ctx, "meal-1", 1); !errors.Is(err, db.ErrVersionConflict) {
+		t.Errorf("DeleteMeal(stale) error = %v, want ErrVersionConflict", err)
+	}
+
+	stale := &models.MealPlan{ID: "plan-1", Name: "Stale", Version: 7}
+	if err := s.UpdateMealPlan(ctx, stale); !errors.Is(err, db.ErrVersionConflict) {
+		t.Errorf("UpdateMealPlan(stale) error = %v, want ErrVersionConflict", err)
+	}
+	if err := s.DeleteMealPlan(ctx, "plan-1", 7); !errors.Is(err, db.ErrVersionConflict) {
+		t.Errorf("DeleteMealPlan(stale) error = %v, want ErrVersionConflict", err)
+	}
+	if _, err := s.GetMeal(ctx, "meal-1"); err != nil {
+		t.Errorf("GetMeal after rejected plan delete: %v", err)
+	}
+}
+
+func testListMealsByPlan(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedPlan(t, s, "plan-1", "alice")
+	seedPlan(t, s, "plan-2", "alice")
+	seedMeal(t, s, "meal-1", "plan-1", "Monday", "Breakfast")
+	seedMeal(t, s, "meal-2", "plan-1", "Monday", "Dinner")
+	seedMeal(t, s, "meal-3", "plan-2", "Friday", "Lunch")
+
+	meals, _, err := s.ListMealsByPlan(ctx, "plan-1", db.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListMealsByPlan: %v", err)
+	}
+
+	var ids []string
+	for _, meal := range meals {
+		ids = append(ids, meal.ID)
+	}
+	sort.Strings(ids)
+	if want := []string{"meal-1", "meal-2"}; !equal(ids, want) {
+		t.Errorf("ListMealsByPlan = %v, want %v", ids, want)
+	}
+
+	meals, _, err = s.ListMealsByPlan(ctx, "empty", db.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListMealsByPlan(empty): %v", err)
+	}
+	if len(meals) != 0 {
+		t.Errorf("ListMealsByPlan(empty) returned %d meals", len(meals))
+	}
+}
+
+func testListMealsOrderAndFilters(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedPlan(t, s, "plan-1", "alice")
+	seedMeal(t, s, "meal-a", "plan-1", "Wednesday", "Breakfast")
+	seedMeal(t, s, "meal-b", "plan-1", "Monday", "Dinner")
+	seedMeal(t, s, "meal-c", "plan-1", "Monday", "Breakfast")
+	seedMeal(t, s, "meal-d", "plan-1", "Sunday", "Lunch")
+
+	tests := []struct {
+		name string
+		opts db.ListOptions
+		want []string
+	}{
+		{"default is by day", db.ListOptions{}, []string{"meal-c", "meal-b", "meal-a", "meal-d"}},
+		{"by name", db.ListOptions{Sort: db.SortByName}, []string{"meal-a", "meal-b", "meal-c", "meal-d"}},
+		{"day filter", db.ListOptions{Day: "Monday"}, []string{"meal-c", "meal-b"}},
+		{"meal type filter", db.ListOptions{MealType: "Breakfast"}, []string{"meal-c", "meal-a"}},
+		{"text filter ignores case", db.ListOptions{Text: "MEAL-D"}, []string{"meal-d"}},
+		{"text matches description", db.ListOptions{Text: "description meal-a"}, []string{"meal-a"}},
+		{"text wildcards are literal", db.ListOptions{Text: "meal_a"}, nil},
+		{"text percent is literal", db.ListOptions{Text: "%"}, nil},
+		{"no match", db.ListOptions{Day: "Monday", MealType: "Lunch"}, nil},
+	}
+	for _, tt := range tests {
+		meals, next, err := s.ListMealsByPlan(ctx, "plan-1", tt.opts)
+		if err != nil {
+			t.Fatalf("%s: ListMealsByPlan: %v", tt.name, err)
+		}
+		var ids []string
+		for _, meal := range meals {
+			ids = append(ids, meal.ID)
+		}
+		if !equal(ids, tt.want) {
+			t.Errorf("%s: ListMealsByPlan = %v, want %v", tt.name, ids, tt.want)
+		}
+		if next != "" {
+			t.Errorf("%s: unlimited list returned cursor %q", tt.name, next)
+		}
+	}
+}
+
+func testListPagination(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	for _, id := range []string{"plan-e", "plan-c", "plan-a", "plan-d", "plan-b"} {
+		seedPlan(t, s, id, "alice")
+	}
+
+	for _, sortOrder := range []db.SortOrder{db.SortByName, db.SortByUpdated} {
+		var ids []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatalf("%s: pagination did not terminate", sortOrder)
+			}
+			plans, next, err := s.ListMealPlansByUser(ctx, "alice", db.ListOptions{Sort: sortOrder, Cursor: cursor, Limit: 2})
+			if err != nil {
+				t.Fatalf("%s: ListMealPlansByUser: %v", sortOrder, err)
+			}
+			if len(plans) > 2 {
+				t.Fatalf("%s: page has %d plans, limit is 2", sortOrder, len(plans))
+			}
+			for _, plan := range plans {
+				ids = append(ids, plan.ID)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+
+		if sortOrder == db.SortByName {
+			if want := []string{"plan-a", "plan-b", "plan-c", "plan-d", "plan-e"}; !equal(ids, want) {
+				t.Errorf("pages by name = %v, want %v", ids, want)
+			}
+			continue
+		}
+		sort.Strings(ids)
+		if want := []string{"plan-a", "plan-b", "plan-c", "plan-d", "plan-e"}; !equal(ids, want) {
+			t.Errorf("pages by %s covered %v, want every plan once", sortOrder, ids)
+		}
+	}
+
+	// A cursor survives the record it points at being deleted
+	plans, next, err := s.ListMealPlansByUser(ctx, "alice", db.ListOptions{Sort: db.SortByName, Limit: 2})
+	if err != nil || len(plans) != 2 {
+		t.Fatalf("ListMealPlansByUser first page = %d plans, %v", len(plans), err)
+	}
+	if err := s.DeleteMealPlan(ctx, "plan-b", 1); err != nil {
+		t.Fatalf("DeleteMealPlan: %v", err)
+	}
+	plans, _, err = s.ListMealPlansByUser(ctx, "alice", db.ListOptions{Sort: db.SortByName, Cursor: next, Limit: 2})
+	if err != nil {
+		t.Fatalf("ListMealPlansByUser second page: %v", err)
+	}
+	if len(plans) != 2 || plans[0].ID != "plan-c" || plans[1].ID != "plan-d" {
+		t.Errorf("second page after delete = %v, want [plan-c plan-d]", plans)
+	}
+}
+
+func testListMealsPagination(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedPlan(t, s, "plan-1", "alice")
+	seedMeal(t, s, "meal-a", "plan-1", "Friday", "Dinner")
+	seedMeal(t, s, "meal-b", "plan-1", "Monday", "Lunch")
+	seedMeal(t, s, "meal-c", "plan-1", "Monday", "Lunch")
+	seedMeal(t, s, "meal-d", "plan-1", "Monday", "Breakfast")
+	seedMeal(t, s, "meal-e", "plan-1", "Sunday", "Breakfast")
+	seedMeal(t, s, "meal-f", "plan-1", "Friday", "Breakfast")
+
+	for _, sortOrder := range []db.SortOrder{db.SortByDay, db.SortByName, db.SortByUpdated} {
+		all, _, err := s.ListMealsByPlan(ctx, "plan-1", db.ListOptions{Sort: sortOrder})
+		if err != nil {
+			t.Fatalf("%s: ListMealsByPlan: %v", sortOrder, err)
+		}
+		var want []string
+		for _, meal := range all {
+			want = append(want, meal.ID)
+		}
+
+		var ids []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > 6 {
+				t.Fatalf("%s: pagination did not terminate", sortOrder)
+			}
+			meals, next, err := s.ListMealsByPlan(ctx, "plan-1", db.ListOptions{Sort: sortOrder, Cursor: cursor, Limit: 2})
+			if err != nil {
+				t.Fatalf("%s: ListMealsByPlan: %v", sortOrder, err)
+			}
+			for _, meal := range meals {
+				ids = append(ids, meal.ID)
+			}
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		if len(want) != 6 || !equal(ids, want) {
+			t.Errorf("pages by %s = %v, want %v", sortOrder, ids, want)
+		}
+	}
+
+	// Filters apply on every page
+	meals, next, err := s.ListMealsByPlan(ctx, "plan-1", db.ListOptions{Day: "Monday", Limit: 2})
+	if err != nil || next == "" {
+		t.Fatalf("ListMealsByPlan(Monday) = cursor %q, %v", next, err)
+	}
+	meals2, next2, err := s.ListMealsByPlan(ctx, "plan-1", db.ListOptions{Day: "Monday", Cursor: next, Limit: 2})
+	if err != nil {
+		t.Fatalf("ListMealsByPlan(Monday, second page): %v", err)
+	}
+	var ids []string
+	for _, meal := range append(meals, meals2...) {
+		ids = append(ids, meal.ID)
+	}
+	if want := []string{"meal-d", "meal-b", "meal-c"}; !equal(ids, want) || next2 != "" {
+		t.Errorf("Monday pages = %v, cursor %q; want %v and no cursor", ids, next2, want)
+	}
+}
+
+func testListInvalidOptions(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedPlan(t, s, "plan-1", "alice")
+	seedPlan(t, s, "plan-2", "alice")
+
+	_, next, err := s.ListMealPlansByUser(ctx, "alice", db.ListOptions{Sort: db.SortByName, Limit: 1})
+	if err != nil || next == "" {
+		t.Fatalf("ListMealPlansByUser = cursor %q, %v", next, err)
+	}
+
+	planTests := []struct {
+		name string
+		opts db.ListOptions
+	}{
+		{"unknown sort", db.ListOptions{Sort: "calories"}},
+		{"day sort on plans", db.ListOptions{Sort: db.SortByDay}},
+		{"day filter on plans", db.ListOptions{Day: "Monday"}},
+		{"malformed cursor", db.ListOptions{Cursor: "not a cursor"}},
+		{"cursor from another sort", db.ListOptions{Sort: db.SortByUpdated, Cursor: next}},
+		{"negative limit", db.ListOptions{Limit: -1}},
+	}
+	for _, tt := range planTests {
+		if _, _, err := s.ListMealPlansByUser(ctx, "alice", tt.opts); !errors.Is(err, db.ErrInvalidListOptions) {
+			t.Errorf("%s: ListMealPlansByUser error = %v, want ErrInvalidListOptions", tt.name, err)
+		}
+	}
+
+	if _, _, err := s.ListMealsByPlan(ctx, "plan-1", db.ListOptions{Sort: "calories"}); !errors.Is(err, db.ErrInvalidListOptions) {
+		t.Errorf("ListMealsByPlan(unknown sort) error = %v, want ErrInvalidListOptions", err)
+	}
+}
+
+func testDeleteMealPlanCascades(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedUser(t, s, "bob")
+	seedPlan(t, s, "plan-1", "alice")
+	seedPlan(t, s, "plan-2", "alice")
+	grant(t, s, "bob", "plan-1", "editor")
+	grant(t, s, "bob", "plan-2", "viewer")
+	seedMeal(t, s, "meal-1", "plan-1", "Monday", "Dinner")
+	seedMeal(t, s, "meal-2", "plan-2", "Monday", "Dinner")
+	code := &models.ShareCode{
+		ID:         "code-1",
+		MealPlanID: "plan-1",
+		CreatedBy:  "alice",
+		Role:       "viewer",
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	if err := s.CreateShareCode(ctx, code); err != nil {
+		t.Fatalf("CreateShareCode: %v", err)
+	}
+
+	if err := s.DeleteMealPlan(ctx, "plan-1", 1); err != nil {
+		t.Fatalf("DeleteMealPlan: %v", err)
+	}
+
+	if _, err := s.GetMeal(ctx, "meal-1"); !errors.Is(err, db.ErrMealNotFound) {
+		t.Errorf("GetMeal(cascaded) error = %v, want ErrMealNotFound", err)
+	}
+	if _, err := s.GetShareCode(ctx, "code-1"); !errors.Is(err, db.ErrShareCodeNotFound) {
+		t.Errorf("GetShareCode(cascaded) error = %v, want ErrShareCodeNotFound", err)
+	}
+	if _, err := s.CheckMealPlanAccess(ctx, "bob", "plan-1"); !errors.Is(err, db.ErrAccessDenied) {
+		t.Errorf("CheckMealPlanAccess(cascaded) error = %v, want ErrAccessDenied", err)
+	}
+
+	// The other plan is untouched
+	if _, err := s.GetMeal(ctx, "meal-2"); err != nil {
+		t.Errorf("GetMeal(other plan): %v", err)
+	}
+	plans, _, err := s.ListMealPlansByUser(ctx, "bob", db.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListMealPlansByUser: %v", err)
+	}
+	if len(plans) != 1 || plans[0].ID != "plan-2" {
+		t.Errorf("ListMealPlansByUser after delete = %v, want [plan-2]", plans)
+	}
+}
+
+func testMissingReferences(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedPlan(t, s, "plan-1", "alice")
+
+	plan := &models.MealPlan{ID: "plan-2", Name: "Orphan", CreatedBy: "nobody"}
+	if err := s.CreateMealPlan(ctx, plan); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("CreateMealPlan(unknown creator) error = %v, want ErrUserNotFound", err)
+	}
+
+	meal := &models.Meal{ID: "meal-1", MealPlanID: "missing", Name: "Soup", Day: "Monday", MealType: "Lunch"}
+	if err := s.CreateMeal(ctx, meal); !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("CreateMeal(unknown plan) error = %v, want ErrMealPlanNotFound", err)
+	}
+
+	access := &models.MealPlanAccess{ID: "access-1", UserID: "alice", MealPlanID: "missing", Role: "viewer"}
+	if err := s.CreateMealPlanAccess(ctx, access); !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("CreateMealPlanAccess(unknown plan) error = %v, want ErrMealPlanNotFound", err)
+	}
+	access = &models.MealPlanAccess{ID: "access-2", UserID: "nobody", MealPlanID: "plan-1", Role: "viewer"}
+	if err := s.CreateMealPlanAccess(ctx, access); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("CreateMealPlanAccess(unknown user) error = %v, want ErrUserNotFound", err)
+	}
+
+	code := &models.ShareCode{
+		ID:         "code-1",
+		MealPlanID: "missing",
+		CreatedBy:  "alice",
+		Role:       "viewer",
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	if err := s.CreateShareCode(ctx, code); !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("CreateShareCode(unknown plan) error = %v, want ErrMealPlanNotFound", err)
+	}
+}
+
+func testWithTxCommit(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+
+	err := s.WithTx(ctx, func(tx db.Store) error {
+		plan := &models.MealPlan{ID: "plan-1", Name: "Plan", CreatedBy: "alice"}
+		if err := tx.CreateMealPlan(ctx, plan); err != nil {
+			return err
+		}
+		return tx.CreateMealPlanAccess(ctx, &models.MealPlanAccess{
+			UserID:     "alice",
+			MealPlanID: "plan-1",
+			Role:       "owner",
+		})
+	})
+	if err != nil {
+		t.Fatalf("WithTx: %v", err)
+	}
+
+	if _, err := s.GetMealPlan(ctx, "plan-1"); err != nil {
+		t.Errorf("GetMealPlan after commit: %v", err)
+	}
+	if ok, err := s.CheckMealPlanOwnership(ctx, "alice", "plan-1"); !ok {
+		t.Errorf("CheckMealPlanOwnership after commit = %v, %v", ok, err)
+	}
+}
+
+func testWithTxRollback(t *testing.T, s db.Store) {
+	ctx := context.Background()
+	seedUser(t, s, "alice")
+	seedPlan(t, s, "plan-1", "alice")
+	seedMeal(t, s, "meal-1", "plan-1", "Monday", "Lunch")
+
+	failure := errors.New("abort")
+	err := s.WithTx(ctx, func(tx db.Store) error {
+		if err := tx.CreateMealPlan(ctx, &models.MealPlan{ID: "plan-2", Name: "Plan", CreatedBy: "alice"}); err != nil {
+			return err
+		}
+		if err := tx.CreateMeal(ctx, &models.Meal{ID: "meal-2", MealPlanID: "plan-2", Name: "Soup", Day: "Monday", MealType: "Dinner"}); err != nil {
+			return err
+		}
+		if err := tx.UpdateMealPlan(ctx, &models.MealPlan{ID: "plan-1", Name: "Renamed", Version: 1}); err != nil {
+			return err
+		}
+		if err := tx.DeleteMealPlan(ctx, "plan-1", 2); err != nil {
+			return err
+		}
+		if err := tx.CreateOrUpdateUser(ctx, &models.User{ID: "alice", Email: "alice@example.org", Name: "Alice"}); err != nil {
+			return err
+		}
+
+		// Changes are visible inside the transaction
+		if _, err := tx.GetMealPlan(ctx, "plan-2"); err != nil {
+			t.Errorf("GetMealPlan inside tx: %v", err)
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("WithTx error = %v, want %v", err, failure)
+	}
+
+	if _, err := s.GetMealPlan(ctx, "plan-2"); !errors.Is(err, db.ErrMealPlanNotFound) {
+		t.Errorf("GetMealPlan(rolled back) error = %v, want ErrMealPlanNotFound", err)
+	}
+	plan, err := s.GetMealPlan(ctx, "plan-1")
+	if err != nil {
+		t.Fatalf("GetMealPlan: %v", err)
+	}
+	if plan.Name != "Plan plan-1" {
+		t.Errorf("plan name after rollback = %q, want unchanged", plan.Name)
+	}
+
+	// Rows removed by the cascade and the lookups that find them are back
+	if _, err := s.GetMeal(ctx, "meal-1"); err != nil {
+		t.Errorf("GetMeal(meal-1) after rollback: %v", err)
+	}
+	meals, _, err := s.ListMealsByPlan(ctx, "plan-1", db.ListOptions{})
+	if err != nil || len(meals) != 1 {
+		t.Errorf("ListMealsByPlan after rollback = %d meals, %v; want 1", len(meals), err)
+	}
+	plans, _, err := s.ListMealPlansByUser(ctx, "alice", db.ListOptions{})
+	if err != nil || len(plans) != 1 || plans[0].ID != "plan-1" {
+		t.Errorf("ListMealPlansByUser after rollback = %v, %v; want only plan-1", plans, err)
+	}
+	if _, err := s.GetUserByEmail(ctx, "alice@example.com"); err != nil {
+		t.Errorf("GetUserByEmail(old email) after rollback: %v", err)
+	}
+	if _, err := s.GetUserByEmail(ctx, "alice@example.org"); !errors.Is(err, db.ErrUserNotFound) {
+		t.Errorf("GetUserByEmail(new email) after rollback error = %v, want ErrUserNotFound", err)
+	}
+}
+
+func testCanceledContext(t *testing.T, s db.Store) {
+	seedUser(t, s, "alice")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.GetUserByID(ctx, "alice"); err == nil {
+		t.Errorf("GetUserByID with canceled context succeeded")
+	}
+	if err := s.CreateMealPlan(ctx, &models.MealPlan{ID: "plan-1", Name: "Plan", CreatedBy: "alice"}); err == nil {
+		t.Errorf("CreateMealPlan with canceled context succeeded")
+	}
+	if err := s.WithTx(ctx, func(tx db.Store) error { return nil }); err == nil {
+		t.Errorf("WithTx with canceled context succeeded")
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
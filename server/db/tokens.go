@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+
+	"my-meal-planner/models"
+)
+
+// AccessTokenTTL is how long an access token is valid. Clients use their
+// session's refresh token to get a new one.
+const AccessTokenTTL = 15 * time.Minute
+
+// TokenClaims represents the claims in a JWT token
+type TokenClaims struct {
+	UserID string `json:"userId"`
+	Email  string `json:"email,omitempty"`
+	// SessionID is the session the token was issued to; revoking the session
+	// invalidates the token
+	SessionID string `json:"sid"`
+	// Scopes limits what the token may be used for; empty means unrestricted
+	Scopes []string `json:"scopes,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// tokenIssuer implements the token operations of the Store interface.
+// It is embedded by every store so they all issue identical tokens.
+type tokenIssuer struct {
+	keys *Keyring
+}
+
+// GenerateToken creates a short-lived access token for a user's session. Each
+// token gets a unique ID (the jti claim) so that it can be told apart from the
+// user's other tokens.
+func (t tokenIssuer) GenerateToken(ctx context.Context, user *models.User, sessionID string) (string, error) {
+	now := time.Now()
+	claims := &TokenClaims{
+		UserID:    user.ID,
+		Email:     user.Email,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Subject:   user.ID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+		},
+	}
+
+	return t.keys.sign(claims)
+}
+
+// JWKS returns the public keys that verify the store's tokens
+func (t tokenIssuer) JWKS() JWKS {
+	return t.keys.JWKS()
+}
+
+// validateToken checks a token's signature and expiry, then uses getSession to
+// reject tokens whose session has been revoked or has expired
+func (t tokenIssuer) validateToken(ctx context.Context, tokenString string, getSession func(context.Context, string) (*models.Session, error)) (*TokenClaims, error) {
+	claims, err := t.parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := getSession(ctx, claims.SessionID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.UserID != claims.UserID || !session.Active(time.Now()) {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// parseToken checks a token's signature and expiry and returns its claims
+func (t tokenIssuer) parseToken(tokenString string) (*TokenClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &TokenClaims{}, t.keys.verificationKey,
+		jwt.WithValidMethods(t.keys.methods()))
+
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims, ok := token.Claims.(*TokenClaims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, ErrInvalidToken
+}
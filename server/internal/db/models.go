@@ -9,6 +9,37 @@ import (
 	"time"
 )
 
+type ApiToken struct {
+	ID         string       `json:"id"`
+	UserID     string       `json:"user_id"`
+	Name       string       `json:"name"`
+	TokenHash  string       `json:"token_hash"`
+	Prefix     string       `json:"prefix"`
+	Scopes     string       `json:"scopes"`
+	CreatedAt  time.Time    `json:"created_at"`
+	LastUsedAt sql.NullTime `json:"last_used_at"`
+	ExpiresAt  sql.NullTime `json:"expires_at"`
+}
+
+type Invitation struct {
+	ID         string    `json:"id"`
+	MealPlanID string    `json:"meal_plan_id"`
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	InvitedBy  string    `json:"invited_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type MagicLink struct {
+	TokenHash    string         `json:"token_hash"`
+	Email        string         `json:"email"`
+	CreatedAt    time.Time      `json:"created_at"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+	UsedAt       sql.NullTime   `json:"used_at"`
+	Name         sql.NullString `json:"name"`
+	PasswordHash sql.NullString `json:"password_hash"`
+}
+
 type Meal struct {
 	ID          string         `json:"id"`
 	MealPlanID  string         `json:"meal_plan_id"`
@@ -18,6 +49,7 @@ type Meal struct {
 	MealType    string         `json:"meal_type"`
 	CreatedAt   sql.NullTime   `json:"created_at"`
 	UpdatedAt   sql.NullTime   `json:"updated_at"`
+	Version     int64          `json:"version"`
 }
 
 type MealPlan struct {
@@ -27,13 +59,43 @@ type MealPlan struct {
 	CreatedBy   string         `json:"created_by"`
 	CreatedAt   sql.NullTime   `json:"created_at"`
 	UpdatedAt   sql.NullTime   `json:"updated_at"`
+	Version     int64          `json:"version"`
 }
 
 type MealPlanAccess struct {
-	ID         string `json:"id"`
-	UserID     string `json:"user_id"`
-	MealPlanID string `json:"meal_plan_id"`
-	Role       string `json:"role"`
+	ID         string       `json:"id"`
+	UserID     string       `json:"user_id"`
+	MealPlanID string       `json:"meal_plan_id"`
+	Role       string       `json:"role"`
+	CreatedAt  sql.NullTime `json:"created_at"`
+}
+
+type OwnershipTransfer struct {
+	MealPlanID string    `json:"meal_plan_id"`
+	FromUserID string    `json:"from_user_id"`
+	ToUserID   string    `json:"to_user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type Password struct {
+	UserID         string       `json:"user_id"`
+	PasswordHash   string       `json:"password_hash"`
+	FailedAttempts int32        `json:"failed_attempts"`
+	LockedUntil    sql.NullTime `json:"locked_until"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+type Session struct {
+	ID               string       `json:"id"`
+	UserID           string       `json:"user_id"`
+	RefreshTokenHash string       `json:"refresh_token_hash"`
+	UserAgent        string       `json:"user_agent"`
+	IpAddress        string       `json:"ip_address"`
+	CreatedAt        time.Time    `json:"created_at"`
+	LastUsedAt       time.Time    `json:"last_used_at"`
+	ExpiresAt        time.Time    `json:"expires_at"`
+	RevokedAt        sql.NullTime `json:"revoked_at"`
 }
 
 type ShareLink struct {
@@ -43,13 +105,22 @@ type ShareLink struct {
 	Role       string       `json:"role"`
 	ExpiresAt  time.Time    `json:"expires_at"`
 	CreatedAt  sql.NullTime `json:"created_at"`
+	MaxUses    int32        `json:"max_uses"`
+	Uses       int32        `json:"uses"`
+}
+
+type ShareLinkRedemption struct {
+	ShareLinkID string    `json:"share_link_id"`
+	UserID      string    `json:"user_id"`
+	RedeemedAt  time.Time `json:"redeemed_at"`
 }
 
 type User struct {
-	ID        string         `json:"id"`
-	Email     string         `json:"email"`
-	Name      string         `json:"name"`
-	Picture   sql.NullString `json:"picture"`
-	CreatedAt sql.NullTime   `json:"created_at"`
-	UpdatedAt sql.NullTime   `json:"updated_at"`
+	ID            string         `json:"id"`
+	Email         string         `json:"email"`
+	Name          string         `json:"name"`
+	Picture       sql.NullString `json:"picture"`
+	CreatedAt     sql.NullTime   `json:"created_at"`
+	UpdatedAt     sql.NullTime   `json:"updated_at"`
+	EmailVerified bool           `json:"email_verified"`
 }
@@ -11,12 +11,139 @@ import (
 	"time"
 )
 
-const createMeal = `-- name: CreateMeal :exec
+const acceptInvitations = `-- name: AcceptInvitations :exec
+INSERT INTO meal_plan_access (id, user_id, meal_plan_id, role, created_at)
+SELECT 'invitation-' || i.id, u.id, i.meal_plan_id, i.role, CURRENT_TIMESTAMP
+FROM invitations i
+JOIN users u ON u.id = $1
+WHERE i.email = LOWER(u.email)
+  AND NOT EXISTS (
+    SELECT 1 FROM meal_plan_access mpa
+    WHERE mpa.meal_plan_id = i.meal_plan_id AND mpa.user_id = u.id)
+`
+
+// Invitations to plans the user can already see add nothing
+func (q *Queries) AcceptInvitations(ctx context.Context, userID string) error {
+	_, err := q.db.ExecContext(ctx, acceptInvitations, userID)
+	return err
+}
+
+const consumeMagicLink = `-- name: ConsumeMagicLink :one
+UPDATE magic_links SET used_at = CURRENT_TIMESTAMP
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2
+RETURNING token_hash, email, created_at, expires_at, used_at, name, password_hash
+`
+
+type ConsumeMagicLinkParams struct {
+	TokenHash string    `json:"token_hash"`
+	Now       time.Time `json:"now"`
+}
+
+func (q *Queries) ConsumeMagicLink(ctx context.Context, arg ConsumeMagicLinkParams) (MagicLink, error) {
+	row := q.db.QueryRowContext(ctx, consumeMagicLink, arg.TokenHash, arg.Now)
+	var i MagicLink
+	err := row.Scan(
+		&i.TokenHash,
+		&i.Email,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.UsedAt,
+		&i.Name,
+		&i.PasswordHash,
+	)
+	return i, err
+}
+
+const createAPIToken = `-- name: CreateAPIToken :exec
+
+INSERT INTO api_tokens (id, user_id, name, token_hash, prefix, scopes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+`
+
+type CreateAPITokenParams struct {
+	ID        string       `json:"id"`
+	UserID    string       `json:"user_id"`
+	Name      string       `json:"name"`
+	TokenHash string       `json:"token_hash"`
+	Prefix    string       `json:"prefix"`
+	Scopes    string       `json:"scopes"`
+	ExpiresAt sql.NullTime `json:"expires_at"`
+}
+
+// API Token Queries
+func (q *Queries) CreateAPIToken(ctx context.Context, arg CreateAPITokenParams) error {
+	_, err := q.db.ExecContext(ctx, createAPIToken,
+		arg.ID,
+		arg.UserID,
+		arg.Name,
+		arg.TokenHash,
+		arg.Prefix,
+		arg.Scopes,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createInvitation = `-- name: CreateInvitation :exec
 
-INSERT INTO meals (id, meal_plan_id, name, description, day, meal_type)
+INSERT INTO invitations (id, meal_plan_id, email, role, invited_by, created_at)
 VALUES ($1, $2, $3, $4, $5, $6)
 `
 
+type CreateInvitationParams struct {
+	ID         string    `json:"id"`
+	MealPlanID string    `json:"meal_plan_id"`
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	InvitedBy  string    `json:"invited_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Invitation Queries
+func (q *Queries) CreateInvitation(ctx context.Context, arg CreateInvitationParams) error {
+	_, err := q.db.ExecContext(ctx, createInvitation,
+		arg.ID,
+		arg.MealPlanID,
+		arg.Email,
+		arg.Role,
+		arg.InvitedBy,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const createMagicLink = `-- name: CreateMagicLink :exec
+
+INSERT INTO magic_links (token_hash, email, name, password_hash, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+`
+
+type CreateMagicLinkParams struct {
+	TokenHash    string         `json:"token_hash"`
+	Email        string         `json:"email"`
+	Name         sql.NullString `json:"name"`
+	PasswordHash sql.NullString `json:"password_hash"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+}
+
+// Magic Link Queries
+func (q *Queries) CreateMagicLink(ctx context.Context, arg CreateMagicLinkParams) error {
+	_, err := q.db.ExecContext(ctx, createMagicLink,
+		arg.TokenHash,
+		arg.Email,
+		arg.Name,
+		arg.PasswordHash,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const createMeal = `-- name: CreateMeal :exec
+
+INSERT INTO meals (id, meal_plan_id, name, description, day, meal_type, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+`
+
 type CreateMealParams struct {
 	ID          string         `json:"id"`
 	MealPlanID  string         `json:"meal_plan_id"`
@@ -24,6 +151,7 @@ type CreateMealParams struct {
 	Description sql.NullString `json:"description"`
 	Day         string         `json:"day"`
 	MealType    string         `json:"meal_type"`
+	CreatedAt   sql.NullTime   `json:"created_at"`
 }
 
 // Meal Queries
@@ -35,14 +163,15 @@ func (q *Queries) CreateMeal(ctx context.Context, arg CreateMealParams) error {
 		arg.Description,
 		arg.Day,
 		arg.MealType,
+		arg.CreatedAt,
 	)
 	return err
 }
 
 const createMealPlan = `-- name: CreateMealPlan :exec
 
-INSERT INTO meal_plans (id, name, description, created_by)
-VALUES ($1, $2, $3, $4)
+INSERT INTO meal_plans (id, name, description, created_by, created_at, updated_at)
+VALUES ($1, $2, $3, $4, $5, $5)
 `
 
 type CreateMealPlanParams struct {
@@ -50,6 +179,7 @@ type CreateMealPlanParams struct {
 	Name        string         `json:"name"`
 	Description sql.NullString `json:"description"`
 	CreatedBy   string         `json:"created_by"`
+	CreatedAt   sql.NullTime   `json:"created_at"`
 }
 
 // Meal Plan Queries
@@ -59,14 +189,43 @@ func (q *Queries) CreateMealPlan(ctx context.Context, arg CreateMealPlanParams)
 		arg.Name,
 		arg.Description,
 		arg.CreatedBy,
+		arg.CreatedAt,
+	)
+	return err
+}
+
+const createSession = `-- name: CreateSession :exec
+
+INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip_address, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6)
+`
+
+type CreateSessionParams struct {
+	ID               string    `json:"id"`
+	UserID           string    `json:"user_id"`
+	RefreshTokenHash string    `json:"refresh_token_hash"`
+	UserAgent        string    `json:"user_agent"`
+	IpAddress        string    `json:"ip_address"`
+	ExpiresAt        time.Time `json:"expires_at"`
+}
+
+// Session Queries
+func (q *Queries) CreateSession(ctx context.Context, arg CreateSessionParams) error {
+	_, err := q.db.ExecContext(ctx, createSession,
+		arg.ID,
+		arg.UserID,
+		arg.RefreshTokenHash,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.ExpiresAt,
 	)
 	return err
 }
 
 const createShareLink = `-- name: CreateShareLink :exec
 
-INSERT INTO share_links (id, meal_plan_id, created_by, role, expires_at)
-VALUES ($1, $2, $3, $4, $5)
+INSERT INTO share_links (id, meal_plan_id, created_by, role, expires_at, max_uses)
+VALUES ($1, $2, $3, $4, $5, $6)
 `
 
 type CreateShareLinkParams struct {
@@ -75,6 +234,7 @@ type CreateShareLinkParams struct {
 	CreatedBy  string    `json:"created_by"`
 	Role       string    `json:"role"`
 	ExpiresAt  time.Time `json:"expires_at"`
+	MaxUses    int32     `json:"max_uses"`
 }
 
 // Share Link Queries
@@ -85,6 +245,7 @@ func (q *Queries) CreateShareLink(ctx context.Context, arg CreateShareLinkParams
 		arg.CreatedBy,
 		arg.Role,
 		arg.ExpiresAt,
+		arg.MaxUses,
 	)
 	return err
 }
@@ -111,71 +272,253 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) error {
 	return err
 }
 
-const deleteExpiredShareLinks = `-- name: DeleteExpiredShareLinks :exec
-DELETE FROM share_links WHERE expires_at < now()
+const deleteAPIToken = `-- name: DeleteAPIToken :execrows
+DELETE FROM api_tokens WHERE id = $1
+`
+
+func (q *Queries) DeleteAPIToken(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteAPIToken, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteExpiredMagicLinks = `-- name: DeleteExpiredMagicLinks :execrows
+DELETE FROM magic_links WHERE expires_at < $1
+`
+
+func (q *Queries) DeleteExpiredMagicLinks(ctx context.Context, now time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredMagicLinks, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteExpiredSessions = `-- name: DeleteExpiredSessions :execrows
+DELETE FROM sessions WHERE expires_at < $1
+`
+
+func (q *Queries) DeleteExpiredSessions(ctx context.Context, now time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredSessions, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteExpiredShareLinks = `-- name: DeleteExpiredShareLinks :execrows
+DELETE FROM share_links WHERE expires_at < $1
 `
 
-func (q *Queries) DeleteExpiredShareLinks(ctx context.Context) error {
-	_, err := q.db.ExecContext(ctx, deleteExpiredShareLinks)
+func (q *Queries) DeleteExpiredShareLinks(ctx context.Context, now time.Time) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredShareLinks, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteInvitation = `-- name: DeleteInvitation :execrows
+DELETE FROM invitations WHERE id = $1
+`
+
+func (q *Queries) DeleteInvitation(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteInvitation, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteInvitationsByEmail = `-- name: DeleteInvitationsByEmail :exec
+DELETE FROM invitations WHERE email = LOWER($1)
+`
+
+func (q *Queries) DeleteInvitationsByEmail(ctx context.Context, email string) error {
+	_, err := q.db.ExecContext(ctx, deleteInvitationsByEmail, email)
 	return err
 }
 
-const deleteMeal = `-- name: DeleteMeal :exec
-DELETE FROM meals WHERE id = $1
+const deleteMeal = `-- name: DeleteMeal :execrows
+DELETE FROM meals WHERE id = $1 AND version = $2
+`
+
+type DeleteMealParams struct {
+	ID      string `json:"id"`
+	Version int64  `json:"version"`
+}
+
+func (q *Queries) DeleteMeal(ctx context.Context, arg DeleteMealParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteMeal, arg.ID, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteMealPlan = `-- name: DeleteMealPlan :execrows
+DELETE FROM meal_plans WHERE id = $1 AND version = $2
+`
+
+type DeleteMealPlanParams struct {
+	ID      string `json:"id"`
+	Version int64  `json:"version"`
+}
+
+func (q *Queries) DeleteMealPlan(ctx context.Context, arg DeleteMealPlanParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteMealPlan, arg.ID, arg.Version)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteMealPlanAccess = `-- name: DeleteMealPlanAccess :execrows
+DELETE FROM meal_plan_access
+WHERE meal_plan_id = $1 AND user_id = $2
+`
+
+type DeleteMealPlanAccessParams struct {
+	MealPlanID string `json:"meal_plan_id"`
+	UserID     string `json:"user_id"`
+}
+
+func (q *Queries) DeleteMealPlanAccess(ctx context.Context, arg DeleteMealPlanAccessParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteMealPlanAccess, arg.MealPlanID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteOwnerlessMealPlans = `-- name: DeleteOwnerlessMealPlans :exec
+DELETE FROM meal_plans
+WHERE (created_by = $1 OR id IN (
+    SELECT meal_plan_id FROM meal_plan_access
+    WHERE user_id = $1 AND role = 'owner'))
+  AND id NOT IN (
+    SELECT meal_plan_id FROM meal_plan_access
+    WHERE user_id <> $1 AND role = 'owner')
 `
 
-func (q *Queries) DeleteMeal(ctx context.Context, id string) error {
-	_, err := q.db.ExecContext(ctx, deleteMeal, id)
+// Plans the user owns alone, or created and nobody owns, go with the user
+func (q *Queries) DeleteOwnerlessMealPlans(ctx context.Context, userID string) error {
+	_, err := q.db.ExecContext(ctx, deleteOwnerlessMealPlans, userID)
 	return err
 }
 
-const deleteMealPlan = `-- name: DeleteMealPlan :exec
-DELETE FROM meal_plans WHERE id = $1
+const deleteOwnershipTransfer = `-- name: DeleteOwnershipTransfer :execrows
+DELETE FROM ownership_transfers WHERE meal_plan_id = $1
+`
+
+func (q *Queries) DeleteOwnershipTransfer(ctx context.Context, mealPlanID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteOwnershipTransfer, mealPlanID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteShareLink = `-- name: DeleteShareLink :execrows
+DELETE FROM share_links WHERE id = $1
+`
+
+func (q *Queries) DeleteShareLink(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteShareLink, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteShareLinksByCreator = `-- name: DeleteShareLinksByCreator :exec
+DELETE FROM share_links WHERE created_by = $1
 `
 
-func (q *Queries) DeleteMealPlan(ctx context.Context, id string) error {
-	_, err := q.db.ExecContext(ctx, deleteMealPlan, id)
+func (q *Queries) DeleteShareLinksByCreator(ctx context.Context, createdBy string) error {
+	_, err := q.db.ExecContext(ctx, deleteShareLinksByCreator, createdBy)
 	return err
 }
 
-const getMealByID = `-- name: GetMealByID :one
-SELECT id, meal_plan_id, name, description, day, meal_type, created_at, updated_at FROM meals WHERE id = $1
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users WHERE id = $1
 `
 
-func (q *Queries) GetMealByID(ctx context.Context, id string) (Meal, error) {
-	row := q.db.QueryRowContext(ctx, getMealByID, id)
-	var i Meal
+func (q *Queries) DeleteUser(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getAPITokenByHash = `-- name: GetAPITokenByHash :one
+SELECT id, user_id, name, token_hash, prefix, scopes, created_at, last_used_at, expires_at FROM api_tokens WHERE token_hash = $1
+`
+
+func (q *Queries) GetAPITokenByHash(ctx context.Context, tokenHash string) (ApiToken, error) {
+	row := q.db.QueryRowContext(ctx, getAPITokenByHash, tokenHash)
+	var i ApiToken
 	err := row.Scan(
 		&i.ID,
-		&i.MealPlanID,
+		&i.UserID,
 		&i.Name,
-		&i.Description,
-		&i.Day,
-		&i.MealType,
+		&i.TokenHash,
+		&i.Prefix,
+		&i.Scopes,
 		&i.CreatedAt,
-		&i.UpdatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
-const getMealPlanAccess = `-- name: GetMealPlanAccess :many
-SELECT id, user_id, meal_plan_id, role FROM meal_plan_access WHERE meal_plan_id = $1
+const getAPITokenByID = `-- name: GetAPITokenByID :one
+SELECT id, user_id, name, token_hash, prefix, scopes, created_at, last_used_at, expires_at FROM api_tokens WHERE id = $1
 `
 
-func (q *Queries) GetMealPlanAccess(ctx context.Context, mealPlanID string) ([]MealPlanAccess, error) {
-	rows, err := q.db.QueryContext(ctx, getMealPlanAccess, mealPlanID)
+func (q *Queries) GetAPITokenByID(ctx context.Context, id string) (ApiToken, error) {
+	row := q.db.QueryRowContext(ctx, getAPITokenByID, id)
+	var i ApiToken
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.TokenHash,
+		&i.Prefix,
+		&i.Scopes,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getAPITokensByUser = `-- name: GetAPITokensByUser :many
+SELECT id, user_id, name, token_hash, prefix, scopes, created_at, last_used_at, expires_at FROM api_tokens WHERE user_id = $1 ORDER BY created_at
+`
+
+func (q *Queries) GetAPITokensByUser(ctx context.Context, userID string) ([]ApiToken, error) {
+	rows, err := q.db.QueryContext(ctx, getAPITokensByUser, userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []MealPlanAccess
+	var items []ApiToken
 	for rows.Next() {
-		var i MealPlanAccess
+		var i ApiToken
 		if err := rows.Scan(
 			&i.ID,
 			&i.UserID,
-			&i.MealPlanID,
-			&i.Role,
+			&i.Name,
+			&i.TokenHash,
+			&i.Prefix,
+			&i.Scopes,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
 		); err != nil {
 			return nil, err
 		}
@@ -190,47 +533,44 @@ func (q *Queries) GetMealPlanAccess(ctx context.Context, mealPlanID string) ([]M
 	return items, nil
 }
 
-const getMealPlanByID = `-- name: GetMealPlanByID :one
-SELECT id, name, description, created_by, created_at, updated_at FROM meal_plans WHERE id = $1
+const getInvitationByID = `-- name: GetInvitationByID :one
+SELECT id, meal_plan_id, email, role, invited_by, created_at FROM invitations WHERE id = $1
 `
 
-func (q *Queries) GetMealPlanByID(ctx context.Context, id string) (MealPlan, error) {
-	row := q.db.QueryRowContext(ctx, getMealPlanByID, id)
-	var i MealPlan
+func (q *Queries) GetInvitationByID(ctx context.Context, id string) (Invitation, error) {
+	row := q.db.QueryRowContext(ctx, getInvitationByID, id)
+	var i Invitation
 	err := row.Scan(
 		&i.ID,
-		&i.Name,
-		&i.Description,
-		&i.CreatedBy,
+		&i.MealPlanID,
+		&i.Email,
+		&i.Role,
+		&i.InvitedBy,
 		&i.CreatedAt,
-		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const getMealPlansByUser = `-- name: GetMealPlansByUser :many
-SELECT mp.id, mp.name, mp.description, mp.created_by, mp.created_at, mp.updated_at
-FROM meal_plans mp
-JOIN meal_plan_access mpa ON mpa.meal_plan_id = mp.id
-WHERE mpa.user_id = $1
+const getInvitationsByMealPlan = `-- name: GetInvitationsByMealPlan :many
+SELECT id, meal_plan_id, email, role, invited_by, created_at FROM invitations WHERE meal_plan_id = $1 ORDER BY created_at, id
 `
 
-func (q *Queries) GetMealPlansByUser(ctx context.Context, userID string) ([]MealPlan, error) {
-	rows, err := q.db.QueryContext(ctx, getMealPlansByUser, userID)
+func (q *Queries) GetInvitationsByMealPlan(ctx context.Context, mealPlanID string) ([]Invitation, error) {
+	rows, err := q.db.QueryContext(ctx, getInvitationsByMealPlan, mealPlanID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []MealPlan
+	var items []Invitation
 	for rows.Next() {
-		var i MealPlan
+		var i Invitation
 		if err := rows.Scan(
 			&i.ID,
-			&i.Name,
-			&i.Description,
-			&i.CreatedBy,
+			&i.MealPlanID,
+			&i.Email,
+			&i.Role,
+			&i.InvitedBy,
 			&i.CreatedAt,
-			&i.UpdatedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -245,28 +585,59 @@ func (q *Queries) GetMealPlansByUser(ctx context.Context, userID string) ([]Meal
 	return items, nil
 }
 
-const getMealsByPlanID = `-- name: GetMealsByPlanID :many
-SELECT id, meal_plan_id, name, description, day, meal_type, created_at, updated_at FROM meals WHERE meal_plan_id = $1
+const getMealByID = `-- name: GetMealByID :one
+SELECT id, meal_plan_id, name, description, day, meal_type, created_at, updated_at, version FROM meals WHERE id = $1
+`
+
+func (q *Queries) GetMealByID(ctx context.Context, id string) (Meal, error) {
+	row := q.db.QueryRowContext(ctx, getMealByID, id)
+	var i Meal
+	err := row.Scan(
+		&i.ID,
+		&i.MealPlanID,
+		&i.Name,
+		&i.Description,
+		&i.Day,
+		&i.MealType,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Version,
+	)
+	return i, err
+}
+
+const getMealPlanAccess = `-- name: GetMealPlanAccess :many
+SELECT mpa.role, mpa.created_at, u.id AS user_id, u.name, u.email, u.picture
+FROM meal_plan_access mpa
+JOIN users u ON u.id = mpa.user_id
+WHERE mpa.meal_plan_id = $1
 `
 
-func (q *Queries) GetMealsByPlanID(ctx context.Context, mealPlanID string) ([]Meal, error) {
-	rows, err := q.db.QueryContext(ctx, getMealsByPlanID, mealPlanID)
+type GetMealPlanAccessRow struct {
+	Role      string         `json:"role"`
+	CreatedAt sql.NullTime   `json:"created_at"`
+	UserID    string         `json:"user_id"`
+	Name      string         `json:"name"`
+	Email     string         `json:"email"`
+	Picture   sql.NullString `json:"picture"`
+}
+
+func (q *Queries) GetMealPlanAccess(ctx context.Context, mealPlanID string) ([]GetMealPlanAccessRow, error) {
+	rows, err := q.db.QueryContext(ctx, getMealPlanAccess, mealPlanID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Meal
+	var items []GetMealPlanAccessRow
 	for rows.Next() {
-		var i Meal
+		var i GetMealPlanAccessRow
 		if err := rows.Scan(
-			&i.ID,
-			&i.MealPlanID,
-			&i.Name,
-			&i.Description,
-			&i.Day,
-			&i.MealType,
+			&i.Role,
 			&i.CreatedAt,
-			&i.UpdatedAt,
+			&i.UserID,
+			&i.Name,
+			&i.Email,
+			&i.Picture,
 		); err != nil {
 			return nil, err
 		}
@@ -281,45 +652,225 @@ func (q *Queries) GetMealsByPlanID(ctx context.Context, mealPlanID string) ([]Me
 	return items, nil
 }
 
-const getShareLinkByID = `-- name: GetShareLinkByID :one
-SELECT id, meal_plan_id, created_by, role, expires_at, created_at FROM share_links WHERE id = $1
+const getMealPlanByID = `-- name: GetMealPlanByID :one
+SELECT id, name, description, created_by, created_at, updated_at, version FROM meal_plans WHERE id = $1
 `
 
-func (q *Queries) GetShareLinkByID(ctx context.Context, id string) (ShareLink, error) {
-	row := q.db.QueryRowContext(ctx, getShareLinkByID, id)
-	var i ShareLink
+func (q *Queries) GetMealPlanByID(ctx context.Context, id string) (MealPlan, error) {
+	row := q.db.QueryRowContext(ctx, getMealPlanByID, id)
+	var i MealPlan
 	err := row.Scan(
 		&i.ID,
-		&i.MealPlanID,
+		&i.Name,
+		&i.Description,
 		&i.CreatedBy,
-		&i.Role,
-		&i.ExpiresAt,
 		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Version,
 	)
 	return i, err
 }
 
-const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, email, name, picture, created_at, updated_at FROM users WHERE email = $1
+const getOwnershipTransfer = `-- name: GetOwnershipTransfer :one
+SELECT meal_plan_id, from_user_id, to_user_id, created_at, expires_at FROM ownership_transfers WHERE meal_plan_id = $1
 `
 
-func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
-	var i User
+func (q *Queries) GetOwnershipTransfer(ctx context.Context, mealPlanID string) (OwnershipTransfer, error) {
+	row := q.db.QueryRowContext(ctx, getOwnershipTransfer, mealPlanID)
+	var i OwnershipTransfer
 	err := row.Scan(
-		&i.ID,
-		&i.Email,
-		&i.Name,
+		&i.MealPlanID,
+		&i.FromUserID,
+		&i.ToUserID,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+	)
+	return i, err
+}
+
+const getPassword = `-- name: GetPassword :one
+SELECT user_id, password_hash, failed_attempts, locked_until, updated_at FROM passwords WHERE user_id = $1
+`
+
+func (q *Queries) GetPassword(ctx context.Context, userID string) (Password, error) {
+	row := q.db.QueryRowContext(ctx, getPassword, userID)
+	var i Password
+	err := row.Scan(
+		&i.UserID,
+		&i.PasswordHash,
+		&i.FailedAttempts,
+		&i.LockedUntil,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getSessionByID = `-- name: GetSessionByID :one
+SELECT id, user_id, refresh_token_hash, user_agent, ip_address, created_at, last_used_at, expires_at, revoked_at FROM sessions WHERE id = $1
+`
+
+func (q *Queries) GetSessionByID(ctx context.Context, id string) (Session, error) {
+	row := q.db.QueryRowContext(ctx, getSessionByID, id)
+	var i Session
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.RefreshTokenHash,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getSessionsByUser = `-- name: GetSessionsByUser :many
+SELECT id, user_id, refresh_token_hash, user_agent, ip_address, created_at, last_used_at, expires_at, revoked_at FROM sessions WHERE user_id = $1 ORDER BY created_at
+`
+
+func (q *Queries) GetSessionsByUser(ctx context.Context, userID string) ([]Session, error) {
+	rows, err := q.db.QueryContext(ctx, getSessionsByUser, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Session
+	for rows.Next() {
+		var i Session
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.RefreshTokenHash,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getShareLinkByID = `-- name: GetShareLinkByID :one
+SELECT id, meal_plan_id, created_by, role, expires_at, created_at, max_uses, uses FROM share_links WHERE id = $1
+`
+
+func (q *Queries) GetShareLinkByID(ctx context.Context, id string) (ShareLink, error) {
+	row := q.db.QueryRowContext(ctx, getShareLinkByID, id)
+	var i ShareLink
+	err := row.Scan(
+		&i.ID,
+		&i.MealPlanID,
+		&i.CreatedBy,
+		&i.Role,
+		&i.ExpiresAt,
+		&i.CreatedAt,
+		&i.MaxUses,
+		&i.Uses,
+	)
+	return i, err
+}
+
+const getShareLinkRedemptionsByMealPlan = `-- name: GetShareLinkRedemptionsByMealPlan :many
+SELECT r.share_link_id, r.user_id, r.redeemed_at FROM share_link_redemptions r
+JOIN share_links sl ON sl.id = r.share_link_id
+WHERE sl.meal_plan_id = $1
+ORDER BY r.redeemed_at, r.user_id
+`
+
+func (q *Queries) GetShareLinkRedemptionsByMealPlan(ctx context.Context, mealPlanID string) ([]ShareLinkRedemption, error) {
+	rows, err := q.db.QueryContext(ctx, getShareLinkRedemptionsByMealPlan, mealPlanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ShareLinkRedemption
+	for rows.Next() {
+		var i ShareLinkRedemption
+		if err := rows.Scan(&i.ShareLinkID, &i.UserID, &i.RedeemedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getShareLinksByMealPlan = `-- name: GetShareLinksByMealPlan :many
+SELECT id, meal_plan_id, created_by, role, expires_at, created_at, max_uses, uses FROM share_links WHERE meal_plan_id = $1 ORDER BY created_at, id
+`
+
+func (q *Queries) GetShareLinksByMealPlan(ctx context.Context, mealPlanID string) ([]ShareLink, error) {
+	rows, err := q.db.QueryContext(ctx, getShareLinksByMealPlan, mealPlanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ShareLink
+	for rows.Next() {
+		var i ShareLink
+		if err := rows.Scan(
+			&i.ID,
+			&i.MealPlanID,
+			&i.CreatedBy,
+			&i.Role,
+			&i.ExpiresAt,
+			&i.CreatedAt,
+			&i.MaxUses,
+			&i.Uses,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserByEmail = `-- name: GetUserByEmail :one
+SELECT id, email, name, picture, created_at, updated_at, email_verified FROM users WHERE email = $1
+`
+
+func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByEmail, email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.Email,
+		&i.Name,
 		&i.Picture,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.EmailVerified,
 	)
 	return i, err
 }
 
 const getUserByID = `-- name: GetUserByID :one
 
-SELECT id, email, name, picture, created_at, updated_at FROM users WHERE id = $1
+SELECT id, email, name, picture, created_at, updated_at, email_verified FROM users WHERE id = $1
 `
 
 // User Queries
@@ -333,13 +884,16 @@ func (q *Queries) GetUserByID(ctx context.Context, id string) (User, error) {
 		&i.Picture,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.EmailVerified,
 	)
 	return i, err
 }
 
 const getUserMealPlanAccess = `-- name: GetUserMealPlanAccess :one
-SELECT id, user_id, meal_plan_id, role FROM meal_plan_access 
+SELECT id, user_id, meal_plan_id, role, created_at FROM meal_plan_access
 WHERE user_id = $1 AND meal_plan_id = $2
+ORDER BY CASE role WHEN 'owner' THEN 0 WHEN 'editor' THEN 1 ELSE 2 END
+LIMIT 1
 `
 
 type GetUserMealPlanAccessParams struct {
@@ -355,21 +909,23 @@ func (q *Queries) GetUserMealPlanAccess(ctx context.Context, arg GetUserMealPlan
 		&i.UserID,
 		&i.MealPlanID,
 		&i.Role,
+		&i.CreatedAt,
 	)
 	return i, err
 }
 
 const grantMealPlanAccess = `-- name: GrantMealPlanAccess :exec
 
-INSERT INTO meal_plan_access (id, user_id, meal_plan_id, role)
-VALUES ($1, $2, $3, $4)
+INSERT INTO meal_plan_access (id, user_id, meal_plan_id, role, created_at)
+VALUES ($1, $2, $3, $4, $5)
 `
 
 type GrantMealPlanAccessParams struct {
-	ID         string `json:"id"`
-	UserID     string `json:"user_id"`
-	MealPlanID string `json:"meal_plan_id"`
-	Role       string `json:"role"`
+	ID         string       `json:"id"`
+	UserID     string       `json:"user_id"`
+	MealPlanID string       `json:"meal_plan_id"`
+	Role       string       `json:"role"`
+	CreatedAt  sql.NullTime `json:"created_at"`
 }
 
 // MealPlanAccess Queries
@@ -379,14 +935,549 @@ func (q *Queries) GrantMealPlanAccess(ctx context.Context, arg GrantMealPlanAcce
 		arg.UserID,
 		arg.MealPlanID,
 		arg.Role,
+		arg.CreatedAt,
 	)
 	return err
 }
 
-const updateMeal = `-- name: UpdateMeal :exec
+const listMealPlansByName = `-- name: ListMealPlansByName :many
+SELECT id, name, description, created_by, created_at, updated_at, version, LOWER(name) AS sort_name FROM meal_plans
+WHERE id IN (SELECT meal_plan_id FROM meal_plan_access WHERE user_id = $1)
+  AND ($2 = '' OR LOWER(name) LIKE $2 ESCAPE '\' OR LOWER(description) LIKE $2 ESCAPE '\')
+  AND (NOT $3 OR (LOWER(name), id) > ($4, $5))
+ORDER BY LOWER(name), id
+LIMIT $6
+`
+
+type ListMealPlansByNameParams struct {
+	UserID    string `json:"user_id"`
+	Pattern   string `json:"pattern"`
+	HasCursor bool   `json:"has_cursor"`
+	AfterName string `json:"after_name"`
+	AfterID   string `json:"after_id"`
+	RowLimit  int32  `json:"row_limit"`
+}
+
+type ListMealPlansByNameRow struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Description sql.NullString `json:"description"`
+	CreatedBy   string         `json:"created_by"`
+	CreatedAt   sql.NullTime   `json:"created_at"`
+	UpdatedAt   sql.NullTime   `json:"updated_at"`
+	Version     int64          `json:"version"`
+	SortName    string         `json:"sort_name"`
+}
+
+func (q *Queries) ListMealPlansByName(ctx context.Context, arg ListMealPlansByNameParams) ([]ListMealPlansByNameRow, error) {
+	rows, err := q.db.QueryContext(ctx, listMealPlansByName,
+		arg.UserID,
+		arg.Pattern,
+		arg.HasCursor,
+		arg.AfterName,
+		arg.AfterID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMealPlansByNameRow
+	for rows.Next() {
+		var i ListMealPlansByNameRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Version,
+			&i.SortName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMealPlansByUpdated = `-- name: ListMealPlansByUpdated :many
+SELECT id, name, description, created_by, created_at, updated_at, version FROM meal_plans
+WHERE id IN (SELECT meal_plan_id FROM meal_plan_access WHERE user_id = $1)
+  AND ($2 = '' OR LOWER(name) LIKE $2 ESCAPE '\' OR LOWER(description) LIKE $2 ESCAPE '\')
+  AND (NOT $3
+    OR updated_at < $4
+    OR (updated_at = $4 AND id > $5))
+ORDER BY updated_at DESC, id
+LIMIT $6
+`
+
+type ListMealPlansByUpdatedParams struct {
+	UserID         string       `json:"user_id"`
+	Pattern        string       `json:"pattern"`
+	HasCursor      bool         `json:"has_cursor"`
+	AfterUpdatedAt sql.NullTime `json:"after_updated_at"`
+	AfterID        string       `json:"after_id"`
+	RowLimit       int32        `json:"row_limit"`
+}
+
+func (q *Queries) ListMealPlansByUpdated(ctx context.Context, arg ListMealPlansByUpdatedParams) ([]MealPlan, error) {
+	rows, err := q.db.QueryContext(ctx, listMealPlansByUpdated,
+		arg.UserID,
+		arg.Pattern,
+		arg.HasCursor,
+		arg.AfterUpdatedAt,
+		arg.AfterID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MealPlan
+	for rows.Next() {
+		var i MealPlan
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Description,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMealsByDay = `-- name: ListMealsByDay :many
+SELECT id, meal_plan_id, name, description, day, meal_type, created_at, updated_at, version FROM meals
+WHERE meal_plan_id = $1
+  AND ($2 = '' OR day = $2)
+  AND ($3 = '' OR meal_type = $3)
+  AND ($4 = '' OR LOWER(name) LIKE $4 ESCAPE '\' OR LOWER(description) LIKE $4 ESCAPE '\')
+  AND (NOT $5 OR (
+    CASE day
+      WHEN 'Monday' THEN 0 WHEN 'Tuesday' THEN 1 WHEN 'Wednesday' THEN 2 WHEN 'Thursday' THEN 3
+      WHEN 'Friday' THEN 4 WHEN 'Saturday' THEN 5 WHEN 'Sunday' THEN 6 ELSE 7 END,
+    day,
+    CASE meal_type WHEN 'Breakfast' THEN 0 WHEN 'Lunch' THEN 1 WHEN 'Dinner' THEN 2 ELSE 3 END,
+    meal_type,
+    id
+  ) > (
+    CAST($6 AS INTEGER), $7,
+    CAST($8 AS INTEGER), $9,
+    $10
+  ))
+ORDER BY
+  CASE day
+    WHEN 'Monday' THEN 0 WHEN 'Tuesday' THEN 1 WHEN 'Wednesday' THEN 2 WHEN 'Thursday' THEN 3
+    WHEN 'Friday' THEN 4 WHEN 'Saturday' THEN 5 WHEN 'Sunday' THEN 6 ELSE 7 END,
+  day,
+  CASE meal_type WHEN 'Breakfast' THEN 0 WHEN 'Lunch' THEN 1 WHEN 'Dinner' THEN 2 ELSE 3 END,
+  meal_type,
+  id
+LIMIT $11
+`
+
+type ListMealsByDayParams struct {
+	MealPlanID        string `json:"meal_plan_id"`
+	Day               string `json:"day"`
+	MealType          string `json:"meal_type"`
+	Pattern           string `json:"pattern"`
+	HasCursor         bool   `json:"has_cursor"`
+	AfterDayRank      int32  `json:"after_day_rank"`
+	AfterDay          string `json:"after_day"`
+	AfterMealTypeRank int32  `json:"after_meal_type_rank"`
+	AfterMealType     string `json:"after_meal_type"`
+	AfterID           string `json:"after_id"`
+	RowLimit          int32  `json:"row_limit"`
+}
+
+func (q *Queries) ListMealsByDay(ctx context.Context, arg ListMealsByDayParams) ([]Meal, error) {
+	rows, err := q.db.QueryContext(ctx, listMealsByDay,
+		arg.MealPlanID,
+		arg.Day,
+		arg.MealType,
+		arg.Pattern,
+		arg.HasCursor,
+		arg.AfterDayRank,
+		arg.AfterDay,
+		arg.AfterMealTypeRank,
+		arg.AfterMealType,
+		arg.AfterID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Meal
+	for rows.Next() {
+		var i Meal
+		if err := rows.Scan(
+			&i.ID,
+			&i.MealPlanID,
+			&i.Name,
+			&i.Description,
+			&i.Day,
+			&i.MealType,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMealsByName = `-- name: ListMealsByName :many
+SELECT id, meal_plan_id, name, description, day, meal_type, created_at, updated_at, version, LOWER(name) AS sort_name FROM meals
+WHERE meal_plan_id = $1
+  AND ($2 = '' OR day = $2)
+  AND ($3 = '' OR meal_type = $3)
+  AND ($4 = '' OR LOWER(name) LIKE $4 ESCAPE '\' OR LOWER(description) LIKE $4 ESCAPE '\')
+  AND (NOT $5 OR (LOWER(name), id) > ($6, $7))
+ORDER BY LOWER(name), id
+LIMIT $8
+`
+
+type ListMealsByNameParams struct {
+	MealPlanID string `json:"meal_plan_id"`
+	Day        string `json:"day"`
+	MealType   string `json:"meal_type"`
+	Pattern    string `json:"pattern"`
+	HasCursor  bool   `json:"has_cursor"`
+	AfterName  string `json:"after_name"`
+	AfterID    string `json:"after_id"`
+	RowLimit   int32  `json:"row_limit"`
+}
+
+type ListMealsByNameRow struct {
+	ID          string         `json:"id"`
+	MealPlanID  string         `json:"meal_plan_id"`
+	Name        string         `json:"name"`
+	Description sql.NullString `json:"description"`
+	Day         string         `json:"day"`
+	MealType    string         `json:"meal_type"`
+	CreatedAt   sql.NullTime   `json:"created_at"`
+	UpdatedAt   sql.NullTime   `json:"updated_at"`
+	Version     int64          `json:"version"`
+	SortName    string         `json:"sort_name"`
+}
+
+func (q *Queries) ListMealsByName(ctx context.Context, arg ListMealsByNameParams) ([]ListMealsByNameRow, error) {
+	rows, err := q.db.QueryContext(ctx, listMealsByName,
+		arg.MealPlanID,
+		arg.Day,
+		arg.MealType,
+		arg.Pattern,
+		arg.HasCursor,
+		arg.AfterName,
+		arg.AfterID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListMealsByNameRow
+	for rows.Next() {
+		var i ListMealsByNameRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.MealPlanID,
+			&i.Name,
+			&i.Description,
+			&i.Day,
+			&i.MealType,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Version,
+			&i.SortName,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listMealsByUpdated = `-- name: ListMealsByUpdated :many
+SELECT id, meal_plan_id, name, description, day, meal_type, created_at, updated_at, version FROM meals
+WHERE meal_plan_id = $1
+  AND ($2 = '' OR day = $2)
+  AND ($3 = '' OR meal_type = $3)
+  AND ($4 = '' OR LOWER(name) LIKE $4 ESCAPE '\' OR LOWER(description) LIKE $4 ESCAPE '\')
+  AND (NOT $5
+    OR updated_at < $6
+    OR (updated_at = $6 AND id > $7))
+ORDER BY updated_at DESC, id
+LIMIT $8
+`
+
+type ListMealsByUpdatedParams struct {
+	MealPlanID     string       `json:"meal_plan_id"`
+	Day            string       `json:"day"`
+	MealType       string       `json:"meal_type"`
+	Pattern        string       `json:"pattern"`
+	HasCursor      bool         `json:"has_cursor"`
+	AfterUpdatedAt sql.NullTime `json:"after_updated_at"`
+	AfterID        string       `json:"after_id"`
+	RowLimit       int32        `json:"row_limit"`
+}
+
+func (q *Queries) ListMealsByUpdated(ctx context.Context, arg ListMealsByUpdatedParams) ([]Meal, error) {
+	rows, err := q.db.QueryContext(ctx, listMealsByUpdated,
+		arg.MealPlanID,
+		arg.Day,
+		arg.MealType,
+		arg.Pattern,
+		arg.HasCursor,
+		arg.AfterUpdatedAt,
+		arg.AfterID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Meal
+	for rows.Next() {
+		var i Meal
+		if err := rows.Scan(
+			&i.ID,
+			&i.MealPlanID,
+			&i.Name,
+			&i.Description,
+			&i.Day,
+			&i.MealType,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Version,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listOwnershipTransfersByRecipient = `-- name: ListOwnershipTransfersByRecipient :many
+SELECT meal_plan_id, from_user_id, to_user_id, created_at, expires_at FROM ownership_transfers
+WHERE to_user_id = $1
+ORDER BY created_at, meal_plan_id
+`
+
+func (q *Queries) ListOwnershipTransfersByRecipient(ctx context.Context, toUserID string) ([]OwnershipTransfer, error) {
+	rows, err := q.db.QueryContext(ctx, listOwnershipTransfersByRecipient, toUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OwnershipTransfer
+	for rows.Next() {
+		var i OwnershipTransfer
+		if err := rows.Scan(
+			&i.MealPlanID,
+			&i.FromUserID,
+			&i.ToUserID,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const reassignMealPlanCreator = `-- name: ReassignMealPlanCreator :exec
+UPDATE meal_plans
+SET created_by = (
+  SELECT mpa.user_id FROM meal_plan_access mpa
+  WHERE mpa.meal_plan_id = meal_plans.id AND mpa.role = 'owner' AND mpa.user_id <> $1
+  ORDER BY mpa.created_at, mpa.id
+  LIMIT 1)
+WHERE created_by = $1
+`
+
+func (q *Queries) ReassignMealPlanCreator(ctx context.Context, userID string) error {
+	_, err := q.db.ExecContext(ctx, reassignMealPlanCreator, userID)
+	return err
+}
+
+const recordFailedLogin = `-- name: RecordFailedLogin :execrows
+UPDATE passwords
+SET failed_attempts = CASE WHEN failed_attempts + 1 >= $1 THEN 0 ELSE failed_attempts + 1 END,
+    locked_until = CASE WHEN failed_attempts + 1 >= $1 THEN $2 ELSE locked_until END
+WHERE user_id = $3
+`
+
+type RecordFailedLoginParams struct {
+	MaxAttempts int32        `json:"max_attempts"`
+	LockedUntil sql.NullTime `json:"locked_until"`
+	UserID      string       `json:"user_id"`
+}
+
+func (q *Queries) RecordFailedLogin(ctx context.Context, arg RecordFailedLoginParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, recordFailedLogin, arg.MaxAttempts, arg.LockedUntil, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const recordShareLinkRedemption = `-- name: RecordShareLinkRedemption :exec
+INSERT INTO share_link_redemptions (share_link_id, user_id, redeemed_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (share_link_id, user_id) DO UPDATE SET redeemed_at = EXCLUDED.redeemed_at
+`
+
+type RecordShareLinkRedemptionParams struct {
+	ShareLinkID string    `json:"share_link_id"`
+	UserID      string    `json:"user_id"`
+	RedeemedAt  time.Time `json:"redeemed_at"`
+}
+
+func (q *Queries) RecordShareLinkRedemption(ctx context.Context, arg RecordShareLinkRedemptionParams) error {
+	_, err := q.db.ExecContext(ctx, recordShareLinkRedemption, arg.ShareLinkID, arg.UserID, arg.RedeemedAt)
+	return err
+}
+
+const resetFailedLogins = `-- name: ResetFailedLogins :execrows
+UPDATE passwords SET failed_attempts = 0, locked_until = NULL WHERE user_id = $1
+`
+
+func (q *Queries) ResetFailedLogins(ctx context.Context, userID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, resetFailedLogins, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeSession = `-- name: RevokeSession :execrows
+UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeSession(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeSession, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateSession = `-- name: RotateSession :execrows
+UPDATE sessions
+SET refresh_token_hash = $1, expires_at = $2, last_used_at = CURRENT_TIMESTAMP
+WHERE id = $3 AND refresh_token_hash = $4 AND revoked_at IS NULL
+`
+
+type RotateSessionParams struct {
+	NewHash   string    `json:"new_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+	ID        string    `json:"id"`
+	OldHash   string    `json:"old_hash"`
+}
+
+func (q *Queries) RotateSession(ctx context.Context, arg RotateSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, rotateSession,
+		arg.NewHash,
+		arg.ExpiresAt,
+		arg.ID,
+		arg.OldHash,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setMealPlanAccessRole = `-- name: SetMealPlanAccessRole :execrows
+UPDATE meal_plan_access SET role = $3
+WHERE meal_plan_id = $1 AND user_id = $2
+`
+
+type SetMealPlanAccessRoleParams struct {
+	MealPlanID string `json:"meal_plan_id"`
+	UserID     string `json:"user_id"`
+	Role       string `json:"role"`
+}
+
+func (q *Queries) SetMealPlanAccessRole(ctx context.Context, arg SetMealPlanAccessRoleParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setMealPlanAccessRole, arg.MealPlanID, arg.UserID, arg.Role)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const touchAPIToken = `-- name: TouchAPIToken :execrows
+UPDATE api_tokens SET last_used_at = $1 WHERE id = $2
+`
+
+type TouchAPITokenParams struct {
+	LastUsedAt sql.NullTime `json:"last_used_at"`
+	ID         string       `json:"id"`
+}
+
+func (q *Queries) TouchAPIToken(ctx context.Context, arg TouchAPITokenParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, touchAPIToken, arg.LastUsedAt, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateMeal = `-- name: UpdateMeal :execrows
 UPDATE meals
-SET name = $2, description = $3, day = $4, meal_type = $5, updated_at = now()
-WHERE id = $1
+SET name = $2, description = $3, day = $4, meal_type = $5, updated_at = $7, version = version + 1
+WHERE id = $1 AND version = $6
 `
 
 type UpdateMealParams struct {
@@ -395,39 +1486,57 @@ type UpdateMealParams struct {
 	Description sql.NullString `json:"description"`
 	Day         string         `json:"day"`
 	MealType    string         `json:"meal_type"`
+	Version     int64          `json:"version"`
+	UpdatedAt   sql.NullTime   `json:"updated_at"`
 }
 
-func (q *Queries) UpdateMeal(ctx context.Context, arg UpdateMealParams) error {
-	_, err := q.db.ExecContext(ctx, updateMeal,
+func (q *Queries) UpdateMeal(ctx context.Context, arg UpdateMealParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateMeal,
 		arg.ID,
 		arg.Name,
 		arg.Description,
 		arg.Day,
 		arg.MealType,
+		arg.Version,
+		arg.UpdatedAt,
 	)
-	return err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const updateMealPlan = `-- name: UpdateMealPlan :exec
+const updateMealPlan = `-- name: UpdateMealPlan :execrows
 UPDATE meal_plans
-SET name = $2, description = $3, updated_at = now()
-WHERE id = $1
+SET name = $2, description = $3, updated_at = $5, version = version + 1
+WHERE id = $1 AND version = $4
 `
 
 type UpdateMealPlanParams struct {
 	ID          string         `json:"id"`
 	Name        string         `json:"name"`
 	Description sql.NullString `json:"description"`
+	Version     int64          `json:"version"`
+	UpdatedAt   sql.NullTime   `json:"updated_at"`
 }
 
-func (q *Queries) UpdateMealPlan(ctx context.Context, arg UpdateMealPlanParams) error {
-	_, err := q.db.ExecContext(ctx, updateMealPlan, arg.ID, arg.Name, arg.Description)
-	return err
+func (q *Queries) UpdateMealPlan(ctx context.Context, arg UpdateMealPlanParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateMealPlan,
+		arg.ID,
+		arg.Name,
+		arg.Description,
+		arg.Version,
+		arg.UpdatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 const updateUser = `-- name: UpdateUser :exec
 UPDATE users 
-SET name = $2, picture = $3, updated_at = now()
+SET name = $2, picture = $3, updated_at = CURRENT_TIMESTAMP
 WHERE id = $1
 `
 
@@ -441,3 +1550,90 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) error {
 	_, err := q.db.ExecContext(ctx, updateUser, arg.ID, arg.Name, arg.Picture)
 	return err
 }
+
+const upsertOwnershipTransfer = `-- name: UpsertOwnershipTransfer :exec
+
+INSERT INTO ownership_transfers (meal_plan_id, from_user_id, to_user_id, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (meal_plan_id) DO UPDATE
+SET from_user_id = EXCLUDED.from_user_id, to_user_id = EXCLUDED.to_user_id,
+    created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+`
+
+type UpsertOwnershipTransferParams struct {
+	MealPlanID string    `json:"meal_plan_id"`
+	FromUserID string    `json:"from_user_id"`
+	ToUserID   string    `json:"to_user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Ownership Transfer Queries
+func (q *Queries) UpsertOwnershipTransfer(ctx context.Context, arg UpsertOwnershipTransferParams) error {
+	_, err := q.db.ExecContext(ctx, upsertOwnershipTransfer,
+		arg.MealPlanID,
+		arg.FromUserID,
+		arg.ToUserID,
+		arg.CreatedAt,
+		arg.ExpiresAt,
+	)
+	return err
+}
+
+const upsertPassword = `-- name: UpsertPassword :exec
+
+INSERT INTO passwords (user_id, password_hash)
+VALUES ($1, $2)
+ON CONFLICT (user_id) DO UPDATE
+SET password_hash = EXCLUDED.password_hash, failed_attempts = 0, locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+`
+
+type UpsertPasswordParams struct {
+	UserID       string `json:"user_id"`
+	PasswordHash string `json:"password_hash"`
+}
+
+// Password Queries
+func (q *Queries) UpsertPassword(ctx context.Context, arg UpsertPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, upsertPassword, arg.UserID, arg.PasswordHash)
+	return err
+}
+
+const upsertUser = `-- name: UpsertUser :exec
+INSERT INTO users (id, email, name, picture, email_verified)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (id) DO UPDATE
+SET email = EXCLUDED.email, name = EXCLUDED.name, email_verified = EXCLUDED.email_verified, updated_at = CURRENT_TIMESTAMP
+`
+
+type UpsertUserParams struct {
+	ID            string         `json:"id"`
+	Email         string         `json:"email"`
+	Name          string         `json:"name"`
+	Picture       sql.NullString `json:"picture"`
+	EmailVerified bool           `json:"email_verified"`
+}
+
+func (q *Queries) UpsertUser(ctx context.Context, arg UpsertUserParams) error {
+	_, err := q.db.ExecContext(ctx, upsertUser,
+		arg.ID,
+		arg.Email,
+		arg.Name,
+		arg.Picture,
+		arg.EmailVerified,
+	)
+	return err
+}
+
+const useShareLink = `-- name: UseShareLink :execrows
+UPDATE share_links SET uses = uses + 1
+WHERE id = $1 AND (max_uses = 0 OR uses < max_uses)
+`
+
+func (q *Queries) UseShareLink(ctx context.Context, id string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useShareLink, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"my-meal-planner/db"
+)
+
+// developmentSecret signs tokens when nothing else is configured. It is
+// public, so it must never be used in production.
+const developmentSecret = "my-meal-planner-secret-key"
+
+// isProduction reports whether APP_ENV marks this as a production deployment
+func isProduction() bool {
+	return os.Getenv("APP_ENV") == "production"
+}
+
+// loadKeyring returns the keys that sign access tokens: the RSA or Ed25519
+// keys in JWT_KEYS_DIR when set, optionally pinned with JWT_ACTIVE_KEY_ID, or
+// else the shared HS256 secret in JWT_SECRET. Production refuses to start with
+// the development secret.
+func loadKeyring() (*db.Keyring, error) {
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		keys, err := db.LoadKeyring(dir, os.Getenv("JWT_ACTIVE_KEY_ID"))
+		if err != nil {
+			return nil, err
+		}
+		log.Println("Signing tokens with keys from", dir)
+		return keys, nil
+	}
+
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" || secret == developmentSecret {
+		if isProduction() {
+			return nil, errors.New("refusing to start in production with the default JWT secret: set JWT_KEYS_DIR or JWT_SECRET")
+		}
+		log.Println("WARNING: signing tokens with the development JWT secret")
+		secret = developmentSecret
+	}
+	return db.NewHMACKeyring([]byte(secret)), nil
+}
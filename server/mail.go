@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"my-meal-planner/mailer"
+)
+
+// loadMailer returns the mailer for sign-in links: SMTP when SMTP_ADDR is set
+// (SMTP_USERNAME and SMTP_PASSWORD optional), otherwise a log of the messages
+// in MAIL_FILE or on stderr. Production without SMTP disables email sign-in,
+// since logged links would let anyone with the logs sign in.
+func loadMailer() (mailer.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "noreply@localhost"
+	}
+
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		log.Println("Sending email through", addr)
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Addr:     addr,
+			From:     from,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}), nil
+	}
+
+	if isProduction() {
+		log.Println("WARNING: SMTP_ADDR is not set; email sign-in is disabled")
+		return nil, nil
+	}
+
+	if path := os.Getenv("MAIL_FILE"); path != "" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("Writing email to", path)
+		return mailer.NewLogMailer(file, from), nil
+	}
+
+	log.Println("Writing email to stderr; set SMTP_ADDR to send it")
+	return mailer.NewLogMailer(os.Stderr, from), nil
+}
@@ -0,0 +1,120 @@
+// Package mailer sends the emails the server needs, such as sign-in links
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// format renders msg as an RFC 5322 message. Header values containing line
+// breaks are refused, so user input can't add headers.
+func format(from string, msg Message) ([]byte, error) {
+	for _, value := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.New("mail header contains a line break")
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes(), nil
+}
+
+// SMTPConfig configures an SMTPMailer
+type SMTPConfig struct {
+	// Addr is the server's host:port
+	Addr string
+	From string
+	// Username and Password enable PLAIN authentication. Go only sends them
+	// over TLS or to localhost, so a local MailHog works without either.
+	Username string
+	Password string
+}
+
+// SMTPMailer sends messages through an SMTP server, upgrading to TLS when the
+// server offers STARTTLS
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+// NewSMTPMailer creates a mailer that sends through the server in config
+func NewSMTPMailer(config SMTPConfig) *SMTPMailer {
+	return &SMTPMailer{config: config}
+}
+
+// Send delivers msg to the SMTP server
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := format(m.config.From, msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		host, _, _ := strings.Cut(m.config.Addr, ":")
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, host)
+	}
+	if err := smtp.SendMail(m.config.Addr, auth, m.config.From, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("failed to send mail to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// LogMailer writes messages to w instead of sending them, for development
+// and tests. Each message is followed by a blank line.
+type LogMailer struct {
+	from  string
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewLogMailer creates a mailer that writes messages to w
+func NewLogMailer(w io.Writer, from string) *LogMailer {
+	return &LogMailer{from: from, w: w}
+}
+
+// Send writes msg to the mailer's writer
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := format(m.from, msg)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, err = fmt.Fprintf(m.w, "%s\r\n\r\n", data)
+	return err
+}
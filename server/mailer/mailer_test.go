@@ -0,0 +1,127 @@
+package mailer_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"my-meal-planner/mailer"
+)
+
+// fakeSMTPServer accepts one message, like a local MailHog, and sends what it
+// received on the returned channel
+func fakeSMTPServer(t *testing.T) (addr string, received <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		var transcript strings.Builder
+		text.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			transcript.WriteString(line + "\n")
+
+			switch verb := strings.ToUpper(strings.Fields(line + " x")[0]); verb {
+			case "EHLO", "HELO":
+				text.PrintfLine("250 localhost")
+			case "MAIL", "RCPT", "RSET", "NOOP":
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+				data, err := text.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				transcript.Write(data)
+				text.PrintfLine("250 OK: queued")
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				messages <- transcript.String()
+				return
+			default:
+				text.PrintfLine("502 Command not implemented")
+			}
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func TestSMTPMailer(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	m := mailer.NewSMTPMailer(mailer.SMTPConfig{Addr: addr, From: "planner@example.com"})
+
+	err := m.Send(context.Background(), mailer.Message{
+		To:      "alice@example.com",
+		Subject: "Your sign-in link",
+		Body:    "Hello\nhttps://example.com/login",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	transcript := <-received
+	for _, want := range []string{
+		"MAIL FROM:<planner@example.com>",
+		"RCPT TO:<alice@example.com>",
+		"To: alice@example.com\n",
+		"Subject: Your sign-in link\n",
+		"Content-Type: text/plain; charset=utf-8\n",
+		"\nHello\nhttps://example.com/login",
+	} {
+		if !strings.Contains(transcript, want) {
+			t.Errorf("SMTP transcript missing %q:\n%s", want, transcript)
+		}
+	}
+}
+
+func TestLogMailer(t *testing.T) {
+	var out bytes.Buffer
+	m := mailer.NewLogMailer(&out, "planner@example.com")
+
+	if err := m.Send(context.Background(), mailer.Message{To: "bob@example.com", Subject: "Grüße", Body: "Hi"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	msg, err := textproto.NewReader(bufio.NewReader(&out)).ReadMIMEHeader()
+	if err != nil {
+		t.Fatalf("reading headers: %v", err)
+	}
+	if msg.Get("From") != "planner@example.com" || msg.Get("To") != "bob@example.com" {
+		t.Errorf("headers = %v, want From and To set", msg)
+	}
+	if got := msg.Get("Subject"); got != "=?utf-8?q?Gr=C3=BC=C3=9Fe?=" {
+		t.Errorf("Subject = %q, want it Q-encoded", got)
+	}
+}
+
+func TestMailerRejectsHeaderInjection(t *testing.T) {
+	m := mailer.NewLogMailer(&bytes.Buffer{}, "planner@example.com")
+	for _, msg := range []mailer.Message{
+		{To: "alice@example.com\r\nBcc: eve@example.com", Subject: "Hi"},
+		{To: "alice@example.com", Subject: "Hi\nBcc: eve@example.com"},
+	} {
+		if err := m.Send(context.Background(), msg); err == nil {
+			t.Errorf("Send(%q, %q) succeeded, want an error", msg.To, msg.Subject)
+		}
+	}
+}
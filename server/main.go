@@ -1,17 +1,19 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 
 	"my-meal-planner/api"
-	"my-meal-planner/db"
 )
 
 func main() {
@@ -22,67 +24,58 @@ func main() {
 		// Continue anyway, as env vars might be set directly
 	}
 
-	/*
-		//open db connection
-		connStr := os.Getenv("DATABASE_URL")
-		dbConn, err := sql.Open("postgres", connStr)
-		if err != nil {
-			log.Fatalf("failed to connect to db: %v", err)
-		}
-		defer dbConn.Close()
+	// The migrate subcommand manages the schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
 
-		//test db connection
-		var result int
-		err = dbConn.QueryRow("SELECT 1").Scan(&result)
-		if err != nil {
-			log.Fatalf("query failed: %v", err)
-		}
+	autoMigrate := flag.Bool("migrate", false, "apply pending database migrations on startup")
+	requestTimeout := flag.Duration("request-timeout", 15*time.Second, "deadline for each API request, 0 to disable")
+	shareCodeSweep := flag.Duration("share-code-sweep", time.Hour, "how often to delete expired share codes, 0 to disable")
+	sessionSweep := flag.Duration("session-sweep", time.Hour, "how often to delete expired sessions and sign-in links, 0 to disable")
+	flag.Parse()
 
-		fmt.Println("Connection successful, result:", result)
-	*/
-	// Get Google OAuth credentials from environment
-	clientID := os.Getenv("GOOGLE_CLIENT_ID")
-	clientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
-	redirectURL := os.Getenv("OAUTH_REDIRECT_URL")
-
-	// Debug logging for OAuth credentials
-	log.Println("OAuth Configuration:")
-	log.Println("Client ID:", clientID)
-	if clientSecret != "" {
-		log.Println("Client Secret:", clientSecret[:5]+"..."+clientSecret[len(clientSecret)-5:])
-	} else {
-		log.Println("Client Secret: <not set>")
+	// Token signing keys
+	keys, err := loadKeyring()
+	if err != nil {
+		log.Fatal(err)
 	}
-	log.Println("Redirect URL:", redirectURL)
 
-	if redirectURL == "" {
-		redirectURL = "http://localhost:8080/auth/google/callback"
+	// Identity providers users can sign in with
+	providers, err := loadProviders()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// JWT secret
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "my-meal-planner-secret-key" // Default secret for development
+	// Mail transport for sign-in links
+	mail, err := loadMailer()
+	if err != nil {
+		log.Fatal(err)
 	}
 
-	// Create OAuth config
-	oauthConfig := &oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURL:  redirectURL,
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.email",
-			"https://www.googleapis.com/auth/userinfo.profile",
-			"openid",
-		},
-		Endpoint: google.Endpoint,
+	// Reverse proxies whose X-Forwarded-For can be believed
+	trustedProxies, err := api.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	// Create store
-	store := db.NewMemoryStore(oauthConfig, []byte(jwtSecret))
+	store, closeStore, err := openStore(keys, *autoMigrate)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeStore()
 
 	// Create handler
-	handler := api.NewHandler(store)
+	handler := api.NewHandler(store,
+		api.WithRequestTimeout(*requestTimeout),
+		api.WithProviders(providers...),
+		api.WithMailer(mail),
+		api.WithCookieSessions(os.Getenv("SESSION_MODE") == "cookie"),
+		api.WithPublicURL(os.Getenv("PUBLIC_URL")),
+		api.WithTrustedProxies(trustedProxies...),
+	)
 
 	// Create mux
 	mux := http.NewServeMux()
@@ -97,10 +90,36 @@ func main() {
 	// Add CORS middleware
 	corsHandler := enableCORS(mux)
 
-	// Start server
-	log.Println("Server starting on http://localhost:8080")
-	if err := http.ListenAndServe(":8080", corsHandler); err != nil {
-		log.Fatal(err)
+	// Start server and shut it down cleanly on interrupt, so deferred
+	// cleanup such as the final memory store snapshot still runs
+	server := &http.Server{Addr: ":8080", Handler: corsHandler}
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Println("Server starting on http://localhost:8080")
+		serverErr <- server.ListenAndServe()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *shareCodeSweep > 0 {
+		go sweepExpired(ctx, *shareCodeSweep, "share codes", store.DeleteExpiredShareCodes)
+	}
+	if *sessionSweep > 0 {
+		go sweepExpired(ctx, *sessionSweep, "sessions", store.DeleteExpiredSessions)
+		go sweepExpired(ctx, *sessionSweep, "sign-in links", store.DeleteExpiredMagicLinks)
+	}
+
+	select {
+	case err := <-serverErr:
+		log.Printf("server stopped: %v", err)
+	case <-ctx.Done():
+		log.Println("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("failed to shut down cleanly: %v", err)
+		}
 	}
 }
 
@@ -117,8 +136,9 @@ func enableCORS(h http.Handler) http.Handler {
 			w.Header().Set("Access-Control-Allow-Origin", origin)
 			w.Header().Set("Vary", "Origin") // Required for varying by Origin
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, X-CSRF-Token")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor, ETag")
 		}
 		// Handle preflight requests
 		if r.Method == http.MethodOptions {
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+
+	"my-meal-planner/db"
+)
+
+// runMigrate implements the `migrate up|down|status` subcommand
+func runMigrate(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: server migrate up|down|status")
+		os.Exit(2)
+	}
+
+	dbConn, err := openDatabase(storeKind())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dbConn.Close()
+
+	migrator, err := db.NewMigrator(dbConn)
+	if err != nil {
+		log.Fatalf("failed to load migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		count, err := migrator.Up(ctx)
+		if err != nil {
+			log.Fatalf("migrate up failed: %v", err)
+		}
+		log.Printf("Applied %d migration(s)", count)
+	case "down":
+		migration, err := migrator.Down(ctx)
+		if err != nil {
+			log.Fatalf("migrate down failed: %v", err)
+		}
+		if migration == nil {
+			log.Println("No migrations to roll back")
+			return
+		}
+		log.Printf("Rolled back %04d_%s", migration.Version, migration.Name)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatalf("migrate status failed: %v", err)
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, state)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q, expected up, down or status\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// migrateOnStartup applies pending migrations before the server starts
+func migrateOnStartup(dbConn *sql.DB) error {
+	migrator, err := db.NewMigrator(dbConn)
+	if err != nil {
+		return err
+	}
+
+	count, err := migrator.Up(context.Background())
+	if err != nil {
+		return err
+	}
+	log.Printf("Applied %d migration(s) on startup", count)
+	return nil
+}
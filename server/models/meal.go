@@ -10,6 +10,7 @@ type Meal struct {
 	Description string    `json:"description"`
 	Day         string    `json:"day"`
 	MealType    string    `json:"mealType"` // Breakfast, Lunch, Dinner
+	Version     int64     `json:"version"`  // Incremented on every update
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
 }
@@ -27,17 +28,45 @@ type MealPlan struct {
 	ID          string    `json:"id"`
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
+	Version     int64     `json:"version"` // Incremented on every update
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
 	CreatedBy   string    `json:"createdBy"` // User ID who created the plan
 }
 
-// MealPlanAccess represents a user's access to a meal plan
+// MealPlanAccess represents a user's access to a meal plan. Every plan has
+// exactly one access record with the owner role.
 type MealPlanAccess struct {
-	ID         string `json:"id"`
-	UserID     string `json:"userId"`
-	MealPlanID string `json:"mealPlanId"`
-	Role       string `json:"role"` // "owner", "editor", "viewer"
+	ID         string    `json:"id"`
+	UserID     string    `json:"userId"`
+	MealPlanID string    `json:"mealPlanId"`
+	Role       string    `json:"role"` // "owner", "editor", "viewer"
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// MealPlanMember is a user with access to a meal plan and their highest role
+type MealPlanMember struct {
+	UserID   string    `json:"userId"`
+	Name     string    `json:"name"`
+	Email    string    `json:"email"`
+	Picture  string    `json:"picture"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joinedAt"` // When the user first got access
+}
+
+// OwnershipTransfer is an offer to hand a meal plan to another member, who
+// becomes its owner by accepting it. A plan has at most one pending offer.
+type OwnershipTransfer struct {
+	MealPlanID string    `json:"mealPlanId"`
+	FromUserID string    `json:"fromUserId"`
+	ToUserID   string    `json:"toUserId"`
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+}
+
+// Expired reports whether the offer can no longer be accepted
+func (t *OwnershipTransfer) Expired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
 }
 
 // ShareLink represents a link that can be used to join a meal plan
@@ -48,4 +77,29 @@ type ShareCode struct {
 	Role       string    `json:"role"`      // Role to assign when joining: "editor" or "viewer"
 	ExpiresAt  time.Time `json:"expiresAt"` // When the link expires
 	CreatedAt  time.Time `json:"createdAt"`
+	MaxUses    int       `json:"maxUses"` // How many times the link can be used; 0 for no limit
+	Uses       int       `json:"uses"`
+}
+
+// UsedUp reports whether the link has been used as often as it allows
+func (c *ShareCode) UsedUp() bool {
+	return c.MaxUses > 0 && c.Uses >= c.MaxUses
+}
+
+// Invitation grants a role on a meal plan to whoever signs in with Email,
+// for inviting people who don't have an account yet
+type Invitation struct {
+	ID         string    `json:"id"`
+	MealPlanID string    `json:"mealPlanId"`
+	Email      string    `json:"email"` // Lower case
+	Role       string    `json:"role"`  // "editor" or "viewer"
+	InvitedBy  string    `json:"invitedBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// ShareCodeRedemption records a user joining a meal plan with a share code
+type ShareCodeRedemption struct {
+	ShareCodeID string    `json:"shareCodeId"`
+	UserID      string    `json:"userId"`
+	RedeemedAt  time.Time `json:"redeemedAt"`
 }
@@ -1,11 +1,84 @@
 package models
 
+import "time"
+
 // User represents a user in the system
 type User struct {
-	ID       string `json:"id"` // Google's 'sub' claim
-	Email    string `json:"email"`
-	Name     string `json:"name"`
-	Picture  string `json:"picture"`
-	CreateAt string `json:"created_at,omitempty"`
-	UpdateAt string `json:"updated_at,omitempty"`
+	ID      string `json:"id"` // Google's 'sub' claim
+	Email   string `json:"email"`
+	Name    string `json:"name"`
+	Picture string `json:"picture"`
+	// EmailVerified is set once the user has shown they receive mail at
+	// Email, through an emailed link or a provider that checked it
+	EmailVerified bool   `json:"emailVerified"`
+	CreateAt      string `json:"created_at,omitempty"`
+	UpdateAt      string `json:"updated_at,omitempty"`
+}
+
+// Session is a signed-in device. Its refresh token is stored only as a hash
+// and is replaced every time it is used.
+type Session struct {
+	ID               string     `json:"id"`
+	UserID           string     `json:"userId"`
+	RefreshTokenHash string     `json:"refreshTokenHash"`
+	UserAgent        string     `json:"userAgent"`
+	IPAddress        string     `json:"ipAddress"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	LastUsedAt       time.Time  `json:"lastUsedAt"`
+	ExpiresAt        time.Time  `json:"expiresAt"`
+	RevokedAt        *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Active reports whether the session can still be used at the given time
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// Password is a local account's password hash together with its failed
+// login count. Once too many logins fail, the account is locked for a while.
+type Password struct {
+	UserID         string     `json:"userId"`
+	Hash           string     `json:"hash"`
+	FailedAttempts int        `json:"failedAttempts"`
+	LockedUntil    *time.Time `json:"lockedUntil,omitempty"`
+	UpdatedAt      time.Time  `json:"updatedAt"`
+}
+
+// Locked reports whether logins are refused at the given time
+func (p *Password) Locked(now time.Time) bool {
+	return p.LockedUntil != nil && now.Before(*p.LockedUntil)
+}
+
+// MagicLink is an emailed sign-in link. Only a hash of its token is stored,
+// and it can be used once before it expires. A link that confirms a
+// registration also carries the new account's name and password hash, since
+// the account is only created once the link is used.
+type MagicLink struct {
+	TokenHash    string     `json:"tokenHash"`
+	Email        string     `json:"email"`
+	Name         string     `json:"name,omitempty"`
+	PasswordHash string     `json:"passwordHash,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ExpiresAt    time.Time  `json:"expiresAt"`
+	UsedAt       *time.Time `json:"usedAt,omitempty"`
+}
+
+// APIToken is a personal access token for scripts and integrations. Only a
+// hash of the token is stored; Prefix keeps its first characters so users can
+// tell their tokens apart.
+type APIToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"userId"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"tokenHash"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Expired reports whether the token can no longer be used at the given time
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"my-meal-planner/auth"
+)
+
+// loadProviders returns the identity providers users can sign in with: Google
+// when GOOGLE_CLIENT_ID is set, plus every OpenID Connect issuer listed in
+// OIDC_PROVIDERS as a JSON array of auth.ProviderConfig. Callback URLs default
+// to PUBLIC_URL, the address the server is reached at.
+func loadProviders() ([]auth.Provider, error) {
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:8080"
+	}
+
+	var configs []auth.ProviderConfig
+	if clientID := os.Getenv("GOOGLE_CLIENT_ID"); clientID != "" {
+		redirectURL := os.Getenv("OAUTH_REDIRECT_URL")
+		if redirectURL == "" {
+			redirectURL = publicURL + "/auth/google/callback"
+		}
+		configs = append(configs, auth.ProviderConfig{
+			Name:         "google",
+			Issuer:       "https://accounts.google.com",
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+			RedirectURL:  redirectURL,
+		})
+	}
+
+	if value := os.Getenv("OIDC_PROVIDERS"); value != "" {
+		parsed, err := auth.ParseProviderConfigs(value, publicURL)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, parsed...)
+	}
+
+	providers := make([]auth.Provider, 0, len(configs))
+	for _, config := range configs {
+		log.Printf("Identity provider %s: issuer %s, callback %s", config.Name, config.Issuer, config.RedirectURL)
+		providers = append(providers, auth.NewOIDCProvider(config))
+	}
+	if len(providers) == 0 {
+		log.Println("WARNING: no identity providers configured; set GOOGLE_CLIENT_ID or OIDC_PROVIDERS")
+	}
+	return providers, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"my-meal-planner/db"
+)
+
+// Store backends selectable with the STORE environment variable
+const (
+	storeMemory   = "memory"
+	storePostgres = "postgres"
+	storeSQLite   = "sqlite"
+)
+
+// storeKind returns the configured store backend. Without STORE it falls back
+// to PostgreSQL when DATABASE_URL is set and the in-memory store otherwise.
+func storeKind() string {
+	if kind := os.Getenv("STORE"); kind != "" {
+		return kind
+	}
+	if os.Getenv("DATABASE_URL") != "" {
+		return storePostgres
+	}
+	return storeMemory
+}
+
+// openStore creates the configured store. The returned func releases it.
+func openStore(keys *db.Keyring, autoMigrate bool) (db.Store, func(), error) {
+	switch kind := storeKind(); kind {
+	case storePostgres, storeSQLite:
+		dbConn, err := openDatabase(kind)
+		if err != nil {
+			return nil, nil, err
+		}
+		closeDB := func() { dbConn.Close() }
+
+		// The SQLite file belongs to this binary, so keep it migrated
+		if autoMigrate || kind == storeSQLite {
+			if err := migrateOnStartup(dbConn); err != nil {
+				closeDB()
+				return nil, nil, fmt.Errorf("failed to migrate db: %w", err)
+			}
+		}
+
+		if kind == storeSQLite {
+			log.Println("Using SQLite store")
+			return db.NewSQLiteStore(dbConn, keys), closeDB, nil
+		}
+		log.Println("Using PostgreSQL store")
+		return db.NewPostgresStore(dbConn, keys), closeDB, nil
+
+	case storeMemory:
+		dataDir := os.Getenv("MEMORY_DATA_DIR")
+		if dataDir == "" {
+			log.Println("Using in-memory store")
+			return db.NewMemoryStore(keys), func() {}, nil
+		}
+
+		memoryStore, err := openMemoryStore(keys, dataDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open memory store: %w", err)
+		}
+		log.Println("Using in-memory store persisted to", dataDir)
+		return memoryStore, func() {
+			if err := memoryStore.Close(); err != nil {
+				log.Printf("failed to close memory store: %v", err)
+			}
+		}, nil
+
+	default:
+		return nil, nil, fmt.Errorf("unknown STORE %q, expected memory, postgres or sqlite", kind)
+	}
+}
+
+// openDatabase opens the SQL database for the given store kind: PostgreSQL
+// at DATABASE_URL or the SQLite file at SQLITE_PATH
+func openDatabase(kind string) (*sql.DB, error) {
+	switch kind {
+	case storePostgres:
+		connStr := os.Getenv("DATABASE_URL")
+		if connStr == "" {
+			return nil, fmt.Errorf("DATABASE_URL must be set for the postgres store")
+		}
+
+		dbConn, err := sql.Open("postgres", connStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to db: %w", err)
+		}
+
+		if err := dbConn.Ping(); err != nil {
+			dbConn.Close()
+			return nil, fmt.Errorf("failed to reach db: %w", err)
+		}
+		return dbConn, nil
+
+	case storeSQLite:
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "meal-planner.db"
+		}
+		return db.OpenSQLite(path)
+
+	default:
+		return nil, fmt.Errorf("store %q has no database", kind)
+	}
+}
+
+// openMemoryStore opens a memory store persisted to dataDir, configured by
+// MEMORY_FSYNC (always, interval or never) and MEMORY_SNAPSHOT_INTERVAL
+func openMemoryStore(keys *db.Keyring, dataDir string) (*db.MemoryStore, error) {
+	fsync, err := db.ParseFsyncPolicy(os.Getenv("MEMORY_FSYNC"))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotInterval := 5 * time.Minute
+	if value := os.Getenv("MEMORY_SNAPSHOT_INTERVAL"); value != "" {
+		snapshotInterval, err = time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MEMORY_SNAPSHOT_INTERVAL: %w", err)
+		}
+	}
+
+	return db.OpenMemoryStore(keys, db.PersistenceOptions{
+		Dir:              dataDir,
+		Fsync:            fsync,
+		SnapshotInterval: snapshotInterval,
+	})
+}
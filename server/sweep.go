@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// sweepExpired calls deleteExpired every interval until ctx is done, logging
+// how many expired records of the named kind it removed
+func sweepExpired(ctx context.Context, interval time.Duration, kind string, deleteExpired func(context.Context) (int64, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := deleteExpired(ctx)
+			if err != nil {
+				log.Printf("failed to delete expired %s: %v", kind, err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("deleted %d expired %s", n, kind)
+			}
+		}
+	}
+}